@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// terminal puts the controlling terminal into raw mode for the browser and
+// reads keys from it. Raw mode is set with stty, so it works wherever stty
+// does without tying the tool to a terminal library.
+type terminal struct {
+	in    *bufio.Reader
+	out   *bufio.Writer
+	saved string // stty settings to restore on close
+}
+
+// openTerminal switches stdin to raw mode and stdout to the alternate
+// screen, failing unless both are terminals
+func openTerminal() (*terminal, error) {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return nil, errors.New("stdin and stdout must be a terminal")
+		}
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read terminal settings: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("cannot set raw mode: %v", err)
+	}
+
+	t := &terminal{in: bufio.NewReader(os.Stdin), out: bufio.NewWriter(os.Stdout), saved: saved}
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	t.out.Flush()
+	return t, nil
+}
+
+// close restores the screen, the cursor and the terminal settings
+func (t *terminal) close() {
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	stty(t.saved)
+}
+
+// stty runs stty on stdin and returns its output
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// size returns the rows and columns of the terminal, 24x80 if unknown
+func (t *terminal) size() (int, int) {
+	var rows, cols int
+	out, err := stty("size")
+	if _, err2 := fmt.Sscan(out, &rows, &cols); err != nil || err2 != nil || rows < 3 || cols < 20 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+// escapeKeys names the escape sequences of the keys the browser uses
+var escapeKeys = map[string]string{
+	"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+	"OA": "up", "OB": "down", "OC": "right", "OD": "left",
+	"[H": "home", "[F": "end", "OH": "home", "OF": "end",
+	"[1~": "home", "[4~": "end", "[5~": "pgup", "[6~": "pgdn",
+}
+
+// readKey reads one key press: a named key such as "up", "enter" or "esc",
+// or the character typed
+func (t *terminal) readKey() (string, error) {
+	b, err := t.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case '\r', '\n':
+		return "enter", nil
+	case 0x7f, 0x08:
+		return "backspace", nil
+	case 0x03:
+		return "ctrl-c", nil
+	case 0x1b:
+	default:
+		return string(b), nil
+	}
+
+	// A lone escape arrives without the rest of a sequence behind it
+	if t.in.Buffered() == 0 {
+		return "esc", nil
+	}
+	seq := []byte{}
+	for {
+		c, err := t.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seq = append(seq, c)
+		if len(seq) > 1 && c >= 0x40 && c <= 0x7e || len(seq) > 8 {
+			break
+		}
+	}
+	if name, ok := escapeKeys[string(seq)]; ok {
+		return name, nil
+	}
+	return "", nil
+}
+
+// readLine reads a line of input on the bottom row after prompt. It
+// returns false if the input was cancelled with escape.
+func (t *terminal) readLine(row int, prompt string) (string, bool) {
+	var line []byte
+	t.out.WriteString("\x1b[?25h")
+	defer t.out.WriteString("\x1b[?25l")
+	for {
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K%s%s", row, prompt, line)
+		t.out.Flush()
+		key, err := t.readKey()
+		switch {
+		case err != nil || key == "esc" || key == "ctrl-c":
+			return "", false
+		case key == "enter":
+			return string(line), true
+		case key == "backspace":
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case len(key) == 1 && key[0] >= ' ':
+			line = append(line, key[0])
+		}
+	}
+}
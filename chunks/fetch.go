@@ -0,0 +1,262 @@
+package chunks
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultParallel = 4
+	DefaultRounds   = 3
+)
+
+// Options configures Fetch
+type Options struct {
+	Mirrors    []string     // Base URLs each serving every chunk under its name
+	Parallel   int          // Chunks downloaded at once
+	Rounds     int          // Times every mirror is tried for a chunk before giving up
+	HTTPClient *http.Client // http.DefaultClient if nil
+
+	// Logf, if set, receives progress and error messages
+	Logf func(format string, args ...interface{})
+}
+
+// FetchManifest downloads and validates the manifest at a URL
+func FetchManifest(ctx context.Context, c *http.Client, rawURL string) (*Manifest, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", rawURL, resp.Status)
+	}
+	return ReadManifest(io.LimitReader(resp.Body, 64<<20))
+}
+
+// Fetch downloads the file a manifest describes to path, spreading the
+// chunks over the mirrors and verifying each against its hash. A chunk
+// that fails is tried on the next mirror. Chunks are written in place into
+// path+".part", and the ones verified are listed in path+".part.done", so
+// an interrupted download resumes with the chunks it lacks; the file is
+// renamed to path once complete.
+func Fetch(ctx context.Context, m *Manifest, path string, opts Options) error {
+	if len(opts.Mirrors) == 0 {
+		return fmt.Errorf("no mirrors to fetch %s from", m.Name)
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = DefaultParallel
+	}
+	if opts.Rounds <= 0 {
+		opts.Rounds = DefaultRounds
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	part, err := os.OpenFile(path+".part", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	if err := part.Truncate(m.Size); err != nil {
+		return err
+	}
+
+	done, err := readDone(path+".part.done", m)
+	if err != nil {
+		return err
+	}
+	state, err := os.OpenFile(path+".part.done", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	var pending []int
+	for i := range m.Chunks {
+		if !done[i] {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) < len(m.Chunks) {
+		logf("Resuming %s with %d of %d chunks already fetched", m.Name, len(m.Chunks)-len(pending), len(m.Chunks))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		stateMu  sync.Mutex
+		errOnce  sync.Once
+		firstErr error
+		fetched  atomic.Int64
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for w := 0; w < opts.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				mirror, err := fetchChunk(ctx, m, i, part, opts)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				// The chunk must be on disk before it is listed as done
+				if err := part.Sync(); err != nil {
+					fail(err)
+					continue
+				}
+				stateMu.Lock()
+				_, err = fmt.Fprintf(state, "%d %s\n", i, m.Chunks[i].SHA256)
+				stateMu.Unlock()
+				if err != nil {
+					fail(err)
+					continue
+				}
+				n := fetched.Add(1)
+				logf("Fetched %s from %s (%d of %d left)", m.Chunks[i].Name, mirror, int64(len(pending))-n, len(m.Chunks))
+			}
+		}()
+	}
+	for _, i := range pending {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := part.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+	state.Close()
+	os.Remove(path + ".part.done")
+	return nil
+}
+
+// readDone returns the chunks a resumed download already verified, by
+// index. Chunks listed with another hash belong to an older manifest.
+func readDone(path string, m *Manifest) (map[int]bool, error) {
+	done := make(map[int]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		i, err := strconv.Atoi(fields[0])
+		if err == nil && i >= 0 && i < len(m.Chunks) && fields[1] == m.Chunks[i].SHA256 {
+			done[i] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// fetchChunk downloads chunk i into its place in part, trying the mirrors
+// in turn starting from one chosen by i, and returns the mirror it came
+// from
+func fetchChunk(ctx context.Context, m *Manifest, i int, part *os.File, opts Options) (string, error) {
+	c := m.Chunks[i]
+	var err error
+	for attempt := 0; attempt < len(opts.Mirrors)*opts.Rounds; attempt++ {
+		if attempt > 0 && attempt%len(opts.Mirrors) == 0 {
+			// Every mirror failed; give them a moment before the next round
+			select {
+			case <-time.After(time.Duration(attempt/len(opts.Mirrors)) * 5 * time.Second):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		mirror := opts.Mirrors[(i+attempt)%len(opts.Mirrors)]
+		if err = download(ctx, opts.HTTPClient, mirror, c, io.NewOffsetWriter(part, m.Offset(i))); err == nil {
+			return mirror, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if opts.Logf != nil {
+			opts.Logf("Fetching %s from %s failed: %v", c.Name, mirror, err)
+		}
+	}
+	return "", fmt.Errorf("fetching %s failed on every mirror: %v", c.Name, err)
+}
+
+// download copies a chunk from a mirror to w, checking its size and hash
+func download(ctx context.Context, client *http.Client, mirror string, c Chunk, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(mirror, "/")+"/"+url.PathEscape(c.Name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(resp.Body, c.Size+1))
+	if err != nil {
+		return err
+	}
+	if n != c.Size {
+		return fmt.Errorf("got %d bytes, not %d", n, c.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != c.SHA256 {
+		return fmt.Errorf("hash %s does not match %s", sum, c.SHA256)
+	}
+	return nil
+}
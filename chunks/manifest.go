@@ -0,0 +1,136 @@
+// Package chunks distributes large files, such as a tames database of tens
+// of gigabytes, as fixed-size chunks listed with their SHA-256 hashes in a
+// manifest. Any static HTTP server can mirror the chunks; Fetch downloads
+// them from several mirrors at once, verifies each and resumes where an
+// interrupted download stopped.
+package chunks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultChunkSize is the chunk size Split uses if none is given
+const DefaultChunkSize = 256 << 20
+
+// ManifestSuffix is appended to a file name for the name of its manifest
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes a file split into chunks. Every chunk but the last
+// is ChunkSize bytes long.
+type Manifest struct {
+	Name      string  `json:"name"` // Base name of the whole file
+	Size      int64   `json:"size"`
+	ChunkSize int64   `json:"chunk_size"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// Chunk is one piece of a file, mirrored under its own name
+type Chunk struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Offset returns where chunk i starts in the whole file
+func (m *Manifest) Offset(i int) int64 {
+	return int64(i) * m.ChunkSize
+}
+
+// Validate checks that the chunks add up to the file they split
+func (m *Manifest) Validate() error {
+	if m.ChunkSize <= 0 {
+		return fmt.Errorf("manifest of %s has no chunk size", m.Name)
+	}
+	var total int64
+	for i, c := range m.Chunks {
+		if c.Size <= 0 || c.Size > m.ChunkSize || c.Size < m.ChunkSize && i != len(m.Chunks)-1 {
+			return fmt.Errorf("chunk %d of %s is %d bytes, not %d", i, m.Name, c.Size, m.ChunkSize)
+		}
+		if h, err := hex.DecodeString(c.SHA256); err != nil || len(h) != sha256.Size {
+			return fmt.Errorf("chunk %d of %s has an invalid hash", i, m.Name)
+		}
+		if c.Name == "" || c.Name != filepath.Base(c.Name) {
+			return fmt.Errorf("chunk %d of %s has an invalid name %q", i, m.Name, c.Name)
+		}
+		total += c.Size
+	}
+	if total != m.Size {
+		return fmt.Errorf("chunks of %s add up to %d bytes, not %d", m.Name, total, m.Size)
+	}
+	return nil
+}
+
+// ReadManifest reads and validates a manifest
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Split writes a file as chunks of chunkSize bytes named after it into
+// dir, followed by their manifest, and returns the manifest. The manifest
+// goes last, so mirrors that copy dir never list chunks they lack.
+func Split(path, dir string, chunkSize int64) (*Manifest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &Manifest{Name: filepath.Base(path), ChunkSize: chunkSize}
+	for i := 0; ; i++ {
+		c := Chunk{Name: fmt.Sprintf("%s.%06d", m.Name, i)}
+		n, sum, err := writeChunk(filepath.Join(dir, c.Name), io.LimitReader(file, chunkSize))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			os.Remove(filepath.Join(dir, c.Name))
+			break
+		}
+		c.Size, c.SHA256 = n, sum
+		m.Chunks = append(m.Chunks, c)
+		m.Size += n
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	manifest := filepath.Join(dir, m.Name+ManifestSuffix)
+	if err := os.WriteFile(manifest+".tmp", append(data, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return m, os.Rename(manifest+".tmp", manifest)
+}
+
+// writeChunk copies r to a new file, returning its size and SHA-256 hash
+func writeChunk(path string, r io.Reader) (int64, string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,413 @@
+// Package client uploads distinguished points from a worker to a pool
+// server. Every batch is written to a local spool directory before it is
+// sent and removed only once the server has accepted it, so points survive
+// network outages and restarts. Each batch carries a random ID the server
+// remembers, so one sent again because its answer was lost is applied and
+// reported once.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultBatchSize     = 10000
+	DefaultFlushInterval = 30 * time.Second
+	DefaultMinBackoff    = time.Second
+	DefaultMaxBackoff    = 5 * time.Minute
+)
+
+// spoolSuffix marks complete batch files in the spool directory
+const spoolSuffix = ".batch.gz"
+
+// Options configures a Client
+type Options struct {
+	Server        string          // Pool server as host:port or base URL
+	Token         string          // API key sent as a bearer token, if the server requires one
+	SpoolDir      string          // Directory holding batches not yet accepted
+	Schema        fastbase.Schema // Record layout of submitted points
+	BatchSize     int             // Records per batch
+	FlushInterval time.Duration   // Longest a partial batch waits before being spooled
+	MinBackoff    time.Duration   // First retry delay after a failed upload
+	MaxBackoff    time.Duration   // Longest retry delay
+	HTTPClient    *http.Client    // Client used for uploads; http.DefaultClient if nil
+	Stream        bool            // Send spooled batches over one POST /dps/stream request
+	MaxSpool      int64           // Bytes the spool may hold; the oldest batches go first beyond it, 0 for no limit
+
+	// OnResult, if set, is called for each batch the server accepts
+	OnResult func(r Result)
+
+	// Logf, if set, receives progress and error messages
+	Logf func(format string, args ...interface{})
+}
+
+// Result is the server's answer to an accepted batch
+type Result struct {
+	Records    int               `json:"records"`
+	Added      int               `json:"added"`
+	Duplicates int               `json:"duplicates"`
+	Collisions []json.RawMessage `json:"collisions"`
+	Replayed   bool              `json:"replayed"` // Sent before; the answer is the one then lost
+}
+
+// Client batches points, spools them to disk and uploads them in order
+type Client struct {
+	opts Options
+	base string // Server base URL
+
+	mu      sync.Mutex
+	batch   *fastbase.Batch
+	started time.Time // When the first record of the current batch was added
+	seq     int       // Distinguishes spool files created in the same nanosecond
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates the spool directory if needed and starts uploading any
+// batches left in it by an earlier run
+func New(opts Options) (*Client, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if err := os.MkdirAll(opts.SpoolDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(opts.Server, "/")
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	c := &Client{
+		opts:  opts,
+		base:  url,
+		batch: fastbase.NewBatch(opts.Schema),
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	c.wake <- struct{}{}
+	go c.run()
+	return c, nil
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.opts.Logf != nil {
+		c.opts.Logf(format, args...)
+	}
+}
+
+// Add queues a record filed under prefix. A full batch is spooled at once.
+func (c *Client) Add(prefix [3]byte, rec []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.batch.Len() == 0 {
+		c.started = time.Now()
+	}
+	if err := c.batch.Add(prefix, rec); err != nil {
+		return err
+	}
+	if c.batch.Len() >= c.opts.BatchSize {
+		return c.spoolLocked()
+	}
+	return nil
+}
+
+// Flush spools the current partial batch so it is uploaded promptly
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.spoolLocked()
+}
+
+// spoolLocked writes the current batch to a new spool file and wakes the
+// uploader. The caller holds c.mu.
+func (c *Client) spoolLocked() error {
+	if c.batch.Len() == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := c.batch.WriteTo(zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// Write under a temporary name and rename, so the uploader never sees a
+	// partial file
+	c.seq++
+	name := filepath.Join(c.opts.SpoolDir, fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), c.seq))
+	if err := os.WriteFile(name+".tmp", buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name+spoolSuffix); err != nil {
+		return err
+	}
+
+	c.batch.Reset()
+	c.trimSpool()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// trimSpool removes the oldest batches while the spool holds more than
+// MaxSpool bytes, so a long outage fills a bounded disk with the latest
+// points rather than stopping the worker. The newest batch always stays.
+func (c *Client) trimSpool() {
+	if c.opts.MaxSpool <= 0 {
+		return
+	}
+	files, err := c.spooled()
+	if err != nil {
+		return
+	}
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > c.opts.MaxSpool && i < len(files)-1; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			continue
+		}
+		total -= sizes[i]
+		c.logf("Spool over %d bytes, dropped the oldest batch %s", c.opts.MaxSpool, filepath.Base(files[i]))
+	}
+}
+
+// Pending returns the number of spooled batches not yet accepted
+func (c *Client) Pending() int {
+	files, _ := c.spooled()
+	return len(files)
+}
+
+// spooled lists complete spool files, oldest first
+func (c *Client) spooled() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.opts.SpoolDir, "*"+spoolSuffix))
+	sort.Strings(files)
+	return files, err
+}
+
+// Close spools the partial batch and keeps uploading until the spool is
+// empty or timeout passes. Batches still spooled are sent by the next
+// Client using the same spool directory.
+func (c *Client) Close(timeout time.Duration) error {
+	err := c.Flush()
+
+	deadline := time.After(timeout)
+	for c.Pending() > 0 {
+		select {
+		case <-deadline:
+			close(c.stop)
+			<-c.done
+			return err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	close(c.stop)
+	<-c.done
+	return err
+}
+
+// run uploads spooled batches in order, backing off exponentially while
+// the server is unreachable, and spools partial batches that have waited
+// for FlushInterval
+func (c *Client) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.opts.FlushInterval / 4)
+	defer ticker.Stop()
+
+	var backoff time.Duration
+	var retry *time.Timer // Set while backing off
+	var outage time.Time  // When the first upload of the current outage failed
+	for {
+		var retryC <-chan time.Time
+		if retry != nil {
+			retryC = retry.C
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.flushAged()
+			continue
+		case <-c.wake:
+			if retry != nil {
+				continue
+			}
+		case <-retryC:
+			retry = nil
+		}
+
+		if c.drain() {
+			if backoff == 0 {
+				outage = time.Now()
+			}
+			backoff = min(max(backoff*2, c.opts.MinBackoff), c.opts.MaxBackoff)
+			retry = time.NewTimer(backoff)
+			c.logf("Server unreachable, retrying in %v (%d batches spooled)", backoff, c.Pending())
+		} else if backoff != 0 && c.Pending() == 0 {
+			c.logf("Server reachable again after %v, spool drained", time.Since(outage).Round(time.Second))
+			backoff = 0
+		}
+	}
+}
+
+// flushAged spools the partial batch once it has waited FlushInterval
+func (c *Client) flushAged() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.batch.Len() > 0 && time.Since(c.started) >= c.opts.FlushInterval {
+		if err := c.spoolLocked(); err != nil {
+			c.logf("Error spooling batch: %v", err)
+		}
+	}
+}
+
+// drain uploads spooled batches until the spool is empty or an upload
+// fails in a way worth retrying, which it reports
+func (c *Client) drain() (retry bool) {
+	files, err := c.spooled()
+	if err != nil {
+		c.logf("Error reading spool: %v", err)
+		return true
+	}
+	if c.opts.Stream && len(files) > 0 {
+		return c.drainStream(files)
+	}
+
+	for _, file := range files {
+		select {
+		case <-c.stop:
+			return false
+		default:
+		}
+
+		err := c.upload(file)
+		if err == nil {
+			os.Remove(file)
+			continue
+		}
+		if rej, ok := err.(*rejectedError); ok {
+			// Retrying a batch the server refuses would block the spool
+			// forever, so set it aside for inspection
+			c.logf("Server rejected %s: %v; moving it aside", filepath.Base(file), rej)
+			os.Rename(file, file+".rejected")
+			continue
+		}
+		c.logf("Upload of %s failed: %v", filepath.Base(file), err)
+		return true
+	}
+	return false
+}
+
+// authorize adds the API key to a request
+func (c *Client) authorize(req *http.Request) {
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+}
+
+// rejectedError is an upload the server refused for good
+type rejectedError struct {
+	status int
+	msg    string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.msg)
+}
+
+// upload posts one spooled batch
+func (c *Client) upload(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.base+"/dps", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+	c.authorize(req)
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return fmt.Errorf("server returned %s", resp.Status)
+	default:
+		return &rejectedError{status: resp.StatusCode, msg: strings.TrimSpace(string(body))}
+	}
+
+	// The batch was accepted, so an unreadable answer is not worth a retry
+	var res Result
+	if err := json.Unmarshal(body, &res); err != nil {
+		c.logf("Uploaded %s, but the server response was invalid: %v", filepath.Base(file), err)
+		return nil
+	}
+	c.logUploaded(file, res)
+	if c.opts.OnResult != nil {
+		c.opts.OnResult(res)
+	}
+	return nil
+}
+
+// logUploaded reports a batch the server accepted
+func (c *Client) logUploaded(file string, res Result) {
+	if res.Replayed {
+		c.logf("Uploaded %s again, which the server had already applied: %d records, %d new, %d duplicates",
+			filepath.Base(file), res.Records, res.Added, res.Duplicates)
+		return
+	}
+	c.logf("Uploaded %s: %d records, %d new, %d duplicates", filepath.Base(file), res.Records, res.Added, res.Duplicates)
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// TestSpoolSurvivesOutage uploads through a server that fails its first
+// requests, and checks that the client backs off exponentially, then
+// drains every spooled batch once the server answers
+func TestSpoolSurvivesOutage(t *testing.T) {
+	const failures, records, batchSize = 3, 25, 10
+
+	var mu sync.Mutex
+	requests := 0
+	received := map[uint64]int{} // Records of each batch ID the server applied
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info, err := fastbase.ScanDelta(zr, func([3]byte, []byte) error { return nil })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received[info.ID] = info.Records
+		fmt.Fprintf(w, `{"records":%d,"added":%d}`, info.Records, info.Records)
+	}))
+	defer srv.Close()
+
+	var logMu sync.Mutex
+	var retries []string
+	c, err := New(Options{
+		Server:     srv.URL,
+		SpoolDir:   t.TempDir(),
+		Schema:     fastbase.SchemaStandard,
+		BatchSize:  batchSize,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+		Logf: func(format string, args ...interface{}) {
+			if strings.HasPrefix(format, "Server unreachable") {
+				logMu.Lock()
+				retries = append(retries, fmt.Sprint(args[0]))
+				logMu.Unlock()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := make([]byte, fastbase.SchemaStandard.RecordLength)
+	for i := 0; i < records; i++ {
+		rec[0], rec[1] = byte(i), byte(i>>8)
+		if err := c.Add([3]byte{rec[0], rec[1], rec[2]}, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.Pending(); n != 0 {
+		t.Errorf("%d batches still spooled", n)
+	}
+	total := 0
+	for _, n := range received {
+		total += n
+	}
+	if len(received) != 3 || total != records {
+		t.Errorf("server applied %d batches of %d records; want 3 of %d", len(received), total, records)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	if want := []string{"10ms", "20ms", "40ms"}; strings.Join(retries, " ") != strings.Join(want, " ") {
+		t.Errorf("retried after %v; want %v", retries, want)
+	}
+}
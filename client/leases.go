@@ -0,0 +1,71 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lease is a subrange of the search range leased from the pool server
+type Lease struct {
+	ID       int       `json:"id"`
+	Start    string    `json:"start"`
+	End      string    `json:"end"`
+	Bits     int       `json:"bits"`
+	Worker   string    `json:"worker"`
+	Expires  time.Time `json:"expires"`
+	Progress uint64    `json:"progress"`
+	Done     bool      `json:"done"`
+}
+
+// AcquireLease asks the server for a subrange to search as worker
+func (c *Client) AcquireLease(worker string) (Lease, error) {
+	return c.leaseCall("/leases", url.Values{"worker": {worker}})
+}
+
+// RenewLease extends a lease, reporting the worker's progress within it
+func (c *Client) RenewLease(l Lease, progress uint64) (Lease, error) {
+	return c.leaseCall(fmt.Sprintf("/leases/%d/renew", l.ID), url.Values{
+		"worker":   {l.Worker},
+		"progress": {strconv.FormatUint(progress, 10)},
+	})
+}
+
+// CompleteLease reports a subrange as fully searched
+func (c *Client) CompleteLease(l Lease, progress uint64) (Lease, error) {
+	return c.leaseCall(fmt.Sprintf("/leases/%d/done", l.ID), url.Values{
+		"worker":   {l.Worker},
+		"progress": {strconv.FormatUint(progress, 10)},
+	})
+}
+
+func (c *Client) leaseCall(path string, form url.Values) (Lease, error) {
+	var l Lease
+
+	req, err := http.NewRequest(http.MethodPost, c.base+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return l, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.authorize(req)
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return l, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode != http.StatusOK {
+		return l, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, &l); err != nil {
+		return l, fmt.Errorf("invalid server response: %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamContentType matches server.StreamContentType
+const streamContentType = "application/x-rckangaroo-stream"
+
+// streamAck is the server's answer to one frame of a stream
+type streamAck struct {
+	Seq   int    `json:"seq"`
+	Error string `json:"error"`
+	Result
+}
+
+// drainStream uploads spooled batches as frames of one POST /dps/stream
+// request instead of a request each. Frames are written without waiting
+// for acks; each file is removed once its ack arrives, so a broken stream
+// only resends the unacknowledged tail, which the server recognizes.
+func (c *Client) drainStream(files []string) (retry bool) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, c.base+"/dps/stream", pr)
+	if err != nil {
+		c.logf("Error creating stream: %v", err)
+		return true
+	}
+	req.Header.Set("Content-Type", streamContentType)
+	c.authorize(req)
+
+	go func() {
+		pw.CloseWithError(c.writeFrames(pw, files))
+	}()
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		c.logf("Stream failed: %v", err)
+		return true
+	}
+	defer resp.Body.Close()
+	defer pr.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		c.logf("Server refused stream: %s %s", resp.Status, strings.TrimSpace(string(body)))
+		return true
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for i, file := range files {
+		var ack streamAck
+		if err := dec.Decode(&ack); err != nil {
+			c.logf("Stream ended after %d of %d batches: %v", i, len(files), err)
+			return true
+		}
+		if ack.Seq != i+1 {
+			c.logf("Stream out of step: got ack %d, expected %d", ack.Seq, i+1)
+			return true
+		}
+
+		if ack.Error != "" {
+			c.logf("Server rejected %s: %s; moving it aside", filepath.Base(file), ack.Error)
+			os.Rename(file, file+".rejected")
+			continue
+		}
+		os.Remove(file)
+		c.logUploaded(file, ack.Result)
+		if c.opts.OnResult != nil {
+			c.opts.OnResult(ack.Result)
+		}
+	}
+	return false
+}
+
+// writeFrames writes each file as a 4-byte big-endian length followed by
+// its contents, stopping early if the client is closed
+func (c *Client) writeFrames(w io.Writer, files []string) error {
+	for _, file := range files {
+		select {
+		case <-c.stop:
+			return nil
+		default:
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > int64(^uint32(0)) {
+			return fmt.Errorf("%s is too large to stream", filepath.Base(file))
+		}
+
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+		if _, err := w.Write(size[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
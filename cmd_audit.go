@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"rckangaroo/fastbase"
+)
+
+// auditThreshold is how unlikely by chance a finding must be to count as
+// an anomaly
+const auditThreshold = 1e-6
+
+// auditCluster counts the findings sharing one origin
+type auditCluster struct {
+	key      string
+	findings int
+	records  int
+}
+
+func runAudit(args []string) int {
+	fs := newFlagSet("audit", "[-keys file] [-examples N] [-range bits] file.db")
+	keysFile := fs.String("keys", "", "The server's file of \"name token\" API keys, to name the workers of a database with provenance")
+	examples := fs.Int("examples", 5, "How many findings of each kind to print in full")
+	rangeBits := fs.Int("range", 0, "Range width in bits; read from the database header when 0")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	names, err := workerNames(*keysFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *rangeBits == 0 {
+		*rangeBits = int(fb.Header[fastbase.HeaderRange])
+	}
+
+	rep := fb.Audit()
+	schema := fb.Schema()
+	tc := rep.TypeCounts
+	fmt.Printf("\nAudit of %s: %d records (tame %d, wild1 %d, wild2 %d), range 2^%d\n",
+		fs.Arg(0), rep.Records, tc[0], tc[1], tc[2], *rangeBits)
+
+	// By chance, each same-type pair of records shares a distance with
+	// probability about 2^-range and an x-coordinate with 2^-(x bits)
+	pairs := 0.0
+	for _, n := range tc {
+		pairs += float64(n) * float64(n-1) / 2
+	}
+	anomalies := false
+
+	records, shared := 0, 0
+	for _, g := range rep.SameDistance {
+		records += len(g.Records)
+		shared += len(g.Records) * (len(g.Records) - 1) / 2
+	}
+	fmt.Printf("\nShared distances (one type, different x-coordinates)\n")
+	fmt.Printf("----------------------------------------\n")
+	expected := pairs * math.Pow(2, -float64(*rangeBits))
+	anomalies = auditVerdict(shared, fmt.Sprintf("%d distances shared by %d records (%d pairs)", len(rep.SameDistance), records, shared), expected) || anomalies
+	if fb.HasProvenance() {
+		printClusters("By origin", clusterDistanceGroups(fb, rep.SameDistance, names))
+	}
+	for i, g := range rep.SameDistance[:min(*examples, len(rep.SameDistance))] {
+		fmt.Printf("%d. %s distance %x\n", i+1, g.Type, g.Distance)
+		for _, m := range g.Records {
+			fmt.Printf("     [%02x %02x %02x] x=%x%s\n", m.Prefix[0], m.Prefix[1], m.Prefix[2], schema.X(m.Record), origin(fb, m.Record, names))
+		}
+	}
+
+	fmt.Printf("\nRepeated x-coordinates (one type, different distances)\n")
+	fmt.Printf("----------------------------------------\n")
+	expected = pairs * math.Pow(2, -8*float64(schema.XLength))
+	anomalies = auditVerdict(len(rep.SameX), fmt.Sprintf("%d pairs", len(rep.SameX)), expected) || anomalies
+	printClusters("By distance offset", clusterOffsets(rep.SameX))
+	if fb.HasProvenance() {
+		printClusters("By origin", clusterRepeatedX(fb, rep.SameX, names))
+	}
+	for i, p := range rep.SameX[:min(*examples, len(rep.SameX))] {
+		fmt.Printf("%d. %s x=%x offset %#x\n", i+1, schema.Type(p.First.Record), schema.X(p.First.Record), p.Offset)
+		for _, m := range []fastbase.Match{p.First, p.Second} {
+			fmt.Printf("     [%02x %02x %02x] distance %x%s\n", m.Prefix[0], m.Prefix[1], m.Prefix[2], schema.Distance(m.Record), origin(fb, m.Record, names))
+		}
+	}
+
+	fmt.Println()
+	if anomalies {
+		fmt.Printf("Result: anomalies found. Kangaroos sharing distances started alike, as from RNGs seeded alike;\n")
+		fmt.Printf("x-coordinates repeating at one offset come from kangaroos walking the same path.\n")
+		return 1
+	}
+	fmt.Printf("Result: no anomalies\n")
+	return 0
+}
+
+// auditVerdict prints how many findings there are against how many chance
+// explains, and reports whether they are an anomaly
+func auditVerdict(found int, what string, expected float64) bool {
+	p := poissonTail(expected, found)
+	improbable := found > 0 && p < auditThreshold
+	verdict := "ok"
+	if improbable {
+		verdict = "IMPROBABLE"
+	}
+	fmt.Printf("Found %s; %.3g expected by chance, P = %.3g: %s\n", what, expected, p, verdict)
+	return improbable
+}
+
+// poissonTail returns the probability of at least k events when lambda
+// are expected
+func poissonTail(lambda float64, k int) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if lambda <= 0 {
+		return 0
+	}
+	term := func(i int) float64 {
+		lg, _ := math.Lgamma(float64(i + 1))
+		return math.Exp(-lambda + float64(i)*math.Log(lambda) - lg)
+	}
+	if float64(k) <= lambda {
+		below := 0.0
+		for i := 0; i < k; i++ {
+			below += term(i)
+		}
+		return math.Max(0, 1-below)
+	}
+	// Past the mean the terms only shrink, so sum until they stop counting
+	sum := 0.0
+	for i := k; ; i++ {
+		t := term(i)
+		sum += t
+		if t < sum*1e-15 || t == 0 {
+			return sum
+		}
+	}
+}
+
+// origin describes who submitted a record, if the database records it
+func origin(fb *fastbase.FastBase, rec []byte, names map[uint32]string) string {
+	p, ok := fb.Provenance(rec)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("  worker %s %s", workerName(names, p.Worker), formatTime(p.Time))
+}
+
+// workerSet names the distinct workers that submitted the records
+func workerSet(fb *fastbase.FastBase, recs [][]byte, names map[uint32]string) string {
+	var set []string
+	for _, rec := range recs {
+		p, _ := fb.Provenance(rec)
+		if name := workerName(names, p.Worker); !slices.Contains(set, name) {
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, " + ")
+}
+
+// clusterDistanceGroups counts shared distances by the workers involved
+func clusterDistanceGroups(fb *fastbase.FastBase, groups []fastbase.DistanceGroup, names map[uint32]string) []auditCluster {
+	byKey := make(map[string]*auditCluster)
+	for _, g := range groups {
+		recs := make([][]byte, len(g.Records))
+		for i, m := range g.Records {
+			recs[i] = m.Record
+		}
+		addCluster(byKey, workerSet(fb, recs, names), len(recs))
+	}
+	return sortClusters(byKey)
+}
+
+// clusterRepeatedX counts repeated x-coordinates by the workers involved
+func clusterRepeatedX(fb *fastbase.FastBase, pairs []fastbase.RepeatedX, names map[uint32]string) []auditCluster {
+	byKey := make(map[string]*auditCluster)
+	for _, p := range pairs {
+		addCluster(byKey, workerSet(fb, [][]byte{p.First.Record, p.Second.Record}, names), 2)
+	}
+	return sortClusters(byKey)
+}
+
+// clusterOffsets counts repeated x-coordinates by the offset between the
+// distances, which two kangaroos walking one path keep
+func clusterOffsets(pairs []fastbase.RepeatedX) []auditCluster {
+	byKey := make(map[string]*auditCluster)
+	for _, p := range pairs {
+		addCluster(byKey, "0x"+new(big.Int).Abs(p.Offset).Text(16), 2)
+	}
+	return sortClusters(byKey)
+}
+
+func addCluster(byKey map[string]*auditCluster, key string, records int) {
+	c := byKey[key]
+	if c == nil {
+		c = &auditCluster{key: key}
+		byKey[key] = c
+	}
+	c.findings++
+	c.records += records
+}
+
+// sortClusters returns the clusters with the most findings first
+func sortClusters(byKey map[string]*auditCluster) []auditCluster {
+	clusters := make([]auditCluster, 0, len(byKey))
+	for _, c := range byKey {
+		clusters = append(clusters, *c)
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		if clusters[a].findings != clusters[b].findings {
+			return clusters[a].findings > clusters[b].findings
+		}
+		return clusters[a].key < clusters[b].key
+	})
+	return clusters
+}
+
+// auditClustersShown bounds the clusters printed of each kind
+const auditClustersShown = 10
+
+func printClusters(title string, clusters []auditCluster) {
+	if len(clusters) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, c := range clusters[:min(auditClustersShown, len(clusters))] {
+		fmt.Printf("  %-40s %8d findings %10d records\n", c.key, c.findings, c.records)
+	}
+	if len(clusters) > auditClustersShown {
+		fmt.Printf("  ... and %d more\n", len(clusters)-auditClustersShown)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/lsm"
+	"rckangaroo/sqlite"
+)
+
+// autotuneHeadroom is the share of memory a FastBase may be planned to
+// fill, leaving the rest to the Go runtime and the garbage collector
+const autotuneHeadroom = 0.75
+
+// autotuneWeights weigh the insert, load and lookup cost of a storage mode
+// for each access pattern
+var autotuneWeights = map[string][3]float64{
+	"insert": {0.6, 0.2, 0.2},
+	"lookup": {0.2, 0.2, 0.6},
+	"mixed":  {1.0 / 3, 1.0 / 3, 1.0 / 3},
+}
+
+// storageMode is a way of keeping a database that autotune measures
+type storageMode struct {
+	name     string
+	inMemory bool // The whole database is loaded into a FastBase
+	snippet  string
+}
+
+// autotuneResult is the measurement of one storage mode on the sample, in
+// seconds per record for each phase
+type autotuneResult struct {
+	mode                 storageMode
+	insert, load, lookup float64
+	memory               float64 // Estimated bytes in memory at the expected size
+}
+
+// cost returns the weighted seconds per record of the result for weights
+func (r autotuneResult) cost(w [3]float64) float64 {
+	return w[0]*r.insert + w[1]*r.load + w[2]*r.lookup
+}
+
+func runAutotune(args []string) int {
+	fs := newFlagSet("autotune", "-records N [-mem size] [-pattern insert|lookup|mixed] [-sample N] [-seed N] [-dir path] [-snippet]")
+	recordsStr := fs.String("records", "", "DPs the database is expected to hold, e.g. 500M; see tune for the DPs a range needs")
+	memStr := fs.String("mem", "", "Memory of the host for the database, e.g. 64GB (default: the total memory of this host)")
+	pattern := fs.String("pattern", "mixed", "Access pattern to optimise for: insert (DPs arriving), lookup (collision checks, mirrors) or mixed")
+	sampleStr := fs.String("sample", "200K", "Records each mode is measured with")
+	seed := fs.Int64("seed", 1, "Seed of the random generator")
+	dir := fs.String("dir", os.TempDir(), "Directory for the files written while measuring; use the disk the database will live on")
+	snippet := fs.Bool("snippet", false, "Print the flags of the recommended mode ready to paste")
+	fs.Parse(args)
+
+	weights, ok := autotuneWeights[*pattern]
+	if fs.NArg() != 0 || *recordsStr == "" || !ok {
+		fs.Usage()
+		return 1
+	}
+	records, err := parseCount(*recordsStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	sample, err := parseCount(*sampleStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var mem float64
+	if *memStr != "" {
+		mem, err = parseSize(*memStr)
+	} else {
+		mem, err = systemMemory()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v (give -mem)\n", err)
+		return 1
+	}
+
+	work, err := os.MkdirTemp(*dir, "rckangaroo-autotune-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(work)
+
+	rng := rand.New(rand.NewSource(*seed))
+	recs := generateRecords(rng, sample, 76)
+	fmt.Printf("Measuring %d records per mode in %s for %d expected DPs, %s of memory, %s pattern\n\n",
+		sample, *dir, records, formatBytes(int64(mem)), *pattern)
+	fmt.Printf("%-8s %12s %12s %12s  %-11s %s\n", "Mode", "Insert/s", "Load/s", "Lookup/s", "Memory", "Fits")
+
+	layout := fastbase.DefaultLayout
+	inMemory := layout.EstimateMemory(float64(records))
+	modes := []struct {
+		mode storageMode
+		run  func() (autotuneResult, error)
+	}{
+		{storageMode{"flat", true, "-db pool.db -journal"}, func() (autotuneResult, error) {
+			path := filepath.Join(work, "flat.db")
+			return measureInMemory(recs, func(fb *fastbase.FastBase) error { return fb.SaveToFile(path) },
+				func(fb *fastbase.FastBase) error { return fb.LoadFromFile(path) })
+		}},
+		{storageMode{"sharded", true, "-db pool.shards   (mkdir pool.shards first)"}, func() (autotuneResult, error) {
+			path := filepath.Join(work, "shards")
+			return measureInMemory(recs, func(fb *fastbase.FastBase) error { return fb.SaveSharded(path) },
+				func(fb *fastbase.FastBase) error { return fb.LoadSharded(path) })
+		}},
+		{storageMode{"sqlite", true, "-db sqlite:pool.sqlite"}, func() (autotuneResult, error) {
+			path := filepath.Join(work, "pool.sqlite")
+			return measureInMemory(recs, func(fb *fastbase.FastBase) error { return fb.SaveToSQLite(path) },
+				func(fb *fastbase.FastBase) error { return fb.LoadFromSQLite(path) })
+		}},
+		{storageMode{"lsm", false, fmt.Sprintf("ingest -store pool.store -mem-records %d", lsm.DefaultMemRecords)}, func() (autotuneResult, error) {
+			return measureLSM(recs, filepath.Join(work, "store"))
+		}},
+	}
+
+	var results []autotuneResult
+	for _, m := range modes {
+		res, err := m.run()
+		if errors.Is(err, sqlite.ErrNoSQLite) {
+			fmt.Printf("%-8s %12s\n", m.mode.name, "not built in")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error measuring %s: %v\n", m.mode.name, err)
+			return 1
+		}
+		res.mode = m.mode
+		res.memory = inMemory
+		if !m.mode.inMemory {
+			// The records in memory before a run is written, and the
+			// sparse index of the runs
+			res.memory = float64(lsm.DefaultMemRecords*(layout.RecordLength+48)) +
+				float64(records)/lsm.DefaultBlockLength*float64(fastbase.SchemaStandard.XLength+16)
+		}
+		results = append(results, res)
+		fits := "yes"
+		if res.memory > mem*autotuneHeadroom {
+			fits = "no"
+		}
+		fmt.Printf("%-8s %12.0f %12.0f %12.0f  %-11s %s\n", res.mode.name, 1/res.insert, 1/res.load, 1/res.lookup,
+			formatBytes(int64(res.memory)), fits)
+	}
+
+	// The cheapest mode that fits, ties going to the one listed first
+	var best *autotuneResult
+	for i := range results {
+		r := &results[i]
+		if r.memory > mem*autotuneHeadroom {
+			continue
+		}
+		if best == nil || r.cost(weights) < best.cost(weights) {
+			best = r
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case best == nil:
+		fmt.Printf("Recommended: none; even the on-disk store's index needs more than %.0f%% of %s.\n",
+			autotuneHeadroom*100, formatBytes(int64(mem)))
+		return 1
+	case !best.mode.inMemory && inMemory <= mem*autotuneHeadroom:
+		fmt.Printf("Recommended: %s, the cheapest for the %s pattern, though %d DPs would also fit in memory (about %s).\n",
+			best.mode.name, *pattern, records, formatBytes(int64(inMemory)))
+	case !best.mode.inMemory && inMemory <= 2*mem*autotuneHeadroom:
+		// Tiering keeps the hot pools in memory and beats the store when
+		// most lookups land on them
+		budget := int64(mem * autotuneHeadroom)
+		fmt.Printf("Recommended: %s. %d DPs need about %s in memory, which does not fit,\n", best.mode.name, records, formatBytes(int64(inMemory)))
+		fmt.Printf("but is within twice the budget: follow -ram-budget %s spills the pools used least to\n", formatBytes(budget))
+		fmt.Printf("disk and is the better choice if lookups mostly hit recent DPs.\n")
+	case !best.mode.inMemory:
+		fmt.Printf("Recommended: %s. %d DPs need about %s in memory, which does not fit.\n", best.mode.name, records, formatBytes(int64(inMemory)))
+	default:
+		fmt.Printf("Recommended: %s, the cheapest for the %s pattern of the modes whose %d DPs fit in memory.\n", best.mode.name, *pattern, records)
+		fmt.Printf("Loading it at that size takes about %s.\n", formatETA(best.load*float64(records)))
+	}
+	if *snippet {
+		fmt.Printf("\n%s\n", best.mode.snippet)
+	}
+	return 0
+}
+
+// measureInMemory measures a mode that loads the whole database into a
+// FastBase: records added and saved with save, loaded with load, and
+// looked up in the loaded database
+func measureInMemory(recs [][]byte, save, load func(*fastbase.FastBase) error) (autotuneResult, error) {
+	var res autotuneResult
+	n := float64(len(recs))
+	fb := fastbase.NewFastBase()
+	r, err := measure("insert", len(recs), func() error {
+		if _, _, err := fb.AddRecords(recs); err != nil {
+			return err
+		}
+		return save(fb)
+	})
+	if err != nil {
+		return res, err
+	}
+	res.insert = r.elapsed.Seconds() / n
+
+	loaded := fastbase.NewFastBase()
+	if r, err = measure("load", len(recs), func() error { return load(loaded) }); err != nil {
+		return res, err
+	}
+	res.load = r.elapsed.Seconds() / n
+
+	r, err = measure("lookup", len(recs), func() error {
+		for _, rec := range recs {
+			if loaded.FindDataBlock(append(rec[:3:3], rec...)) == nil {
+				return fmt.Errorf("record %x not found", rec)
+			}
+		}
+		return nil
+	})
+	res.lookup = r.elapsed.Seconds() / n
+	return res, err
+}
+
+// measureLSM measures an on-disk store in dir: records added and flushed
+// to a run, the store reopened, and records looked up in it
+func measureLSM(recs [][]byte, dir string) (autotuneResult, error) {
+	var res autotuneResult
+	n := float64(len(recs))
+	schema := fastbase.SchemaStandard
+	store, err := lsm.Open(dir, schema, lsm.Options{})
+	if err != nil {
+		return res, err
+	}
+	r, err := measure("insert", len(recs), func() error {
+		if _, err := store.Add(recs); err != nil {
+			return err
+		}
+		return store.Flush()
+	})
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+	res.insert = r.elapsed.Seconds() / n
+
+	if r, err = measure("load", len(recs), func() error {
+		store, err = lsm.Open(dir, schema, lsm.Options{})
+		return err
+	}); err != nil {
+		return res, err
+	}
+	defer store.Close()
+	res.load = r.elapsed.Seconds() / n
+
+	r, err = measure("lookup", len(recs), func() error {
+		for _, rec := range recs {
+			found, err := store.Lookup(schema.X(rec))
+			if err != nil {
+				return err
+			}
+			if len(found) == 0 {
+				return fmt.Errorf("record %x not found", rec)
+			}
+		}
+		return nil
+	})
+	res.lookup = r.elapsed.Seconds() / n
+	return res, err
+}
+
+// systemMemory returns the total memory of the host, from /proc/meminfo
+func systemMemory() (float64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errors.New("cannot tell the memory of this host")
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			if kb, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				return kb * 1024, nil
+			}
+		}
+	}
+	return 0, errors.New("cannot tell the memory of this host")
+}
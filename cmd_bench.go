@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// benchResult is the measurement of one benchmark phase
+type benchResult struct {
+	name    string
+	ops     int
+	elapsed time.Duration
+	allocs  uint64
+	bytes   uint64
+}
+
+func runBench(args []string) int {
+	fs := newFlagSet("bench", "[-records N] [-lookups N] [-merge N] [-seed N] [-dir path]")
+	recordsStr := fs.String("records", "1M", "Records added one by one with AddRecord, then saved and loaded")
+	lookupsStr := fs.String("lookups", "1M", "FindDataBlock lookups of present and of absent records each")
+	mergeStr := fs.String("merge", "250K", "Records of the database merged into the loaded one, half of them already present")
+	seed := fs.Int64("seed", 1, "Seed of the random generator")
+	dir := fs.String("dir", os.TempDir(), "Directory for the file written by the save and load phases")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	var sizes [3]int
+	for i, s := range []string{*recordsStr, *lookupsStr, *mergeStr} {
+		n, err := parseCount(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		sizes[i] = n
+	}
+	n, lookups, merged := sizes[0], sizes[1], sizes[2]
+
+	rng := rand.New(rand.NewSource(*seed))
+	records := generateRecords(rng, n, 76)
+	hits := make([][]byte, lookups)
+	for i := range hits {
+		rec := records[rng.Intn(n)]
+		hits[i] = append(rec[:3:3], rec...)
+	}
+	misses := make([][]byte, lookups)
+	for i, rec := range generateRecords(rng, lookups, 76) {
+		misses[i] = append(rec[:3:3], rec...)
+	}
+	src := fastbase.NewFastBase()
+	extra := append(generateRecords(rng, merged-merged/2, 76), records[:min(merged/2, n)]...)
+	if _, _, err := src.AddRecords(extra); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	filename := filepath.Join(*dir, fmt.Sprintf("rckangaroo-bench-%d.db", os.Getpid()))
+	defer os.Remove(filename)
+
+	fmt.Printf("Benchmarking %d records, %d lookups, %d merged records (seed %d)\n\n", n, lookups, len(extra), *seed)
+	fmt.Printf("%-10s %10s %10s %14s %12s %12s\n", "Phase", "Ops", "Time", "Ops/s", "Allocs/op", "Bytes/op")
+
+	fb := fastbase.NewFastBase()
+	loaded := fastbase.NewFastBase()
+	phases := []struct {
+		name string
+		ops  int
+		run  func() error
+	}{
+		{"add", n, func() error {
+			for _, rec := range records {
+				if _, err := fb.AddRecord(rec[0], rec[1], rec[2], rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"find-hit", lookups, func() error {
+			for _, key := range hits {
+				if fb.FindDataBlock(key) == nil {
+					return fmt.Errorf("record %x not found", key[3:])
+				}
+			}
+			return nil
+		}},
+		{"find-miss", lookups, func() error {
+			for _, key := range misses {
+				fb.FindDataBlock(key)
+			}
+			return nil
+		}},
+		{"save", n, func() error { return fb.SaveToFile(filename) }},
+		{"load", n, func() error { return loaded.LoadFromFile(filename) }},
+		{"merge", len(extra), func() error {
+			_, err := loaded.Merge(src)
+			return err
+		}},
+	}
+	for _, p := range phases {
+		res, err := measure(p.name, p.ops, p.run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error in %s: %v\n", p.name, err)
+			return 1
+		}
+		fmt.Println(res)
+	}
+	return 0
+}
+
+// measure runs fn after a garbage collection and returns its duration and
+// the allocations it made
+func measure(name string, ops int, fn func() error) (benchResult, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return benchResult{
+		name:    name,
+		ops:     ops,
+		elapsed: elapsed,
+		allocs:  after.Mallocs - before.Mallocs,
+		bytes:   after.TotalAlloc - before.TotalAlloc,
+	}, err
+}
+
+func (r benchResult) String() string {
+	ops := max(r.ops, 1)
+	return fmt.Sprintf("%-10s %10d %10s %14.0f %12.2f %12.1f", r.name, r.ops, r.elapsed.Round(time.Millisecond),
+		float64(r.ops)/r.elapsed.Seconds(), float64(r.allocs)/float64(ops), float64(r.bytes)/float64(ops))
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"rckangaroo/fastbase"
+)
+
+// Views of the browser
+const (
+	viewPrefixes = iota
+	viewRecords
+	viewHeader
+)
+
+// browsePrefix is a prefix holding records and how many
+type browsePrefix struct {
+	prefix [3]byte
+	count  uint32
+}
+
+// browser is the state of the interactive database browser
+type browser struct {
+	fb       *fastbase.FastBase
+	filename string
+	where    []fastbase.Filter
+	term     *terminal
+
+	prefixes   []browsePrefix
+	pcur, ptop int // Cursor and first shown row of the prefix view
+
+	records    [][]byte // Records of the open prefix passing the filters
+	rcur, rtop int
+
+	view, back int // Current view, and the one the header view returns to
+	typ        int // Type shown in the records view, or -1 for all
+	status     string
+	stats      *fastbase.StatsReport // Computed when the header view is first opened
+}
+
+func runBrowse(args []string) int {
+	fs := newFlagSet("browse", "[-where expr] file.db")
+	where := fs.String("where", "", "Only show records matching a filter expression, e.g. type=wild1")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	filters, err := compileWhere(fb, *where)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	b := &browser{fb: fb, filename: fs.Arg(0), where: filters, typ: -1}
+	fb.EachPrefix(func(prefix [3]byte, count int) bool {
+		b.prefixes = append(b.prefixes, browsePrefix{prefix, uint32(count)})
+		return true
+	})
+
+	b.term, err = openTerminal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer b.term.close()
+	b.run()
+	return 0
+}
+
+// run draws the screen and handles keys until the user quits
+func (b *browser) run() {
+	b.status = "Enter opens a prefix, / jumps to an x-coordinate, t filters by type, i shows the header, q quits"
+	for {
+		rows, cols := b.term.size()
+		b.draw(rows, cols)
+		key, err := b.term.readKey()
+		if err != nil {
+			return
+		}
+		b.status = ""
+		page := rows - 3
+
+		switch key {
+		case "q", "ctrl-c":
+			return
+		case "i":
+			if b.view == viewHeader {
+				b.view = b.back
+			} else {
+				b.back, b.view = b.view, viewHeader
+			}
+			continue
+		case "t":
+			b.typ++
+			if b.typ > int(fastbase.TypeWild2) {
+				b.typ = -1
+			}
+			if b.view == viewRecords {
+				b.openPrefix(0)
+			}
+			continue
+		case "/":
+			if x, ok := b.term.readLine(rows, "x-coordinate (hex, at least 3 bytes): "); ok {
+				b.jump(x)
+			}
+			continue
+		}
+
+		switch b.view {
+		case viewPrefixes:
+			if key == "enter" || key == "right" || key == "l" {
+				if len(b.prefixes) > 0 {
+					b.openPrefix(0)
+				}
+				continue
+			}
+			moveCursor(key, &b.pcur, &b.ptop, len(b.prefixes), page)
+		case viewRecords:
+			if key == "esc" || key == "left" || key == "h" || key == "backspace" {
+				b.view = viewPrefixes
+				continue
+			}
+			moveCursor(key, &b.rcur, &b.rtop, len(b.records), page)
+		case viewHeader:
+			if key == "esc" || key == "left" || key == "h" || key == "backspace" {
+				b.view = b.back
+			}
+		}
+	}
+}
+
+// moveCursor applies a navigation key to a cursor over n rows, keeping it
+// within the page of rows starting at top
+func moveCursor(key string, cur, top *int, n, page int) {
+	switch key {
+	case "up", "k":
+		*cur--
+	case "down", "j":
+		*cur++
+	case "pgup", "b":
+		*cur -= page
+		*top -= page
+	case "pgdn", " ":
+		*cur += page
+		*top += page
+	case "home", "g":
+		*cur = 0
+	case "end", "G":
+		*cur = n - 1
+	}
+	clampCursor(cur, top, n, page)
+}
+
+// clampCursor keeps a cursor on one of n rows and the page around it
+func clampCursor(cur, top *int, n, page int) {
+	*cur = max(0, min(*cur, n-1))
+	*top = max(0, min(*top, n-page))
+	if *cur < *top {
+		*top = *cur
+	}
+	if *cur >= *top+page {
+		*top = *cur - page + 1
+	}
+}
+
+// filters returns the -where filters plus the type filter
+func (b *browser) filters() []fastbase.Filter {
+	if b.typ < 0 {
+		return b.where
+	}
+	schema, typ := b.fb.Schema(), fastbase.KangarooType(b.typ)
+	return append(b.where[:len(b.where):len(b.where)], func(prefix [3]byte, rec []byte) bool {
+		return schema.Type(rec) == typ
+	})
+}
+
+// openPrefix shows the records of the prefix under the cursor with the
+// cursor on record cur
+func (b *browser) openPrefix(cur int) {
+	b.records = b.fb.ListRecords(b.prefixes[b.pcur].prefix, b.filters()...)
+	b.rcur, b.rtop = cur, cur
+	b.view = viewRecords
+}
+
+// jump moves to the first record whose x-coordinate is at or after x
+func (b *browser) jump(s string) {
+	x, err := hex.DecodeString(strings.TrimPrefix(strings.ReplaceAll(s, " ", ""), "0x"))
+	if err != nil || len(x) < 3 {
+		b.status = fmt.Sprintf("Invalid x-coordinate %q", s)
+		return
+	}
+	schema := b.fb.Schema()
+	if len(x) > schema.XLength {
+		x = x[:schema.XLength]
+	}
+	prefix := [3]byte{x[0], x[1], x[2]}
+	b.pcur = sort.Search(len(b.prefixes), func(i int) bool {
+		return bytes.Compare(b.prefixes[i].prefix[:], prefix[:]) >= 0
+	})
+	if b.pcur == len(b.prefixes) || b.prefixes[b.pcur].prefix != prefix {
+		b.pcur = min(b.pcur, len(b.prefixes)-1)
+		b.view = viewPrefixes
+		b.status = fmt.Sprintf("No records under [%02x %02x %02x]", prefix[0], prefix[1], prefix[2])
+		return
+	}
+
+	b.openPrefix(0)
+	b.rcur = sort.Search(len(b.records), func(i int) bool {
+		return bytes.Compare(schema.X(b.records[i])[:len(x)], x) >= 0
+	})
+	b.rtop = b.rcur
+	if b.rcur < len(b.records) && bytes.Equal(schema.X(b.records[b.rcur])[:len(x)], x) {
+		b.status = fmt.Sprintf("Found x-coordinate %x", x)
+	} else {
+		b.status = fmt.Sprintf("No record with x-coordinate %x; showing the next one", x)
+	}
+}
+
+// draw redraws the whole screen
+func (b *browser) draw(rows, cols int) {
+	out := b.term.out
+	out.WriteString("\x1b[H")
+
+	// line writes one row cut to the width, in reverse video if inverse
+	line := func(s string, inverse bool) {
+		if len(s) > cols {
+			s = s[:cols]
+		}
+		if inverse {
+			s = "\x1b[7m" + s + "\x1b[0m"
+		}
+		out.WriteString("\x1b[2K" + s + "\r\n")
+	}
+
+	typ := "all types"
+	if b.typ >= 0 {
+		typ = "type " + fastbase.KangarooType(b.typ).String()
+	}
+	title := fmt.Sprintf(" %s  %d prefixes  %s schema  %s", filepath.Base(b.filename), len(b.prefixes), b.fb.Schema().Name, typ)
+	line(fmt.Sprintf("%-*s", cols, title), true)
+
+	// The body is a heading and a page of rows, one of them under the cursor
+	var body []string
+	selected := -1
+	page := rows - 3
+	switch b.view {
+	case viewPrefixes:
+		body = append(body, fmt.Sprintf("   %-10s %10s", "Prefix", "Records"))
+		clampCursor(&b.pcur, &b.ptop, len(b.prefixes), page)
+		for i := b.ptop; i < min(b.ptop+page, len(b.prefixes)); i++ {
+			if i == b.pcur {
+				selected = len(body)
+			}
+			p := b.prefixes[i]
+			body = append(body, fmt.Sprintf("[%02x %02x %02x] %10d", p.prefix[0], p.prefix[1], p.prefix[2], p.count))
+		}
+	case viewRecords:
+		p := b.prefixes[b.pcur]
+		body = append(body, fmt.Sprintf("   Records under [%02x %02x %02x]: %d of %d shown", p.prefix[0], p.prefix[1], p.prefix[2], len(b.records), p.count))
+		clampCursor(&b.rcur, &b.rtop, len(b.records), page)
+		schema := b.fb.Schema()
+		for i := b.rtop; i < min(b.rtop+page, len(b.records)); i++ {
+			if i == b.rcur {
+				selected = len(body)
+			}
+			rec := b.records[i]
+			body = append(body, fmt.Sprintf("%5d %x %x %s", i+1, schema.X(rec), schema.Distance(rec), schema.Type(rec)))
+		}
+	case viewHeader:
+		body = b.headerLines()
+	}
+	for i := 0; i < rows-2; i++ {
+		switch {
+		case i >= len(body):
+			line("", false)
+		case i == 0 || b.view == viewHeader:
+			line(body[i], false)
+		case i == selected:
+			line(" > "+body[i], true)
+		default:
+			line("   "+body[i], false)
+		}
+	}
+
+	status := b.status
+	if status == "" {
+		switch b.view {
+		case viewPrefixes:
+			status = "Up/Down PgUp/PgDn Home/End move  Enter open  / jump  t type  i header  q quit"
+		case viewRecords:
+			status = "Up/Down PgUp/PgDn Home/End move  Esc back  / jump  t type  i header  q quit"
+		default:
+			status = "Esc back  q quit"
+		}
+	}
+	if len(status) > cols {
+		status = status[:cols]
+	}
+	out.WriteString("\x1b[2K" + status)
+	out.Flush()
+}
+
+// headerLines describes the header and the contents of the database
+func (b *browser) headerLines() []string {
+	if b.stats == nil {
+		report := b.fb.Stats()
+		b.stats = &report
+	}
+	h, layout, schema := b.fb.Header, b.fb.Layout(), b.fb.Schema()
+	lines := []string{
+		"   Header",
+		fmt.Sprintf("   Bytes 0-15:       % x", h[:16]),
+		fmt.Sprintf("   Range:            2^%d", h[fastbase.HeaderRange]),
+		fmt.Sprintf("   DP bits:          %d", h[fastbase.HeaderDPBits]),
+		fmt.Sprintf("   Format version:   %d", h[fastbase.HeaderVersion]),
+		fmt.Sprintf("   Schema:           %s (%d)", schema.Name, schema.ID),
+		fmt.Sprintf("   Record length:    %d", layout.RecordLength),
+		fmt.Sprintf("   Compare length:   %d", layout.CompareLength),
+		fmt.Sprintf("   Prefix depth:     %d", layout.PrefixDepth),
+		fmt.Sprintf("   Page size:        %s", formatBytes(int64(layout.PageSize))),
+		fmt.Sprintf("   Snapshot ID:      %016x", binary.LittleEndian.Uint64(h[fastbase.HeaderSnapshot:])),
+		"",
+		"   Contents",
+		fmt.Sprintf("   Records:          %d", b.stats.TotalRecords),
+		fmt.Sprintf("   Prefixes:         %d", len(b.prefixes)),
+		fmt.Sprintf("   Largest list:     %d", b.stats.MaxListSize),
+	}
+	for t, ts := range b.stats.Types {
+		lines = append(lines, fmt.Sprintf("   %-17s %d", fastbase.KangarooType(t).String()+":", ts.Count))
+	}
+	lines = append(lines, fmt.Sprintf("   Memory:           %s", formatBytes(b.fb.MemoryUsage())))
+	return lines
+}
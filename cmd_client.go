@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/client"
+	"rckangaroo/fastbase"
+)
+
+func runClient(args []string) int {
+	fs := newFlagSet("client", "-server host:port|https://host:port [-token T] [-spool dir] [-stream] [file.db ...]   (reads DP lines from stdin when no database is given)")
+	serverAddr := fs.String("server", "", "Pool server to upload to, as host:port or URL")
+	spoolDir := fs.String("spool", "rckangaroo-spool", "Directory for batches not yet accepted by the server")
+	spoolMax := fs.String("spool-max", "4GB", "Most the spool may hold while the server is unreachable; the oldest batches are dropped beyond it, 0 keeps all")
+	batchSize := fs.Int("batch", client.DefaultBatchSize, "Records per uploaded batch")
+	flushEvery := fs.Duration("flush", client.DefaultFlushInterval, "Longest a partial batch waits before upload")
+	schemaName := fs.String("schema", fastbase.SchemaStandard.Name, "Record schema of DP lines read from stdin")
+	token := fs.String("token", "", "API key for the pool server")
+	caFile := fs.String("ca", "", "PEM file of CA certificates to trust for an https server, e.g. a self-signed one")
+	stream := fs.Bool("stream", false, "Send batches over one streaming request with pipelined acks instead of a request each")
+	drainTimeout := fs.Duration("drain-timeout", time.Minute, "How long to keep retrying spooled batches before exiting")
+	fs.Parse(args)
+
+	if *serverAddr == "" {
+		fs.Usage()
+		return 1
+	}
+
+	schema, err := fastbase.SchemaByName(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Databases determine their own schema; all must agree with the client's
+	var dbs []*fastbase.FastBase
+	for _, filename := range fs.Args() {
+		fb, err := loadDatabase(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if len(dbs) == 0 {
+			schema = fb.Schema()
+		} else if fb.Schema().ID != schema.ID {
+			fmt.Fprintf(os.Stderr, "Error: %s uses the %s schema, not %s\n", filename, fb.Schema().Name, schema.Name)
+			return 1
+		}
+		dbs = append(dbs, fb)
+	}
+
+	httpClient, err := serverHTTPClient(*caFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	maxSpool, err := parseSize(*spoolMax)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	c, err := client.New(client.Options{
+		Server:        *serverAddr,
+		Token:         *token,
+		HTTPClient:    httpClient,
+		SpoolDir:      *spoolDir,
+		Schema:        schema,
+		BatchSize:     *batchSize,
+		FlushInterval: *flushEvery,
+		Stream:        *stream,
+		MaxSpool:      int64(maxSpool),
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+		},
+		OnResult: func(r client.Result) {
+			for _, c := range r.Collisions {
+				fmt.Printf("\nCOLLISION reported by server: %s\n", c)
+			}
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	queued := 0
+	if len(dbs) > 0 {
+		for _, fb := range dbs {
+			fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+				err = c.Add(prefix, rec)
+				queued++
+				return err == nil
+			})
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "prefix,") {
+				continue
+			}
+			prefix, rec, perr := fastbase.ParseTextRecord(schema, line)
+			if perr != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping line %d: %v\n", lineNo, perr)
+				continue
+			}
+			if err = c.Add(prefix, rec); err != nil {
+				break
+			}
+			queued++
+		}
+		if err == nil {
+			err = scanner.Err()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	closeErr := c.Close(*drainTimeout)
+	if closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", closeErr)
+	}
+
+	fmt.Printf("Queued %d records\n", queued)
+	if n := c.Pending(); n > 0 {
+		fmt.Printf("%d batches remain spooled in %s and will be sent on the next run\n", n, *spoolDir)
+	}
+	if err != nil || closeErr != nil {
+		return 1
+	}
+	return 0
+}
+
+// serverHTTPClient returns the client to reach a pool server with, trusting
+// only the CA certificates of caFile if given
+func serverHTTPClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+func runCollisions(args []string) int {
+	fs := newFlagSet("collisions", "[-near] [-pubkey <hex> -start <hex> -range <bits> | -puzzle N] file.db")
+	near := fs.Bool("near", false, "Also list near collisions: records of one kangaroo type on the same x-coordinate at different distances")
+	pubKey := fs.String("pubkey", "", "Public key to derive and verify private keys for (optional)")
+	start := fs.String("start", "", "Start offset of the key range, in hex")
+	bits := fs.Int("range", 0, "Bit range of the private key")
+	puzzle := fs.Int("puzzle", 0, puzzleUsage)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	// Key derivation is only attempted when a target is given
+	var target *kangaroo.Target
+	if *pubKey != "" || *start != "" || *bits != 0 || *puzzle != 0 {
+		if err := applyPuzzle(*puzzle, pubKey, start, bits); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		t, err := parseTarget(*pubKey, *start, *bits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		target = &t
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	schema := fb.Schema()
+	collisions := fb.FindCollisions()
+	fmt.Printf("\nFound %d collision pairs\n", len(collisions))
+	fmt.Printf("----------------------------------------\n")
+	solved := solvePairs(target, schema, collisions, printCollision)
+	total := len(collisions)
+
+	if *near {
+		nears := fb.FindNearCollisions()
+		fmt.Printf("\nFound %d near collision pairs\n", len(nears))
+		fmt.Printf("----------------------------------------\n")
+		solved += solvePairs(target, schema, nears, printNearCollision)
+		total += len(nears)
+	}
+
+	if target != nil {
+		fmt.Printf("----------------------------------------\n")
+		fmt.Printf("Verified %d of %d pairs\n", solved, total)
+	}
+
+	return 0
+}
+
+// solvePairs prints each pair, and with a target the key it verifies, and
+// returns how many verified
+func solvePairs(target *kangaroo.Target, schema fastbase.Schema, pairs []fastbase.Collision, print func(int, fastbase.Schema, fastbase.Collision)) int {
+	solved := 0
+	for i, c := range pairs {
+		print(i+1, schema, c)
+		if target == nil {
+			continue
+		}
+		key, err := kangaroo.Solve(kangaroo.Symmetric{}, *target, schema, c.First, c.Second)
+		if err != nil {
+			fmt.Printf("  result:       %v\n", err)
+			continue
+		}
+		solved++
+		printKey("  ", key)
+	}
+	return solved
+}
+
+func printCollision(n int, schema fastbase.Schema, c fastbase.Collision) {
+	printPair("Collision", n, schema, c)
+}
+
+func printNearCollision(n int, schema fastbase.Schema, c fastbase.Collision) {
+	printPair("Near collision", n, schema, c)
+}
+
+func printPair(kind string, n int, schema fastbase.Schema, c fastbase.Collision) {
+	fmt.Printf("%s %d at [%02x %02x %02x]:\n", kind, n, c.Prefix[0], c.Prefix[1], c.Prefix[2])
+	for _, rec := range [][]byte{c.First, c.Second} {
+		fmt.Printf("  x=%x d=%x type=%s\n", schema.X(rec), schema.Distance(rec), schema.Type(rec))
+	}
+}
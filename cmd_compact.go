@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/query"
+)
+
+func runCompact(args []string) int {
+	fs := newFlagSet("compact", "[-remove expr] -out out.db in.db")
+	outFile := fs.String("out", "", "Path to write the compacted database to")
+	remove := fs.String("remove", "", "Remove the records matching this filter expression first, e.g. \"type==wild1 && distbits<100\"")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *outFile == "" {
+		fs.Usage()
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	removed := 0
+	if *remove != "" {
+		f, err := query.Compile(*remove, fb.Schema())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -remove expression: %v\n", err)
+			return 1
+		}
+		if removed, err = fb.Remove(f); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Removed %d records\n", removed)
+	}
+
+	fmt.Printf("Compacting...\n")
+	stats := fb.Compact()
+
+	fmt.Printf("Saving compacted database to: %s\n", *outFile)
+	if err := saveDatabase(fb, *outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving compacted file: %v\n", err)
+		return 1
+	}
+
+	// Object store URLs have no local size to compare
+	var sizeIn, sizeOut int64
+	fiIn, errIn := os.Stat(fs.Arg(0))
+	fiOut, errOut := os.Stat(*outFile)
+	if errIn == nil && errOut == nil {
+		sizeIn, sizeOut = fiIn.Size(), fiOut.Size()
+	}
+
+	fmt.Printf("\nCompaction Report:\n")
+	fmt.Printf("----------------------------------------\n")
+	if *remove != "" {
+		fmt.Printf("Records removed:    %d\n", removed)
+	}
+	fmt.Printf("Duplicates dropped: %d\n", stats.Duplicates)
+	fmt.Printf("Memory:             %d -> %d bytes (%d saved)\n",
+		stats.BytesBefore, stats.BytesAfter, stats.Reclaimed())
+	fmt.Printf("Pool pages:         %d -> %d\n", stats.PagesBefore, stats.PagesAfter)
+	if sizeOut > 0 {
+		fmt.Printf("File size:          %d -> %d bytes (%d saved)\n", sizeIn, sizeOut, sizeIn-sizeOut)
+	}
+
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+	"rckangaroo/secp256k1"
+)
+
+func runDerive(args []string) int {
+	fs := newFlagSet("derive", "-tame <record hex> -wild <record hex> (-rangestart <hex> -range <bits> [-pubkey <hex>] | -puzzle N) [-schema name]")
+	tameHex := fs.String("tame", "", "Tame record in hex, as printed by -format raw")
+	wildHex := fs.String("wild", "", "Wild record in hex; a wild pair also works, with the first given as -tame")
+	rangeStart := fs.String("rangestart", "", "Start of the key range, in hex")
+	bits := fs.Int("range", 0, "Width of the key range in bits, which sets Int_HalfRange")
+	pubKey := fs.String("pubkey", "", "Public key to verify the candidate against, in hex")
+	puzzle := fs.Int("puzzle", 0, "Bitcoin puzzle number; sets -rangestart, -range and, if published, -pubkey where not given")
+	schemaName := fs.String("schema", fastbase.SchemaStandard.Name, "Record schema: "+strings.Join(fastbase.SchemaNames(), ", "))
+	fs.Parse(args)
+
+	if err := applyPuzzle(*puzzle, pubKey, rangeStart, bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if fs.NArg() != 0 || *tameHex == "" || *wildHex == "" || *rangeStart == "" || *bits == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	schema, err := fastbase.SchemaByName(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var recs [2][]byte
+	for i, s := range []string{*tameHex, *wildHex} {
+		rec, err := hex.DecodeString(strings.TrimPrefix(strings.ReplaceAll(s, " ", ""), "0x"))
+		if err != nil || len(rec) != schema.RecordLength {
+			fmt.Fprintf(os.Stderr, "Error: records must be %d hex bytes for the %s schema\n", schema.RecordLength, schema.Name)
+			return 1
+		}
+		if t := schema.Type(rec); t > fastbase.TypeWild2 {
+			fmt.Fprintf(os.Stderr, "Error: invalid kangaroo type %d\n", t)
+			return 1
+		}
+		recs[i] = rec
+	}
+	r, err := kangaroo.NewRange(*rangeStart, *bits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	tame, wild := recs[0], recs[1]
+	dt := schema.DecodeDistance(schema.Distance(tame))
+	dw := schema.DecodeDistance(schema.Distance(wild))
+	fmt.Printf("Tame:          x=%x d=%s type=%s\n", schema.X(tame), dt, schema.Type(tame))
+	fmt.Printf("Wild:          x=%x d=%s type=%s\n", schema.X(wild), dw, schema.Type(wild))
+	if !bytes.Equal(schema.X(tame), schema.X(wild)) {
+		fmt.Printf("Warning: the records have different x-coordinates and do not collide\n")
+	}
+
+	// The documented arithmetic, shifted from the range back to the key
+	half := r.HalfRange()
+	k := kangaroo.Naive{}.Candidates(dt, schema.Type(tame), dw, schema.Type(wild), half)[0]
+	key := new(big.Int).Add(k, r.Start)
+	key.Mod(key, secp256k1.N)
+	fmt.Printf("\nCandidate:\n")
+	printKey("  ", key)
+
+	if *pubKey == "" {
+		return 0
+	}
+	target, err := parseTarget(*pubKey, *rangeStart, *bits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if _, ok := target.Verify(k); ok {
+		fmt.Printf("\nVerified: the candidate matches %x\n", target.PubKey.Compressed())
+		return 0
+	}
+
+	// Walks on x alone leave the signs of the distances open
+	solved, err := kangaroo.Solve(kangaroo.Symmetric{}, target, schema, tame, wild)
+	if err != nil {
+		fmt.Printf("\nNot verified: the candidate does not match %x\n", target.PubKey.Compressed())
+		return 1
+	}
+	fmt.Printf("\nNot verified, but a sign-flipped candidate matches %x:\n", target.PubKey.Compressed())
+	printKey("  ", solved)
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runDiff(args []string) int {
+	fs := newFlagSet("diff", "[-delta out.db] a.db b.db")
+	deltaFile := fs.String("delta", "", "Write the records only in b.db to this database file")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	fbA, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fbB, err := loadDatabase(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if fbA.Schema().ID != fbB.Schema().ID {
+		fmt.Fprintf(os.Stderr, "Error: cannot compare a %s database with a %s database\n",
+			fbA.Schema().Name, fbB.Schema().Name)
+		return 1
+	}
+
+	var delta *fastbase.FastBase
+	var onlyB func(prefix [3]byte, rec []byte)
+	var deltaErr error
+	if *deltaFile != "" {
+		delta = fastbase.NewFastBase()
+		delta.Header = fbB.Header
+		onlyB = func(prefix [3]byte, rec []byte) {
+			if deltaErr == nil {
+				_, deltaErr = delta.AddRecord(prefix[0], prefix[1], prefix[2], rec)
+			}
+		}
+	}
+
+	res := fbA.Diff(fbB, onlyB)
+
+	fmt.Printf("\nDiff of %s and %s:\n", fs.Arg(0), fs.Arg(1))
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("Only in A: %d\n", res.OnlyA)
+	fmt.Printf("Only in B: %d\n", res.OnlyB)
+	fmt.Printf("Common:    %d\n", res.Common)
+
+	if delta != nil {
+		if deltaErr != nil {
+			fmt.Fprintf(os.Stderr, "Error building delta: %v\n", deltaErr)
+			return 1
+		}
+		fmt.Printf("\nSaving %d records only in B to: %s\n", res.OnlyB, *deltaFile)
+		if err := delta.SaveToFile(*deltaFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving delta file: %v\n", err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/server"
+)
+
+// digestShown bounds the divergent prefix bands printed
+const digestShown = 20
+
+func runDigest(args []string) int {
+	fs := newFlagSet("digest", "[-server host:port|https://host:port [-token T]] a.db [b.db]")
+	serverAddr := fs.String("server", "", "Compare a.db with the database of this pool server")
+	token := fs.String("token", "", "API key for the pool server")
+	caFile := fs.String("ca", "", "PEM file of CA certificates to trust for an https server")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 || (*serverAddr != "" && fs.NArg() != 1) {
+		fs.Usage()
+		return 1
+	}
+
+	fbA, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	local := func(fb *fastbase.FastBase) func(path []byte) (fastbase.DigestNode, error) {
+		return func(path []byte) (fastbase.DigestNode, error) { return fb.DigestNode(path) }
+	}
+	a := local(fbA)
+
+	var b func(path []byte) (fastbase.DigestNode, error)
+	nameB := fs.Arg(1)
+	switch {
+	case *serverAddr != "":
+		httpClient, err := serverHTTPClient(*caFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		peer := server.Peer{URL: *serverAddr, Token: *token, Client: httpClient}
+		b = func(path []byte) (fastbase.DigestNode, error) {
+			return server.PeerDigest(context.Background(), peer, path)
+		}
+		nameB = *serverAddr
+	case nameB != "":
+		fbB, err := loadDatabase(nameB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if fbA.Schema().ID != fbB.Schema().ID {
+			fmt.Fprintf(os.Stderr, "Error: cannot compare a %s database with a %s database\n",
+				fbA.Schema().Name, fbB.Schema().Name)
+			return 1
+		}
+		b = local(fbB)
+	}
+
+	rootA := fbA.Digest()
+	fmt.Printf("\n%x  %s\n", rootA, fs.Arg(0))
+	if b == nil {
+		return 0
+	}
+	nodeB, err := b(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%x  %s\n", nodeB.Hash, nameB)
+	if rootA == nodeB.Hash {
+		fmt.Printf("\nThe databases hold the same records\n")
+		return 0
+	}
+
+	diverge, err := fastbase.DivergentPrefixes(a, b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	prefixes := 0
+	for _, r := range diverge {
+		prefixes += r.Count()
+	}
+	fmt.Printf("\nThe databases differ in %d bands of %d prefixes:\n", len(diverge), prefixes)
+	for i, r := range diverge {
+		if i == digestShown {
+			fmt.Printf("  ... and %d more\n", len(diverge)-digestShown)
+			break
+		}
+		fmt.Printf("  %s\n", r)
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runEncrypt(args []string) int {
+	fs := newFlagSet("encrypt", "[-decrypt] in.db out.db | -generate-key file\n\n"+
+		"The secret is the key file named by $"+envKeyFile+", or else the passphrase in $"+envPassphrase+".\n"+
+		"Other commands read it from there too, to load encrypted databases and save them encrypted.")
+	decrypt := fs.Bool("decrypt", false, "Save the database unencrypted")
+	generate := fs.String("generate-key", "", "Write a new random key file")
+	fs.Parse(args)
+
+	if *generate != "" {
+		if err := fastbase.GenerateKeyFile(*generate); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Key file written to: %s\n", *generate)
+		return 0
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+	if os.Getenv(envKeyFile) == "" && os.Getenv(envPassphrase) == "" {
+		fmt.Fprintf(os.Stderr, "Error: set %s or %s\n", envKeyFile, envPassphrase)
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := fb.SetEncrypted(!*decrypt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	verb := "Encrypting"
+	if *decrypt {
+		verb = "Decrypting"
+	}
+	fmt.Printf("%s to: %s\n", verb, fs.Arg(1))
+	if err := saveDatabase(fb, fs.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", fs.Arg(1), err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+func runEstimate(args []string) int {
+	fs := newFlagSet("estimate", "-range bits -dp bits -tame N -wild1 N -wild2 N [-rate jumps/s] | [-rate jumps/s] file.db")
+	rangeBits := fs.Int("range", 0, "Range width in bits; read from the database header when a file is given")
+	dpBits := fs.Int("dp", -1, "DP bits; read from the database header when a file is given")
+	tame := fs.Int("tame", 0, "Tame DPs collected so far")
+	wild1 := fs.Int("wild1", 0, "Wild1 DPs collected so far")
+	wild2 := fs.Int("wild2", 0, "Wild2 DPs collected so far")
+	rate := fs.Float64("rate", 0, "Jumps per second of all workers, to turn operations into time")
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		// The database supplies the counts, and the range and DP bits
+		// unless they were given
+		fb, err := loadDatabaseQuiet(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if *rangeBits == 0 {
+			*rangeBits = int(fb.Header[fastbase.HeaderRange])
+		}
+		if *dpBits < 0 {
+			*dpBits = int(fb.Header[fastbase.HeaderDPBits])
+		}
+		types := fb.Stats().Types
+		*tame, *wild1, *wild2 = types[0].Count, types[1].Count, types[2].Count
+	default:
+		fs.Usage()
+		return 1
+	}
+	if *rangeBits < 1 || *rangeBits > 256 || *dpBits < 0 || *dpBits > 64 {
+		fmt.Fprintf(os.Stderr, "Error: -range must be in 1...256 and -dp in 0...64\n")
+		return 1
+	}
+
+	e := kangaroo.EstimateProgress(*rangeBits, *dpBits, *tame, *wild1, *wild2)
+	fmt.Printf("Range:                  2^%d, DP %d\n", *rangeBits, *dpBits)
+	fmt.Printf("Expected operations:    %s\n", formatOps(e.ExpectedOps))
+	fmt.Printf("Expected DPs:           %.4g\n", e.ExpectedDPs)
+	fmt.Printf("DPs so far:             %d (tame %d, wild1 %d, wild2 %d)\n", *tame+*wild1+*wild2, *tame, *wild1, *wild2)
+	fmt.Printf("Operations done:        %s (%.2f%% of expected)\n", formatOps(e.OpsDone), e.Progress()*100)
+	fmt.Printf("P(collision by now):    %.2f%%\n", e.Probability*100)
+	fmt.Printf("Expected remaining:     %s\n", formatOps(e.OpsRemaining))
+	if *rate > 0 {
+		fmt.Printf("Expected time left:     %s at %.4g jumps/s\n", formatETA(e.OpsRemaining / *rate), *rate)
+	}
+	return 0
+}
+
+// formatOps renders an operation count as a power of two and in full
+func formatOps(ops float64) string {
+	if ops < 1 {
+		return fmt.Sprintf("%.4g", ops)
+	}
+	return fmt.Sprintf("2^%.3f (%.4g)", math.Log2(ops), ops)
+}
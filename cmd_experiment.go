@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/kangaroo"
+)
+
+func runExperiment(args []string) int {
+	fs := newFlagSet("experiment", "(-pubkey <hex> -start <hex> -range <bits> | -puzzle N) file.db")
+	pubKey := fs.String("pubkey", "", "Public key the database was collected for (compressed or uncompressed hex)")
+	start := fs.String("start", "", "Start offset of the key range, in hex")
+	bits := fs.Int("range", 0, "Bit range of the private key")
+	puzzle := fs.Int("puzzle", 0, puzzleUsage)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	if err := applyPuzzle(*puzzle, pubKey, start, bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	target, err := parseTarget(*pubKey, *start, *bits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	collisions := fb.FindCollisions()
+	fmt.Printf("Found %d collision pairs\n", len(collisions))
+
+	results := kangaroo.RunExperiment(collisions, fb.Schema(), target, kangaroo.Naive{}, kangaroo.Symmetric{})
+
+	fmt.Printf("\n%-10s %8s %11s %9s %6s %12s\n", "Strategy", "Pairs", "Candidates", "Verified", "Keys", "Runtime")
+	fmt.Printf("----------------------------------------------------------------\n")
+	for _, res := range results {
+		fmt.Printf("%-10s %8d %11d %9d %6d %12v\n",
+			res.Strategy, res.Pairs, res.Candidates, res.Verified, len(res.Keys), res.Runtime)
+	}
+	for _, res := range results {
+		for _, key := range res.Keys {
+			fmt.Printf("%s found key: %064x\n", res.Strategy, key)
+		}
+	}
+
+	return 0
+}
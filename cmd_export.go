@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"rckangaroo/fastbase"
+)
+
+func runExport(args []string) int {
+	fs := newFlagSet("export", "[-format csv|ndjson|db] [-type tame,wild1,wild2] [-where expr] [-worker name] [-since time] [-out file] file.db")
+	formatName := fs.String("format", "csv", "Output format: csv, ndjson, or db for a database file in the same layout, such as a tames file to seed a new run")
+	types := fs.String("type", "", "Only export records of these comma-separated kangaroo types, e.g. tame")
+	outFile := fs.String("out", "", "Write to this file instead of stdout")
+	where := fs.String("where", "", "Only export records matching this filter expression")
+	worker := fs.String("worker", "", "Only export records submitted by this worker, by API key name or 0x-prefixed ID")
+	since := fs.String("since", "", "Only export records submitted since this time, date or duration ago")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	toDB := *formatName == "db"
+	if toDB && *outFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: -format db needs -out\n")
+		return 1
+	}
+	var format fastbase.ExportFormat
+	if !toDB {
+		f, err := fastbase.ParseExportFormat(*formatName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		format = f
+	}
+	typeFilter, err := parseTypes(*types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Records go to stdout unless -out is given, so keep it clean
+	fb, err := loadDatabaseQuiet(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	filters, err := compileWhere(fb, *where)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	pf, err := provenanceFilters(fb, *worker, *since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	filters = append(filters, pf...)
+	if typeFilter != nil {
+		filters = append(filters, typeFilter)
+	}
+
+	if toDB {
+		return exportDatabase(fb, *outFile, filters)
+	}
+
+	var w io.Writer = os.Stdout
+	if *outFile != "" {
+		file, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := fb.ExportTo(w, format, filters...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting records: %v\n", err)
+		return 1
+	}
+
+	if *outFile != "" {
+		fmt.Printf("Exported %d records to: %s\n", count, *outFile)
+	}
+	return 0
+}
+
+// exportDatabase saves the records passing the filters to a new database
+// with the header and layout of fb
+func exportDatabase(fb *fastbase.FastBase, filename string, filters []fastbase.Filter) int {
+	layout := fb.Layout()
+	out := fastbase.NewFastBase(fastbase.WithRecordLength(layout.RecordLength),
+		fastbase.WithCompareLength(layout.CompareLength), fastbase.WithPrefixDepth(layout.PrefixDepth),
+		fastbase.WithPageSize(layout.PageSize))
+	out.Header = fb.Header
+	stats, err := out.Merge(fb, filters...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := saveDatabase(out, filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", filename, err)
+		return 1
+	}
+	fmt.Printf("Exported %d records to: %s\n", stats.Added, filename)
+	return 0
+}
+
+// parseTypes builds a filter from a comma-separated list of kangaroo types,
+// returning nil for an empty list
+func parseTypes(list string) (fastbase.Filter, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var types []fastbase.KangarooType
+	for _, name := range strings.Split(list, ",") {
+		t, err := fastbase.ParseKangarooType(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return fastbase.TypeFilter(types...), nil
+}
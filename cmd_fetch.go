@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/chunks"
+)
+
+func runFetch(args []string) int {
+	fs := newFlagSet("fetch", "[-mirrors url,url,...] [-parallel 4] [-out file.db] manifest.json|manifest-url")
+	mirrors := fs.String("mirrors", "", "Comma-separated base URLs serving the chunks (default: where the manifest URL points)")
+	parallel := fs.Int("parallel", chunks.DefaultParallel, "Chunks to download at once")
+	out := fs.String("out", "", "File to download to (default: the name in the manifest)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// The manifest may be a local copy or come from a mirror, which then
+	// serves the chunks beside it unless others are given
+	var m *chunks.Manifest
+	var mirrorList []string
+	source := fs.Arg(0)
+	if strings.Contains(source, "://") {
+		var err error
+		if m, err = chunks.FetchManifest(ctx, nil, source); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		mirrorList = []string{source[:strings.LastIndex(source, "/")]}
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		m, err = chunks.ReadManifest(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if *mirrors != "" {
+		mirrorList = nil
+		for _, u := range strings.Split(*mirrors, ",") {
+			mirrorList = append(mirrorList, strings.TrimSpace(u))
+		}
+	}
+	if len(mirrorList) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: -mirrors is required with a local manifest\n")
+		return 1
+	}
+	if *out == "" {
+		*out = m.Name
+	}
+
+	fmt.Printf("Fetching %s (%d bytes in %d chunks) from %d mirrors into %s\n", m.Name, m.Size, len(m.Chunks), len(mirrorList), *out)
+	started := time.Now()
+	err := chunks.Fetch(ctx, m, *out, chunks.Options{
+		Mirrors:  mirrorList,
+		Parallel: *parallel,
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Run fetch again to resume with the chunks still missing\n")
+		return 1
+	}
+	fmt.Printf("Fetched %s in %s; every chunk matched its hash\n", *out, time.Since(started).Round(time.Second))
+	return 0
+}
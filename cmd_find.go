@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+func runFind(args []string) int {
+	fs := newFlagSet("find", "-x <24 hex chars> [-scan] file.db")
+	xHex := fs.String("x", "", "Truncated x-coordinate to look up (24 hex chars for the standard schema)")
+	scan := fs.Bool("scan", false, "Search every list instead of only the list for the first 3 bytes of x")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *xHex == "" {
+		fs.Usage()
+		return 1
+	}
+
+	x, err := hex.DecodeString(strings.TrimPrefix(strings.ReplaceAll(*xHex, " ", ""), "0x"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid x-coordinate hex: %v\n", err)
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var matches []fastbase.Match
+	if *scan {
+		matches, err = fb.ScanX(x)
+	} else {
+		matches, err = fb.FindX(x)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("\nRecords with x-coordinate %x:\n", x)
+	fmt.Printf("Total records: %d\n", len(matches))
+	fmt.Printf("----------------------------------------\n")
+	schema := fb.Schema()
+	for i, m := range matches {
+		mem := m.Record
+		fmt.Printf("Record %d at [%02x %02x %02x]:\n", i+1, m.Prefix[0], m.Prefix[1], m.Prefix[2])
+		fmt.Printf("  distance:     %x\n", schema.Distance(mem))
+		fmt.Printf("  type:         %d (%s)\n", schema.Type(mem), schema.Type(mem))
+		if p, ok := fb.Provenance(mem); ok {
+			fmt.Printf("  worker:       %08x\n", p.Worker)
+			if !p.Time.IsZero() {
+				fmt.Printf("  submitted:    %s\n", p.Time.Format(time.RFC3339))
+			}
+		}
+		fmt.Printf("----------------------------------------\n")
+	}
+
+	return 0
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/gpu"
+	"rckangaroo/kangaroo"
+)
+
+// followChunk is how much of a stream is read at once
+const followChunk = 1 << 20
+
+// followBatch is what a followed source produced since the last batch:
+// records to add, and the header of the database they came from if the
+// source is one
+type followBatch struct {
+	records [][]byte
+	header  *[256]byte
+}
+
+func runFollow(args []string) int {
+	fs := newFlagSet("follow", "-db out.db [-format gpu|text|fastbase] [-ram-budget size [-spill-dir dir]] [-maxmem size [-on-maxmem refuse|spill|raise-dp]] [-log-level L] [-journal] [-pubkey <hex> -start <hex> -range <bits> | -puzzle N] [-notify-* ...] <source>")
+	dbFile := fs.String("db", "", "Database to collect the DPs in; created if it does not exist")
+	format := fs.String("format", "gpu", "Source format: gpu for the raw 48-byte DPs of the kernels, text for import dump lines, fastbase for a database the C++ solver saves over and over")
+	pubKey := fs.String("pubkey", "", "Public key the C++ solver is solving, in hex, to derive the key from collisions")
+	start := fs.String("start", "", "With -pubkey, start of the key range, in hex")
+	bits := fs.Int("range", 0, "With -pubkey, width of the key range in bits")
+	puzzle := fs.Int("puzzle", 0, puzzleUsage)
+	poll := fs.Duration("poll", time.Second, "How often to look for more output once the source is read to its end")
+	every := fs.Duration("every", 10*time.Second, "How often to print progress")
+	saveEvery := fs.Duration("save-every", 5*time.Minute, "How often to save -db while following")
+	ramBudget := fs.String("ram-budget", "", "Memory for records, e.g. 16GB; the pools used least recently spill to disk beyond it")
+	spillDir := fs.String("spill-dir", "", "With -ram-budget, directory to spill pools to (default: the database path + .spill)")
+	maxMem := fs.String("maxmem", "", "Memory the database may use, e.g. 32GB, lists included; see -on-maxmem")
+	onMaxMem := fs.String("on-maxmem", "refuse", "At -maxmem: refuse to stop following and save, spill to spill more pools to disk, raise-dp to raise the DP bits, dropping the records no longer distinguished")
+	logLevel := fs.String("log-level", "", logLevelUsage)
+	useJournal := fs.Bool("journal", false, journalUsage)
+	nf := addNotifyFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *dbFile == "" {
+		fs.Usage()
+		return 1
+	}
+	source := fs.Arg(0)
+	if err := applyPuzzle(*puzzle, pubKey, start, bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var target *kangaroo.Target
+	if *pubKey != "" {
+		t, err := parseTarget(*pubKey, *start, *bits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		target = &t
+	}
+	notifiers, err := nf.notifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	policy, err := fastbase.ParseMemoryPolicy(*onMaxMem)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var opts []fastbase.Option
+	var budget float64
+	if *ramBudget != "" {
+		if budget, err = parseSize(*ramBudget); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if *maxMem != "" {
+		limit, err := parseSize(*maxMem)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		// Spilling to fit the limit lowers the budget from the limit itself
+		if policy == fastbase.MemorySpill && budget == 0 {
+			budget = limit
+		}
+		opts = append(opts, fastbase.WithMemoryLimit(int64(limit), policy))
+		fmt.Printf("Limiting memory to %s, then: %s\n", formatBytes(int64(limit)), policy)
+	}
+	if budget > 0 {
+		if *spillDir == "" {
+			*spillDir = *dbFile + ".spill"
+		}
+		opts = append(opts, fastbase.WithTiering(*spillDir, int64(budget)))
+		fmt.Printf("Keeping %s of records in memory, spilling to %s\n", formatBytes(int64(budget)), *spillDir)
+	}
+	fb := fastbase.NewFastBase(opts...)
+	fb.SetLogger(logger)
+	if budget > 0 {
+		// Clearing removes the spilled pools
+		defer func() {
+			fb.ReleaseMemory()
+			os.Remove(*spillDir)
+		}()
+	}
+	if _, err := os.Stat(*dbFile); err == nil {
+		if err := loadInto(fb, *dbFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	} else {
+		fmt.Printf("Creating new FastBase file: %s\n", *dbFile)
+		if target != nil {
+			fb.Header[fastbase.HeaderRange] = byte(target.Range.Bits)
+		}
+	}
+	schema := fb.Schema()
+	if *useJournal {
+		if err := openJournal(fb, *dbFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer fb.CloseJournal()
+	}
+
+	var parse func([]byte, bool) ([][]byte, int, error)
+	switch *format {
+	case "gpu":
+		parse = func(buf []byte, _ bool) ([][]byte, int, error) { return parseGPUDPs(fb, buf) }
+	case "text":
+		parse = func(buf []byte, final bool) ([][]byte, int, error) { return parseTextDPs(fb, buf, final) }
+	case "fastbase":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q\n", *format)
+		return 1
+	}
+
+	var key *big.Int
+	collisions, nears := 0, 0
+	solve := func(c fastbase.Collision) {
+		if key != nil {
+			return
+		}
+		if k, err := kangaroo.Solve(kangaroo.Symmetric{}, *target, schema, c.First, c.Second); err == nil {
+			key = k
+		}
+	}
+	// Near collisions of wild kangaroos may give the key too
+	fb.SetHooks(fastbase.Hooks{
+		OnCollision: func(c fastbase.Collision) {
+			collisions++
+			if target == nil {
+				printCollision(collisions, schema, c)
+				return
+			}
+			solve(c)
+		},
+		OnNearCollision: func(c fastbase.Collision) {
+			nears++
+			if target == nil {
+				printNearCollision(nears, schema, c)
+				return
+			}
+			solve(c)
+		},
+	})
+
+	ctx, stop := interruptContext()
+	defer stop()
+	batches := make(chan followBatch, 4)
+	failed := make(chan error, 1)
+	go func() {
+		var err error
+		if *format == "fastbase" {
+			err = followDatabase(ctx, fb, source, *poll, batches)
+		} else {
+			err = followStream(ctx, source, parse, *poll, batches)
+		}
+		failed <- err
+	}()
+
+	fmt.Printf("Following %s output of %s into %s\n", *format, source, *dbFile)
+	if target != nil {
+		fmt.Printf("Solving %x in [%x, +2^%d)\n", target.PubKey.Compressed(), target.Range.Start, target.Range.Bits)
+	}
+	started := time.Now()
+	lastSave := started
+	added, unsaved := 0, 0
+	// Records are counted once, as each walk of the lists would read every
+	// spilled pool back
+	stored, raises := fb.Stats().TotalRecords, 0
+	add := func(b followBatch) error {
+		if b.header != nil && fb.Header[fastbase.HeaderRange] == 0 {
+			fb.Header[fastbase.HeaderRange] = b.header[fastbase.HeaderRange]
+			fb.Header[fastbase.HeaderDPBits] = b.header[fastbase.HeaderDPBits]
+		}
+		n, _, err := fb.AddRecords(b.records)
+		added += n
+		unsaved += n
+		return err
+	}
+	ingest := func(b followBatch) error {
+		err := add(b)
+		if jerr := fb.FlushJournal(false); jerr != nil && err == nil {
+			err = jerr
+		}
+		var limitErr *fastbase.MemoryLimitError
+		if policy != fastbase.MemorySpill || !errors.As(err, &limitErr) || unsaved == 0 {
+			return err
+		}
+		// Pools holding unsaved records cannot spill; saving frees them
+		fmt.Printf("Memory limit reached, saving to %s to spill more\n", *dbFile)
+		if err := saveDatabaseAtomic(fb, *dbFile); err != nil {
+			return err
+		}
+		lastSave, unsaved = time.Now(), 0
+		return add(b)
+	}
+	status := func() {
+		elapsed := time.Since(started)
+		if ls := fb.MemoryLimitStats(); ls.Raises != raises {
+			// Raising the DP bits dropped records
+			raises = ls.Raises
+			stored = fb.Stats().TotalRecords - added
+		}
+		fmt.Printf("[%s] New DPs: %d (%.3g/s), Records: %d, Collisions: %d",
+			formatClock(elapsed), added, float64(added)/elapsed.Seconds(), stored+added, collisions)
+		if nears > 0 {
+			fmt.Printf(", Near collisions: %d", nears)
+		}
+		if ts := fb.TierStats(); ts.Budget > 0 {
+			fmt.Printf(", In memory: %s, Spilled pools: %d", formatBytes(ts.HotBytes), ts.ColdPools)
+		}
+		if ls := fb.MemoryLimitStats(); ls.Raises > 0 {
+			fmt.Printf(", DP raised to %d", ls.DPBits)
+		}
+		fmt.Println()
+	}
+
+	ticker := time.NewTicker(*every)
+	defer ticker.Stop()
+	err = nil
+	for done := false; !done && key == nil; {
+		select {
+		case b := <-batches:
+			err = ingest(b)
+			done = err != nil
+		case <-ticker.C:
+			status()
+			if unsaved > 0 && time.Since(lastSave) >= *saveEvery {
+				if err = saveDatabaseAtomic(fb, *dbFile); err != nil {
+					done = true
+				}
+				lastSave, unsaved = time.Now(), 0
+			}
+		case err = <-failed:
+			// The source ended; take what it passed on before it did
+			for len(batches) > 0 && err == nil {
+				err = ingest(<-batches)
+			}
+			done = true
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	fmt.Printf("Saving to: %s\n", *dbFile)
+	if serr := saveDatabaseAtomic(fb, *dbFile); serr != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *dbFile, serr)
+		return 1
+	}
+	if key != nil {
+		fmt.Println()
+		printKey("", key)
+		sendNotifications(notifiers, keyEvent("follow", *target, key))
+		return 0
+	}
+	if err != nil || target != nil {
+		return 1
+	}
+	return 0
+}
+
+// followStream reads source as it is written, like tail -f, passing the
+// records parse finds in each stretch read to out. parse returns the
+// records and how many bytes it consumed, leaving a partial record for the
+// next stretch; final is set when no more is coming. A regular file is
+// polled for more once read to its end, and read again from the start if
+// it shrinks; a named pipe ends when its writer closes it.
+func followStream(ctx context.Context, source string, parse func(buf []byte, final bool) ([][]byte, int, error), poll time.Duration, out chan<- followBatch) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	pipe := fi.Mode()&os.ModeNamedPipe != 0
+
+	var pending []byte
+	var offset int64
+	chunk := make([]byte, followChunk)
+	for {
+		n, err := f.Read(chunk)
+		offset += int64(n)
+		pending = append(pending, chunk[:n]...)
+		final := pipe && err == io.EOF
+		if n > 0 || final {
+			records, used, perr := parse(pending, final)
+			if perr != nil {
+				return perr
+			}
+			pending = append(pending[:0], pending[used:]...)
+			if len(records) > 0 {
+				select {
+				case out <- followBatch{records: records}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+		switch {
+		case err == nil:
+			continue
+		case err != io.EOF:
+			return err
+		case pipe:
+			if len(pending) > 0 {
+				return fmt.Errorf("%s ended inside a record", source)
+			}
+			return nil
+		}
+
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return nil
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			fmt.Printf("%s shrank, reading it again from the start\n", source)
+			pending, offset = pending[:0], 0
+		}
+	}
+}
+
+// followDatabase loads source each time it changes, passing all its
+// records to out, converted to records of fb. The C++ solver saves its
+// database to a temporary file renamed over the last, so every load sees a
+// whole one.
+func followDatabase(ctx context.Context, fb *fastbase.FastBase, source string, poll time.Duration, out chan<- followBatch) error {
+	var size int64
+	var modified time.Time
+	for {
+		fi, err := os.Stat(source)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Not saved yet
+		case err != nil:
+			return err
+		case fi.Size() != size || !fi.ModTime().Equal(modified):
+			db, err := loadDatabaseQuiet(source)
+			if err != nil {
+				return err
+			}
+			records, err := parseCPPDatabase(fb, db)
+			if err != nil {
+				return fmt.Errorf("%s: %v", source, err)
+			}
+			size, modified = fi.Size(), fi.ModTime()
+			select {
+			case out <- followBatch{records: records, header: &db.Header}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// parseGPUDPs converts the whole DPs at the start of buf, as the kernels
+// report them, to records of fb
+func parseGPUDPs(fb *fastbase.FastBase, buf []byte) ([][]byte, int, error) {
+	n := len(buf) / gpu.DPSize
+	records := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		rec, err := gpu.Record(fb.Schema(), buf[i*gpu.DPSize:(i+1)*gpu.DPSize])
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, fb.Widen(rec))
+	}
+	return records, n * gpu.DPSize, nil
+}
+
+// parseCPPDatabase converts the records of a database the C++ solver
+// saved to records of fb
+func parseCPPDatabase(fb *fastbase.FastBase, db *fastbase.FastBase) ([][]byte, error) {
+	if db.Schema().ID != fastbase.SchemaStandard.ID || db.Layout().RecordLength != fastbase.DBRecordLength {
+		return nil, errors.New("not a database of the C++ solver")
+	}
+	var records [][]byte
+	var err error
+	db.ForEach(func(prefix [3]byte, rec []byte) bool {
+		var r []byte
+		if r, err = gpu.DBRecord(fb.Schema(), prefix, rec); err != nil {
+			return false
+		}
+		records = append(records, fb.Widen(r))
+		return true
+	})
+	return records, err
+}
+
+// parseTextDPs converts the whole lines at the start of buf, in any form
+// import accepts, to records of fb. The last line needs no newline when
+// final is set.
+func parseTextDPs(fb *fastbase.FastBase, buf []byte, final bool) ([][]byte, int, error) {
+	used := bytes.LastIndexByte(buf, '\n') + 1
+	if final {
+		used = len(buf)
+	}
+	var records [][]byte
+	for _, line := range strings.Split(string(buf[:used]), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "prefix,") {
+			continue
+		}
+		_, rec, err := fastbase.ParseTextRecord(fb.Schema(), line)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%q: %v", line, err)
+		}
+		records = append(records, fb.Widen(rec))
+	}
+	return records, used, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runFsck(args []string) int {
+	fs := newFlagSet("fsck", "[-strict [-check-prefix]] [-out recovered.db] file.db")
+	outFile := fs.String("out", "", "Write everything that could be salvaged to this database file")
+	strict := fs.Bool("strict", false, "Also check list order, record contents and file size")
+	checkPrefix := fs.Bool("check-prefix", false, "With -strict, check that every record starts with its list prefix")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	fmt.Printf("Checking FastBase file: %s\n", fs.Arg(0))
+	fb := fastbase.NewFastBase()
+	if err := applySecret(fb); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	rep, err := fb.Recover(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("\nCheck Report:\n")
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("Lists read:       %d\n", rep.Lists)
+	fmt.Printf("Records read:     %d\n", rep.Records)
+	if rep.Complete {
+		fmt.Printf("Status:           OK\n")
+	} else {
+		fmt.Printf("Status:           CORRUPT\n")
+		fmt.Printf("Problem:          %v\n", rep.Err)
+		fmt.Printf("Corruption at:    offset %d, list [%02x %02x %02x]\n",
+			rep.CorruptOffset, rep.CorruptPrefix[0], rep.CorruptPrefix[1], rep.CorruptPrefix[2])
+		fmt.Printf("Records lost:     %d in that list, plus any in later lists\n", rep.LostRecords)
+	}
+
+	valid := true
+	if *strict {
+		// A truncated file cannot match its counts, so only check its contents
+		if rep.Complete {
+			err = fb.ValidateFile(fs.Arg(0), *checkPrefix)
+		} else {
+			err = fb.Validate(*checkPrefix)
+		}
+		if err != nil {
+			valid = false
+			fmt.Printf("Validation:       FAILED\n%v\n", err)
+		} else {
+			fmt.Printf("Validation:       OK\n")
+		}
+	}
+
+	if *outFile != "" {
+		fmt.Printf("\nSaving recovered database to: %s\n", *outFile)
+		if err := fb.SaveToFile(*outFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving recovered file: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if !rep.Complete || !valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+	"rckangaroo/secp256k1"
+)
+
+// generateBatch is how many records generate adds per AddRecords call
+const generateBatch = 1 << 20
+
+func runGenerate(args []string) int {
+	fs := newFlagSet("generate", "[-records N] [-seed N] [-range bits] [-dp bits] [-collisions N] [-start hex] out.db")
+	recordsStr := fs.String("records", "1M", "Number of random records, e.g. 10M")
+	seed := fs.Int64("seed", 1, "Seed of the random generator; the same seed gives the same database")
+	rangeBits := fs.Int("range", 76, "Range width in bits, recorded in the header and bounding distances")
+	dpBits := fs.Int("dp", 16, "DP bits recorded in the header")
+	planted := fs.Int("collisions", 0, "Tame/wild collisions to plant, all solving to one random key")
+	startHex := fs.String("start", "0", "Start of the key range of the planted key, in hex")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	n, err := parseCount(*recordsStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	maxBits := 8*(fastbase.SchemaStandard.DistanceLength-1) - 2
+	if *rangeBits < 8 || *rangeBits > maxBits || *dpBits < 0 || *dpBits > 255 || *planted < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -range must be in 8...%d, -dp in 0...255 and -collisions not negative\n", maxBits)
+		return 1
+	}
+	r, err := kangaroo.NewRange(*startHex, *rangeBits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fb := fastbase.NewFastBase()
+	fb.Header[fastbase.HeaderRange] = byte(*rangeBits)
+	fb.Header[fastbase.HeaderDPBits] = byte(*dpBits)
+	rng := rand.New(rand.NewSource(*seed))
+
+	fmt.Printf("Generating %d records (seed %d, range 2^%d)...\n", n, *seed, *rangeBits)
+	start := time.Now()
+	for done := 0; done < n; {
+		batch := generateRecords(rng, min(generateBatch, n-done), *rangeBits)
+		if _, _, err := fb.AddRecords(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		done += len(batch)
+	}
+
+	if *planted > 0 {
+		key, pubKey, pairs := plantCollisions(rng, r, *planted)
+		if _, _, err := fb.AddRecords(pairs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Planted %d collisions solving to private key %x\n", *planted, key)
+		fmt.Printf("Check with: rckangaroo collisions -pubkey %s -start %s -range %d %s\n",
+			hex.EncodeToString(pubKey.Compressed()), *startHex, *rangeBits, fs.Arg(0))
+	}
+	fmt.Printf("Generated in %s\n", time.Since(start).Round(time.Millisecond))
+
+	fmt.Printf("Saving to: %s\n", fs.Arg(0))
+	if err := saveDatabase(fb, fs.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving file: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// generateRecords returns n standard-schema records with random
+// x-coordinates, types in equal shares, and distances as the solver leaves
+// them: tame ones within [0, 2^rangeBits), wild ones within half of that
+// either side of zero
+func generateRecords(rng *rand.Rand, n, rangeBits int) [][]byte {
+	schema := fastbase.SchemaStandard
+	buf := make([]byte, n*schema.RecordLength)
+	records := make([][]byte, n)
+	for i := range records {
+		rec := buf[i*schema.RecordLength : (i+1)*schema.RecordLength]
+		rng.Read(rec[:schema.XLength])
+		typ := fastbase.KangarooType(rng.Intn(3))
+		if typ == fastbase.TypeTame {
+			randomDistance(rng, schema.Distance(rec), rangeBits, false)
+		} else {
+			randomDistance(rng, schema.Distance(rec), rangeBits-1, rng.Intn(2) == 0)
+		}
+		rec[len(rec)-1] = byte(typ)
+		records[i] = rec
+	}
+	return records
+}
+
+// randomDistance fills a little-endian distance field with a random
+// magnitude below 2^bits, negated in two's complement if neg is set
+func randomDistance(rng *rand.Rand, field []byte, bits int, neg bool) {
+	clear(field)
+	rng.Read(field[:(bits+7)/8])
+	if bits%8 != 0 {
+		field[bits/8] &= byte(1)<<(bits%8) - 1
+	}
+	if !neg {
+		return
+	}
+	carry := 1
+	for i := range field {
+		v := int(^field[i]) + carry
+		field[i], carry = byte(v), v>>8
+	}
+}
+
+// plantCollisions picks a random key within r and returns it, its public
+// key and n tame/wild record pairs that the naive derivation solves to it:
+// a tame kangaroo at distance t and a wild one at t - k + HalfRange both
+// stand on the point t*G
+func plantCollisions(rng *rand.Rand, r kangaroo.Range, n int) (*big.Int, secp256k1.Point, [][]byte) {
+	width := new(big.Int).Lsh(big.NewInt(1), uint(r.Bits))
+	k := new(big.Int).Rand(rng, width)
+	key := new(big.Int).Add(k, r.Start)
+	key.Mod(key, secp256k1.N)
+	half := r.HalfRange()
+
+	var pairs [][]byte
+	for len(pairs) < 2*n {
+		t := new(big.Int).Rand(rng, width)
+		w := new(big.Int).Sub(t, k)
+		w.Add(w, half)
+
+		var x [32]byte
+		secp256k1.ScalarBaseMult(t).X.FillBytes(x[:])
+		wild := fastbase.TypeWild1 + fastbase.KangarooType(rng.Intn(2))
+		tameRec, err1 := fastbase.NewRecord(x, t, fastbase.TypeTame)
+		wildRec, err2 := fastbase.NewRecord(x, w, wild)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		pairs = append(pairs, tameRec[:], wildRec[:])
+	}
+	return key, secp256k1.ScalarBaseMult(key), pairs
+}
+
+// parseCount parses a record count such as 10M or 250000, in decimal units
+func parseCount(s string) (int, error) {
+	v, mult, unit, ok := splitUnit(s)
+	if !ok || unit != "" {
+		return 0, fmt.Errorf("invalid count %q, want e.g. 10M", s)
+	}
+	if mult != 0 {
+		v *= math.Pow(1000, float64(strings.IndexByte("KMGTPE", mult)+1))
+	}
+	return int(v), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+func runGenTames(args []string) int {
+	fs := newFlagSet("gentames", "-range <bits> [-dp bits] [-max N] [-save-every 5m] [-resume] tames.db")
+	bits := fs.Int("range", 0, "Width of the key ranges the tames are for, in bits")
+	dpBits := fs.Int("dp", -1, "DP bits; from tames.db if it exists, else chosen for the range")
+	maxOps := fs.Float64("max", 1.0, "Stop after this many times the jumps a solve of the range is expected to take")
+	workers := fs.Int("workers", runtime.NumCPU(), "Goroutines walking kangaroos")
+	kangaroos := fs.Int("kangaroos", kangaroo.DefaultKangaroos, "Kangaroos per worker")
+	saveEvery := fs.Duration("save-every", 5*time.Minute, "How often to save tames.db while running")
+	every := fs.Duration("every", 10*time.Second, "How often to print progress")
+	seed := fs.Int64("seed", 0, "Seed of the tame start positions; random if 0")
+	resume := fs.Bool("resume", false, "Continue the kangaroos the last run saved beside tames.db instead of starting new ones")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *bits == 0 {
+		fs.Usage()
+		return 1
+	}
+	if *maxOps <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max must be positive\n")
+		return 1
+	}
+	filename := fs.Arg(0)
+
+	// Tames from earlier runs are kept and added to
+	fb := fastbase.NewFastBase()
+	if _, err := os.Stat(filename); err == nil {
+		if fb, err = loadDatabase(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := checkWalkHeader(fb, *bits, dpBits); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", filename, err)
+			return 1
+		}
+	} else if *resume {
+		fmt.Fprintf(os.Stderr, "Error: nothing to resume: %s does not exist\n", filename)
+		return 1
+	}
+	if *dpBits < 0 {
+		*dpBits = autoDPBits(*bits, *workers**kangaroos)
+	}
+	fb.Header[fastbase.HeaderRange] = byte(*bits)
+	fb.Header[fastbase.HeaderDPBits] = byte(*dpBits)
+
+	solver, err := kangaroo.NewSolver(kangaroo.SolverConfig{
+		Range: kangaroo.Range{Bits: *bits}, DPBits: *dpBits, Schema: fb.Schema(),
+		Workers: *workers, Kangaroos: *kangaroos, Seed: *seed,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *resume {
+		if err := resumeWalk(solver, filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("Generating tames for 2^%d ranges with %d x %d kangaroos, DP %d, until %gx expected jumps (%s)\n",
+		*bits, solver.Workers(), solver.Kangaroos(), *dpBits, *maxOps, formatOps(*maxOps*kangaroo.ExpectedOps(*bits)))
+	ctx, stop := interruptContext()
+	defer stop()
+	err = walk(ctx, solver, fb, *bits, *maxOps, *every, func([]fastbase.Collision) bool {
+		// Tames meeting tames solve nothing
+		return true
+	}, func(st *kangaroo.State) error {
+		return saveWalk(fb, filename, st)
+	}, *saveEvery)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if ctx.Err() != nil {
+		fmt.Printf("\nInterrupted after %s jumps and %d DPs\n", formatOps(float64(solver.Ops())), solver.DPs())
+	}
+	fmt.Printf("\nSaving %d tames to: %s\n", fb.Stats().TotalRecords, filename)
+	st, _ := solver.Snapshot(context.Background())
+	if err := saveWalk(fb, filename, st); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", filename, err)
+		return 1
+	}
+	return 0
+}
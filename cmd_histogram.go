@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const histogramBarWidth = 40
+
+func runHistogram(args []string) int {
+	fs := newFlagSet("histogram", "file.db")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	report := fb.Stats()
+
+	fmt.Printf("\nList Size Distribution:\n")
+	fmt.Printf("----------------------------------------\n")
+	maxLists := 0
+	for _, b := range report.ListSizeHistogram {
+		if b.Lists > maxLists {
+			maxLists = b.Lists
+		}
+	}
+	for _, b := range report.ListSizeHistogram {
+		label := fmt.Sprintf("%d-%d", b.Min, b.Max)
+		if b.Min == b.Max {
+			label = fmt.Sprintf("%d", b.Min)
+		}
+		fmt.Printf("%-22s %10d %s\n", label, b.Lists, histogramBar(b.Lists, maxLists))
+	}
+
+	fmt.Printf("\nRecords per Pool (first prefix byte):\n")
+	fmt.Printf("----------------------------------------\n")
+	maxRecords, fullestPool := 0, 0
+	for i, p := range report.Pools {
+		if p.Records > maxRecords {
+			maxRecords, fullestPool = p.Records, i
+		}
+	}
+	for i, p := range report.Pools {
+		fmt.Printf("[%02x] %10d records %6d pages (%5.2f%% of limit) %s\n",
+			i, p.Records, p.Pages, float64(p.Pages)*100/float64(fb.Pools[i].MaxPages()),
+			histogramBar(p.Records, maxRecords))
+	}
+
+	fullest := report.Pools[fullestPool]
+	fmt.Printf("\nFullest pool: [%02x] with %d records in %d of %d pages\n",
+		fullestPool, fullest.Records, fullest.Pages, fb.Pools[fullestPool].MaxPages())
+
+	return 0
+}
+
+// histogramBar renders value as a bar scaled against maxValue
+func histogramBar(value, maxValue int) string {
+	if maxValue == 0 || value == 0 {
+		return ""
+	}
+	n := value * histogramBarWidth / maxValue
+	if n == 0 {
+		n = 1
+	}
+	return strings.Repeat("#", n)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runImport(args []string) int {
+	fs := newFlagSet("import", "-db <file> [dump.txt ...]   (reads stdin when no dump is given or for \"-\")")
+	dbFile := fs.String("db", "", "Database to import into; created if it does not exist")
+	recordLength := fs.Int("record-length", fastbase.DBRecordLength, "Record length of a new database; bytes beyond the schema hold metadata")
+	compareLength := fs.Int("compare-length", fastbase.DBFindLength, "Leading record bytes that order records in a new database")
+	prefixDepth := fs.Int("prefix-depth", fastbase.DefaultLayout.PrefixDepth, "Leading key bytes that select a list in a new database: 2 for small databases, 4 for huge ones")
+	pageSize := fs.String("page-size", "1MB", "Pool page size of a new database, a power of two from 4KB to 256MB: small for tiny databases, large for huge ones")
+	fs.Parse(args)
+
+	if *dbFile == "" {
+		fs.Usage()
+		return 1
+	}
+
+	size, err := parseSize(*pageSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	layout := fastbase.Layout{RecordLength: *recordLength, CompareLength: *compareLength, PrefixDepth: *prefixDepth, PageSize: int(size)}
+	if err := layout.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fb := fastbase.NewFastBase(fastbase.WithRecordLength(layout.RecordLength), fastbase.WithCompareLength(layout.CompareLength),
+		fastbase.WithPrefixDepth(layout.PrefixDepth), fastbase.WithPageSize(layout.PageSize))
+	if _, err := os.Stat(*dbFile); err == nil {
+		loaded, err := loadDatabase(*dbFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fb = loaded
+	} else {
+		fmt.Printf("Creating new FastBase file: %s\n", *dbFile)
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	collisions, nears := 0, 0
+	fb.SetHooks(fastbase.Hooks{
+		OnCollision: func(c fastbase.Collision) {
+			collisions++
+			printCollision(collisions, fb.Schema(), c)
+		},
+		OnNearCollision: func(c fastbase.Collision) {
+			nears++
+			printNearCollision(nears, fb.Schema(), c)
+		},
+	})
+
+	var total fastbase.ImportResult
+	for _, input := range inputs {
+		var r io.Reader = os.Stdin
+		name := "stdin"
+		if input != "-" {
+			file, err := os.Open(input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			defer file.Close()
+			r, name = file, input
+		}
+
+		fmt.Printf("Importing records from: %s\n", name)
+		res, err := fb.ImportText(r)
+		total.Lines += res.Lines
+		total.Added += res.Added
+		total.Duplicates += res.Duplicates
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", name, err)
+			return 1
+		}
+	}
+
+	fmt.Printf("%d records read\n", total.Lines)
+	fmt.Printf("Added %d new records, skipped %d duplicates\n", total.Added, total.Duplicates)
+	if nears > 0 {
+		fmt.Printf("Found %d collisions and %d near collisions\n", collisions, nears)
+	}
+
+	fmt.Printf("Saving to: %s\n", *dbFile)
+	if err := fb.SaveToFile(*dbFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving file: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+	"rckangaroo/lsm"
+)
+
+// ingestBatch is how many DPs are added to a store at once
+const ingestBatch = 100000
+
+func runIngest(args []string) int {
+	fs := newFlagSet("ingest", "-store dir [-schema name] [-pubkey <hex> -start <hex> -range <bits> | -puzzle N] [-notify-* ...] a.db ... | -")
+	storeDir := fs.String("store", "", "Directory of the on-disk store to add the DPs to; created if it does not exist")
+	schemaName := fs.String("schema", "", "Record schema of a new store: "+strings.Join(fastbase.SchemaNames(), ", ")+"; that of the first database by default")
+	memRecords := fs.Int("mem-records", lsm.DefaultMemRecords, "Records held in memory before they are written out to disk")
+	pubKey := fs.String("pubkey", "", "Public key being solved, in hex, to derive the key from collisions")
+	start := fs.String("start", "", "With -pubkey, start of the key range, in hex")
+	bits := fs.Int("range", 0, "With -pubkey, width of the key range in bits")
+	puzzle := fs.Int("puzzle", 0, puzzleUsage)
+	nf := addNotifyFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || *storeDir == "" {
+		fs.Usage()
+		return 1
+	}
+	if err := applyPuzzle(*puzzle, pubKey, start, bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var target *kangaroo.Target
+	if *pubKey != "" {
+		t, err := parseTarget(*pubKey, *start, *bits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		target = &t
+	}
+	notifiers, err := nf.notifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Databases are loaded one at a time into a scratch FastBase, as merge
+	// loads them, so memory is bounded by the largest input, not the store
+	scratch := fastbase.NewFastBase()
+	first := 0
+	schema, err := lsm.SchemaOf(*storeDir)
+	switch {
+	case err == nil:
+		if *schemaName != "" && *schemaName != schema.Name {
+			fmt.Fprintf(os.Stderr, "Error: %s holds %s records, not %s\n", *storeDir, schema.Name, *schemaName)
+			return 1
+		}
+	case !os.IsNotExist(err):
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	case *schemaName != "":
+		if schema, err = fastbase.SchemaByName(*schemaName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	case fs.Arg(0) != "-":
+		if err := loadInto(scratch, fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		schema, first = scratch.Schema(), 1
+	default:
+		schema = fastbase.SchemaStandard
+	}
+
+	store, err := lsm.Open(*storeDir, schema, lsm.Options{MemRecords: *memRecords})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Ingesting into %s (%s records, %d stored)\n", *storeDir, schema.Name, store.Len())
+	if target != nil {
+		fmt.Printf("Solving %x in [%x, +2^%d)\n", target.PubKey.Compressed(), target.Range.Start, target.Range.Bits)
+	}
+
+	var key *big.Int
+	var total fastbase.MergeStats
+	add := func(records [][]byte) error {
+		stats, err := store.Add(records)
+		for i, c := range stats.Found {
+			if target == nil {
+				printCollision(total.Collisions+i+1, schema, c)
+			} else if key == nil {
+				if k, err := kangaroo.Solve(kangaroo.Symmetric{}, *target, schema, c.First, c.Second); err == nil {
+					key = k
+				}
+			}
+		}
+		total.Add(stats)
+		return err
+	}
+
+	for n, input := range fs.Args() {
+		if key != nil {
+			break
+		}
+		if input == "-" {
+			err = ingestText(schema, add)
+		} else {
+			if n >= first {
+				err = loadInto(scratch, input)
+			}
+			if err == nil {
+				err = ingestDatabase(scratch, schema, add)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error ingesting %s: %v\n", input, err)
+			break
+		}
+	}
+	scratch.ReleaseMemory()
+
+	if cerr := store.Close(); cerr != nil && err == nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", cerr)
+		err = cerr
+	}
+	fmt.Printf("\nIngest Report:\n")
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("Records in:         %d\n", total.Records)
+	fmt.Printf("Records added:      %d\n", total.Added)
+	fmt.Printf("Duplicates skipped: %d\n", total.Duplicates)
+	fmt.Printf("Collisions found:   %d\n", total.Collisions)
+
+	if key != nil {
+		fmt.Println()
+		printKey("", key)
+		sendNotifications(notifiers, keyEvent("ingest", *target, key))
+		return 0
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// ingestDatabase adds the records of a loaded database to a store of
+// schema, cut to the schema's record length
+func ingestDatabase(fb *fastbase.FastBase, schema fastbase.Schema, add func([][]byte) error) error {
+	if s := fb.Schema(); s.ID != schema.ID {
+		return fmt.Errorf("database holds %s records, not %s", s.Name, schema.Name)
+	}
+	var records [][]byte
+	var err error
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		records = append(records, fb.Narrow(rec))
+		if len(records) == ingestBatch {
+			err = add(records)
+			records = records[:0]
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return add(records)
+}
+
+// ingestText adds text DPs read from standard input, in any form import
+// accepts, in batches
+func ingestText(schema fastbase.Schema, add func([][]byte) error) error {
+	scanner := bufio.NewScanner(os.Stdin)
+	var records [][]byte
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "prefix,") {
+			continue
+		}
+		_, rec, err := fastbase.ParseTextRecord(schema, line)
+		if err != nil {
+			return fmt.Errorf("%q: %v", line, err)
+		}
+		records = append(records, rec)
+		if len(records) == ingestBatch {
+			if err := add(records); err != nil {
+				return err
+			}
+			records = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return add(records)
+}
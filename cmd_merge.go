@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runMerge(args []string) int {
+	fs := newFlagSet("merge", "-out merged.db [-tame-only] [-trusted keys.txt] [-sign key.pem] a.db b.db ...")
+	outFile := fs.String("out", "", "Path to write the merged database to")
+	tameOnly := fs.Bool("tame-only", false, "Merge only tame kangaroos")
+	bloomBits := fs.Int("bloom", 0, "Bloom filter bits per record for the merged database, speeding up its duplicate checks; 0 disables")
+	trustedFile := fs.String("trusted", "", "Only merge inputs signed by a key in this file of \"name hexkey\" lines")
+	keyFile := fs.String("sign", "", "Sign the merged database with the key in this file")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || *outFile == "" {
+		fs.Usage()
+		return 1
+	}
+
+	// Check every signature before merging anything, so one tampered or
+	// unsigned input rejects the whole merge
+	if *trustedFile != "" {
+		trusted, err := fastbase.LoadTrustedKeys(*trustedFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		for _, filename := range fs.Args() {
+			signer, err := fastbase.VerifyFile(filename, trusted)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: rejecting %s: %v\n", filename, err)
+				return 1
+			}
+			fmt.Printf("%s: signed by %s\n", filename, signer)
+		}
+	}
+	var signingKey ed25519.PrivateKey
+	if *keyFile != "" {
+		key, err := fastbase.LoadSigningKey(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		signingKey = key
+	}
+
+	var filters []fastbase.Filter
+	if *tameOnly {
+		filters = append(filters, fastbase.TypeFilter(fastbase.TypeTame))
+	}
+
+	// Inputs are loaded one at a time into a scratch FastBase whose pages are
+	// recycled between files, so memory stays bounded by the merged result
+	// plus the largest input
+	var opts []fastbase.Option
+	if *bloomBits > 0 {
+		opts = append(opts, fastbase.WithBloomFilter(*bloomBits))
+	}
+	merged := fastbase.NewFastBase(opts...)
+	scratch := fastbase.NewFastBase()
+	var total fastbase.MergeStats
+
+	for n, filename := range fs.Args() {
+		if err := loadInto(scratch, filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if n == 0 {
+			merged.Header = scratch.Header
+		} else if h := scratch.Header; h[fastbase.HeaderRange] != merged.Header[fastbase.HeaderRange] || h[fastbase.HeaderDPBits] != merged.Header[fastbase.HeaderDPBits] {
+			fmt.Printf("Warning: %s was collected with range %d / DP %d, not range %d / DP %d\n", filename,
+				h[fastbase.HeaderRange], h[fastbase.HeaderDPBits],
+				merged.Header[fastbase.HeaderRange], merged.Header[fastbase.HeaderDPBits])
+		}
+
+		stats, err := merged.Merge(scratch, filters...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging %s: %v\n", filename, err)
+			return 1
+		}
+		fmt.Printf("  %d records, %d added, %d duplicates, %d collisions\n",
+			stats.Records, stats.Added, stats.Duplicates, stats.Collisions)
+		for i, c := range stats.Found {
+			printCollision(total.Collisions+i+1, merged.Schema(), c)
+		}
+		total.Add(stats)
+	}
+	scratch.ReleaseMemory()
+
+	fmt.Printf("\nMerge Report:\n")
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("Files merged:       %d\n", fs.NArg())
+	fmt.Printf("Records in:         %d\n", total.Records)
+	fmt.Printf("Records written:    %d\n", total.Added)
+	fmt.Printf("Duplicates skipped: %d\n", total.Duplicates)
+	fmt.Printf("Collisions found:   %d\n", total.Collisions)
+
+	fmt.Printf("\nSaving merged result to: %s\n", *outFile)
+	if err := saveDatabase(merged, *outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving merged file: %v\n", err)
+		return 1
+	}
+	if signingKey != nil {
+		if err := fastbase.SignFile(*outFile, signingKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Error signing merged file: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Signed: %s%s\n", *outFile, fastbase.SignatureSuffix)
+	}
+
+	return 0
+}
+
+// loadInto loads a FastBase file into an existing FastBase, reusing its pages
+func loadInto(fb *fastbase.FastBase, filename string) error {
+	fmt.Printf("Loading FastBase file: %s\n", filename)
+	bar := newProgressBar()
+	defer bar.finish()
+	return readDatabase(fb, filename, bar.update)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"rckangaroo/fastbase"
+)
+
+func runMigrate(args []string) int {
+	fs := newFlagSet("migrate", "-schema <name> -out <file> file.db")
+	schemaName := fs.String("schema", fastbase.SchemaWideDistance.Name, "Target record schema: "+strings.Join(fastbase.SchemaNames(), ", "))
+	outFile := fs.String("out", "", "Path to write the migrated database to")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *outFile == "" {
+		fs.Usage()
+		return 1
+	}
+
+	schema, err := fastbase.SchemaByName(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	from := fb.Schema()
+	fmt.Printf("Migrating from %s (x[%d], d[%d]) to %s (x[%d], d[%d])...\n",
+		from.Name, from.XLength, from.DistanceLength, schema.Name, schema.XLength, schema.DistanceLength)
+	if err := fb.Migrate(schema); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Saving migrated database to: %s\n", *outFile)
+	if err := saveDatabase(fb, *outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving migrated file: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"rckangaroo/fastbase"
+)
+
+func runMkrecord(args []string) int {
+	fs := newFlagSet("mkrecord", "-x <64 hex chars> -distance <n> -type <tame|wild1|wild2> [-schema name] [-text] | -decode <64 hex chars>")
+	xHex := fs.String("x", "", "Full 32-byte x-coordinate in hex")
+	distStr := fs.String("distance", "", "Signed distance, decimal or 0x-prefixed hex")
+	typStr := fs.String("type", "", "Kangaroo type: tame, wild1, wild2 or 0-2")
+	schemaName := fs.String("schema", fastbase.SchemaStandard.Name, "Record schema: "+strings.Join(fastbase.SchemaNames(), ", "))
+	text := fs.Bool("text", false, "Print an \"x distance type\" line for the import command instead of the raw record")
+	decode := fs.String("decode", "", "Raw standard-schema record in hex to decode instead")
+	fs.Parse(args)
+
+	if *decode != "" && fs.NArg() == 0 {
+		return decodeRecord(*decode)
+	}
+	if fs.NArg() != 0 || *xHex == "" || *distStr == "" || *typStr == "" {
+		fs.Usage()
+		return 1
+	}
+
+	xBytes, err := hex.DecodeString(strings.TrimPrefix(*xHex, "0x"))
+	if err != nil || len(xBytes) != 32 {
+		fmt.Fprintf(os.Stderr, "Error: x must be 32 hex bytes\n")
+		return 1
+	}
+	var x [32]byte
+	copy(x[:], xBytes)
+
+	distance, ok := new(big.Int).SetString(*distStr, 0)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: invalid distance %q\n", *distStr)
+		return 1
+	}
+
+	typ, err := fastbase.ParseKangarooType(*typStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	schema, err := fastbase.SchemaByName(*schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	rec, err := schema.NewRecord(x, distance, typ)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *text {
+		fmt.Printf("%x %x %d\n", schema.X(rec[:]), schema.Distance(rec[:]), schema.Type(rec[:]))
+	} else {
+		fmt.Printf("%x\n", rec)
+	}
+	return 0
+}
+
+// decodeRecord prints the fields of a raw record given in hex
+func decodeRecord(recHex string) int {
+	b, err := hex.DecodeString(strings.TrimPrefix(recHex, "0x"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid record hex: %v\n", err)
+		return 1
+	}
+	rec, err := fastbase.ParseRecord(b)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	prefix := rec.Prefix()
+	fmt.Printf("prefix:       [%02x %02x %02x]\n", prefix[0], prefix[1], prefix[2])
+	fmt.Printf("x-coordinate: %s\n", groupHex(rec.X[:]))
+	fmt.Printf("distance:     %s (%s)\n", groupHex(rec.Distance[:]), rec.DistanceInt())
+	fmt.Printf("type:         %d (%s)\n", rec.Type, rec.Type)
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+func runRefilter(args []string) int {
+	fs := newFlagSet("refilter", "-dp <bits> -out out.db in.db")
+	dpBits := fs.Int("dp", 0, "DP bits to keep records for; at least those the database was collected with")
+	outFile := fs.String("out", "", "Path to write the re-filtered database to")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *outFile == "" || *dpBits <= 0 {
+		fs.Usage()
+		return 1
+	}
+
+	fb, err := loadDatabase(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	schema := fb.Schema()
+	if limit := 8 * (schema.XLength - 3); *dpBits > limit {
+		fmt.Fprintf(os.Stderr, "Error: DP bits must be at most %d for the %s schema\n", limit, schema.Name)
+		return 1
+	}
+	// Fewer bits than collected with would keep every record yet claim
+	// points the run never looked for
+	from := int(fb.Header[fastbase.HeaderDPBits])
+	if *dpBits < from {
+		fmt.Fprintf(os.Stderr, "Error: database was collected with DP %d; cannot lower it to %d\n", from, *dpBits)
+		return 1
+	}
+
+	layout := fb.Layout()
+	out := fastbase.NewFastBase(fastbase.WithRecordLength(layout.RecordLength),
+		fastbase.WithCompareLength(layout.CompareLength), fastbase.WithPrefixDepth(layout.PrefixDepth),
+		fastbase.WithPageSize(layout.PageSize))
+	out.Header = fb.Header
+	out.Header[fastbase.HeaderDPBits] = byte(*dpBits)
+
+	fmt.Printf("Re-filtering from DP %d to DP %d...\n", from, *dpBits)
+	stats, err := out.Merge(fb, kangaroo.DPFilter(schema, *dpBits))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Saving re-filtered database to: %s\n", *outFile)
+	if err := saveDatabase(out, *outFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving re-filtered file: %v\n", err)
+		return 1
+	}
+
+	total := fb.Stats().TotalRecords
+	kept := 0.0
+	if total > 0 {
+		kept = float64(stats.Added) * 100 / float64(total)
+	}
+	fmt.Printf("\nRe-filter Report:\n")
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("Records:  %d -> %d (%.2f%% kept)\n", total, stats.Added, kept)
+	fmt.Printf("Expected: %.2f%% for %d more DP bits\n", 100/float64(uint64(1)<<(*dpBits-from)), *dpBits-from)
+
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/server"
+)
+
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "-public-readonly [-listen addr] [-rate N] [-burst N] file.db")
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	readOnly := fs.Bool("public-readonly", false, "Expose only GET /stats, /find and /prefix/{hex}, and the health probes, to anonymous clients")
+	rate := fs.Float64("rate", 5, "Requests per second allowed per client IP (0 disables limiting)")
+	burst := fs.Int("burst", 20, "Request burst allowed per client IP")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	if !*readOnly {
+		fmt.Fprintf(os.Stderr, "Error: serve only supports the -public-readonly mirror mode\n")
+		return 1
+	}
+
+	// Lists are read from the file as they are asked for, through its
+	// offset table; files without one are loaded whole
+	opts := server.Options{ReadOnly: true, RateLimit: *rate, RateBurst: *burst}
+	fb := fastbase.NewFastBase()
+	fmt.Printf("Reading statistics of %s\n", fs.Arg(0))
+	mirror, err := fastbase.OpenMirror(fs.Arg(0), server.MaxTopLists)
+	switch {
+	case err == nil:
+		opts.Mirror = mirror
+	case errors.Is(err, fastbase.ErrNoOffsetTable):
+		fmt.Printf("%s has no offset table to read lists from in place; loading it into memory (rewrite it with compact to serve it from disk)\n", fs.Arg(0))
+		if fb, err = loadDatabase(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	srv := server.New(fb, opts)
+
+	fmt.Printf("Serving read-only mirror on %s (GET /stats, GET /find?x=<hex>, GET /prefix/{hex}, GET /healthz, GET /readyz)\n", *listen)
+	if err := http.ListenAndServe(*listen, srv.Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
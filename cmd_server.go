@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+	"rckangaroo/notify"
+	"rckangaroo/server"
+	"rckangaroo/sqlite"
+)
+
+func runServer(args []string) int {
+	fs := newFlagSet("server", "-db pool.db [-listen addr] [-keys file | -token T] [-tls-cert f -tls-key f] [-provenance] [-rate N] [-upload-rate N] [-check-dp bits] [-peers host:port,... -peer-token T] [-web addr] [-log-level L] [-journal] [-range-bits N -split-bits K ...] [-pubkey hex | -puzzle N] [-notify-* ...]")
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	dbFile := fs.String("db", "", "Database to aggregate into; created if it does not exist")
+	saveEvery := fs.Duration("save-every", 5*time.Minute, "How often to persist new records")
+	backgroundSave := fs.Bool("background-save", false, "Save a frozen copy of the database while uploads continue, taking as much memory again while it is written")
+	maxBatch := fs.Int64("max-batch", server.DefaultMaxBatchBytes, "Largest batch a worker may submit, in bytes")
+	rangeStart := fs.String("range-start", "0", "With -range-bits, start of the search range in hex")
+	rangeBits := fs.Int("range-bits", 0, "Width of the search range in bits; enables subrange leases")
+	splitBits := fs.Int("split-bits", 8, "Split the range into 2^N subranges for leasing")
+	leaseTTL := fs.Duration("lease-ttl", 15*time.Minute, "How long a lease lasts without renewal")
+	leaseFile := fs.String("leases", "", "File to persist lease state in (default: the database path + .leases.json)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file; serves HTTPS together with -tls-key")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	keysFile := fs.String("keys", "", "File of \"name token\" API keys workers must present")
+	token := fs.String("token", "", "Shared API key workers must present (key name \"shared\")")
+	provenance := fs.Bool("provenance", false, "Create a new database whose records carry the worker and time that submitted them")
+	web := fs.String("web", "", "Address to serve an HTML dashboard on, e.g. :8081; it needs no API key and shows no distances")
+	webEvery := fs.Duration("web-every", 10*time.Second, "How often the dashboard samples the database")
+	pubKey := fs.String("pubkey", "", "With -range-bits, public key to verify collisions against so found keys can be reported")
+	puzzle := fs.Int("puzzle", 0, "Bitcoin puzzle number; sets -pubkey, -range-start and -range-bits where not given")
+	rate := fs.Float64("rate", 0, "Requests per second allowed per client IP; 0 disables limiting")
+	burst := fs.Int("burst", 20, "Request burst allowed per client IP")
+	uploadRate := fs.Float64("upload-rate", 0, "DPs per second each worker may upload, by API key or else IP; 0 disables limiting")
+	uploadBurst := fs.Int("upload-burst", 100000, "DPs a worker may upload at once under -upload-rate; larger batches are refused")
+	checkDP := fs.Int("check-dp", 0, "Refuse batches with DPs not distinguished at this many bits; 0 skips the check")
+	wedgeTimeout := fs.Duration("wedge-timeout", server.DefaultWedgeTimeout, "How long GET /healthz waits on the database before reporting the server wedged")
+	peers := fs.String("peers", "", "Comma-separated pool servers to reconcile the database with, as host:port or URL")
+	peerToken := fs.String("peer-token", "", "API key the -peers know this server by")
+	peerCA := fs.String("peer-ca", "", "PEM file of CA certificates to trust for https -peers")
+	syncEvery := fs.Duration("sync-every", 10*time.Minute, "How often to reconcile with the -peers")
+	logLevel := fs.String("log-level", "", logLevelUsage)
+	useJournal := fs.Bool("journal", false, journalUsage)
+	nf := addNotifyFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *dbFile == "" {
+		fs.Usage()
+		return 1
+	}
+	if *rangeStart == "0" && *puzzle != 0 {
+		// The default start is no contradiction of the puzzle's
+		*rangeStart = ""
+	}
+	if err := applyPuzzle(*puzzle, pubKey, rangeStart, rangeBits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var target *kangaroo.Target
+	if *pubKey != "" {
+		t, err := parseTarget(*pubKey, *rangeStart, *rangeBits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		target = &t
+	}
+	notifiers, err := nf.notifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var leases *server.LeaseManager
+	if *rangeBits > 0 {
+		r, err := kangaroo.NewRange(*rangeStart, *rangeBits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		leases, err = server.NewLeaseManager(r.Start, r.Bits, *splitBits, *leaseTTL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if *leaseFile == "" {
+			*leaseFile = *dbFile + ".leases.json"
+		}
+		if _, err := os.Stat(*leaseFile); err == nil {
+			if err := leases.Load(*leaseFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Loaded lease state from %s\n", *leaseFile)
+		}
+		fmt.Printf("Leasing 2^%d subranges of 2^%d keys each\n", *splitBits, *rangeBits-*splitBits)
+	}
+
+	var peerList []server.Peer
+	if *peers != "" {
+		httpClient, err := serverHTTPClient(*peerCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		for _, url := range strings.Split(*peers, ",") {
+			peerList = append(peerList, server.Peer{URL: strings.TrimSpace(url), Token: *peerToken, Client: httpClient})
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fmt.Fprintf(os.Stderr, "Error: -tls-cert and -tls-key must be given together\n")
+		return 1
+	}
+
+	var auth *server.Auth
+	if *keysFile != "" || *token != "" {
+		auth = server.NewAuth()
+		if *token != "" {
+			auth.AddKey("shared", *token)
+		}
+		if *keysFile != "" {
+			if err := auth.LoadKeys(*keysFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+	} else {
+		fmt.Printf("Warning: no -keys or -token given, anyone can upload points\n")
+	}
+
+	// The server listens while the database loads, answering health probes
+	// only, so no collision comes before the schema is known
+	var schema fastbase.Schema
+	var printMu sync.Mutex
+	found := 0
+	srv := server.New(nil, server.Options{
+		MaxBatchBytes:  *maxBatch,
+		Leases:         leases,
+		Auth:           auth,
+		RateLimit:      *rate,
+		RateBurst:      *burst,
+		UploadRate:     *uploadRate,
+		UploadBurst:    *uploadBurst,
+		DPBits:         *checkDP,
+		WedgeTimeout:   *wedgeTimeout,
+		BackgroundSave: *backgroundSave,
+		Logger:         logger,
+		OnCollision: func(c fastbase.Collision) {
+			printMu.Lock()
+			defer printMu.Unlock()
+			found++
+			fmt.Printf("\n%s ", time.Now().Format(time.RFC3339))
+			printCollision(found, schema, c)
+			if target == nil {
+				// Nothing to verify against; every collision may be the one
+				go sendNotifications(notifiers, collisionEvent(schema, c))
+				return
+			}
+			key, err := kangaroo.Solve(kangaroo.Symmetric{}, *target, schema, c.First, c.Second)
+			if err != nil {
+				fmt.Printf("  result:       %v\n", err)
+				return
+			}
+			printKey("  ", key)
+			go sendNotifications(notifiers, keyEvent("server", *target, key))
+		},
+	})
+
+	ctx, stop := interruptContext()
+	defer stop()
+	started := time.Now()
+	httpServer := &http.Server{Addr: *listen, Handler: srv.Handler()}
+	serveErr := make(chan error, 1)
+	go func() {
+		if *tlsCert != "" {
+			serveErr <- httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		} else {
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+
+	fb, err := loadServerDatabase(*dbFile, *provenance)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	schema = fb.Schema()
+	fb.SetLogger(logger)
+	if *useJournal {
+		if err := openJournal(fb, *dbFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	srv.Ready(fb)
+
+	go func() {
+		for range time.Tick(*saveEvery) {
+			saved, err := srv.Save(*dbFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *dbFile, err)
+			} else if saved {
+				fmt.Printf("Saved %s\n", *dbFile)
+			}
+			if leases != nil {
+				if err := leases.Save(*leaseFile); err != nil {
+					fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *leaseFile, err)
+				}
+			}
+		}
+	}()
+
+	if len(peerList) > 0 {
+		go func() {
+			for {
+				for _, p := range peerList {
+					syncWithPeer(ctx, srv, p)
+				}
+				select {
+				case <-time.After(*syncEvery):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		fmt.Printf("Reconciling with %d peers every %v (GET /sync/summary, GET /sync/records)\n", len(peerList), *syncEvery)
+	}
+
+	if *web != "" {
+		ln, err := net.Listen("tcp", *web)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		dash := server.NewDashboard(srv)
+		dash.Sample()
+		go func() {
+			for range time.Tick(*webEvery) {
+				dash.Sample()
+			}
+		}()
+		go func() {
+			if err := http.Serve(ln, dash.Handler()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error serving the dashboard: %v\n", err)
+			}
+		}()
+		fmt.Printf("Dashboard on http://%s/\n", ln.Addr())
+	}
+
+	fmt.Printf("Aggregating into %s on %s (POST /dps, POST /dps/stream, GET /collisions, GET /events, GET /stats, GET /prefix/{hex}, GET /find?x=<hex>)\n", *dbFile, *listen)
+	if auth != nil {
+		fmt.Printf("Uploads, leases, collisions, events, GET /keys and GET /workers require an API key\n")
+	}
+	if leases != nil {
+		fmt.Printf("Leasing subranges (POST /leases, POST /leases/{id}/renew, POST /leases/{id}/done, GET /leases)\n")
+	}
+	fmt.Printf("Probes: GET /healthz fails when wedged, GET /readyz also while loading or short of memory\n")
+	select {
+	case err := <-serveErr:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	case <-ctx.Done():
+	}
+
+	// Let batches being applied finish, but don't wait long on event
+	// streams that never end
+	fmt.Printf("\nShutting down...\n")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		httpServer.Close()
+	}
+
+	code := 0
+	if saved, err := srv.Save(*dbFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *dbFile, err)
+		code = 1
+	} else if saved {
+		fmt.Printf("Saved %s\n", *dbFile)
+	}
+	if err := fb.CloseJournal(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing the journal of %s: %v\n", *dbFile, err)
+		code = 1
+	}
+	if leases != nil {
+		if err := leases.Save(*leaseFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *leaseFile, err)
+			code = 1
+		}
+	}
+
+	printMu.Lock()
+	defer printMu.Unlock()
+	fmt.Printf("\nServed for %s: %d records in %s, %d collisions since startup\n",
+		time.Since(started).Round(time.Second), srv.Stats().TotalRecords, *dbFile, found)
+	return code
+}
+
+// loadServerDatabase loads the database a server aggregates into, or
+// creates it, with room for provenance if asked to
+func loadServerDatabase(dbFile string, provenance bool) (*fastbase.FastBase, error) {
+	path := dbFile
+	if sqlite.IsURL(dbFile) {
+		path = sqlite.Path(dbFile)
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Printf("Creating new FastBase file: %s\n", dbFile)
+		var opts []fastbase.Option
+		if provenance {
+			opts = append(opts, fastbase.WithProvenance())
+			fmt.Printf("Recording the worker and time of every new record\n")
+		}
+		return fastbase.NewFastBase(opts...), nil
+	}
+	fb, err := loadDatabase(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	if provenance && !fb.HasProvenance() {
+		fmt.Printf("Warning: %s has no room for provenance; -provenance only applies to new databases\n", dbFile)
+	}
+	if fb.HasProvenance() {
+		fmt.Printf("Recording the worker and time of every new record\n")
+	}
+	return fb, nil
+}
+
+// syncWithPeer reconciles the server's database with a peer's once,
+// printing what was exchanged
+func syncWithPeer(ctx context.Context, srv *server.Server, p server.Peer) {
+	started := time.Now()
+	st, err := srv.SyncWith(ctx, p)
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Error syncing with %s: %v\n", p.URL, err)
+	}
+	if st.Differed > 0 {
+		fmt.Printf("%s Synced with %s in %s: %d of %d buckets differed, pulled %d records (%d new, %d collisions), pushed %d\n",
+			time.Now().Format(time.RFC3339), p.URL, time.Since(started).Round(time.Millisecond),
+			st.Differed, st.Compared, st.Pulled, st.Added, st.Collisions, st.Pushed)
+	}
+}
+
+// collisionEvent describes a collision the server has no target to verify
+func collisionEvent(schema fastbase.Schema, c fastbase.Collision) notify.Event {
+	var details strings.Builder
+	for _, rec := range [][]byte{c.First, c.Second} {
+		fmt.Fprintf(&details, "x=%x d=%x type=%s\n", schema.X(rec), schema.Distance(rec), schema.Type(rec))
+	}
+	return notify.Event{
+		Time:    time.Now(),
+		Source:  "server",
+		Message: fmt.Sprintf("rckangaroo server found a collision at [%02x %02x %02x]", c.Prefix[0], c.Prefix[1], c.Prefix[2]),
+		Details: strings.TrimSpace(details.String()),
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/server"
+)
+
+func runServerStatus(args []string) int {
+	fs := newFlagSet("server-status", "-server host:port|https://host:port [-token T] [-dead 10m] [-json]")
+	serverAddr := fs.String("server", "", "Pool server to ask, as host:port or URL")
+	token := fs.String("token", "", "API key for the pool server; any worker's will do")
+	caFile := fs.String("ca", "", "PEM file of CA certificates to trust for an https server, e.g. a self-signed one")
+	dead := fs.Duration("dead", 10*time.Minute, "Flag workers not seen for this long as dead")
+	jsonOut := fs.Bool("json", false, "Print the leaderboard as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *serverAddr == "" {
+		fs.Usage()
+		return 1
+	}
+	httpClient, err := serverHTTPClient(*caFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	base := strings.TrimSuffix(*serverAddr, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	var board []server.WorkerStats
+	if err := getServerJSON(httpClient, base+"/workers", *token, &board); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(board)
+		return 0
+	}
+
+	// Readiness needs no key and fails with the details of why
+	var health struct {
+		Status string  `json:"status"`
+		Uptime float64 `json:"uptime_seconds"`
+	}
+	if err := getServerJSON(httpClient, base+"/readyz", "", &health); err == nil || health.Status != "" {
+		fmt.Printf("Server %s: %s, up %s\n\n", base, health.Status, formatClock(time.Duration(health.Uptime*float64(time.Second))))
+	}
+
+	fmt.Printf("%4s  %-16s %12s %7s %10s %8s %10s %10s  %s\n", "Rank", "Worker", "Added", "Share", "Duplicates", "Invalid", "Collisions", "DPs/s", "Last seen")
+	now := time.Now()
+	deadCount := 0
+	for _, w := range board {
+		seen := "never"
+		if !w.LastSeen.IsZero() {
+			idle := now.Sub(w.LastSeen)
+			seen = formatClock(idle) + " ago"
+			if idle >= *dead {
+				seen += ", dead"
+				deadCount++
+			}
+		}
+		fmt.Printf("%4d  %-16s %12d %6.1f%% %10d %8d %10d %10.3g  %s\n", w.Rank, w.Name, w.Added, w.Share*100,
+			w.Duplicates, w.Invalid, w.Collisions, w.Rate, seen)
+	}
+	if deadCount > 0 {
+		fmt.Printf("\n%d of %d workers not seen for %v\n", deadCount, len(board), *dead)
+	}
+	return 0
+}
+
+// getServerJSON decodes the JSON a pool server answers a GET of url with.
+// Error answers carrying JSON are decoded too, besides being reported.
+func getServerJSON(c *http.Client, url, token string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	jerr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if jerr != nil {
+		return fmt.Errorf("invalid server response: %v", jerr)
+	}
+	return nil
+}
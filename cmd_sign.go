@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runSign(args []string) int {
+	fs := newFlagSet("sign", "-generate key.pem [-name name] | -key key.pem file.db ...")
+	generate := fs.String("generate", "", "Create a new signing key in this file and print its trusted keys line")
+	name := fs.String("name", "worker", "Name to print in the trusted keys line of a generated key")
+	keyFile := fs.String("key", "", "Sign the databases with the key in this file")
+	fs.Parse(args)
+
+	if *generate != "" {
+		pub, err := fastbase.GenerateSigningKey(*generate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Signing key written to: %s\n", *generate)
+		fmt.Printf("Add this line to the pool's trusted keys file:\n%s %x\n", *name, pub)
+		return 0
+	}
+
+	if *keyFile == "" || fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+	key, err := fastbase.LoadSigningKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, filename := range fs.Args() {
+		if err := fastbase.SignFile(filename, key); err != nil {
+			fmt.Fprintf(os.Stderr, "Error signing %s: %v\n", filename, err)
+			return 1
+		}
+		fmt.Printf("Signed %s: %s%s\n", filename, filename, fastbase.SignatureSuffix)
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"time"
+
+	"rckangaroo/kangaroo"
+)
+
+func runSimulate(args []string) int {
+	fs := newFlagSet("simulate", "[-range bits] [-n trials] [-dp bits] [-strategy symmetric|naive] [-seed N]")
+	bits := fs.Int("range", 32, "Width of the ranges the keys are planted in, in bits")
+	trials := fs.Int("n", 10, "Keys to plant and solve")
+	dpBits := fs.Int("dp", -1, "DP bits; chosen for the range if negative")
+	strategy := fs.String("strategy", "symmetric", "Key derivation strategy: symmetric or naive")
+	maxOps := fs.Float64("max", 20, "Count a trial as failed after this many times the expected jumps")
+	workers := fs.Int("workers", runtime.NumCPU(), "Goroutines walking kangaroos")
+	kangaroos := fs.Int("kangaroos", 64, "Kangaroos per worker")
+	seed := fs.Int64("seed", 0, "Seed of the keys and start positions; random if 0")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *trials < 1 {
+		fs.Usage()
+		return 1
+	}
+	var strat kangaroo.Strategy
+	switch *strategy {
+	case "symmetric":
+		strat = kangaroo.Symmetric{}
+	case "naive":
+		strat = kangaroo.Naive{}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown strategy %q\n", *strategy)
+		return 1
+	}
+	if *dpBits < 0 {
+		*dpBits = autoDPBits(*bits, *workers**kangaroos)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	cfg := kangaroo.SimConfig{
+		Bits: *bits, DPBits: *dpBits, Workers: *workers, Kangaroos: *kangaroos,
+		MaxOps: *maxOps, Strategy: strat,
+	}
+
+	fmt.Printf("Simulating %d solves of 2^%d ranges with %d x %d kangaroos, DP %d, %s strategy, seed %d\n\n",
+		*trials, *bits, *workers, *kangaroos, *dpBits, strat.Name(), *seed)
+	fmt.Printf("%5s %18s %21s %8s %9s %7s %10s  %s\n", "Trial", "Key", "Jumps", "xExpect", "DPs", "Cycles", "Runtime", "Result")
+	ctx, stop := interruptContext()
+	defer stop()
+	expected := kangaroo.ExpectedOps(*bits)
+	solved, failed := 0, 0
+	var ratios float64
+	for i := 1; i <= *trials; i++ {
+		res, err := kangaroo.Simulate(ctx, cfg, rng)
+		if ctx.Err() != nil {
+			fmt.Printf("\nInterrupted\n")
+			break
+		}
+		result := "solved"
+		switch {
+		case err != nil:
+			result = "error: " + err.Error()
+			failed++
+		case !res.Solved():
+			result = fmt.Sprintf("not solved, %d collisions", res.Collisions)
+			failed++
+		default:
+			solved++
+			ratios += float64(res.Ops) / expected
+		}
+		fmt.Printf("%5d %18x %21s %8.2f %9d %7d %10v  %s\n", i, res.Key, formatOps(float64(res.Ops)),
+			float64(res.Ops)/expected, res.DPs, res.Cycles, res.Runtime.Round(time.Millisecond), result)
+	}
+
+	fmt.Printf("\nSolved %d of %d", solved, solved+failed)
+	if solved > 0 {
+		fmt.Printf(", on average in %.2fx the expected jumps (%.2f sqrt(range))", ratios/float64(solved), ratios/float64(solved)*kangaroo.SOTAFactor)
+	}
+	fmt.Println()
+	if failed > 0 || ctx.Err() != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/gpu"
+	"rckangaroo/kangaroo"
+)
+
+func runSolve(args []string) int {
+	fs := newFlagSet("solve", "(-pubkey <hex>[,<hex>...] [-pubkeys file] -start <hex> -range <bits> | -puzzle N) [-dp bits] [-tames tames.db] [-out file.db [-resume]] [-ram-budget size [-spill-dir dir]] [-maxmem size [-on-maxmem refuse|spill|raise-dp]] [-max N] [-notify-* ...]")
+	pubKey := fs.String("pubkey", "", "Public keys to solve, compressed or uncompressed, in hex, separated by commas")
+	pubKeysFile := fs.String("pubkeys", "", "File of more public keys to solve in the same range, one per line")
+	start := fs.String("start", "", "Start of the key range, in hex")
+	bits := fs.Int("range", 0, "Width of the key range in bits")
+	puzzle := fs.Int("puzzle", 0, puzzleUsage)
+	dpBits := fs.Int("dp", -1, "DP bits; from the tames database if given, else chosen for the range")
+	tamesFile := fs.String("tames", "", "Tames database from gentames for the same range width, preloaded so the solve needs about half the jumps")
+	outFile := fs.String("out", "", "Save the DPs collected, tames included, and the kangaroos to this file as it runs")
+	saveEvery := fs.Duration("save-every", 5*time.Minute, "How often to save -out while running")
+	resume := fs.Bool("resume", false, "Continue from the DPs and kangaroos last saved to -out instead of starting over")
+	ramBudget := fs.String("ram-budget", "", "Memory for DPs, e.g. 16GB; the pools used least recently spill to disk beyond it once loaded or saved")
+	spillDir := fs.String("spill-dir", "", "With -ram-budget, directory to spill pools to (default: a new temporary directory)")
+	maxMem := fs.String("maxmem", "", "Memory the DPs may use, e.g. 32GB, lists included; see -on-maxmem")
+	onMaxMem := fs.String("on-maxmem", "refuse", "At -maxmem: refuse to stop as if interrupted, saving -out to resume from, spill to spill more pools to disk, stopping when only unsaved pools are left, raise-dp to raise the DP bits, dropping the DPs no longer distinguished")
+	maxOps := fs.Float64("max", 0, "Give up after this many times the expected jumps; 0 runs until solved")
+	workers := fs.Int("workers", runtime.NumCPU(), "Goroutines walking kangaroos")
+	kangaroos := fs.Int("kangaroos", kangaroo.DefaultKangaroos, "Kangaroos per worker")
+	every := fs.Duration("every", 10*time.Second, "How often to print progress")
+	gpuDevices := fs.String("gpu", "", "Walk on these CUDA devices instead of the CPU: \"all\" or indexes like 0,1; needs a build with -tags cuda, and tames from the C++ solver or earlier -gpu runs")
+	nf := addNotifyFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	if err := applyPuzzle(*puzzle, pubKey, start, bits); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	targets, err := parseTargets(*pubKey, *pubKeysFile, *start, *bits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	notifiers, err := nf.notifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *resume && (*outFile == "" || *tamesFile != "") {
+		fmt.Fprintf(os.Stderr, "Error: -resume needs -out, which already holds any tames, and no -tames\n")
+		return 1
+	}
+	if *gpuDevices != "" && (len(targets) > 1 || *resume) {
+		fmt.Fprintf(os.Stderr, "Error: -gpu solves one public key at a time and cannot -resume, its kangaroos stay on the devices\n")
+		return 1
+	}
+
+	policy, err := fastbase.ParseMemoryPolicy(*onMaxMem)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var opts []fastbase.Option
+	var budget float64
+	if *ramBudget != "" {
+		if budget, err = parseSize(*ramBudget); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if *maxMem != "" {
+		limit, err := parseSize(*maxMem)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		// Spilling to fit the limit lowers the budget from the limit itself
+		if policy == fastbase.MemorySpill && budget == 0 {
+			budget = limit
+		}
+		opts = append(opts, fastbase.WithMemoryLimit(int64(limit), policy))
+		fmt.Printf("Limiting memory to %s, then: %s\n", formatBytes(int64(limit)), policy)
+	}
+	if budget > 0 {
+		if *spillDir == "" {
+			if *spillDir, err = os.MkdirTemp("", "rckangaroo-spill-"); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		opts = append(opts, fastbase.WithTiering(*spillDir, int64(budget)))
+		fmt.Printf("Keeping %s of DPs in memory, spilling to %s\n", formatBytes(int64(budget)), *spillDir)
+	}
+	fb := fastbase.NewFastBase(opts...)
+	if budget > 0 {
+		// Clearing removes the spilled pools
+		defer func() {
+			fb.ReleaseMemory()
+			os.Remove(*spillDir)
+		}()
+	}
+	if dbFile := *tamesFile; dbFile != "" || *resume {
+		if *resume {
+			dbFile = *outFile
+		}
+		if err := loadInto(fb, dbFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := checkWalkHeader(fb, *bits, dpBits); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", dbFile, err)
+			return 1
+		}
+		fmt.Printf("Preloaded %d DPs\n", fb.Stats().TotalRecords)
+	}
+	var solver kangaroo.Walker
+	var gpuSolver *gpu.Solver
+	var herds string
+	if *gpuDevices != "" {
+		devices, err := parseDevices(*gpuDevices)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if *dpBits < 0 {
+			*dpBits = max(autoDPBits(*bits, gpu.TypicalKangaroos*max(len(devices), 1)), gpu.MinDP)
+		}
+		gpuSolver, err = gpu.NewSolver(gpu.Config{Devices: devices, Target: targets[0], DPBits: *dpBits, Schema: fb.Schema()})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		solver, herds = gpuSolver, fmt.Sprintf("%d kangaroos on the GPU", gpuSolver.Kangaroos())
+	} else {
+		if *dpBits < 0 {
+			*dpBits = autoDPBits(*bits, *workers**kangaroos)
+		}
+		cpuSolver, err := kangaroo.NewSolver(kangaroo.SolverConfig{
+			Range: targets[0].Range, Targets: targets, DPBits: *dpBits, Schema: fb.Schema(),
+			Workers: *workers, Kangaroos: *kangaroos,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if *resume {
+			if err := resumeWalk(cpuSolver, *outFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		solver, herds = cpuSolver, fmt.Sprintf("%d x %d kangaroos", cpuSolver.Workers(), cpuSolver.Kangaroos())
+	}
+	fb.Header[fastbase.HeaderRange] = byte(*bits)
+	fb.Header[fastbase.HeaderDPBits] = byte(*dpBits)
+	var save func(*kangaroo.State) error
+	if *outFile != "" {
+		save = func(st *kangaroo.State) error { return saveWalk(fb, *outFile, st) }
+	}
+
+	if len(targets) == 1 {
+		fmt.Printf("Solving %x", targets[0].PubKey.Compressed())
+	} else {
+		fmt.Printf("Solving %d public keys", len(targets))
+	}
+	fmt.Printf(" in [%x, +2^%d) with %s, DP %d\n", targets[0].Range.Start, *bits, herds, *dpBits)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	// A collision of a tame with a wild solves the wild's target, which
+	// the record does not name; it is the one a derived key verifies for
+	solved := make([]*big.Int, len(targets))
+	found := 0
+	err = walk(ctx, solver, fb, *bits, *maxOps, *every, func(collisions []fastbase.Collision) bool {
+		schema := fb.Schema()
+		for _, c := range collisions {
+			for i, t := range targets {
+				if solved[i] != nil {
+					continue
+				}
+				if k, err := kangaroo.Solve(kangaroo.Symmetric{}, t, schema, c.First, c.Second); err == nil {
+					solved[i] = k
+					found++
+					if len(targets) > 1 {
+						fmt.Printf("Solved %d of %d: %x\n", found, len(targets), t.PubKey.Compressed())
+					}
+					break
+				}
+			}
+		}
+		return found < len(targets)
+	}, save, *saveEvery)
+	// Refused inserts end the run like an interrupt, so -out can resume it
+	var limitErr *fastbase.MemoryLimitError
+	limited := errors.As(err, &limitErr)
+	if err != nil && !limited {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if gpuSolver != nil && gpuSolver.Errors() > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d GPU kernel failures or lost DPs\n", gpuSolver.Errors())
+	}
+	interrupted := found < len(targets) && (ctx.Err() != nil || limited)
+	if limited {
+		fmt.Printf("\n%v\n", err)
+	}
+	if interrupted {
+		fmt.Printf("\nInterrupted after %s jumps and %d DPs\n", formatOps(float64(solver.Ops())), solver.DPs())
+	}
+	if *outFile != "" {
+		fmt.Printf("Saving DPs to: %s\n", *outFile)
+		st, _ := solver.Snapshot(context.Background())
+		if err := saveWalk(fb, *outFile, st); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving %s: %v\n", *outFile, err)
+			return 1
+		}
+	}
+	for i, t := range targets {
+		if solved[i] == nil {
+			continue
+		}
+		fmt.Println()
+		if len(targets) > 1 {
+			fmt.Printf("Target %d: %x\n", i+1, t.PubKey.Compressed())
+		}
+		printKey("", solved[i])
+		sendNotifications(notifiers, keyEvent("solve", t, solved[i]))
+	}
+	switch {
+	case interrupted:
+		return 1
+	case found < len(targets):
+		fmt.Printf("\nGave up after %s jumps with %d of %d solved\n", formatOps(float64(solver.Ops())), found, len(targets))
+		return 1
+	}
+	return 0
+}
+
+// parseTargets parses the comma-separated public keys of pubKeyList, and
+// those of listFile, one per line with # comments, as targets in one range
+func parseTargets(pubKeyList, listFile, startHex string, bits int) ([]kangaroo.Target, error) {
+	var hexKeys []string
+	for _, k := range strings.Split(pubKeyList, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			hexKeys = append(hexKeys, k)
+		}
+	}
+	if listFile != "" {
+		data, err := os.ReadFile(listFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				hexKeys = append(hexKeys, line)
+			}
+		}
+	}
+	if len(hexKeys) == 0 {
+		hexKeys = []string{""} // For parseTarget's error
+	}
+
+	targets := make([]kangaroo.Target, 0, len(hexKeys))
+	seen := make(map[string]bool)
+	for _, k := range hexKeys {
+		t, err := parseTarget(k, startHex, bits)
+		if err != nil {
+			return nil, err
+		}
+		pub := string(t.PubKey.Compressed())
+		if seen[pub] {
+			return nil, fmt.Errorf("public key %x is given twice", t.PubKey.Compressed())
+		}
+		seen[pub] = true
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// parseDevices parses the -gpu device list, nil for all devices
+func parseDevices(s string) ([]int, error) {
+	if s == "all" {
+		return nil, nil
+	}
+	var devices []int
+	for _, f := range strings.Split(s, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid GPU device %q", f)
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// checkWalkHeader checks that a database was collected for the range
+// width, and for the DP bits unless they are to be taken from it
+func checkWalkHeader(fb *fastbase.FastBase, bits int, dpBits *int) error {
+	if got := int(fb.Header[fastbase.HeaderRange]); got != bits {
+		return fmt.Errorf("collected for a %d-bit range, not %d bits", got, bits)
+	}
+	got := int(fb.Header[fastbase.HeaderDPBits])
+	if *dpBits >= 0 && *dpBits != got {
+		return fmt.Errorf("collected with DP %d, not %d", got, *dpBits)
+	}
+	*dpBits = got
+	return nil
+}
+
+// autoDPBits chooses DP bits leaving each kangaroo about 16 DPs on the way
+// to an expected solve, so little of the walks is lost below the threshold
+func autoDPBits(rangeBits, kangaroos int) int {
+	path := kangaroo.ExpectedOps(rangeBits) / float64(max(kangaroos, 1))
+	return max(int(math.Floor(math.Log2(path/16))), 0)
+}
+
+// walk runs a solver and adds the DPs it reaches to fb until collided
+// returns false for the collisions completed by a batch, maxOps times the
+// expected jumps of the range are done, or ctx is cancelled. Every interval
+// it prints progress, and every saveEvery it calls save, if given, with a
+// snapshot of the kangaroos that fb holds every DP of, or nil if they
+// cannot be saved, stopping on its error. The DPs the workers hold when stopped are added too, so none
+// walked are lost.
+func walk(ctx context.Context, solver kangaroo.Walker, fb *fastbase.FastBase, bits int, maxOps float64, every time.Duration, collided func([]fastbase.Collision) bool, save func(*kangaroo.State) error, saveEvery time.Duration) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan [][]byte, runtime.NumCPU())
+	done := make(chan struct{})
+	go func() {
+		solver.Run(ctx, out)
+		close(done)
+	}()
+
+	// add reports whether the walk should go on after a batch
+	add := func(batch [][]byte) (bool, error) {
+		_, collisions, err := fb.AddRecords(batch)
+		if err != nil {
+			return false, err
+		}
+		return len(collisions) == 0 || collided(collisions), nil
+	}
+
+	// snapshot keeps reading out while it waits, since a worker passing on
+	// a batch holds its herd, then adds the batches passed before the
+	// snapshot. It returns a nil state if ctx was cancelled first.
+	snapshot := func() (*kangaroo.State, bool, error) {
+		taken := make(chan *kangaroo.State, 1)
+		go func() {
+			st, _ := solver.Snapshot(ctx)
+			taken <- st
+		}()
+		for {
+			select {
+			case batch := <-out:
+				if more, err := add(batch); err != nil || !more {
+					return nil, more, err
+				}
+			case st := <-taken:
+				for len(out) > 0 {
+					if more, err := add(<-out); err != nil || !more {
+						return nil, more, err
+					}
+				}
+				return st, true, nil
+			}
+		}
+	}
+	defer func() {
+		cancel()
+		flush := func(batch [][]byte) {
+			if _, ferr := add(batch); ferr != nil && err == nil {
+				err = ferr
+			}
+		}
+		for running := true; running; {
+			select {
+			case batch := <-out:
+				flush(batch)
+			case <-done:
+				running = false
+			}
+		}
+		for len(out) > 0 {
+			flush(<-out)
+		}
+	}()
+
+	expected := kangaroo.ExpectedOps(bits)
+	started, startOps := time.Now(), solver.Ops()
+	lastSave := started
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch := <-out:
+			more, err := add(batch)
+			if err != nil {
+				return err
+			}
+			if !more {
+				printWalkStatus(solver, fb, expected, started, startOps)
+				return nil
+			}
+		case <-ticker.C:
+			printWalkStatus(solver, fb, expected, started, startOps)
+			if save != nil && saveEvery > 0 && time.Since(lastSave) >= saveEvery {
+				lastSave = time.Now()
+				st, more, err := snapshot()
+				if err != nil {
+					return err
+				}
+				if !more {
+					printWalkStatus(solver, fb, expected, started, startOps)
+					return nil
+				}
+				if err := save(st); err != nil {
+					return err
+				}
+			}
+			if maxOps > 0 && float64(solver.Ops()) >= maxOps*expected {
+				return nil
+			}
+		case <-ctx.Done():
+			printWalkStatus(solver, fb, expected, started, startOps)
+			return nil
+		}
+	}
+}
+
+// printWalkStatus prints the jumps made against those a solve of the range
+// is expected to take, the speed since started, when the solver had made
+// startOps jumps, any kangaroos restarted after cycling, and DP bits raised
+// under a memory limit
+func printWalkStatus(solver kangaroo.Walker, fb *fastbase.FastBase, expected float64, started time.Time, startOps uint64) {
+	ops := float64(solver.Ops())
+	elapsed := time.Since(started)
+	rate := (ops - float64(startOps)) / elapsed.Seconds()
+	notes := ""
+	if n := solver.Cycles(); n > 0 {
+		notes = fmt.Sprintf(", Cycles: %d", n)
+	}
+	if ls := fb.MemoryLimitStats(); ls.Raises > 0 {
+		notes += fmt.Sprintf(", DP raised to %d", ls.DPBits)
+	}
+	fmt.Printf("[%s] Jumps: %s (%.1f%% of expected), Speed: %.3g jumps/s, DPs: %d, Records: %d%s\n",
+		formatClock(elapsed), formatOps(ops), ops*100/expected, rate, solver.DPs(), fb.Stats().TotalRecords, notes)
+}
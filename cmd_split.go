@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"rckangaroo/chunks"
+)
+
+func runSplit(args []string) int {
+	fs := newFlagSet("split", "[-size 256MB] [-out dir] file.db")
+	size := fs.String("size", "256MB", "Size of each chunk")
+	outDir := fs.String("out", ".", "Directory to write the chunks and manifest to, for mirrors to serve")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	chunkSize, err := parseSize(*size)
+	if err != nil || chunkSize < 1 {
+		fmt.Fprintf(os.Stderr, "Error: invalid -size %q\n", *size)
+		return 1
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	m, err := chunks.Split(fs.Arg(0), *outDir, int64(chunkSize))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	manifest := filepath.Join(*outDir, m.Name+chunks.ManifestSuffix)
+	fmt.Printf("Split %s (%d bytes) into %d chunks of up to %d bytes\n", fs.Arg(0), m.Size, len(m.Chunks), m.ChunkSize)
+	fmt.Printf("Manifest: %s\n", manifest)
+	fmt.Printf("Serve %s from any number of mirrors, then download with:\n", *outDir)
+	fmt.Printf("  rckangaroo fetch -mirrors https://mirror1/path,https://mirror2/path %s\n", m.Name+chunks.ManifestSuffix)
+	return 0
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+func runStats(args []string) int {
+	fs := newFlagSet("stats", "[-json] [-top N] [-where expr] [-worker name] [-since time] [-watch interval] file.db")
+	jsonOut := fs.Bool("json", false, "Print statistics as JSON")
+	top := fs.Int("top", 0, "Also list the N fullest prefixes with per-type breakdowns")
+	where := fs.String("where", "", "Only count records matching this filter expression")
+	worker := fs.String("worker", "", "Only count records submitted by this worker, by API key name or 0x-prefixed ID")
+	since := fs.String("since", "", "Only count records submitted since this time, date or duration ago")
+	watch := fs.Duration("watch", 0, "Reload the database at this interval, or read what its journal gained, and print new DPs, per-type rates and the projected time to a collision")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	filename := fs.Arg(0)
+
+	if *watch != 0 {
+		if *watch < 0 || *jsonOut || *top > 0 || *worker != "" || *since != "" {
+			fmt.Fprintf(os.Stderr, "Error: -watch needs a positive interval and cannot be combined with -json, -top, -worker or -since\n")
+			return 1
+		}
+		return watchStats(filename, *watch, *where)
+	}
+
+	if *jsonOut {
+		fb, err := loadDatabaseQuiet(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		filters, err := statsFilters(fb, *where, *worker, *since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := printStatsJSON(fb, *top, filters...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fb, loadTime, err := loadDatabaseTimed(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	filters, err := statsFilters(fb, *where, *worker, *since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	printStats(fb, filename, loadTime, *top, filters...)
+	return 0
+}
+
+// statsFilters combines the -where filters with those of -worker and -since
+func statsFilters(fb *fastbase.FastBase, where, worker, since string) ([]fastbase.Filter, error) {
+	filters, err := compileWhere(fb, where)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := provenanceFilters(fb, worker, since)
+	return append(filters, pf...), err
+}
+
+// formatETA renders a number of seconds as a duration, or in years when
+// it runs past one
+func formatETA(secs float64) string {
+	const year = 365.25 * 24 * 3600
+	if secs >= year {
+		return fmt.Sprintf("%.3g years", secs/year)
+	}
+	return time.Duration(secs * float64(time.Second)).Round(time.Second).String()
+}
+
+// statsSample is what one reload of a watched database found
+type statsSample struct {
+	at      time.Time
+	modTime time.Time
+	size    int64
+	types   [3]int
+
+	// The journal beside the database, read up to offset, if it keeps one
+	journal     os.FileInfo
+	journalBase uint64
+	offset      int64
+	journaled   [3]int
+}
+
+// total returns the records of all types in the sample
+func (s statsSample) total() int {
+	return s.types[0] + s.types[1] + s.types[2]
+}
+
+// watchStats reloads a database every interval and prints a line per
+// sample with the DPs added since the previous one, the rate per type and
+// the time left until the expected number of DPs for a collision, as far
+// as the header records the range and DP bits. An unchanged file is not
+// reloaded, and a failed reload, as of a file being rewritten, only skips
+// a sample. A database keeping a journal is reloaded only when checkpoints
+// replace it; in between, the records appended to the journal are read
+// and counted. It runs until interrupted.
+func watchStats(filename string, interval time.Duration, where string) int {
+	journalFile := filename + fastbase.JournalSuffix
+	var filters []fastbase.Filter
+	sample := func(prev statsSample) (statsSample, *fastbase.FastBase, error) {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return prev, nil, err
+		}
+		cur, fb := prev, (*fastbase.FastBase)(nil)
+		cur.at = time.Now()
+		if !fi.ModTime().Equal(prev.modTime) || fi.Size() != prev.size {
+			if fb, err = loadDatabaseQuiet(filename); err != nil {
+				return prev, nil, err
+			}
+			if filters, err = compileWhere(fb, where); err != nil {
+				return prev, nil, err
+			}
+			cur = statsSample{at: cur.at, modTime: fi.ModTime(), size: fi.Size(),
+				journalBase: binary.LittleEndian.Uint64(fb.Header[fastbase.HeaderSnapshot:])}
+			for t, ts := range fb.Stats(filters...).Types {
+				cur.types[t] = ts.Count
+			}
+		}
+
+		ji, err := os.Stat(journalFile)
+		if err != nil {
+			return cur, fb, nil
+		}
+		// A journal replaced by a checkpoint is read from the start
+		start := cur.offset
+		if cur.journal == nil || !os.SameFile(ji, cur.journal) || ji.Size() < start {
+			start = 0
+		}
+		var journaled [3]int
+		info, offset, err := fastbase.TailJournal(journalFile, start, func(prefix [3]byte, rec []byte) {
+			for _, f := range filters {
+				if !f(prefix, rec) {
+					return
+				}
+			}
+			if t := rec[len(rec)-1]; t < 3 {
+				journaled[t]++
+			}
+		})
+		// A journal not yet checkpointed into the file loaded holds records
+		// it may have, so it waits for the checkpoint
+		if err != nil || info.BaseID != cur.journalBase {
+			return cur, fb, nil
+		}
+		if start == 0 {
+			for t, n := range cur.journaled {
+				cur.types[t] -= n
+			}
+			cur.journaled = [3]int{}
+		}
+		for t, n := range journaled {
+			cur.types[t] += n
+			cur.journaled[t] += n
+		}
+		cur.journal, cur.offset = ji, offset
+		return cur, fb, nil
+	}
+
+	prev, fb, err := sample(statsSample{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	rangeBits, dpBits := int(fb.Header[fastbase.HeaderRange]), int(fb.Header[fastbase.HeaderDPBits])
+	expected := 0.0
+	fmt.Printf("Watching %s every %s\n", filename, interval)
+	if rangeBits > 0 {
+		expected = kangaroo.ExpectedDPs(rangeBits, dpBits)
+		fmt.Printf("Range 2^%d with DP %d: about %.4g DPs expected for a collision\n", rangeBits, dpBits, expected)
+	}
+	fmt.Printf("%s  %d DPs (tame %d, wild1 %d, wild2 %d)\n", prev.at.Format(time.DateTime),
+		prev.total(), prev.types[0], prev.types[1], prev.types[2])
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cur, _, err := sample(prev)
+		if err != nil {
+			fmt.Printf("%s  reload failed: %v\n", time.Now().Format(time.DateTime), err)
+			continue
+		}
+
+		secs := cur.at.Sub(prev.at).Seconds()
+		rate := func(n int) float64 { return float64(n) / secs }
+		added := cur.total() - prev.total()
+		line := fmt.Sprintf("%s  %d DPs (%+d, %.1f/s)  tame %.1f/s  wild1 %.1f/s  wild2 %.1f/s",
+			cur.at.Format(time.DateTime), cur.total(), added, rate(added),
+			rate(cur.types[0]-prev.types[0]), rate(cur.types[1]-prev.types[1]), rate(cur.types[2]-prev.types[2]))
+		if expected > 0 {
+			line += fmt.Sprintf("  %.1f%% of expected", float64(cur.total())*100/expected)
+			switch remaining := expected - float64(cur.total()); {
+			case remaining <= 0:
+				line += ", past the expected count"
+			case added > 0:
+				line += ", ETA " + formatETA(remaining/rate(added))
+			}
+		}
+		fmt.Println(line)
+		prev = cur
+	}
+	return 0
+}
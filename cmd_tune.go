@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+// defaultKangaroos is the herd of one 128-SM GPU as RCKangaroo sizes it:
+// 256 threads of 24 kangaroos per SM
+const defaultKangaroos = 256 * 24 * 128
+
+// DP bits the C++ RCKangaroo accepts
+const (
+	minDPBits = 14
+	maxDPBits = 60
+)
+
+func runTune(args []string) int {
+	fs := newFlagSet("tune", "-range 2^78 -mem 64GB -rate 8GKeys/s [-kangaroos N] [-record-length N] [-prefix-depth N]")
+	rangeStr := fs.String("range", "", "Range width, as 2^N or N bits")
+	memStr := fs.String("mem", "", "Memory available to the FastBase, e.g. 64GB (binary units)")
+	rateStr := fs.String("rate", "", "Jumps per second of all workers, e.g. 8GKeys/s (decimal units)")
+	kangaroos := fs.Float64("kangaroos", defaultKangaroos, "Kangaroos walking at once across all workers")
+	recordLength := fs.Int("record-length", fastbase.DBRecordLength, "Record length of the database")
+	prefixDepth := fs.Int("prefix-depth", fastbase.DefaultLayout.PrefixDepth, "Prefix depth of the database")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *rangeStr == "" || *memStr == "" || *rateStr == "" {
+		fs.Usage()
+		return 1
+	}
+	rangeBits, err := parseRangeBits(*rangeStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	mem, err := parseSize(*memStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	rate, err := parseRate(*rateStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	layout := fastbase.DefaultLayout
+	layout.RecordLength, layout.PrefixDepth = *recordLength, *prefixDepth
+	if err := layout.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *kangaroos < 1 {
+		fmt.Fprintf(os.Stderr, "Error: -kangaroos must be positive\n")
+		return 1
+	}
+
+	// The lowest DP that fits wastes the least work: every kangaroo walks
+	// on about 2^DP jumps past the collision before its next DP shows it
+	ops := kangaroo.ExpectedOps(rangeBits)
+	memory := func(dp int) float64 { return layout.EstimateMemory(kangaroo.ExpectedDPs(rangeBits, dp)) }
+	best := minDPBits
+	for best <= maxDPBits && memory(best) > mem {
+		best++
+	}
+	if best > maxDPBits {
+		fmt.Fprintf(os.Stderr, "Error: even DP %d needs more than %s\n", maxDPBits, formatBytes(int64(mem)))
+		return 1
+	}
+
+	fmt.Printf("Range 2^%d, %s of memory, %.4g jumps/s, %.4g kangaroos\n", rangeBits, formatBytes(int64(mem)), rate, *kangaroos)
+	fmt.Printf("Expected operations: %s, %s at that rate without DP overhead\n\n", formatOps(ops), formatETA(ops/rate))
+	fmt.Printf("  DP  Expected DPs  Memory       DPs/kangaroo  DP overhead  Expected time\n")
+	for dp := max(minDPBits, best-3); dp <= min(maxDPBits, best+3); dp++ {
+		dps := kangaroo.ExpectedDPs(rangeBits, dp)
+		overhead := *kangaroos * math.Pow(2, float64(dp))
+		mark := " "
+		switch {
+		case dp == best:
+			mark = "*"
+		case memory(dp) > mem:
+			mark = "!"
+		}
+		fmt.Printf("%s %2d  %12.4g  %-11s  %12.4g  %10.2f%%  %s\n", mark, dp, dps, formatBytes(int64(memory(dp))),
+			dps / *kangaroos, overhead/ops*100, formatETA((ops+overhead)/rate))
+	}
+
+	fmt.Printf("\nRecommended: DP %d (* above; ! marks DPs that do not fit)\n", best)
+	fmt.Printf("Each DP bit less halves the jumps the kangaroos waste walking on to their next DP\n")
+	fmt.Printf("after the collision, about kangaroos x 2^DP, but doubles the DPs the FastBase holds.\n")
+	if best == minDPBits {
+		fmt.Printf("DP %d is the lowest RCKangaroo accepts, and its expected DPs fit in %s.\n", best, formatBytes(int64(mem)))
+	} else {
+		fmt.Printf("DP %d is the lowest whose expected DPs fit in %s.\n", best, formatBytes(int64(mem)))
+	}
+	if kangaroo.ExpectedDPs(rangeBits, best) / *kangaroos < 5 {
+		fmt.Printf("Warning: under 5 DPs per kangaroo, so DP overhead is large; more memory or fewer kangaroos would help.\n")
+	}
+	return 0
+}
+
+// parseRangeBits parses a range width given as 2^N or N
+func parseRangeBits(s string) (int, error) {
+	bits, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(s), "2^"))
+	if err != nil || bits < 1 || bits > 256 {
+		return 0, fmt.Errorf("invalid range %q, want 2^N or N with N in 1...256", s)
+	}
+	return bits, nil
+}
+
+// splitUnit splits a number with an optional K, M, G, T, P or E multiplier
+// from the unit text that follows
+func splitUnit(s string) (float64, byte, string, bool) {
+	s = strings.TrimSpace(s)
+	digit := func(i int) bool { return i < len(s) && s[i] >= '0' && s[i] <= '9' }
+	end := 0
+scan:
+	for end < len(s) {
+		switch {
+		case digit(end) || s[end] == '.':
+			end++
+		case (s[end] == 'e' || s[end] == 'E') && end > 0 && (digit(end+1) || s[end+1:] != "" && s[end+1] == '-' && digit(end+2)):
+			end += 2
+		default:
+			break scan
+		}
+	}
+	v, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil || v <= 0 {
+		return 0, 0, "", false
+	}
+	rest := s[end:]
+	var mult byte
+	if rest != "" && strings.IndexByte("KMGTPE", rest[0]&^0x20) >= 0 && !strings.HasPrefix(strings.ToLower(rest), "key") {
+		mult, rest = rest[0]&^0x20, rest[1:]
+	}
+	return v, mult, strings.ToLower(rest), true
+}
+
+// parseSize parses a memory size such as 64GB or 512MiB, in binary units
+func parseSize(s string) (float64, error) {
+	v, mult, unit, ok := splitUnit(s)
+	if !ok || unit != "" && unit != "b" && unit != "ib" {
+		return 0, fmt.Errorf("invalid memory size %q, want e.g. 64GB", s)
+	}
+	if mult != 0 {
+		v *= math.Pow(1024, float64(strings.IndexByte("KMGTPE", mult)+1))
+	}
+	return v, nil
+}
+
+// parseRate parses a jump rate such as 8GKeys/s or 2.5e9, in decimal units
+func parseRate(s string) (float64, error) {
+	v, mult, unit, ok := splitUnit(s)
+	switch unit {
+	case "", "/s", "keys/s", "key/s", "keys", "jumps/s":
+	default:
+		ok = false
+	}
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q, want e.g. 8GKeys/s", s)
+	}
+	if mult != 0 {
+		v *= math.Pow(1000, float64(strings.IndexByte("KMGTPE", mult)+1))
+	}
+	return v, nil
+}
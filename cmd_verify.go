@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+func runVerify(args []string) int {
+	fs := newFlagSet("verify", "-trusted keys.txt file.db ...")
+	trustedFile := fs.String("trusted", "", "File of \"name hexkey\" lines listing the keys whose signatures are accepted")
+	fs.Parse(args)
+
+	if *trustedFile == "" || fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+	trusted, err := fastbase.LoadTrustedKeys(*trustedFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, filename := range fs.Args() {
+		signer, err := fastbase.VerifyFile(filename, trusted)
+		if err != nil {
+			fmt.Printf("%s: REJECTED: %v\n", filename, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: signed by %s\n", filename, signer)
+	}
+	if failed > 0 {
+		fmt.Printf("\n%d of %d files failed verification\n", failed, fs.NArg())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/server"
+)
+
+func runWorkers(args []string) int {
+	fs := newFlagSet("workers", "[-keys file] [-since time] [-until time] [-period all|day|week|month] [-format text|csv|json] [-out file] file.db")
+	keysFile := fs.String("keys", "", "The server's file of \"name token\" API keys, to name the workers")
+	since := fs.String("since", "", "Only count records submitted since this time, date or duration ago")
+	until := fs.String("until", "", "Only count records submitted before this time, date or duration ago")
+	periodName := fs.String("period", "all", "Count each worker's records per UTC day, ISO week or month, or over all records counted")
+	format := fs.String("format", "text", "Output format: text, or csv or json for computing payouts or credit")
+	outFile := fs.String("out", "", "Write to this file instead of stdout")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *format != "text" && *format != "csv" && *format != "json" {
+		fs.Usage()
+		return 1
+	}
+	period, err := fastbase.ParsePeriod(*periodName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	names, err := workerNames(*keysFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Reports go to stdout unless -out is given, so keep it clean
+	fb, err := loadDatabaseQuiet(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !fb.HasProvenance() {
+		fmt.Fprintf(os.Stderr, "Error: %s does not record provenance (see server -provenance)\n", fs.Arg(0))
+		return 1
+	}
+	filters, err := provenanceFilters(fb, "", *since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *until != "" {
+		t, err := parseSince(*until)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		filters = append(filters, fb.UntilFilter(t))
+	}
+
+	var w io.Writer = os.Stdout
+	if *outFile != "" {
+		file, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	contribs, uncredited := fb.Contributions(period, filters...)
+	switch *format {
+	case "csv":
+		err = writeContributionsCSV(w, contribs, names)
+	case "json":
+		err = writeContributionsJSON(w, contribs, names)
+	default:
+		writeContributions(w, contribs, uncredited, names)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *outFile != "" {
+		fmt.Printf("Wrote %d contributions to: %s\n", len(contribs), *outFile)
+	}
+	return 0
+}
+
+// writeContributions prints contributions as a table per period
+func writeContributions(w io.Writer, contribs []fastbase.Contribution, uncredited int, names map[uint32]string) {
+	total := 0
+	workers := make(map[uint32]bool)
+	for i, c := range contribs {
+		if c.Rank == 1 {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			if !c.Period.IsZero() {
+				fmt.Fprintf(w, "Period from %s\n", c.Period.Format("2006-01-02"))
+			}
+			fmt.Fprintf(w, "%4s  %-8s  %-16s %12s %7s %10s %10s %10s  %-20s  %-20s\n",
+				"Rank", "Worker", "Name", "Records", "Share", "Tame", "Wild1", "Wild2", "First", "Last")
+		}
+		fmt.Fprintf(w, "%4d  %08x  %-16s %12d %6.2f%% %10d %10d %10d  %-20s  %-20s\n", c.Rank, c.Worker, names[c.Worker],
+			c.Records, c.Share*100, c.Types[0], c.Types[1], c.Types[2], formatTime(c.First), formatTime(c.Last))
+		total += c.Records
+		workers[c.Worker] = true
+	}
+	fmt.Fprintf(w, "\n%d workers, %d records credited\n", len(workers), total)
+	if uncredited > 0 {
+		fmt.Fprintf(w, "%d records imported or submitted without an API key are credited to no one\n", uncredited)
+	}
+}
+
+// contributionRow is a contribution as exported, with its worker's name
+// and times in UTC
+type contributionRow struct {
+	Period  string  `json:"period,omitempty"`
+	Rank    int     `json:"rank"`
+	Worker  string  `json:"worker"`
+	Name    string  `json:"name"`
+	Records int     `json:"records"`
+	Tame    int     `json:"tame"`
+	Wild1   int     `json:"wild1"`
+	Wild2   int     `json:"wild2"`
+	Share   float64 `json:"share"`
+	First   string  `json:"first,omitempty"`
+	Last    string  `json:"last,omitempty"`
+}
+
+func newContributionRow(c fastbase.Contribution, names map[uint32]string) contributionRow {
+	utc := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	row := contributionRow{
+		Rank: c.Rank, Worker: fmt.Sprintf("%08x", c.Worker), Name: names[c.Worker], Records: c.Records,
+		Tame: c.Types[0], Wild1: c.Types[1], Wild2: c.Types[2], Share: c.Share, First: utc(c.First), Last: utc(c.Last),
+	}
+	if !c.Period.IsZero() {
+		row.Period = c.Period.Format("2006-01-02")
+	}
+	return row
+}
+
+// writeContributionsCSV writes contributions as CSV with a header line
+func writeContributionsCSV(w io.Writer, contribs []fastbase.Contribution, names map[uint32]string) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"period", "rank", "worker", "name", "records", "tame", "wild1", "wild2", "share", "first", "last"})
+	for _, c := range contribs {
+		r := newContributionRow(c, names)
+		cw.Write([]string{r.Period, strconv.Itoa(r.Rank), r.Worker, r.Name, strconv.Itoa(r.Records), strconv.Itoa(r.Tame),
+			strconv.Itoa(r.Wild1), strconv.Itoa(r.Wild2), strconv.FormatFloat(r.Share, 'f', 6, 64), r.First, r.Last})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeContributionsJSON writes contributions as a JSON array
+func writeContributionsJSON(w io.Writer, contribs []fastbase.Contribution, names map[uint32]string) error {
+	rows := make([]contributionRow, len(contribs))
+	for i, c := range contribs {
+		rows[i] = newContributionRow(c, names)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// workerNames maps worker IDs to the API key names of a server keys file,
+// if given, and worker 0 to the workers without a key
+func workerNames(keysFile string) (map[uint32]string, error) {
+	names := map[uint32]string{0: "(no key)"}
+	if keysFile == "" {
+		return names, nil
+	}
+	auth := server.NewAuth()
+	if err := auth.LoadKeys(keysFile); err != nil {
+		return nil, err
+	}
+	for name := range auth.Stats() {
+		names[fastbase.WorkerID(name)] = name
+	}
+	return names, nil
+}
+
+// workerName returns the name of a worker, or its ID in hex
+func workerName(names map[uint32]string, id uint32) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("%08x", id)
+}
+
+// formatTime renders a time in local time, or "-" if it is unknown
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+	"rckangaroo/keys"
+	"rckangaroo/notify"
+	"rckangaroo/objstore"
+	"rckangaroo/query"
+	"rckangaroo/sqlite"
+)
+
+// command is a CLI subcommand invoked as "rckangaroo <name> [flags] [args]"
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"audit":         {"Detect improbable duplicate distances and repeated x-coordinates, clustered by origin", runAudit},
+		"autotune":      {"Measure storage modes on this host and recommend one for an expected DP count", runAutotune},
+		"bench":         {"Measure insert, lookup, save, load and merge throughput", runBench},
+		"browse":        {"Browse prefixes and records of a database interactively in the terminal", runBrowse},
+		"client":        {"Upload distinguished points to a pool server, spooling them while offline", runClient},
+		"collisions":    {"Find same-x records of different types and derive keys", runCollisions},
+		"compact":       {"Rewrite a database without duplicates or slack, optionally removing records", runCompact},
+		"derive":        {"Derive and verify the private key from a colliding tame and wild record", runDerive},
+		"diff":          {"Compare two databases and optionally save the records only in the second", runDiff},
+		"digest":        {"Print a hash of a database's records and find the prefixes where two databases differ", runDigest},
+		"estimate":      {"Estimate the work left and the chance a collision has already occurred", runEstimate},
+		"experiment":    {"Compare collision/key-derivation strategies on a database", runExperiment},
+		"encrypt":       {"Encrypt or decrypt a database with the key file or passphrase in the environment", runEncrypt},
+		"export":        {"Export records as CSV or NDJSON", runExport},
+		"fetch":         {"Download a database split into chunks from several mirrors at once, verifying and resuming", runFetch},
+		"find":          {"Look up records by truncated x-coordinate", runFind},
+		"fsck":          {"Check a database file and salvage what a truncated file still holds", runFsck},
+		"generate":      {"Generate a random database for testing, optionally with planted collisions", runGenerate},
+		"follow":        {"Collect the DPs the C++ GPU solver writes as it runs, solving its key from collisions", runFollow},
+		"gentames":      {"Walk tame kangaroos for a range width and save them to speed up later solves", runGenTames},
+		"histogram":     {"Show the distribution of list sizes and records per pool", runHistogram},
+		"import":        {"Import records from hex text dumps", runImport},
+		"ingest":        {"Add DPs to an on-disk store for DP sets larger than memory, solving from collisions", runIngest},
+		"merge":         {"Merge many databases into one, skipping duplicates", runMerge},
+		"migrate":       {"Re-encode records into another record schema", runMigrate},
+		"mkrecord":      {"Build a record from an x-coordinate, distance and type", runMkrecord},
+		"refilter":      {"Keep only the records distinguished at more DP bits, to raise a run's DP mid-way", runRefilter},
+		"sample":        {"Print a uniformly random sample of records to sanity-check a database", runSample},
+		"serve":         {"Serve a read-only public mirror of a database over HTTP", runServe},
+		"server":        {"Run a pool server that aggregates distinguished points from workers", runServer},
+		"server-status": {"Show the worker leaderboard of a pool server and the workers gone quiet", runServerStatus},
+		"sign":          {"Generate a signing key, or sign databases so pools can verify who produced them", runSign},
+		"simulate":      {"Plant random keys in small ranges and solve them end to end to validate the solver", runSimulate},
+		"solve":         {"Solve public keys in a range on the CPU or CUDA GPUs, optionally reusing precomputed tames", runSolve},
+		"split":         {"Split a database into hashed chunks with a manifest, for mirrors to serve to fetch", runSplit},
+		"stats":         {"Show database statistics", runStats},
+		"tune":          {"Recommend DP bits for a range, memory budget and jump rate", runTune},
+		"verify":        {"Check database signatures against a file of trusted keys", runVerify},
+		"workers":       {"Credit workers with the records they submitted, per period, as a table, CSV or JSON", runWorkers},
+	}
+}
+
+// printCommands lists the available subcommands after the flag usage
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(flag.CommandLine.Output(), "\nCommands (rckangaroo <command> -h for details):\n")
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-13s %s\n", name, commands[name].summary)
+	}
+}
+
+// newFlagSet creates a flag set for a subcommand with a usage line
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rckangaroo %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// loadDatabase loads a FastBase file, reporting progress on stdout and, when
+// it is a terminal, a progress bar on stderr
+func loadDatabase(filename string) (*fastbase.FastBase, error) {
+	fb, _, err := loadDatabaseTimed(filename)
+	return fb, err
+}
+
+// loadDatabaseTimed is loadDatabase that also returns how long loading took
+func loadDatabaseTimed(filename string) (*fastbase.FastBase, time.Duration, error) {
+	fmt.Printf("Loading FastBase file: %s\n", filename)
+	start := time.Now()
+	fb := fastbase.NewFastBase()
+	bar := newProgressBar()
+	err := readDatabase(fb, filename, bar.update)
+	bar.finish()
+	if err != nil {
+		return nil, 0, err
+	}
+	return fb, time.Since(start), nil
+}
+
+// loadDatabaseQuiet loads a FastBase file without printing anything, for
+// machine-readable output modes
+func loadDatabaseQuiet(filename string) (*fastbase.FastBase, error) {
+	fb := fastbase.NewFastBase()
+	if err := readDatabase(fb, filename, nil); err != nil {
+		return nil, err
+	}
+	return fb, nil
+}
+
+// Environment variables holding the secret of encrypted databases
+const (
+	envKeyFile    = "RCKANGAROO_KEY_FILE"
+	envPassphrase = "RCKANGAROO_PASSPHRASE"
+)
+
+// applySecret gives fb the secret named by the environment, if any, so
+// encrypted databases load transparently and stay encrypted when saved
+func applySecret(fb *fastbase.FastBase) error {
+	if path := os.Getenv(envKeyFile); path != "" {
+		secret, err := fastbase.ReadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		fb.SetSecret(secret)
+	} else if p := os.Getenv(envPassphrase); p != "" {
+		fb.SetSecret(fastbase.Passphrase(p))
+	}
+	return nil
+}
+
+// readDatabase loads a FastBase file, a shard directory, an s3:// or
+// gs:// object, or an sqlite: database, into fb. progress, if not nil,
+// follows the load of a file.
+func readDatabase(fb *fastbase.FastBase, filename string, progress fastbase.ProgressFunc) error {
+	if err := applySecret(fb); err != nil {
+		return err
+	}
+	if objstore.IsURL(filename) {
+		if err := fb.LoadFromObjectStore(context.Background(), filename); err != nil {
+			return fmt.Errorf("error loading FastBase object: %v", err)
+		}
+		return nil
+	}
+	if sqlite.IsURL(filename) {
+		if _, err := os.Stat(sqlite.Path(filename)); os.IsNotExist(err) {
+			return fmt.Errorf("file '%s' does not exist", sqlite.Path(filename))
+		}
+		if err := fb.LoadFromSQLite(sqlite.Path(filename)); err != nil {
+			return fmt.Errorf("error loading FastBase from SQLite: %v", err)
+		}
+		return nil
+	}
+
+	fi, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("file '%s' does not exist", filename)
+	}
+	if err == nil && fi.IsDir() {
+		if err := fb.LoadSharded(filename); err != nil {
+			return fmt.Errorf("error loading FastBase shards: %v", err)
+		}
+		return nil
+	}
+	if err := fb.LoadFromFileCtx(context.Background(), filename, progress); err != nil {
+		return fmt.Errorf("error loading FastBase file: %v", err)
+	}
+	return nil
+}
+
+// saveDatabase saves fb to a file, as shards to an existing directory, to
+// an sqlite: database, or uploads it to an s3:// or gs:// URL
+func saveDatabase(fb *fastbase.FastBase, filename string) error {
+	if err := applySecret(fb); err != nil {
+		return err
+	}
+	if objstore.IsURL(filename) {
+		return fb.SaveToObjectStore(context.Background(), filename)
+	}
+	if sqlite.IsURL(filename) {
+		return fb.SaveToSQLite(sqlite.Path(filename))
+	}
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		return fb.SaveSharded(filename)
+	}
+	return fb.SaveToFile(filename)
+}
+
+// saveDatabaseAtomic is saveDatabase for a database that must survive a
+// crash mid-save: local files are written beside the target and renamed
+// over it, so the previous save stays whole until the new one is, and the
+// journal of a database keeping one is then checkpointed. SQLite saves are
+// transactions, which need no copy.
+func saveDatabaseAtomic(fb *fastbase.FastBase, filename string) error {
+	if objstore.IsURL(filename) || sqlite.IsURL(filename) {
+		return saveDatabase(fb, filename)
+	}
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		return saveDatabase(fb, filename)
+	}
+	if err := applySecret(fb); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := fb.SaveToFile(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	// The Bloom filter cache is named after the file it was saved with
+	os.Rename(tmp+fastbase.BloomSuffix, filename+fastbase.BloomSuffix)
+	return fb.CheckpointJournal()
+}
+
+// journalUsage describes the -journal flag of commands adding to a database
+const journalUsage = "Log each new DP to the database path + " + fastbase.JournalSuffix + " as it arrives, replaying it at startup, so a crash between saves loses none"
+
+// openJournal replays the journal of a database file into fb and keeps
+// logging new records to it, for the -journal flag. Only local database
+// files keep a journal.
+func openJournal(fb *fastbase.FastBase, dbFile string) error {
+	if objstore.IsURL(dbFile) || sqlite.IsURL(dbFile) {
+		return fmt.Errorf("-journal needs a local database file, not %s", dbFile)
+	}
+	if fi, err := os.Stat(dbFile); err == nil && fi.IsDir() {
+		return fmt.Errorf("-journal needs a database file, not the shard directory %s", dbFile)
+	}
+	filename := dbFile + fastbase.JournalSuffix
+	info, err := fb.OpenJournal(filename)
+	if err != nil {
+		return err
+	}
+	if info.Records > 0 || info.Lost > 0 {
+		byType := info.RecoveredByType
+		fmt.Printf("Replayed %d DPs from %s: %d recovered that %s lacked (%d tame, %d wild1, %d wild2), %d already saved\n",
+			info.Records, filename, info.Recovered, dbFile, byType[fastbase.TypeTame], byType[fastbase.TypeWild1],
+			byType[fastbase.TypeWild2], info.Saved)
+	}
+	if info.Lost > 0 {
+		fmt.Printf("Warning: %d DP lost, cut short at the end of %s, of unknown type; its %d bytes were set aside in %s\n",
+			info.Lost, filename, info.BytesDiscarded, info.DiscardedTo)
+	}
+	if info.Stale() {
+		fmt.Printf("Warning: %s extends snapshot %016x, but %s is snapshot %016x; if %s was restored from an older\n",
+			filename, info.BaseID, dbFile, info.LoadedID, dbFile)
+		fmt.Printf("copy, DPs saved since that copy and not in the journal are lost, in numbers no file records\n")
+	}
+	fmt.Printf("Journaling new DPs to %s\n", filename)
+	return nil
+}
+
+// walkStateFile returns the file keeping the kangaroos of a walk whose DPs
+// are saved to dbFile, or "" for object storage, which holds databases only
+func walkStateFile(dbFile string) string {
+	if objstore.IsURL(dbFile) {
+		return ""
+	}
+	if sqlite.IsURL(dbFile) {
+		return sqlite.Path(dbFile) + ".state.json"
+	}
+	return strings.TrimSuffix(dbFile, "/") + ".state.json"
+}
+
+// saveWalk saves the DPs of a walk, then its kangaroos if st is given. A
+// crash between the two leaves older kangaroos, which only repeat some
+// jumps, rather than newer ones whose DPs are missing.
+func saveWalk(fb *fastbase.FastBase, dbFile string, st *kangaroo.State) error {
+	if err := saveDatabaseAtomic(fb, dbFile); err != nil {
+		return err
+	}
+	if f := walkStateFile(dbFile); f != "" && st != nil {
+		return st.Save(f)
+	}
+	return nil
+}
+
+// resumeWalk has a solver continue the kangaroos saved beside dbFile
+func resumeWalk(solver *kangaroo.Solver, dbFile string) error {
+	f := walkStateFile(dbFile)
+	if f == "" {
+		return fmt.Errorf("cannot resume %s: kangaroos are only saved beside local databases", dbFile)
+	}
+	st, err := kangaroo.LoadState(f)
+	if err != nil {
+		return err
+	}
+	if err := solver.Resume(st); err != nil {
+		return fmt.Errorf("%s: %v", f, err)
+	}
+	fmt.Printf("Resuming %d kangaroos after %s jumps from: %s\n", st.Kangaroos(), formatOps(float64(st.Ops)), f)
+	return nil
+}
+
+// interruptContext returns a context cancelled by SIGINT or SIGTERM, so
+// long runs can stop their workers and save before exiting. A second
+// signal kills the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// logLevelUsage documents the -log-level flag of long-running commands
+const logLevelUsage = "Log database and server events at this level and above to stderr: debug, info, warn or error; off if empty"
+
+// newLogger returns a logger writing text records at level and above to
+// stderr, or nil, which keeps logs off, for an empty level
+func newLogger(level string) (*slog.Logger, error) {
+	if level == "" {
+		return nil, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q; use debug, info, warn or error", level)
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})), nil
+}
+
+// compileWhere compiles a -where filter expression for the database's
+// schema, returning no filters for an empty expression
+func compileWhere(fb *fastbase.FastBase, expr string) ([]fastbase.Filter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	f, err := query.Compile(expr, fb.Schema())
+	if err != nil {
+		return nil, fmt.Errorf("invalid -where expression: %v", err)
+	}
+	return []fastbase.Filter{f}, nil
+}
+
+// provenanceFilters returns filters for the -worker and -since flags,
+// none if both are empty. A worker is given by name, as its API key is
+// called, or as a worker ID like 0x1a2b3c4d; -since takes an RFC 3339
+// time, a date, or a duration before now such as 24h.
+func provenanceFilters(fb *fastbase.FastBase, worker, since string) ([]fastbase.Filter, error) {
+	if worker == "" && since == "" {
+		return nil, nil
+	}
+	if !fb.HasProvenance() {
+		return nil, fmt.Errorf("-worker and -since need a database with provenance (see server -provenance)")
+	}
+
+	var filters []fastbase.Filter
+	if worker != "" {
+		filters = append(filters, fb.WorkerFilter(parseWorker(worker)))
+	}
+	if since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fb.SinceFilter(t))
+	}
+	return filters, nil
+}
+
+// parseWorker returns the worker ID of a worker name or of an ID in hex
+// with a 0x prefix
+func parseWorker(s string) uint32 {
+	if h, ok := strings.CutPrefix(s, "0x"); ok {
+		if id, err := strconv.ParseUint(h, 16, 32); err == nil {
+			return uint32(id)
+		}
+	}
+	return fastbase.WorkerID(s)
+}
+
+// parseSince parses an RFC 3339 time, a date, or a duration before now
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q, want e.g. 2024-05-01, 2024-05-01T12:00:00Z or 24h", s)
+}
+
+// prefixRecords returns the records of one list that pass every filter
+func prefixRecords(fb *fastbase.FastBase, prefix [3]byte, filters []fastbase.Filter) [][]byte {
+	return fb.ListRecords(prefix, filters...)
+}
+
+// parseTarget builds a solving target from the -pubkey, -start and -range flags
+func parseTarget(pubKeyHex, startHex string, bits int) (kangaroo.Target, error) {
+	if pubKeyHex == "" || startHex == "" || bits == 0 {
+		return kangaroo.Target{}, fmt.Errorf("-pubkey, -start and -range are required")
+	}
+
+	pubKey, err := keys.ParsePubKey(pubKeyHex)
+	if err != nil {
+		return kangaroo.Target{}, err
+	}
+
+	r, err := kangaroo.NewRange(startHex, bits)
+	if err != nil {
+		return kangaroo.Target{}, err
+	}
+
+	return kangaroo.NewTarget(pubKey, r), nil
+}
+
+// printKey prints a recovered private key in every form a wallet may
+// want, indented by indent
+func printKey(indent string, key *big.Int) {
+	for _, f := range keys.NewKey(key).Formats() {
+		fmt.Printf("%s%-16s %s\n", indent, f.Name+":", f.Value)
+	}
+}
+
+// puzzleUsage describes the -puzzle flag of commands taking a target
+const puzzleUsage = "Bitcoin puzzle number; sets -pubkey, -start and -range where not given"
+
+// applyPuzzle fills the -pubkey, -start and -range flags left empty from
+// the preset of a puzzle, if one is given, and rejects values that
+// contradict it. The public key stays empty if it was never published.
+func applyPuzzle(puzzle int, pubKeyHex, startHex *string, bits *int) error {
+	if puzzle == 0 {
+		return nil
+	}
+	p, err := kangaroo.LookupPuzzle(puzzle)
+	if err != nil {
+		return err
+	}
+	r := p.Range()
+
+	if *startHex != "" {
+		if start, ok := new(big.Int).SetString(*startHex, 16); !ok || start.Cmp(r.Start) != 0 {
+			return fmt.Errorf("puzzle %d starts at %x, not %s", puzzle, r.Start, *startHex)
+		}
+	}
+	if *bits != 0 && *bits != r.Bits {
+		return fmt.Errorf("puzzle %d has a %d-bit range, not %d bits", puzzle, r.Bits, *bits)
+	}
+	*startHex, *bits = r.Start.Text(16), r.Bits
+
+	// Unpublished keys are left for -pubkey to give
+	switch {
+	case p.PubKey == "":
+	case *pubKeyHex == "":
+		*pubKeyHex = p.PubKey
+	default:
+		given, err := keys.ParsePubKey(*pubKeyHex)
+		if err != nil {
+			return err
+		}
+		preset, _ := keys.ParsePubKey(p.PubKey)
+		if !given.Equal(preset) {
+			return fmt.Errorf("puzzle %d has public key %s, not %s", puzzle, p.PubKey, *pubKeyHex)
+		}
+	}
+	return nil
+}
+
+// envTelegramToken names the environment variable holding the bot token
+// for -notify-telegram, kept off the command line where ps would show it
+const envTelegramToken = "RCKANGAROO_TELEGRAM_TOKEN"
+
+// notifyFlags are the flags of commands that report found keys to
+// someone who may not be watching
+type notifyFlags struct {
+	command, webhook, discord, telegram *string
+}
+
+// addNotifyFlags declares the -notify-* flags on fs
+func addNotifyFlags(fs *flag.FlagSet) *notifyFlags {
+	return &notifyFlags{
+		command:  fs.String("notify-cmd", "", "Shell command to run when a key is found, given the event as JSON on stdin and in RCK_* variables"),
+		webhook:  fs.String("notify-webhook", "", "URL to POST the event to as JSON when a key is found"),
+		discord:  fs.String("notify-discord", "", "Discord webhook URL to post found keys to"),
+		telegram: fs.String("notify-telegram", "", "Telegram chat ID to message found keys to, from the bot in "+envTelegramToken),
+	}
+}
+
+// notifiers builds the notifiers the flags ask for
+func (f *notifyFlags) notifiers() ([]notify.Notifier, error) {
+	var ns []notify.Notifier
+	if *f.command != "" {
+		ns = append(ns, notify.Command{Shell: *f.command})
+	}
+	if *f.webhook != "" {
+		ns = append(ns, notify.Webhook{URL: *f.webhook})
+	}
+	if *f.discord != "" {
+		ns = append(ns, notify.Discord{URL: *f.discord})
+	}
+	if *f.telegram != "" {
+		token := os.Getenv(envTelegramToken)
+		if token == "" {
+			return nil, fmt.Errorf("-notify-telegram needs the bot token in %s", envTelegramToken)
+		}
+		ns = append(ns, notify.Telegram{Token: token, ChatID: *f.telegram})
+	}
+	return ns, nil
+}
+
+// keyEvent describes a verified key of a target for notifiers
+func keyEvent(source string, target kangaroo.Target, key *big.Int) notify.Event {
+	k := keys.NewKey(key)
+	return notify.Event{
+		Time:    time.Now(),
+		Source:  source,
+		Message: fmt.Sprintf("rckangaroo %s solved %x", source, target.PubKey.Compressed()),
+		Key:     fmt.Sprintf("%064x", key),
+		WIF:     keys.WIF(key, true),
+		PubKey:  hex.EncodeToString(k.Public.Compressed()),
+		Address: keys.P2PKH(k.Public, true),
+	}
+}
+
+// sendNotifications delivers an event, reporting notifiers that failed
+func sendNotifications(notifiers []notify.Notifier, e notify.Event) {
+	if len(notifiers) == 0 {
+		return
+	}
+	if err := notify.Send(context.Background(), notifiers, e); err != nil {
+		fmt.Fprintf(os.Stderr, "Error notifying: %v\n", err)
+	}
+}
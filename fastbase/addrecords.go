@@ -0,0 +1,202 @@
+package fastbase
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// AddRecords inserts a batch of records, each filed under the first three
+// bytes of its x-coordinate as the solver does, skipping those already
+// present. The batch is sorted and grouped by list so every list grows
+// at most once and takes its new records in a single merge, which is much
+// faster than calling AddRecord per record for large batches. It returns
+// how many records were new and the collisions they completed, both with
+// existing records and within the batch. Hooks fire as for AddRecord.
+func (fb *FastBase) AddRecords(records [][]byte) (int, []Collision, error) {
+	n := fb.layout.RecordLength
+	for i, rec := range records {
+		if len(rec) != n {
+			return 0, nil, fmt.Errorf("record %d: %w", i, recordLengthError(len(rec), n))
+		}
+	}
+
+	// Sorting whole records also puts exact duplicates next to each other.
+	// At depth 4 a prefix spans many lists, so the split byte comes first.
+	split := fb.index.depth == 4
+	sorted := make([][]byte, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(a, b int) bool {
+		ra, rb := sorted[a], sorted[b]
+		if split && ra[splitByte] != rb[splitByte] && bytes.Equal(ra[:3], rb[:3]) {
+			return ra[splitByte] < rb[splitByte]
+		}
+		return bytes.Compare(ra, rb) < 0
+	})
+
+	schema := fb.Schema()
+	added := 0
+	var collisions []Collision
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && bytes.Equal(sorted[end][:3], sorted[start][:3]) &&
+			(!split || sorted[end][splitByte] == sorted[start][splitByte]) {
+			end++
+		}
+
+		prefix := [3]byte{sorted[start][0], sorted[start][1], sorted[start][2]}
+		a, c, err := fb.addGroup(schema, prefix, sorted[start:end])
+		added += a
+		collisions = append(collisions, c...)
+		if err != nil {
+			return added, collisions, err
+		}
+		start = end
+	}
+	if err := fb.spillOver(); err != nil {
+		return added, collisions, err
+	}
+	return added, collisions, fb.enforceLimit()
+}
+
+// addGroup merges sorted records filed under prefix into their list, which
+// they all share
+func (fb *FastBase) addGroup(schema Schema, prefix [3]byte, recs [][]byte) (int, []Collision, error) {
+	if fb.limit != nil {
+		admitted := make([][]byte, 0, len(recs))
+		for _, rec := range recs {
+			skip, err := fb.admit(rec)
+			if err != nil {
+				return 0, nil, err
+			}
+			if !skip {
+				admitted = append(admitted, rec)
+			}
+		}
+		if recs = admitted; len(recs) == 0 {
+			return 0, nil, nil
+		}
+	}
+	if err := fb.modify(prefix[0]); err != nil {
+		return 0, nil, err
+	}
+	list := fb.index.getOrCreate(prefix, recs[0])
+	n := fb.identityLength()
+
+	// Keep the records that are new to the list and to the batch
+	var fresh [][]byte
+	var collisions, nears []Collision
+	for idx, rec := range recs {
+		if idx > 0 && bytes.Equal(rec[:n], recs[idx-1][:n]) {
+			if fb.hooks.OnDuplicate != nil {
+				fb.hooks.OnDuplicate(prefix, recs[idx-1])
+			}
+			continue
+		}
+		if dup := fb.findDuplicate(list, prefix, rec); dup != nil {
+			if fb.hooks.OnDuplicate != nil {
+				fb.hooks.OnDuplicate(prefix, dup)
+			}
+			continue
+		}
+
+		if other := fb.batchPartner(schema, prefix, rec, fresh, false); other != nil {
+			collisions = append(collisions, Collision{Prefix: prefix, First: other, Second: append([]byte(nil), rec...)})
+		}
+		if fb.hooks.OnNearCollision != nil {
+			if near := fb.batchPartner(schema, prefix, rec, fresh, true); near != nil {
+				nears = append(nears, Collision{Prefix: prefix, First: near, Second: append([]byte(nil), rec...)})
+			}
+		}
+		fresh = append(fresh, rec)
+	}
+	if len(fresh) == 0 {
+		return 0, nil, nil
+	}
+
+	// Grow the list once for the whole group
+	need := uint64(list.Count) + uint64(len(fresh))
+	if need > uint64(MaxListSize) {
+		return 0, nil, ErrListFull
+	}
+	if uint32(need) > list.Capacity {
+		newCap := need + max(need/2, DBMinGrowCount)
+		newCap = min(newCap, uint64(MaxListSize))
+		newData := make([]uint32, list.Count, newCap)
+		copy(newData, list.Data[:list.Count])
+		list.Data = newData
+		list.Capacity = uint32(newCap)
+	}
+
+	ptrs := make([]uint32, len(fresh))
+	for f, rec := range fresh {
+		ptr, _, err := fb.storeRecord(prefix, rec)
+		if err != nil {
+			return 0, nil, err
+		}
+		ptrs[f] = ptr
+	}
+
+	// Merge from the back so nothing is moved twice
+	data := list.Data[:need]
+	i, j := int(list.Count)-1, len(fresh)-1
+	for k := len(data) - 1; j >= 0; k-- {
+		if i >= 0 && fb.compareEntry(data[i], prefix, fresh[j]) > 0 {
+			data[k] = data[i]
+			i--
+		} else {
+			data[k] = ptrs[j]
+			j--
+		}
+	}
+	list.Data = data
+	list.Count = uint32(need)
+
+	for f, ptr := range ptrs {
+		fb.track(prefix, ptr, fresh[f])
+		fb.bloomAdd(prefix[0], fresh[f])
+	}
+	if fb.hooks.OnCollision != nil {
+		for _, c := range collisions {
+			fb.hooks.OnCollision(c)
+		}
+	}
+	for _, c := range nears {
+		fb.hooks.OnNearCollision(c)
+	}
+	return len(fresh), collisions, nil
+}
+
+// batchPartner is partner for a record of a sorted batch, also looking
+// among the records of the batch kept before it
+func (fb *FastBase) batchPartner(schema Schema, prefix [3]byte, rec []byte, fresh [][]byte, near bool) []byte {
+	if other := fb.partner(schema, prefix, rec, near); other != nil {
+		return other
+	}
+	for f := len(fresh) - 1; f >= 0 && bytes.Equal(schema.X(fresh[f]), schema.X(rec)); f-- {
+		if pairs(schema, fresh[f], rec, near) {
+			return append([]byte(nil), fresh[f]...)
+		}
+	}
+	return nil
+}
+
+// findDuplicate returns the record of a list filed under prefix equal to
+// rec in every byte but the type and the provenance, or nil
+func (fb *FastBase) findDuplicate(list *ListRecord, prefix [3]byte, rec []byte) []byte {
+	if !fb.bloomMayContain(prefix[0], rec) {
+		return nil
+	}
+
+	n := fb.identityLength()
+	for pos := fb.lowerBound(list, prefix, rec); pos < int(list.Count); pos++ {
+		if fb.compareEntry(list.Data[pos], prefix, rec) != 0 {
+			return nil
+		}
+		mem := fb.Pools[prefix[0]].GetRecordPtr(list.Data[pos])
+		if bytes.Equal(mem[:n], rec[:n]) {
+			return mem
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package fastbase
+
+import (
+	"bytes"
+	"hash/maphash"
+	"maps"
+	"math/big"
+	"slices"
+)
+
+// DistanceGroup is a distance that several records of one kangaroo type
+// share while standing on different x-coordinates
+type DistanceGroup struct {
+	Type     KangarooType
+	Distance []byte
+	Records  []Match // Copies of the records, in prefix order
+}
+
+// RepeatedX is a pair of records of one kangaroo type that stand on the
+// same x-coordinate at different distances
+type RepeatedX struct {
+	First, Second Match    // Copies of the records, in list order
+	Offset        *big.Int // Distance of Second minus distance of First
+}
+
+// AuditReport lists the records no healthy solve should produce by chance.
+// Kangaroos of one herd sharing a distance share their start, as when
+// workers seed their RNG alike; kangaroos meeting on one point walk on
+// together and keep reporting the same x-coordinates at a fixed offset.
+type AuditReport struct {
+	Records      int    // Records examined
+	TypeCounts   [3]int // Records of each type
+	SameDistance []DistanceGroup
+	SameX        []RepeatedX
+}
+
+// Audit looks for distances shared by records of one type on different
+// x-coordinates, and x-coordinates shared by records of one type at
+// different distances. Records of different types on one x-coordinate are
+// collisions (see FindCollisions) and not reported.
+func (fb *FastBase) Audit() AuditReport {
+	var rep AuditReport
+	schema := fb.Schema()
+	seed := maphash.MakeSeed()
+	distanceHash := func(rec []byte) uint64 {
+		return maphash.Bytes(seed, schema.Distance(rec)) + uint64(schema.Type(rec))
+	}
+
+	// Find the distance hashes occurring more than once first, keeping 8
+	// bytes per record, then collect only the records carrying them
+	hashes := make([]uint64, 0, fb.recordCount())
+	var run []Match
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		rep.Records++
+		if t := schema.Type(rec); int(t) < len(rep.TypeCounts) {
+			rep.TypeCounts[t]++
+		}
+		hashes = append(hashes, distanceHash(rec))
+
+		// Records on one x-coordinate are adjacent in their list. The run
+		// points into pool memory, which stays put while nothing is added.
+		if len(run) > 0 && (run[0].Prefix != prefix || !bytes.Equal(schema.X(run[0].Record), schema.X(rec))) {
+			rep.SameX = append(rep.SameX, repeatedX(schema, run)...)
+			run = run[:0]
+		}
+		run = append(run, Match{Prefix: prefix, Record: rec})
+		return true
+	})
+	rep.SameX = append(rep.SameX, repeatedX(schema, run)...)
+
+	slices.Sort(hashes)
+	shared := make(map[uint64][]Match)
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i] == hashes[i-1] {
+			shared[hashes[i]] = nil
+		}
+	}
+	if len(shared) == 0 {
+		return rep
+	}
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		h := distanceHash(rec)
+		if matches, ok := shared[h]; ok {
+			shared[h] = append(matches, Match{Prefix: prefix, Record: append([]byte(nil), rec...)})
+		}
+		return true
+	})
+
+	// Split each bucket by exact distance and type, as hashes may collide
+	for _, h := range slices.Sorted(maps.Keys(shared)) {
+		matches := shared[h]
+		for len(matches) > 0 {
+			g := DistanceGroup{Type: schema.Type(matches[0].Record), Distance: schema.Distance(matches[0].Record)}
+			var rest []Match
+			for _, m := range matches {
+				if schema.Type(m.Record) == g.Type && bytes.Equal(schema.Distance(m.Record), g.Distance) {
+					g.Records = append(g.Records, m)
+				} else {
+					rest = append(rest, m)
+				}
+			}
+			if len(g.Records) > 1 {
+				rep.SameDistance = append(rep.SameDistance, g)
+			}
+			matches = rest
+		}
+	}
+	return rep
+}
+
+// repeatedX returns the pairs of same-type records in a run of records on
+// one x-coordinate
+func repeatedX(schema Schema, run []Match) []RepeatedX {
+	var pairs []RepeatedX
+	for a := 0; a < len(run); a++ {
+		for b := a + 1; b < len(run); b++ {
+			first, second := run[a].Record, run[b].Record
+			if schema.Type(first) != schema.Type(second) {
+				continue
+			}
+			offset := schema.DecodeDistance(schema.Distance(second))
+			offset.Sub(offset, schema.DecodeDistance(schema.Distance(first)))
+			pairs = append(pairs, RepeatedX{
+				First:  Match{Prefix: run[a].Prefix, Record: append([]byte(nil), first...)},
+				Second: Match{Prefix: run[b].Prefix, Record: append([]byte(nil), second...)},
+				Offset: offset,
+			})
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,260 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// BloomSuffix is appended to a database file name to name the file its
+// bloom filters are saved in
+const BloomSuffix = ".bloom"
+
+// bloomMagic starts every bloom filter file
+var bloomMagic = [8]byte{'R', 'C', 'K', 'B', 'L', 'O', 'O', 'M'}
+
+// bloomProbes is the number of bits set per record. With the default 10
+// bits per record it gives a false positive rate of about 1%.
+const bloomProbes = 7
+
+// bloomMinBits is the smallest filter, so tiny pools don't rebuild often
+const bloomMinBits = 1 << 12
+
+// WithBloomFilter keeps a bloom filter per pool with about bitsPerRecord
+// bits for each record, so lookups of absent records (FindDataBlock,
+// FindMany and the duplicate check of inserts) usually skip the binary
+// search. Filters grow with the pools and are saved next to the database
+// file by SaveToFile; see BloomSuffix.
+func WithBloomFilter(bitsPerRecord int) Option {
+	return func(c *config) { c.bloomBits = bitsPerRecord }
+}
+
+// bloom is the filter of one pool
+type bloom struct {
+	words []uint64
+	mask  uint64 // Bit count minus one; the bit count is a power of two
+	count int    // Records added
+	limit int    // Records the filter was sized for
+}
+
+// bloomSet holds the filters of all pools
+type bloomSet struct {
+	bitsPerRecord int
+	pools         [256]bloom
+}
+
+func newBloomSet(bitsPerRecord int) *bloomSet {
+	return &bloomSet{bitsPerRecord: bitsPerRecord}
+}
+
+// size allocates an empty filter for limit records
+func (b *bloom) size(limit, bitsPerRecord int) {
+	n := uint64(max(limit*bitsPerRecord, bloomMinBits))
+	n = 1 << bits.Len64(n-1)
+	b.words = make([]uint64, n/64)
+	b.mask = n - 1
+	b.count = 0
+	b.limit = int(n) / bitsPerRecord
+}
+
+// bloomHash hashes a record key with FNV-1a, which is stable across runs
+// so saved filters stay valid
+func bloomHash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (b *bloom) add(h uint64) {
+	delta := h>>33 | 1
+	for i := 0; i < bloomProbes; i++ {
+		bit := h & b.mask
+		b.words[bit/64] |= 1 << (bit % 64)
+		h += delta
+	}
+	b.count++
+}
+
+func (b *bloom) mayContain(h uint64) bool {
+	if b.count == 0 {
+		return false
+	}
+	delta := h>>33 | 1
+	for i := 0; i < bloomProbes; i++ {
+		bit := h & b.mask
+		if b.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// bloomAdd records a new record of pool i, growing the filter when it is
+// full
+func (fb *FastBase) bloomAdd(i byte, rec []byte) {
+	if fb.blooms == nil {
+		return
+	}
+	b := &fb.blooms.pools[i]
+	if b.count >= b.limit {
+		fb.rebuildBloom(i, 2*max(b.count, 1))
+		return // The rebuild saw the new record already
+	}
+	b.add(bloomHash(rec[:fb.layout.CompareLength]))
+}
+
+// bloomMayContain reports whether pool i may hold a record with the key
+// of rec. It is always true without filters.
+func (fb *FastBase) bloomMayContain(i byte, rec []byte) bool {
+	if fb.blooms == nil {
+		return true
+	}
+	return fb.blooms.pools[i].mayContain(bloomHash(rec[:fb.layout.CompareLength]))
+}
+
+// rebuildBloom refills the filter of pool i from its records, sized for at
+// least limit records
+func (fb *FastBase) rebuildBloom(i byte, limit int) {
+	b := &fb.blooms.pools[i]
+	b.size(limit, fb.blooms.bitsPerRecord)
+	if fb.isCold(i) {
+		fb.eachColdRecord(i, func(rec []byte) {
+			b.add(bloomHash(rec[:fb.layout.CompareLength]))
+		})
+		return
+	}
+	fb.eachListIn(i, func(list *ListRecord) {
+		for m := uint32(0); m < list.Count; m++ {
+			b.add(bloomHash(fb.Pools[i].GetRecordPtr(list.Data[m])[:fb.layout.CompareLength]))
+		}
+	})
+}
+
+// rebuildBlooms refills every filter from the records, as after a load or
+// a change of record keys
+func (fb *FastBase) rebuildBlooms() {
+	if fb.blooms == nil {
+		return
+	}
+	for i := 0; i < 256; i++ {
+		records := 0
+		if fb.isCold(byte(i)) {
+			records = int(fb.tier.segs[i].start[65536])
+		} else {
+			fb.eachListIn(byte(i), func(list *ListRecord) {
+				records += int(list.Count)
+			})
+		}
+		fb.rebuildBloom(byte(i), records+records/2)
+	}
+}
+
+// clearBlooms empties every filter
+func (fb *FastBase) clearBlooms() {
+	if fb.blooms == nil {
+		return
+	}
+	for i := range fb.blooms.pools {
+		fb.blooms.pools[i] = bloom{}
+	}
+}
+
+// saveBlooms writes the filters to filename, tagged with the snapshot they
+// describe
+func (fb *FastBase) saveBlooms(filename string, snapshotID uint64) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	var hdr [24]byte
+	binary.LittleEndian.PutUint64(hdr[0:], snapshotID)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(fb.blooms.bitsPerRecord))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(fb.layout.CompareLength))
+	w.Write(bloomMagic[:])
+	w.Write(hdr[:])
+
+	var buf [8]byte
+	for i := range fb.blooms.pools {
+		b := &fb.blooms.pools[i]
+		var pool [24]byte
+		binary.LittleEndian.PutUint64(pool[0:], uint64(len(b.words)))
+		binary.LittleEndian.PutUint64(pool[8:], uint64(b.count))
+		binary.LittleEndian.PutUint64(pool[16:], uint64(b.limit))
+		w.Write(pool[:])
+		for _, word := range b.words {
+			binary.LittleEndian.PutUint64(buf[:], word)
+			w.Write(buf[:])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// errStaleBloom reports a filter file that doesn't match the database
+var errStaleBloom = errors.New("bloom filter file does not match the database")
+
+// loadBlooms reads filters saved by saveBlooms for the given snapshot
+func (fb *FastBase) loadBlooms(filename string, snapshotID uint64) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	var magic [8]byte
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != bloomMagic {
+		return errStaleBloom
+	}
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(hdr[0:]) != snapshotID ||
+		binary.LittleEndian.Uint64(hdr[8:]) != uint64(fb.blooms.bitsPerRecord) ||
+		binary.LittleEndian.Uint64(hdr[16:]) != uint64(fb.layout.CompareLength) {
+		return errStaleBloom
+	}
+
+	var pools [256]bloom
+	var buf [8]byte
+	for i := range pools {
+		var pool [24]byte
+		if _, err := io.ReadFull(r, pool[:]); err != nil {
+			return err
+		}
+		words := binary.LittleEndian.Uint64(pool[0:])
+		if words == 0 {
+			continue // Never sized, as for an empty pool
+		}
+		if words&(words-1) != 0 || words > 1<<32 {
+			return fmt.Errorf("bloom filter %d has an invalid size", i)
+		}
+		b := &pools[i]
+		b.words = make([]uint64, words)
+		b.mask = words*64 - 1
+		b.count = int(binary.LittleEndian.Uint64(pool[8:]))
+		b.limit = int(binary.LittleEndian.Uint64(pool[16:]))
+		for w := range b.words {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return err
+			}
+			b.words[w] = binary.LittleEndian.Uint64(buf[:])
+		}
+	}
+	fb.blooms.pools = pools
+	return nil
+}
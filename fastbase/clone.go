@@ -0,0 +1,130 @@
+package fastbase
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Clone returns a deep copy of the FastBase, such as a snapshot to save
+// while the original takes more records. The copy has the records, lists,
+// header, Bloom filters, secret and logger of the original, and the records
+// tracked for delta saves, so it can save the deltas the original could.
+// Pools spilled to disk are read into memory: the copy has no tiering,
+// memory limit, hooks or journal.
+func (fb *FastBase) Clone() (*FastBase, error) {
+	c := &FastBase{secret: fb.secret, log: fb.log}
+	c.setLayout(fb.layout)
+	c.Header = fb.Header
+
+	for i := range fb.Pools {
+		if fb.isCold(byte(i)) {
+			seg := fb.tier.segs[i]
+			size := seg.size(fb.layout.RecordLength)
+			r := bufio.NewReaderSize(io.NewSectionReader(seg.file, 0, size), 1<<20)
+			if _, err := c.readPool(r, byte(i), 4, 0, size, &RecoverReport{}); err != nil {
+				return nil, fmt.Errorf("reading spilled pool %02x: %w", i, err)
+			}
+			continue
+		}
+
+		// Pages are copied whole, so record pointers stay valid in the copy
+		pool, dst := &fb.Pools[i], &c.Pools[i]
+		dst.Pages = make([][]byte, len(pool.Pages))
+		for p, page := range pool.Pages {
+			dst.Pages[p] = append([]byte(nil), page...)
+		}
+		dst.Ptr = pool.Ptr
+		for top := i << 8; top < i<<8+256; top++ {
+			table := fb.index.tables[top]
+			if table == nil {
+				continue
+			}
+			copied := make([]*ListRecord, len(table))
+			for n, list := range table {
+				if list == nil {
+					continue
+				}
+				data := make([]uint32, len(list.Data), cap(list.Data))
+				copy(data, list.Data)
+				copied[n] = &ListRecord{Count: list.Count, Capacity: list.Capacity, Data: data}
+			}
+			c.index.tables[top] = copied
+		}
+	}
+
+	c.added = append([]recordRef(nil), fb.added...)
+	c.snapshots = append([]snapshot(nil), fb.snapshots...)
+	if fb.blooms != nil {
+		c.blooms = newBloomSet(fb.blooms.bitsPerRecord)
+		for i, b := range fb.blooms.pools {
+			b.words = append([]uint64(nil), b.words...)
+			c.blooms.pools[i] = b
+		}
+	}
+	return c, nil
+}
+
+// Equal reports whether other holds the same records as fb, byte for byte
+// and filed under the same prefixes, in the same schema and layout. The
+// rest of the headers is not compared, so a database saved and loaded
+// again under a new snapshot ID is still equal to the original.
+func (fb *FastBase) Equal(other *FastBase) bool {
+	if fb == other {
+		return true
+	}
+	if fb.layout != other.layout || fb.Schema().ID != other.Schema().ID || fb.recordCount() != other.recordCount() {
+		return false
+	}
+
+	// With as many records in all, other holds nothing else if it holds
+	// every record of fb
+	equal := true
+	var mine, theirs []uint32
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		a := fb.prefixRecords(prefix, fb.mergeRuns(prefix, runs, &mine))
+		b := other.prefixRecords(prefix, other.mergeRuns(prefix, other.prefixRuns(prefix), &theirs))
+		equal = sameRecords(a, b)
+		return equal
+	})
+	return equal
+}
+
+// prefixRecords returns the records of ptrs, filed under prefix
+func (fb *FastBase) prefixRecords(prefix [3]byte, ptrs []uint32) [][]byte {
+	records := make([][]byte, len(ptrs))
+	for n, ptr := range ptrs {
+		records[n] = fb.Pools[prefix[0]].GetRecordPtr(ptr)
+	}
+	return records
+}
+
+// sameRecords reports whether two lists hold the same records. Records
+// whose compared bytes tie may be in either order, so lists that differ
+// in order are compared again sorted.
+func sameRecords(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	inOrder := true
+	for n := range a {
+		if !bytes.Equal(a[n], b[n]) {
+			inOrder = false
+			break
+		}
+	}
+	if inOrder {
+		return true
+	}
+	for _, records := range [][][]byte{a, b} {
+		sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i], records[j]) < 0 })
+	}
+	for n := range a {
+		if !bytes.Equal(a[n], b[n]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package fastbase
+
+import "bytes"
+
+// Collision is a pair of records sharing an x-coordinate but carrying
+// different kangaroo types, or for a near collision the same type at
+// different distances
+type Collision struct {
+	Prefix [3]byte // Prefix of the list holding both records
+	First  []byte  // Record that sorts first in the list
+	Second []byte  // Record that sorts second in the list
+}
+
+// FindCollisions walks every list and returns all pairs of records that
+// share the same x-coordinate but have different kangaroo types. Lists are
+// sorted by x, so such records are always adjacent.
+func (fb *FastBase) FindCollisions() []Collision {
+	return fb.findPairs(false)
+}
+
+// FindNearCollisions walks every list and returns all pairs of records that
+// share the same x-coordinate and kangaroo type at different distances.
+// Two kangaroos of one herd reached the same point class: for wild
+// kangaroos of the x-only walk that may give the key as a collision does,
+// while for tame ones it only shows work done twice.
+func (fb *FastBase) FindNearCollisions() []Collision {
+	return fb.findPairs(true)
+}
+
+// findPairs returns the collisions of every list, or with near the near
+// collisions
+func (fb *FastBase) findPairs(near bool) []Collision {
+	var collisions []Collision
+	schema := fb.Schema()
+
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		pool := &fb.Pools[prefix[0]]
+		for _, run := range runs {
+			start := 0
+			for start < len(run) {
+				// Find the run of records with the same x-coordinate
+				first := pool.GetRecordPtr(run[start])
+				end := start + 1
+				for end < len(run) {
+					next := pool.GetRecordPtr(run[end])
+					if !bytes.Equal(schema.X(first), schema.X(next)) {
+						break
+					}
+					end++
+				}
+
+				for a := start; a < end; a++ {
+					recA := pool.GetRecordPtr(run[a])
+					for b := a + 1; b < end; b++ {
+						recB := pool.GetRecordPtr(run[b])
+						if pairs(schema, recA, recB, near) {
+							collisions = append(collisions, Collision{Prefix: prefix, First: recA, Second: recB})
+						}
+					}
+				}
+
+				start = end
+			}
+		}
+		return true
+	})
+
+	return collisions
+}
@@ -0,0 +1,216 @@
+package fastbase
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CompactStats reports what a compaction reclaimed
+type CompactStats struct {
+	Duplicates  int   // Exact duplicate records dropped
+	BytesBefore int64 // Pool and list memory before compacting
+	BytesAfter  int64 // Pool and list memory after compacting
+	PagesBefore int   // Pool pages before compacting, recycled ones included
+	PagesAfter  int   // Pool pages after compacting
+}
+
+// Reclaimed returns the bytes of memory the compaction freed
+func (s CompactStats) Reclaimed() int64 {
+	return s.BytesBefore - s.BytesAfter
+}
+
+// MemoryUsage returns the bytes held by pool pages, recycled ones included,
+// and by list pointer slices. Pools spilled to disk hold none.
+func (fb *FastBase) MemoryUsage() int64 {
+	var total int64
+	for i := range fb.Pools {
+		total += fb.poolBytes(i)
+		if fb.isCold(byte(i)) {
+			continue
+		}
+		fb.eachListIn(byte(i), func(list *ListRecord) {
+			total += int64(cap(list.Data)) * 4
+		})
+	}
+	return total
+}
+
+// Remove drops the records passing all filters from their lists and
+// returns how many it dropped; without filters it drops every record.
+// Their slots stay allocated in the pool pages, leaving holes that Compact
+// reclaims. Spilled pools are read back to be searched. Removed records
+// are no longer tracked and an open journal is rewritten without them,
+// but the file last saved still holds them until the database is saved
+// whole again: deltas cannot drop records, so the deltas saved since are
+// forgotten and SaveDeltaSince only accepts that last full save.
+func (fb *FastBase) Remove(filters ...Filter) (int, error) {
+	removed := make([]map[uint32]bool, 256)
+	total := 0
+	for i := 0; i < 256; i++ {
+		if err := fb.touch(byte(i)); err != nil {
+			return total, err
+		}
+		pool := &fb.Pools[i]
+		for top := i << 8; top < i<<8+256; top++ {
+			for pos, list := range fb.index.tables[top] {
+				if list == nil || list.Count == 0 {
+					continue
+				}
+				prefix := [3]byte{byte(i), byte(top), byte(pos)}
+				if fb.index.depth == 4 {
+					prefix[2] = byte(pos >> 8)
+				}
+
+				// Kept pointers move down over the removed ones in place
+				kept := list.Data[:0]
+				for _, ptr := range list.Data {
+					if fb.index.depth == 2 {
+						prefix[2] = pool.tag(ptr)
+					}
+					if !matchAll(filters, prefix, pool.GetRecordPtr(ptr)) {
+						kept = append(kept, ptr)
+						continue
+					}
+					if removed[i] == nil {
+						// The pool's segment, if it was spilled, no longer
+						// matches it
+						if err := fb.modify(byte(i)); err != nil {
+							return total, err
+						}
+						removed[i] = make(map[uint32]bool)
+					}
+					removed[i][ptr] = true
+					total++
+				}
+				list.Data = kept
+				list.Count = uint32(len(kept))
+			}
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+
+	added := fb.added[:0]
+	for _, ref := range fb.added {
+		if !removed[ref.prefix[0]][ref.ptr] {
+			added = append(added, ref)
+		}
+	}
+	fb.added = added
+	base := uint64(0)
+	if len(fb.snapshots) > 0 {
+		base = fb.snapshots[0].id
+	}
+	fb.snapshots = []snapshot{{id: base}}
+	fb.resets++
+	if j := fb.journal; j != nil {
+		if err := fb.writeJournal(j.name); err != nil {
+			return total, fmt.Errorf("rewriting the journal: %w", err)
+		}
+	}
+	return total, nil
+}
+
+// Compact rewrites every pool so records are packed in list order, drops
+// exact duplicate records, trims list capacities to their counts and
+// releases recycled pages. Slots of records no list points to any more,
+// as after Remove, and pages left with none in use, go with the old
+// pages, which are left to the garbage collector rather than recycled.
+// Records added since the last snapshot stay tracked for SaveDeltaSince.
+// Pools spilled to disk are left there, as they are packed when read back
+// anyway.
+func (fb *FastBase) Compact() CompactStats {
+	stats, _ := fb.compact(nil)
+	return stats
+}
+
+// compact is Compact, also dropping the records keep rejects if it is not
+// nil, and returning how many it dropped. Dropped records stay tracked,
+// pointing nowhere, so the caller must reset the snapshots.
+func (fb *FastBase) compact(keep func(rec []byte) bool) (CompactStats, int) {
+	stats := CompactStats{BytesBefore: fb.MemoryUsage(), PagesBefore: int(fb.pagedBytes() / int64(fb.layout.PageSize))}
+	dropped := 0
+
+	// Pointers of tracked records, per pool, so they can be remapped
+	tracked := make([]map[uint32]uint32, 256)
+	for _, ref := range fb.added {
+		if tracked[ref.prefix[0]] == nil {
+			tracked[ref.prefix[0]] = make(map[uint32]uint32)
+		}
+		tracked[ref.prefix[0]][ref.ptr] = ref.ptr
+	}
+
+	for i := 0; i < 256; i++ {
+		if fb.isCold(byte(i)) {
+			continue
+		}
+		fb.modify(byte(i))
+		old := fb.Pools[i]
+		fb.Pools[i] = MemPool{}
+		pool := &fb.Pools[i]
+		pool.setLayout(fb.layout)
+
+		fb.eachListIn(byte(i), func(list *ListRecord) {
+			kept := make([]uint32, 0, list.Count)
+			runStart := 0 // First kept record sharing the current key
+			for m := uint32(0); m < list.Count; m++ {
+				rec := old.GetRecordPtr(list.Data[m])
+				tag := old.tag(list.Data[m])
+				if keep != nil && !keep(rec) {
+					dropped++
+					continue
+				}
+
+				if n := len(kept); n > 0 && (pool.tag(kept[n-1]) != tag ||
+					compareKey(pool.GetRecordPtr(kept[n-1]), rec, fb.layout.CompareLength) != 0) {
+					runStart = n
+				}
+				dup := -1
+				for n := runStart; n < len(kept); n++ {
+					if bytes.Equal(pool.GetRecordPtr(kept[n]), rec) {
+						dup = n
+						break
+					}
+				}
+
+				var ptr uint32
+				if dup >= 0 {
+					stats.Duplicates++
+					ptr = kept[dup]
+				} else {
+					// The old pool held at least as many records, so
+					// allocation cannot run out of pages
+					var mem []byte
+					ptr, mem, _ = pool.allocRecord()
+					copy(mem, rec)
+					pool.setTag(ptr, tag)
+					kept = append(kept, ptr)
+				}
+
+				if t := tracked[i]; t != nil {
+					if _, ok := t[list.Data[m]]; ok {
+						t[list.Data[m]] = ptr
+					}
+				}
+			}
+
+			if len(kept) < cap(kept) {
+				kept = append([]uint32(nil), kept...)
+			}
+			list.Data = kept
+			list.Count = uint32(len(kept))
+			list.Capacity = list.Count
+		})
+	}
+
+	// Point tracked records at their new copies. A dropped duplicate maps to
+	// the record it duplicated; snapshot offsets into the log stay valid.
+	for n, ref := range fb.added {
+		fb.added[n].ptr = tracked[ref.prefix[0]][ref.ptr]
+	}
+
+	stats.BytesAfter = fb.MemoryUsage()
+	stats.PagesAfter = int(fb.pagedBytes() / int64(fb.layout.PageSize))
+	return stats, dropped
+}
@@ -0,0 +1,82 @@
+package fastbase
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveThenCompact(t *testing.T) {
+	// Small pages, so pools span about three and compacting frees about one
+	path := filepath.Join(t.TempDir(), "test.db")
+	journal := path + JournalSuffix
+	fb := NewFastBase(WithPageSize(1 << 12))
+	addTestRecords(t, fb, 0, 76800)
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.OpenJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, fb, 76800, 77400)
+	pages := fb.pagedBytes()
+
+	// Records cycle through the types, so a third of them are wild1
+	removed, err := fb.Remove(TypeFilter(TypeWild1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 25800 {
+		t.Errorf("removed %d records; want 25800", removed)
+	}
+	if n := fb.Stats().TotalRecords; n != 51600 {
+		t.Errorf("%d records left; want 51600", n)
+	}
+	if n := fb.Unsaved(); n != 400 {
+		t.Errorf("%d records tracked; want the 400 added and kept", n)
+	}
+	if fb.pagedBytes() != pages {
+		t.Errorf("Remove changed pool pages from %d to %d bytes; want the slots kept until Compact", pages, fb.pagedBytes())
+	}
+
+	stats := fb.Compact()
+	if stats.Reclaimed() <= 0 || stats.PagesAfter >= stats.PagesBefore {
+		t.Errorf("compacting reclaimed %d bytes, %d pages to %d; want some of both", stats.Reclaimed(), stats.PagesBefore, stats.PagesAfter)
+	}
+	for n := 0; n < 77400; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		if found := fb.FindDataBlock(append(prefix[:], rec...)) != nil; found != (n%3 != 1) {
+			t.Fatalf("record %d of type %d found %v after compacting", n, n%3, found)
+		}
+	}
+
+	// A crash now replays the journal, rewritten without the removed records
+	fb.CloseJournal()
+	fb = loadTestDB(t, path)
+	info, err := fb.OpenJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.CloseJournal()
+	if info.Records != 400 || info.RecoveredByType[TypeWild1] != 0 {
+		t.Errorf("replayed %d records, %d of them wild1; want 400 and none", info.Records, info.RecoveredByType[TypeWild1])
+	}
+}
+
+func TestRemoveForgetsDeltas(t *testing.T) {
+	fb := loadTestDB(t, savedTestDB(t, 30))
+	base := fb.SnapshotID()
+	addTestRecords(t, fb, 30, 40)
+	delta, err := fb.SaveDeltaSince(base, filepath.Join(t.TempDir(), "1.delta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.Remove(TypeFilter(TypeTame)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.SaveDeltaSince(delta, filepath.Join(t.TempDir(), "2.delta")); err == nil {
+		t.Error("saved a delta from a snapshot holding removed records")
+	}
+	if _, err := fb.SaveDeltaSince(base, filepath.Join(t.TempDir(), "2.delta")); err != nil {
+		t.Errorf("saving a delta from the last full save: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package fastbase
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// compareKeyBytewise is the byte-at-a-time comparison compareKey replaced,
+// kept as the baseline of the benchmarks
+func compareKeyBytewise(a, b []byte, n int) int {
+	cmp := 0
+	for i := 0; i < n && cmp == 0; i++ {
+		cmp = int(a[i]) - int(b[i])
+	}
+	switch {
+	case cmp < 0:
+		return -1
+	case cmp > 0:
+		return 1
+	}
+	return 0
+}
+
+// sign maps a comparison result to -1, 0 or +1
+func sign(c int) int {
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestCompareKey(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 1; n <= 40; n++ {
+		a := make([]byte, n)
+		rng.Read(a)
+		for _, tc := range []struct {
+			name   string
+			modify func(b []byte)
+		}{
+			{"equal", func(b []byte) {}},
+			{"last byte higher", func(b []byte) { b[n-1]++ }},
+			{"last byte lower", func(b []byte) { b[n-1]-- }},
+			{"first byte higher", func(b []byte) { b[0]++ }},
+			{"last byte of a word", func(b []byte) { b[min(7, n-1)] ^= 0x80 }},
+			{"first byte of the tail", func(b []byte) { b[n/8*8%n] ^= 0x01 }},
+		} {
+			b := append([]byte(nil), a...)
+			tc.modify(b)
+			want := bytes.Compare(a, b)
+			if got := compareKey(a, b, n); got != want {
+				t.Errorf("%d bytes, %s: compareKey(a, b) = %d; want %d", n, tc.name, got, want)
+			}
+			if got := compareKey(b, a, n); got != -want {
+				t.Errorf("%d bytes, %s: compareKey(b, a) = %d; want %d", n, tc.name, got, -want)
+			}
+			if got := compareKeyBytewise(a, b, n); got != want {
+				t.Errorf("%d bytes, %s: baseline gives %d; want %d", n, tc.name, got, want)
+			}
+		}
+	}
+
+	// Bytes past n are ignored
+	a, b := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 11}
+	if c := compareKey(a, b, 9); c != 0 {
+		t.Errorf("compareKey of keys differing after n = %d; want 0", c)
+	}
+}
+
+// benchLists fills a FastBase with records filed under a few prefixes, so
+// its lists are long, as after merging millions of records, and returns
+// it with the keys of its records, prefix first
+func benchLists(b *testing.B, records, prefixes int) (*FastBase, [][]byte) {
+	b.Helper()
+	rng := rand.New(rand.NewSource(1))
+	fb := NewFastBase()
+	keys := make([][]byte, records)
+	for n := range keys {
+		key := make([]byte, 3+fb.layout.RecordLength)
+		key[0], key[1], key[2] = 0x12, 0x34, byte(n%prefixes)
+		rng.Read(key[3:])
+		key[len(key)-1] = byte(TypeTame)
+		if _, err := fb.AddRecord(key[0], key[1], key[2], key[3:]); err != nil {
+			b.Fatal(err)
+		}
+		keys[n] = key
+	}
+	return fb, keys
+}
+
+// lowerBoundWith is lowerBound comparing keys with cmp
+func lowerBoundWith(fb *FastBase, list *ListRecord, prefix [3]byte, data []byte, cmp func(a, b []byte, n int) int) int {
+	pool := &fb.Pools[prefix[0]]
+	left, right := 0, int(list.Count)
+	for left < right {
+		mid := (left + right) / 2
+		if cmp(pool.GetRecordPtr(list.Data[mid]), data, fb.layout.CompareLength) < 0 {
+			left = mid + 1
+		} else {
+			right = mid
+		}
+	}
+	return left
+}
+
+// findWith is FindDataBlock comparing keys with cmp
+func findWith(fb *FastBase, key []byte, cmp func(a, b []byte, n int) int) []byte {
+	prefix := [3]byte{key[0], key[1], key[2]}
+	list := fb.index.get(prefix, key[3:])
+	if list == nil {
+		return nil
+	}
+	pos := lowerBoundWith(fb, list, prefix, key[3:], cmp)
+	if pos >= int(list.Count) {
+		return nil
+	}
+	rec := fb.Pools[prefix[0]].GetRecordPtr(list.Data[pos])
+	if cmp(rec, key[3:], fb.layout.CompareLength) != 0 {
+		return nil
+	}
+	return rec
+}
+
+var compareFuncs = []struct {
+	name string
+	cmp  func(a, b []byte, n int) int
+}{
+	{"bytewise", compareKeyBytewise},
+	{"words", compareKey},
+}
+
+func BenchmarkLowerBound(b *testing.B) {
+	fb, keys := benchLists(b, 1<<16, 4)
+	for _, f := range compareFuncs {
+		b.Run(f.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				key := keys[i%len(keys)]
+				prefix := [3]byte{key[0], key[1], key[2]}
+				lowerBoundWith(fb, fb.index.get(prefix, key[3:]), prefix, key[3:], f.cmp)
+			}
+		})
+	}
+}
+
+func BenchmarkFindDataBlock(b *testing.B) {
+	fb, keys := benchLists(b, 1<<16, 64)
+	for _, f := range compareFuncs {
+		b.Run(f.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if findWith(fb, keys[i%len(keys)], f.cmp) == nil {
+					b.Fatal("record not found")
+				}
+			}
+		})
+	}
+	b.Run("FindDataBlock", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if fb.FindDataBlock(keys[i%len(keys)]) == nil {
+				b.Fatal("record not found")
+			}
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package fastbase
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Period is a span of time contributions are counted over. Periods start
+// at midnight UTC, so every collector draws the same boundaries.
+type Period int
+
+const (
+	PeriodAll   Period = iota // The whole span of the records counted
+	PeriodDay                 // Calendar days
+	PeriodWeek                // ISO weeks, starting on Monday
+	PeriodMonth               // Calendar months
+)
+
+var periodNames = [...]string{"all", "day", "week", "month"}
+
+// String returns the period's name, as ParsePeriod accepts it
+func (p Period) String() string {
+	if int(p) < len(periodNames) {
+		return periodNames[p]
+	}
+	return fmt.Sprintf("unknown(%d)", int(p))
+}
+
+// ParsePeriod accepts a period's name
+func ParsePeriod(s string) (Period, error) {
+	for i, name := range periodNames {
+		if s == name {
+			return Period(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid period %q, want all, day, week or month", s)
+}
+
+// Start returns the start of the period holding t. PeriodAll and the zero
+// time, of records without a submission time, start at the zero time.
+func (p Period) Start(t time.Time) time.Time {
+	if p == PeriodAll || t.IsZero() {
+		return time.Time{}
+	}
+	y, m, d := t.UTC().Date()
+	switch p {
+	case PeriodWeek:
+		d -= (int(t.UTC().Weekday()) + 6) % 7
+	case PeriodMonth:
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Contribution counts the records one worker submitted in one period
+type Contribution struct {
+	Period  time.Time `json:"period"` // Start of the period; zero for PeriodAll
+	Rank    int       `json:"rank"`   // Within the period, from 1
+	Worker  uint32    `json:"worker"`
+	Records int       `json:"records"`
+	Types   [3]int    `json:"types"` // Records by kangaroo type
+	Share   float64   `json:"share"` // Of the records credited in the period
+	First   time.Time `json:"first"` // Earliest submission counted
+	Last    time.Time `json:"last"`  // Latest submission counted
+}
+
+// Contributions counts the records each worker submitted in each period,
+// among those all filters select. Records the FastBase holds were accepted
+// as new, duplicates never being stored, so the counts are what a pool
+// credits its workers with. Records of worker 0, imported or submitted
+// without an API key, are credited to no one and only counted in the
+// uncredited total. Contributions are ordered by period, then by records,
+// most first, and ties by worker ID, so the same database always gives
+// the same ranks. It returns nothing if the FastBase does not record
+// provenance.
+func (fb *FastBase) Contributions(period Period, filters ...Filter) (contribs []Contribution, uncredited int) {
+	if !fb.HasProvenance() {
+		return nil, 0
+	}
+	type key struct {
+		period time.Time
+		worker uint32
+	}
+	schema := fb.Schema()
+	byKey := make(map[key]*Contribution)
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		p, _ := fb.Provenance(rec)
+		if p.Worker == 0 {
+			uncredited++
+			return true
+		}
+		k := key{period.Start(p.Time), p.Worker}
+		c := byKey[k]
+		if c == nil {
+			c = &Contribution{Period: k.period, Worker: p.Worker}
+			byKey[k] = c
+		}
+		c.Records++
+		if t := schema.Type(rec); int(t) < len(c.Types) {
+			c.Types[t]++
+		}
+		if !p.Time.IsZero() {
+			if c.First.IsZero() || p.Time.Before(c.First) {
+				c.First = p.Time
+			}
+			if p.Time.After(c.Last) {
+				c.Last = p.Time
+			}
+		}
+		return true
+	}, filters...)
+
+	contribs = make([]Contribution, 0, len(byKey))
+	totals := make(map[time.Time]int)
+	for _, c := range byKey {
+		contribs = append(contribs, *c)
+		totals[c.Period] += c.Records
+	}
+	sort.Slice(contribs, func(i, j int) bool {
+		a, b := &contribs[i], &contribs[j]
+		switch {
+		case !a.Period.Equal(b.Period):
+			return a.Period.Before(b.Period)
+		case a.Records != b.Records:
+			return a.Records > b.Records
+		}
+		return a.Worker < b.Worker
+	})
+	for i := range contribs {
+		c := &contribs[i]
+		c.Rank = 1
+		if i > 0 && contribs[i-1].Period.Equal(c.Period) {
+			c.Rank = contribs[i-1].Rank + 1
+		}
+		c.Share = float64(c.Records) / float64(totals[c.Period])
+	}
+	return contribs, uncredited
+}
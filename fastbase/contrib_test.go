@@ -0,0 +1,85 @@
+package fastbase
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// submit applies records from..to-1 of the test series to fb as a batch
+// from worker at t, as the server does
+func submit(t *testing.T, fb *FastBase, worker uint32, at time.Time, from, to int) {
+	t.Helper()
+	b := NewBatch(SchemaStandard)
+	for n := from; n < to; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		if err := b.Add(prefix, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fb.ApplyDeltaAs(&buf, Provenance{Worker: worker, Time: at}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContributions(t *testing.T) {
+	day1 := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC) // A Monday
+	day2 := day1.Add(24 * time.Hour)
+
+	fb := NewFastBase(WithProvenance())
+	submit(t, fb, 7, day1, 0, 5)
+	submit(t, fb, 3, day1, 5, 10)
+	submit(t, fb, 9, day1, 10, 12)
+	submit(t, fb, 9, day2, 12, 20)
+	submit(t, fb, 7, day2, 20, 21)
+	submit(t, fb, 7, day2, 0, 5) // Duplicates, never stored or credited
+	submit(t, fb, 0, day2, 21, 24)
+
+	type want struct {
+		period time.Time
+		rank   int
+		worker uint32
+		n      int
+	}
+	midnight := func(t time.Time) time.Time { return t.Truncate(24 * time.Hour) }
+	for _, tc := range []struct {
+		period  Period
+		filters []Filter
+		want    []want
+	}{
+		// Workers 7 and 3 tie on day 1, and the lower ID ranks first
+		{PeriodDay, nil, []want{
+			{midnight(day1), 1, 3, 5}, {midnight(day1), 2, 7, 5}, {midnight(day1), 3, 9, 2},
+			{midnight(day2), 1, 9, 8}, {midnight(day2), 2, 7, 1},
+		}},
+		{PeriodWeek, nil, []want{{midnight(day1), 1, 9, 10}, {midnight(day1), 2, 7, 6}, {midnight(day1), 3, 3, 5}}},
+		{PeriodAll, []Filter{fb.UntilFilter(day2)}, []want{{time.Time{}, 1, 3, 5}, {time.Time{}, 2, 7, 5}, {time.Time{}, 3, 9, 2}}},
+		{PeriodMonth, []Filter{fb.SinceFilter(day2)}, []want{{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 1, 9, 8}, {time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 2, 7, 1}}},
+	} {
+		contribs, uncredited := fb.Contributions(tc.period, tc.filters...)
+		if len(contribs) != len(tc.want) {
+			t.Fatalf("%v: %d contributions; want %d", tc.period, len(contribs), len(tc.want))
+		}
+		totals := make(map[time.Time]int)
+		for _, w := range tc.want {
+			totals[w.period] += w.n
+		}
+		for i, w := range tc.want {
+			c := contribs[i]
+			if !c.Period.Equal(w.period) || c.Rank != w.rank || c.Worker != w.worker || c.Records != w.n {
+				t.Errorf("%v: contribution %d is %v rank %d worker %d with %d records; want %v rank %d worker %d with %d",
+					tc.period, i, c.Period, c.Rank, c.Worker, c.Records, w.period, w.rank, w.worker, w.n)
+			}
+			if share := float64(w.n) / float64(totals[w.period]); c.Share != share {
+				t.Errorf("%v: contribution %d has share %v; want %v", tc.period, i, c.Share, share)
+			}
+		}
+		if tc.filters == nil && uncredited != 3 {
+			t.Errorf("%v: %d uncredited records; want 3", tc.period, uncredited)
+		}
+	}
+}
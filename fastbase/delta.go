@@ -0,0 +1,331 @@
+package fastbase
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// deltaMagic starts every delta file
+var deltaMagic = [8]byte{'R', 'C', 'K', 'D', 'E', 'L', 'T', 'A'}
+
+// recordRef locates a record added since the last full save
+type recordRef struct {
+	prefix [3]byte
+	ptr    uint32
+}
+
+// track records a record just added for delta saves and the journal
+func (fb *FastBase) track(prefix [3]byte, ptr uint32, rec []byte) {
+	fb.added = append(fb.added, recordRef{prefix: prefix, ptr: ptr})
+	fb.logRecord(prefix, rec)
+}
+
+// snapshot marks how many records had been added when a save was taken
+type snapshot struct {
+	id    uint64
+	added int
+}
+
+// newSnapshotID returns a random, non-zero snapshot identifier
+func newSnapshotID() uint64 {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.LittleEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+// resetSnapshots forgets tracked records and starts over from snapshot id
+func (fb *FastBase) resetSnapshots(id uint64) {
+	fb.added = nil
+	fb.snapshots = []snapshot{{id: id}}
+	fb.resets++
+}
+
+// Unsaved returns how many records were added since the last full save or
+// load, such as those replayed from a journal
+func (fb *FastBase) Unsaved() int {
+	return len(fb.added)
+}
+
+// SnapshotID returns the ID of the latest snapshot: the last full save or
+// load, or the last delta saved since then. A FastBase that was never saved
+// or loaded is at snapshot 0.
+func (fb *FastBase) SnapshotID() uint64 {
+	if len(fb.snapshots) == 0 {
+		return 0
+	}
+	return fb.snapshots[len(fb.snapshots)-1].id
+}
+
+// SaveDeltaSince writes the records added since snapshotID to a delta file
+// and returns the ID of the new snapshot it takes. snapshotID must be the
+// last full save or load, or a delta saved after it; chaining each delta
+// from the previous one keeps every file small.
+func (fb *FastBase) SaveDeltaSince(snapshotID uint64, filename string) (uint64, error) {
+	if fb.Encrypted() {
+		return 0, errors.New("encrypted databases can only be saved whole")
+	}
+	if len(fb.snapshots) == 0 {
+		fb.snapshots = []snapshot{{id: 0}}
+	}
+
+	start := -1
+	for _, s := range fb.snapshots {
+		if s.id == snapshotID {
+			start = s.added
+			break
+		}
+	}
+	if start < 0 {
+		return 0, fmt.Errorf("unknown snapshot %016x", snapshotID)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	newID := newSnapshotID()
+	refs := fb.added[start:]
+
+	header := fb.Header
+	fb.layout.putHeader(&header)
+	writeDeltaHeader(w, header, snapshotID, newID, len(refs))
+	for _, ref := range refs {
+		w.Write(ref.prefix[:])
+		w.Write(fb.Pools[ref.prefix[0]].GetRecordPtr(ref.ptr))
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	fb.snapshots = append(fb.snapshots, snapshot{id: newID, added: len(fb.added)})
+	fb.logger().Info("delta saved", "file", filename, "records", len(refs),
+		"base", fmt.Sprintf("%016x", snapshotID), snapshotAttr(newID))
+	return newID, nil
+}
+
+// writeDeltaHeader writes everything in a delta file that precedes the
+// records; errors surface when the buffered writer is flushed
+func writeDeltaHeader(w *bufio.Writer, header [256]byte, baseID, id uint64, records int) {
+	var ids [24]byte
+	binary.LittleEndian.PutUint64(ids[0:], baseID)
+	binary.LittleEndian.PutUint64(ids[8:], id)
+	binary.LittleEndian.PutUint64(ids[16:], uint64(records))
+
+	w.Write(deltaMagic[:])
+	w.Write(header[:])
+	w.Write(ids[:])
+}
+
+// Batch collects records outside any FastBase, for shipping to a pool
+// server or another database in the delta file format
+type Batch struct {
+	Header  [256]byte // Header of the database the records belong to
+	ID      uint64    // Random, so a server can tell a batch sent again
+	entries []byte    // Prefix and record of each entry, back to back
+}
+
+// NewBatch starts an empty batch of records laid out according to schema
+func NewBatch(schema Schema) *Batch {
+	b := &Batch{ID: newSnapshotID()}
+	b.Header[HeaderSchema] = schema.ID
+	if schema.RecordLength != DBRecordLength {
+		b.Header[HeaderRecordLength] = byte(schema.RecordLength)
+	}
+	return b
+}
+
+// Add appends a record filed under prefix
+func (b *Batch) Add(prefix [3]byte, rec []byte) error {
+	if n := b.recordLength(); len(rec) != n {
+		return recordLengthError(len(rec), n)
+	}
+	b.entries = append(b.entries, prefix[:]...)
+	b.entries = append(b.entries, rec...)
+	return nil
+}
+
+// Len returns the number of records in the batch
+func (b *Batch) Len() int {
+	return len(b.entries) / (3 + b.recordLength())
+}
+
+// recordLength returns the record length set in the batch header
+func (b *Batch) recordLength() int {
+	if n := b.Header[HeaderRecordLength]; n != 0 {
+		return int(n)
+	}
+	return DBRecordLength
+}
+
+// Reset empties the batch for the next, keeping its header but not its ID
+func (b *Batch) Reset() {
+	b.entries = b.entries[:0]
+	b.ID = newSnapshotID()
+}
+
+// WriteTo writes the batch in the delta file format, so ApplyDelta and
+// ApplyDeltaFrom accept it, with the batch ID as the delta's
+func (b *Batch) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	writeDeltaHeader(bw, b.Header, 0, b.ID, b.Len())
+	bw.Write(b.entries)
+	n := int64(len(deltaMagic) + len(b.Header) + 24 + len(b.entries))
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DeltaInfo describes a delta file
+type DeltaInfo struct {
+	BaseID  uint64 // Snapshot the delta was taken against
+	ID      uint64 // Snapshot the delta brings its writer to
+	Records int    // Records in the delta
+}
+
+// ApplyDelta adds the records of a delta file written by SaveDeltaSince,
+// skipping those already present. Deltas may be applied to any database
+// with the same schema, in any order and more than once.
+func (fb *FastBase) ApplyDelta(filename string) (DeltaInfo, MergeStats, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return DeltaInfo{}, MergeStats{}, err
+	}
+	defer file.Close()
+	return fb.ApplyDeltaFrom(bufio.NewReader(file))
+}
+
+// ApplyDeltaFrom is like ApplyDelta but reads the delta from r
+func (fb *FastBase) ApplyDeltaFrom(r io.Reader) (DeltaInfo, MergeStats, error) {
+	return fb.ApplyDeltaFunc(r, nil)
+}
+
+// ApplyDeltaFunc is like ApplyDeltaFrom and also calls added, if not nil,
+// for every record that was new to fb. The record slice is only valid
+// during the call.
+func (fb *FastBase) ApplyDeltaFunc(r io.Reader, added func(prefix [3]byte, rec []byte)) (DeltaInfo, MergeStats, error) {
+	return fb.applyDelta(r, nil, added)
+}
+
+// ApplyDeltaAs is like ApplyDeltaFunc but stamps every record with p when
+// fb records provenance. The delta may then also hold records without room
+// for provenance, as workers send them.
+func (fb *FastBase) ApplyDeltaAs(r io.Reader, p Provenance, added func(prefix [3]byte, rec []byte)) (DeltaInfo, MergeStats, error) {
+	return fb.applyDelta(r, &p, added)
+}
+
+// applyDelta applies a delta, stamping its records with stamp if not nil
+func (fb *FastBase) applyDelta(r io.Reader, stamp *Provenance, added func(prefix [3]byte, rec []byte)) (DeltaInfo, MergeStats, error) {
+	var stats MergeStats
+	info, header, err := readDeltaHeader(r)
+	if err != nil {
+		return info, stats, err
+	}
+
+	schema := fb.Schema()
+	if s, err := SchemaByID(header[HeaderSchema]); err != nil {
+		return info, stats, err
+	} else if s.ID != schema.ID {
+		return info, stats, fmt.Errorf("cannot apply a %s delta to a %s database", s.Name, schema.Name)
+	}
+
+	layout, err := layoutFromHeader(header)
+	if err != nil {
+		return info, stats, err
+	}
+	// Stamped records may come without their provenance bytes
+	stamped := stamp != nil && fb.HasProvenance()
+	widen := stamped && layout.RecordLength+ProvenanceLength == fb.layout.RecordLength
+	if layout.RecordLength != fb.layout.RecordLength && !widen {
+		return info, stats, fmt.Errorf("cannot apply a delta of %d-byte records to a database of %d-byte records",
+			layout.RecordLength, fb.layout.RecordLength)
+	}
+
+	buf := make([]byte, 3+layout.RecordLength)
+	rec := buf[3:]
+	if widen {
+		rec = make([]byte, fb.layout.RecordLength)
+	}
+	for n := 0; n < info.Records; n++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return info, stats, fmt.Errorf("error reading delta record %d: %v", n, err)
+		}
+		if widen {
+			copy(rec, buf[3:len(buf)-1])
+			rec[len(rec)-1] = buf[len(buf)-1]
+		}
+		if stamped {
+			fb.SetProvenance(rec, *stamp)
+		}
+		if err := fb.mergeRecord(schema, [3]byte{buf[0], buf[1], buf[2]}, rec, &stats, added); err != nil {
+			return info, stats, err
+		}
+	}
+
+	fb.logger().Debug("delta applied", snapshotAttr(info.ID), "records", stats.Records, "added", stats.Added,
+		"duplicates", stats.Duplicates, "collisions", stats.Collisions)
+	return info, stats, nil
+}
+
+// readDeltaHeader reads everything in a delta file that precedes the
+// records
+func readDeltaHeader(r io.Reader) (DeltaInfo, [256]byte, error) {
+	var info DeltaInfo
+	var magic [8]byte
+	var header [256]byte
+	var ids [24]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != deltaMagic {
+		return info, header, errors.New("not a delta file")
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return info, header, fmt.Errorf("error reading delta header: %v", err)
+	}
+	if _, err := io.ReadFull(r, ids[:]); err != nil {
+		return info, header, fmt.Errorf("error reading delta header: %v", err)
+	}
+	info.BaseID = binary.LittleEndian.Uint64(ids[0:])
+	info.ID = binary.LittleEndian.Uint64(ids[8:])
+	info.Records = int(binary.LittleEndian.Uint64(ids[16:]))
+	return info, header, nil
+}
+
+// ScanDelta calls fn for every record of a delta without applying any,
+// stopping at the first error fn returns, so a delta can be checked whole
+// before it is applied. The record slice is only valid during the call.
+func ScanDelta(r io.Reader, fn func(prefix [3]byte, rec []byte) error) (DeltaInfo, error) {
+	info, header, err := readDeltaHeader(r)
+	if err != nil {
+		return info, err
+	}
+	layout, err := layoutFromHeader(header)
+	if err != nil {
+		return info, err
+	}
+	buf := make([]byte, 3+layout.RecordLength)
+	for n := 0; n < info.Records; n++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return info, fmt.Errorf("error reading delta record %d: %v", n, err)
+		}
+		if err := fn([3]byte{buf[0], buf[1], buf[2]}, buf[3:]); err != nil {
+			return info, fmt.Errorf("delta record %d: %v", n, err)
+		}
+	}
+	return info, nil
+}
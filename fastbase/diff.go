@@ -0,0 +1,65 @@
+package fastbase
+
+import "bytes"
+
+// DiffResult counts how the records of two databases overlap
+type DiffResult struct {
+	OnlyA  int // Records only in the receiver
+	OnlyB  int // Records only in the other database
+	Common int // Records present in both
+}
+
+// Diff compares fb (A) with other (B) record by record. If onlyB is not
+// nil it is called for every record found only in B; the record slice
+// points into B's pool memory.
+func (fb *FastBase) Diff(other *FastBase, onlyB func(prefix [3]byte, rec []byte)) DiffResult {
+	var res DiffResult
+	other.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		for _, run := range runs {
+			for _, ptr := range run {
+				rec := other.Pools[prefix[0]].GetRecordPtr(ptr)
+				if fb.containsRecord(prefix, rec) {
+					res.Common++
+					continue
+				}
+				res.OnlyB++
+				if onlyB != nil {
+					onlyB(prefix, rec)
+				}
+			}
+		}
+		return true
+	})
+	res.OnlyA = fb.recordCount() - res.Common
+	return res
+}
+
+// containsRecord reports whether a byte-for-byte copy of rec is filed
+// under prefix
+func (fb *FastBase) containsRecord(prefix [3]byte, rec []byte) bool {
+	if records, cold := fb.coldList(prefix); cold {
+		n := fb.layout.RecordLength
+		for off := fb.coldLowerBound(records, rec) * n; off < len(records); off += n {
+			if compareKey(records[off:], rec, fb.layout.CompareLength) != 0 {
+				return false
+			}
+			if bytes.Equal(records[off:off+n], rec) {
+				return true
+			}
+		}
+		return false
+	}
+	list := fb.index.get(prefix, rec)
+	if list == nil {
+		return false
+	}
+	for pos := fb.lowerBound(list, prefix, rec); pos < int(list.Count); pos++ {
+		if fb.compareEntry(list.Data[pos], prefix, rec) != 0 {
+			return false
+		}
+		if bytes.Equal(fb.Pools[prefix[0]].GetRecordPtr(list.Data[pos]), rec) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package fastbase
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// The digest tree has a level per prefix byte: the root, a node per first
+// byte, a node per first two bytes, and a leaf per prefix hashing the
+// records filed under it. A node hashes its depth and, in order, the byte
+// and hash of each non-empty child, so two databases holding the same
+// records have the same root, whatever their layout, provenance or the
+// order the records came in, and differing subtrees lead to the prefixes
+// where they differ.
+const digestLeafDepth = 3
+
+// DigestNode is a node of the digest tree of a database
+type DigestNode struct {
+	Hash     [32]byte
+	Children map[byte][32]byte // Non-empty children by their prefix byte; nil for a leaf
+}
+
+// Digest returns the root of the digest tree of the database, which two
+// databases share exactly when they hold the same records
+func (fb *FastBase) Digest() [32]byte {
+	return fb.digest(nil, nil)
+}
+
+// DigestNode returns the node of the digest tree for the prefixes starting
+// with path, a path of up to three prefix bytes; the empty path is the root
+func (fb *FastBase) DigestNode(path []byte) (DigestNode, error) {
+	if len(path) > digestLeafDepth {
+		return DigestNode{}, fmt.Errorf("digest path must be at most %d bytes, got %d", digestLeafDepth, len(path))
+	}
+	var node DigestNode
+	if len(path) < digestLeafDepth {
+		node.Children = make(map[byte][32]byte)
+	}
+	node.Hash = fb.digest(path, func(b byte, h [32]byte) { node.Children[b] = h })
+	return node, nil
+}
+
+// pathRange returns the band of prefixes starting with path
+func pathRange(path []byte) PrefixRange {
+	var r PrefixRange
+	copy(r.Lo[:], path)
+	copy(r.Hi[:], path)
+	for i := len(path); i < len(r.Hi); i++ {
+		r.Hi[i] = 0xff
+	}
+	return r
+}
+
+// digest hashes the subtree at path in one pass over its records, calling
+// child, if not nil, with the hash of each non-empty child
+func (fb *FastBase) digest(path []byte, child func(b byte, h [32]byte)) [32]byte {
+	schema := fb.Schema()
+	depth := len(path)
+	if depth == digestLeafDepth {
+		return leafDigest(schema, fb.ListRecords([3]byte{path[0], path[1], path[2]}))
+	}
+
+	// One node is open at each depth below the subtree's root: the one
+	// holding the latest prefix
+	var nodes [digestLeafDepth]hash.Hash
+	var open [digestLeafDepth]bool
+	var last [3]byte
+	for d := depth; d < digestLeafDepth; d++ {
+		nodes[d] = sha256.New()
+	}
+	nodes[depth].Write([]byte{byte(depth)})
+
+	// closeBelow closes the open nodes deeper than d into their parents
+	closeBelow := func(d int) {
+		for k := digestLeafDepth - 1; k > d; k-- {
+			if !open[k] {
+				continue
+			}
+			var h [32]byte
+			nodes[k].Sum(h[:0])
+			nodes[k-1].Write(last[k-1 : k])
+			nodes[k-1].Write(h[:])
+			if k-1 == depth && child != nil {
+				child(last[k-1], h)
+			}
+			open[k] = false
+		}
+	}
+
+	first := true
+	fb.ForEachInRange(pathRange(path), func(prefix [3]byte, records [][]byte) bool {
+		if !first {
+			common := 0
+			for common < digestLeafDepth && prefix[common] == last[common] {
+				common++
+			}
+			closeBelow(common)
+		}
+		first = false
+		last = prefix
+		for d := depth + 1; d < digestLeafDepth; d++ {
+			if !open[d] {
+				nodes[d].Reset()
+				nodes[d].Write([]byte{byte(d)})
+				open[d] = true
+			}
+		}
+
+		leaf := leafDigest(schema, records)
+		nodes[digestLeafDepth-1].Write(prefix[digestLeafDepth-1:])
+		nodes[digestLeafDepth-1].Write(leaf[:])
+		if depth == digestLeafDepth-1 && child != nil {
+			child(prefix[digestLeafDepth-1], leaf)
+		}
+		return true
+	})
+	closeBelow(depth)
+
+	var root [32]byte
+	nodes[depth].Sum(root[:0])
+	return root
+}
+
+// leafDigest hashes the x-coordinate, distance and type of the records of
+// one prefix, in byte order
+func leafDigest(schema Schema, records [][]byte) [32]byte {
+	n := schema.XLength + schema.DistanceLength
+	entries := make([][]byte, len(records))
+	for i, rec := range records {
+		entries[i] = append(append(make([]byte, 0, n+1), rec[:n]...), rec[len(rec)-1])
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+
+	h := sha256.New()
+	h.Write([]byte{digestLeafDepth})
+	for _, e := range entries {
+		h.Write(e)
+	}
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// DivergentPrefixes walks two digest trees down from their roots, fetching
+// nodes with a and b, and returns the bands of prefixes whose records
+// differ, in order. A subtree only one side holds is returned whole rather
+// than walked. Either side may be a remote database, such as a server's.
+func DivergentPrefixes(a, b func(path []byte) (DigestNode, error)) ([]PrefixRange, error) {
+	var diverge []PrefixRange
+	var walk func(path []byte) error
+	walk = func(path []byte) error {
+		na, err := a(path)
+		if err != nil {
+			return err
+		}
+		nb, err := b(path)
+		if err != nil {
+			return err
+		}
+		if na.Hash == nb.Hash {
+			return nil
+		}
+		if len(path) == digestLeafDepth {
+			diverge = append(diverge, pathRange(path))
+			return nil
+		}
+		for c := 0; c < 256; c++ {
+			ha, okA := na.Children[byte(c)]
+			hb, okB := nb.Children[byte(c)]
+			sub := append(path[:len(path):len(path)], byte(c))
+			switch {
+			case !okA && !okB, ha == hb:
+			case okA != okB:
+				diverge = append(diverge, pathRange(sub))
+			default:
+				if err := walk(sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return diverge, walk(nil)
+}
@@ -0,0 +1,352 @@
+package fastbase
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Header bytes of encrypted files. The header itself stays readable, so
+// tools can tell what a file holds and which secret it needs; everything
+// after it is encrypted.
+const (
+	// HeaderEncryption holds how the file is encrypted: 0 if it is not,
+	// else EncryptKeyFile or EncryptPassphrase
+	HeaderEncryption = 16
+
+	// HeaderSalt holds the 16 random bytes a file key is derived with
+	HeaderSalt = 17
+)
+
+// Encryption kinds recorded in HeaderEncryption. Both encrypt with
+// AES-256-GCM under a key derived from the secret and the file's salt.
+const (
+	// EncryptKeyFile derives the file key from a key file with HMAC-SHA256
+	EncryptKeyFile = 1
+
+	// EncryptPassphrase derives the file key from a passphrase with
+	// PBKDF2-HMAC-SHA256
+	EncryptPassphrase = 2
+)
+
+const (
+	saltLength        = 16
+	encryptChunkSize  = 64 << 10 // Plaintext bytes per GCM message
+	minKeyFileLength  = 32
+	keyFileSecretSize = 32
+	gcmTagSize        = 16
+)
+
+// pbkdf2Iterations is the PBKDF2 work factor of passphrases. Tests lower
+// it; files record only the salt, so it cannot change otherwise without
+// making existing files unreadable.
+var pbkdf2Iterations = 600000
+
+// ErrEncrypted reports an encrypted file read without the secret it needs
+var ErrEncrypted = errors.New("database is encrypted; no passphrase or key file given")
+
+// Secret is a passphrase or key file contents that encrypted files are
+// keyed by
+type Secret struct {
+	kind     byte
+	material []byte
+
+	mu   sync.Mutex // Guards salt and key
+	salt []byte     // Salt of the last file key derived
+	key  []byte     // Last file key derived
+}
+
+// Passphrase returns the secret of a passphrase
+func Passphrase(p string) *Secret {
+	return &Secret{kind: EncryptPassphrase, material: []byte(p)}
+}
+
+// ReadKeyFile returns the secret in a key file, such as one written by
+// GenerateKeyFile. Surrounding whitespace is ignored.
+func ReadKeyFile(path string) (*Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) < minKeyFileLength {
+		return nil, fmt.Errorf("key file %s holds fewer than %d bytes", path, minKeyFileLength)
+	}
+	return &Secret{kind: EncryptKeyFile, material: key}, nil
+}
+
+// GenerateKeyFile writes a new random key, in hex, to a file readable only
+// by its owner
+func GenerateKeyFile(path string) error {
+	key := make([]byte, keyFileSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, hex.EncodeToString(key)); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// fileKey derives the AES-256 key of a file from the secret and its salt.
+// The last key is kept, as loading prefix ranges of one passphrase file
+// would otherwise run PBKDF2 for every range.
+func (s *Secret) fileKey(salt []byte) []byte {
+	if s.kind == EncryptKeyFile {
+		mac := hmac.New(sha256.New, s.material)
+		mac.Write(salt)
+		return mac.Sum(nil)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key == nil || !bytes.Equal(s.salt, salt) {
+		s.key = pbkdf2SHA256(s.material, salt, pbkdf2Iterations)
+		s.salt = append(s.salt[:0], salt...)
+	}
+	return s.key
+}
+
+// pbkdf2SHA256 derives one 32-byte block with PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
+
+// SetSecret sets the secret that encrypted files are read and saved with.
+// Loading a file then decrypts it if its header says it is encrypted.
+func (fb *FastBase) SetSecret(s *Secret) {
+	fb.secret = s
+}
+
+// SetEncrypted turns encryption of later saves on or off. Turning it on
+// needs a secret set by SetSecret. A loaded database stays as encrypted as
+// its file was until this is called.
+func (fb *FastBase) SetEncrypted(on bool) error {
+	if !on {
+		fb.Header[HeaderEncryption] = 0
+		clear(fb.Header[HeaderSalt : HeaderSalt+saltLength])
+		return nil
+	}
+	if fb.secret == nil {
+		return ErrEncrypted
+	}
+	fb.Header[HeaderEncryption] = fb.secret.kind
+	return nil
+}
+
+// Encrypted reports whether saves of the FastBase are encrypted
+func (fb *FastBase) Encrypted() bool {
+	return fb.Header[HeaderEncryption] != 0
+}
+
+// checkEncryption checks that an encrypted header can be read with the
+// secret of the FastBase
+func (fb *FastBase) checkEncryption(header *[256]byte) error {
+	switch kind := header[HeaderEncryption]; {
+	case kind == 0:
+		return nil
+	case kind != EncryptKeyFile && kind != EncryptPassphrase:
+		return fmt.Errorf("unsupported encryption %d", kind)
+	case fb.secret == nil:
+		return ErrEncrypted
+	case fb.secret.kind != kind && kind == EncryptKeyFile:
+		return errors.New("database is encrypted with a key file, not a passphrase")
+	case fb.secret.kind != kind:
+		return errors.New("database is encrypted with a passphrase, not a key file")
+	}
+	return nil
+}
+
+// newFileAEAD returns the cipher of a file with the given header
+func (fb *FastBase) newFileAEAD(header *[256]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(fb.secret.fileKey(header[HeaderSalt : HeaderSalt+saltLength]))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of chunk n. The last chunk is marked so a
+// file cut at a chunk boundary does not decrypt as complete.
+func chunkNonce(nonce []byte, n uint64, last bool) []byte {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[3:], n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptedSize returns how many bytes n bytes take encrypted
+func encryptedSize(n int64) int64 {
+	chunks := max((n+encryptChunkSize-1)/encryptChunkSize, 1)
+	return n + chunks*gcmTagSize
+}
+
+// encryptWriter encrypts what follows the header of a file in chunks of
+// encryptChunkSize bytes, each sealed with the header as additional data
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	out    []byte
+	nonce  []byte
+	n      uint64
+}
+
+// encryptingWriter stores a new salt in header, which must be written to w
+// unencrypted, and returns a writer encrypting the rest of the file. The
+// writer must be closed to write the last chunk.
+func (fb *FastBase) encryptingWriter(w io.Writer, header *[256]byte) (*encryptWriter, error) {
+	if fb.secret == nil {
+		return nil, ErrEncrypted
+	}
+	header[HeaderEncryption] = fb.secret.kind
+	if _, err := rand.Read(header[HeaderSalt : HeaderSalt+saltLength]); err != nil {
+		return nil, err
+	}
+	aead, err := fb.newFileAEAD(header)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: append([]byte(nil), header[:]...),
+		buf:    make([]byte, 0, encryptChunkSize),
+		out:    make([]byte, 0, encryptChunkSize+aead.Overhead()),
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, so the last
+		// chunk is always the one sealed by Close
+		if len(e.buf) == encryptChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.nonce, e.n, last), e.buf, e.header)
+	e.n++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// decryptReader decrypts the chunks written by an encryptWriter
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	in     []byte
+	plain  []byte
+	nonce  []byte
+	n      uint64
+	done   bool
+}
+
+// decryptingReader returns a reader of what follows the header in r: the
+// decrypted contents if the header says the file is encrypted, else r
+// itself
+func (fb *FastBase) decryptingReader(r io.Reader) (io.Reader, error) {
+	if fb.Header[HeaderEncryption] == 0 {
+		return r, nil
+	}
+	aead, err := fb.newFileAEAD(&fb.Header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, encryptChunkSize+aead.Overhead()),
+		aead:   aead,
+		header: append([]byte(nil), fb.Header[:]...),
+		in:     make([]byte, encryptChunkSize+aead.Overhead()),
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk. A chunk is the last one if it is
+// short or nothing follows it.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.in)
+	last := err == io.ErrUnexpectedEOF
+	if err == nil {
+		_, err = d.r.Peek(1)
+		last = err == io.EOF
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	plain, err := d.aead.Open(d.in[:0], chunkNonce(d.nonce, d.n, last), d.in[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("encrypted chunk %d does not decrypt: wrong secret, or the file is damaged or truncated", d.n)
+	}
+	d.plain = plain
+	d.n++
+	d.done = last
+	return nil
+}
@@ -0,0 +1,47 @@
+package fastbase
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by inserts and loads, wrapped with context where there is
+// any, so callers can tell failures apart with errors.Is
+var (
+	// ErrPoolOverflow reports a pool holding as many pages as its 32-bit
+	// record pointers can address
+	ErrPoolOverflow = errors.New("memory pool overflow")
+
+	// ErrListFull reports a list holding MaxListSize records. The records
+	// may still fit the FastBase at a deeper prefix depth.
+	ErrListFull = errors.New("list capacity exceeded")
+
+	// ErrBadRecordLength reports a record not as long as the layout's
+	// records
+	ErrBadRecordLength = errors.New("bad record length")
+)
+
+// CorruptFileError reports a database file that cannot be read to the end,
+// truncated or damaged at Offset. Recover keeps the records before it.
+type CorruptFileError struct {
+	Offset int64   // Byte offset of the count or record that could not be read
+	Prefix [3]byte // List being read
+	Err    error   // Underlying read error
+}
+
+// Error implements error
+func (e *CorruptFileError) Error() string {
+	return fmt.Sprintf("corrupt file at offset %d, list [%02x][%02x][%02x]: %v",
+		e.Offset, e.Prefix[0], e.Prefix[1], e.Prefix[2], e.Err)
+}
+
+// Unwrap returns the underlying read error
+func (e *CorruptFileError) Unwrap() error {
+	return e.Err
+}
+
+// recordLengthError returns ErrBadRecordLength for a record of length got
+// where want bytes were expected
+func recordLengthError(got, want int) error {
+	return fmt.Errorf("%w: data length must be %d bytes, got %d", ErrBadRecordLength, want, got)
+}
@@ -0,0 +1,95 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportFormat selects the text format written by ExportTo
+type ExportFormat string
+
+const (
+	// ExportCSV writes a header line followed by one comma-separated line per record
+	ExportCSV ExportFormat = "csv"
+
+	// ExportNDJSON writes one JSON object per line
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+// ParseExportFormat validates an export format name
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch f := ExportFormat(name); f {
+	case ExportCSV, ExportNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q (want csv or ndjson)", name)
+	}
+}
+
+// ExportTo writes every record passing the filters as one line with its
+// prefix, x-coordinate and distance in hex and its numeric type, followed
+// by the worker ID in hex and the submission time if the FastBase records
+// provenance. It returns the number of records written.
+func (fb *FastBase) ExportTo(w io.Writer, format ExportFormat, filters ...Filter) (int, error) {
+	if _, err := ParseExportFormat(string(format)); err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	provenance := fb.HasProvenance()
+	if format == ExportCSV {
+		header := "prefix,x,distance,type\n"
+		if provenance {
+			header = "prefix,x,distance,type,worker,time\n"
+		}
+		if _, err := bw.WriteString(header); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	schema := fb.Schema()
+	var err error
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		p := hex.EncodeToString(prefix[:])
+		x := hex.EncodeToString(schema.X(rec))
+		d := hex.EncodeToString(schema.Distance(rec))
+		switch {
+		case format == ExportCSV && provenance:
+			worker, at := provenanceText(fb, rec)
+			_, err = fmt.Fprintf(bw, "%s,%s,%s,%d,%s,%s\n", p, x, d, schema.Type(rec), worker, at)
+		case format == ExportCSV:
+			_, err = fmt.Fprintf(bw, "%s,%s,%s,%d\n", p, x, d, schema.Type(rec))
+		case provenance:
+			worker, at := provenanceText(fb, rec)
+			_, err = fmt.Fprintf(bw, "{\"prefix\":\"%s\",\"x\":\"%s\",\"distance\":\"%s\",\"type\":%d,\"worker\":\"%s\",\"time\":\"%s\"}\n",
+				p, x, d, schema.Type(rec), worker, at)
+		default:
+			_, err = fmt.Fprintf(bw, "{\"prefix\":\"%s\",\"x\":\"%s\",\"distance\":\"%s\",\"type\":%d}\n", p, x, d, schema.Type(rec))
+		}
+		if err != nil {
+			return false
+		}
+		count++
+		return true
+	}, filters...)
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// provenanceText renders the worker ID and submission time of a record,
+// the time empty if unknown
+func provenanceText(fb *FastBase, rec []byte) (string, string) {
+	p, _ := fb.Provenance(rec)
+	at := ""
+	if !p.Time.IsZero() {
+		at = p.Time.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%08x", p.Worker), at
+}
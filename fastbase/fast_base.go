@@ -2,40 +2,82 @@
 package fastbase
 
 import (
-	"bytes"
-	"errors"
+	"bufio"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"time"
 )
 
 const (
-	// MemPageSize represents the size of a memory page
+	// MemPageSize is the size of a memory page in the default layout; see
+	// WithPageSize
 	MemPageSize = 1 << 20 // 1MB
 
-	// MaxPageCount is the maximum number of pages allowed in a memory pool
-	MaxPageCount = 1 << 16 // 64K pages
+	// MaxPageCount is the most pages a memory pool of default-layout
+	// records may hold. Record pointers count records across pages in 32
+	// bits, so a pool holds at most 2^32 records; pools of longer records
+	// fit fewer per page and may hold more pages, see MemPool.MaxPages.
+	MaxPageCount = 1 << 32 / RecordsPerPage // 128K pages, 128GB
 
-	// DBRecordLength is the length of each data block record
+	// DBRecordLength is the length of each data block record in the default
+	// layout, and the shortest record any layout may use
 	DBRecordLength = 32
 
 	// DBMinGrowCount is the minimum growth count for list capacity
 	DBMinGrowCount = 16
 
-	// DBFindLength is the length used for data block comparison
+	// DBFindLength is the length used for data block comparison in the
+	// default layout
 	DBFindLength = 29
 
-	// RecordsPerPage is the number of records that can fit in a memory page
+	// RecordsPerPage is the number of default-layout records that fit in a
+	// memory page
 	RecordsPerPage = MemPageSize / DBRecordLength
 )
 
 // MaxListSize is the maximum number of items allowed in a single list
-const MaxListSize uint16 = 0xFFFF // Allow maximum uint16 value
+const MaxListSize uint32 = 0xFFFFFFFF // Allow maximum uint32 value
+
+// MaxListSizeV1 is the largest list the original 16-bit count format can hold
+const MaxListSizeV1 uint32 = 0xFFFF
+
+// Header byte offsets
+const (
+	// HeaderRange holds the range width in bits (set by the C++ solver)
+	HeaderRange = 0
+
+	// HeaderDPBits holds the DP bits the database was collected with
+	HeaderDPBits = 1
+
+	// HeaderVersion holds the file format version
+	HeaderVersion = 2
+
+	// HeaderSchema holds the record schema ID (see Schema)
+	HeaderSchema = 3
+
+	// HeaderSnapshot holds the 8-byte little-endian ID of the full save the
+	// file was written by (see SaveDeltaSince)
+	HeaderSnapshot = 8
+)
+
+// File format versions
+const (
+	// FormatV1 is the original format with 16-bit list counts, as written by
+	// the C++ RCKangaroo. Its header leaves the version byte zeroed.
+	FormatV1 = 0
+
+	// FormatV2 stores list counts as 32-bit values
+	FormatV2 = 2
+)
 
 // ListRecord represents a list of data block references
 type ListRecord struct {
-	Count    uint16   // Number of items in the list
-	Capacity uint16   // Allocated capacity
+	Count    uint32   // Number of items in the list
+	Capacity uint32   // Allocated capacity
 	Data     []uint32 // References to data blocks
 }
 
@@ -43,99 +85,152 @@ type ListRecord struct {
 type MemPool struct {
 	Pages [][]byte // Memory pages
 	Ptr   uint32   // Current pointer position in the current page
+	free  [][]byte // Pages released by Clear, reused before allocating new ones
+
+	recordLength uint32 // Bytes per record; DBRecordLength if zero
+	tagLength    uint32 // Bytes kept after each record for the index; see listIndex
+	pageSize     uint32 // Bytes per page
+	perPage      uint32 // Records per page
 }
 
-// FastBase implements a fast storage and retrieval system using prefix-based indexing
+// FastBase implements a fast storage and retrieval system using prefix-based indexing.
+//
+// A FastBase is not safe for concurrent use. Lookups and iteration may run
+// in several goroutines at once while nothing writes, as under the read
+// lock of the pool server, except with WithTiering: a tiered FastBase
+// moves pools between memory and disk as it reads them, so every call,
+// reads included, must come from one goroutine at a time.
 type FastBase struct {
-	Pools  [256]MemPool               // Memory pools for each first byte prefix
-	Lists  [256][256][256]*ListRecord // 3-byte prefix based lookup table
-	Header [256]byte                  // Header information
+	Pools  [256]MemPool // Memory pools for each first byte prefix
+	Header [256]byte    // Header information
+
+	index listIndex // Lists of record pointers, by leading key bytes
+
+	added     []recordRef // Records added since the last full save or load
+	snapshots []snapshot  // Snapshots taken since then, oldest first
+	resets    uint64      // Times the two were reset; see Freeze
+
+	hooks  Hooks        // Event callbacks set by SetHooks
+	layout Layout       // Record layout, recorded in the header on save
+	blooms *bloomSet    // Per-pool filters for negative lookups; nil if disabled
+	secret *Secret      // Secret of encrypted files; see SetSecret
+	tier   *tierSet     // Pools spilled to disk; nil if disabled
+	limit  *memLimit    // Memory limit; nil if unlimited
+	log    *slog.Logger // Set by SetLogger; nil discards logs
+
+	journal *journal // Log of added records; see OpenJournal
 }
 
-// NewFastBase creates a new FastBase instance
-func NewFastBase() *FastBase {
+// NewFastBase creates a new FastBase instance in the default layout, or
+// the one described by opts. It panics if the options describe an invalid
+// layout; see Layout.Validate.
+func NewFastBase(opts ...Option) *FastBase {
 	fb := &FastBase{}
 
-	// Initialize all list records
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				fb.Lists[i][j][k] = &ListRecord{}
-			}
+	cfg := config{layout: DefaultLayout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.provenance {
+		cfg.layout.RecordLength = max(cfg.layout.RecordLength, DBRecordLength+ProvenanceLength)
+		fb.Header[HeaderProvenance] = 1
+	}
+	if err := cfg.layout.Validate(); err != nil {
+		panic("fastbase: " + err.Error())
+	}
+	fb.setLayout(cfg.layout)
+	if cfg.bloomBits > 0 {
+		fb.blooms = newBloomSet(cfg.bloomBits)
+	}
+	if cfg.tierDir != "" {
+		fb.tier = &tierSet{dir: cfg.tierDir, budget: cfg.tierBudget}
+	}
+	if cfg.memLimit > 0 {
+		if cfg.memPolicy == MemorySpill && fb.tier == nil {
+			panic("fastbase: memory policy spill needs WithTiering")
 		}
+		fb.limit = &memLimit{limit: cfg.memLimit, policy: cfg.memPolicy}
 	}
 
 	return fb
 }
 
-// Clear removes all data from the FastBase
+// Clear removes all data from the FastBase. Pool pages are kept on a
+// freelist and reused by later inserts or loads; call ReleaseMemory to
+// give the memory back instead.
 func (fb *FastBase) Clear() {
-	// Clear all memory pools
+	// Recycle all memory pool pages
 	for i := range fb.Pools {
-		fb.Pools[i].Pages = fb.Pools[i].Pages[:0]
-		fb.Pools[i].Ptr = 0
+		fb.Pools[i].recycle()
 	}
 
-	// Reset all lists
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				fb.Lists[i][j][k].Count = 0
-				fb.Lists[i][j][k].Capacity = 0
-				fb.Lists[i][j][k].Data = nil
-			}
-		}
+	// Drop all lists, and the pools spilled to disk
+	fb.index.reset(fb.layout.PrefixDepth)
+	if fb.tier != nil {
+		fb.tier.reset()
+	}
+	if fb.limit != nil {
+		fb.limit.over, fb.limit.dpBits = 0, 0
+	}
+
+	fb.resetSnapshots(0)
+	fb.clearBlooms()
+}
+
+// ReleaseMemory removes all data from the FastBase and drops every pool
+// page, including recycled ones, so the garbage collector can reclaim them
+func (fb *FastBase) ReleaseMemory() {
+	fb.Clear()
+	for i := range fb.Pools {
+		fb.Pools[i].Pages = nil
+		fb.Pools[i].free = nil
 	}
 }
 
-// AddDataBlock adds a new data block to the FastBase
+// AddDataBlock adds a data block, a 3-byte prefix followed by a record, and
+// returns the stored record, as the C++ RCKangaroo does. Lists stay sorted,
+// as FindDataBlock needs: with pos -1 the record goes where it belongs, and
+// any other pos must be that place, a list index the record sorts between
+// the neighbours of. Unlike AddRecord it neither skips duplicates nor
+// calls hooks, so callers look the record up first.
 func (fb *FastBase) AddDataBlock(data []byte, pos int) ([]byte, error) {
-	if len(data) < 3 {
-		return nil, errors.New("data block must be at least 3 bytes")
+	if len(data) != 3+fb.layout.RecordLength {
+		return nil, recordLengthError(len(data)-3, fb.layout.RecordLength)
 	}
 
 	// Get the list for the 3-byte prefix
-	list := fb.Lists[data[0]][data[1]][data[2]]
-
-	// Allocate memory for the data block
-	ptr, mem, err := fb.Pools[data[0]].allocRecord()
-	if err != nil {
+	prefix := [3]byte{data[0], data[1], data[2]}
+	if skip, err := fb.admit(data[3:]); skip || err != nil {
 		return nil, err
 	}
+	if err := fb.modify(prefix[0]); err != nil {
+		return nil, err
+	}
+	list := fb.index.getOrCreate(prefix, data[3:])
 
-	// Copy data to the allocated memory
-	copy(mem, data[3:])
-
-	// Insert the pointer into the list at the specified position
 	if pos == -1 {
-		pos = int(list.Count)
+		pos = fb.lowerBound(list, prefix, data[3:])
+	} else if pos < 0 || pos > int(list.Count) ||
+		pos > 0 && fb.compareEntry(list.Data[pos-1], prefix, data[3:]) > 0 ||
+		pos < int(list.Count) && fb.compareEntry(list.Data[pos], prefix, data[3:]) < 0 {
+		return nil, fmt.Errorf("position %d would break the order of list [%02x][%02x][%02x]", pos, prefix[0], prefix[1], prefix[2])
 	}
 
-	// Ensure capacity
-	if list.Count >= list.Capacity {
-		grow := uint16(list.Count / 2)
-		if grow < DBMinGrowCount {
-			grow = DBMinGrowCount
-		}
-		newCap := list.Count + grow
-		if newCap > 0xFFFF {
-			return nil, errors.New("list capacity overflow")
-		}
-
-		newData := make([]uint32, newCap)
-		copy(newData, list.Data)
-		list.Data = newData
-		list.Capacity = newCap
+	// Copy the data block into its pool
+	ptr, mem, err := fb.storeRecord(prefix, data[3:])
+	if err != nil {
+		return nil, err
 	}
-
-	// Insert the pointer
-	if pos < int(list.Count) {
-		copy(list.Data[pos+1:], list.Data[pos:list.Count])
+	if err := insertPtr(list, pos, ptr); err != nil {
+		return nil, err
 	}
-	list.Data[pos] = ptr
-	list.Count++
+	fb.track(prefix, ptr, mem)
+	fb.bloomAdd(data[0], mem)
 
-	return mem, nil
+	if err := fb.spillOver(); err != nil {
+		return mem, err
+	}
+	return mem, fb.enforceLimit()
 }
 
 // FindDataBlock searches for a data block in the FastBase
@@ -144,277 +239,527 @@ func (fb *FastBase) FindDataBlock(data []byte) []byte {
 		return nil
 	}
 
-	list := fb.Lists[data[0]][data[1]][data[2]]
-	pos := fb.lowerBound(list, data[0], data[3:])
+	if !fb.bloomMayContain(data[0], data[3:]) {
+		return nil
+	}
 
-	if pos >= int(list.Count) {
+	prefix := [3]byte{data[0], data[1], data[2]}
+	if records, cold := fb.coldList(prefix); cold {
+		n := fb.layout.RecordLength
+		pos := fb.coldLowerBound(records, data[3:])
+		if pos*n >= len(records) || compareKey(records[pos*n:], data[3:], fb.layout.CompareLength) != 0 {
+			return nil
+		}
+		return records[pos*n : (pos+1)*n]
+	}
+	list := fb.index.get(prefix, data[3:])
+	if list == nil {
 		return nil
 	}
+	pos := fb.lowerBound(list, prefix, data[3:])
 
-	ptr := list.Data[pos]
-	mem := fb.Pools[data[0]].GetRecordPtr(ptr)
+	if pos >= int(list.Count) {
+		return nil
+	}
 
 	// Compare the data
-	for i := 0; i < DBFindLength; i++ {
-		if mem[i] != data[i+3] {
-			return nil
-		}
+	ptr := list.Data[pos]
+	if fb.compareEntry(ptr, prefix, data[3:]) != 0 {
+		return nil
 	}
 
-	return mem
+	return fb.Pools[data[0]].GetRecordPtr(ptr)
 }
 
 // SaveToFile saves the FastBase to a file
 func (fb *FastBase) SaveToFile(filename string) error {
-	file, err := os.Create(filename)
+	started := time.Now()
+	snapshotID, err := fb.saveFile(filename, nil)
 	if err != nil {
 		return err
 	}
+
+	fb.commitSnapshot(snapshotID)
+	fb.saved(filename, snapshotID, started)
+	return nil
+}
+
+// saved finishes a save of snapshotID to filename, begun at started
+func (fb *FastBase) saved(filename string, snapshotID uint64, started time.Time) {
+	// The filters are only a cache, checked against the snapshot on load,
+	// so failing to save them doesn't fail the save
+	if fb.blooms != nil {
+		if err := fb.saveBlooms(filename+BloomSuffix, snapshotID); err != nil {
+			fb.logger().Warn("saving bloom filters failed", "file", filename+BloomSuffix, "err", err)
+		}
+	}
+	fb.logger().Info("database saved", "file", filename, snapshotAttr(snapshotID), "elapsed", time.Since(started))
+}
+
+// saveFile writes a snapshot to filename and returns its ID
+func (fb *FastBase) saveFile(filename string, t *progressTracker) (uint64, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
 	defer file.Close()
 
+	w := bufio.NewWriterSize(file, 1<<20)
+	snapshotID, err := fb.writeSnapshot(w, t)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return snapshotID, file.Close()
+}
+
+// writeSnapshot writes the whole database under a new snapshot ID, which
+// the caller passes to commitSnapshot once the copy is safely stored.
+// Progress is reported to t after each pool.
+func (fb *FastBase) writeSnapshot(w io.Writer, t *progressTracker) (uint64, error) {
+	// Stay with the original format unless some list outgrew 16-bit counts,
+	// so the C++ RCKangaroo can read the header and list structure whenever
+	// possible. Its records differ from the standard schema, keeping x[3..11]
+	// and a 22-byte distance, so it misreads the records themselves; see
+	// gpu.DBRecord for the conversion the other way.
+	header := fb.Header
+	fb.layout.putHeader(&header)
+	snapshotID := newSnapshotID()
+	binary.LittleEndian.PutUint64(header[HeaderSnapshot:], snapshotID)
+	header[HeaderVersion] = FormatV1
+	countSize := 2
+	if fb.maxListCount(0, 256) > MaxListSizeV1 {
+		header[HeaderVersion] = FormatV2
+		countSize = 4
+	}
+
+	records := fb.recordCount()
+	fb.logger().Debug("writing snapshot", snapshotAttr(snapshotID), "records", records, "format", header[HeaderVersion])
+	tableOffset := int64(len(header)) + 256*256*256*int64(countSize) + int64(records)*int64(fb.layout.RecordLength)
+	if t != nil {
+		t.total = tableOffset + offsetTableSize
+	}
+
+	// Encrypted files have no offset table, as their lists cannot be
+	// read in place
+	if header[HeaderEncryption] != 0 {
+		enc, err := fb.encryptingWriter(w, &header)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(header[:]); err != nil {
+			return 0, err
+		}
+		if t != nil {
+			t.total = tableOffset
+		}
+		if err := fb.writeLists(enc, 0, 256, countSize, int64(len(header)), 0, nil, t); err != nil {
+			return 0, err
+		}
+		return snapshotID, enc.Close()
+	}
+
 	// Write header
-	if _, err := file.Write(fb.Header[:]); err != nil {
-		return err
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	offsets := make([]uint64, 65536)
+	if err := fb.writeLists(w, 0, 256, countSize, int64(len(header)), 0, offsets, t); err != nil {
+		return 0, err
+	}
+	if err := writeOffsetTable(w, offsets, tableOffset); err != nil {
+		return 0, err
 	}
 
-	// Write lists
-	countBuf := make([]byte, 2)
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				list := fb.Lists[i][j][k]
-				// Write count in little-endian format
-				countBuf[0] = byte(list.Count & 0xFF)
-				countBuf[1] = byte(list.Count >> 8)
-				if _, err := file.Write(countBuf); err != nil {
-					return err
-				}
-
-				// Write data blocks
-				for m := uint16(0); m < list.Count; m++ {
-					ptr := list.Data[m]
-					data := fb.Pools[i].GetRecordPtr(ptr)
-					if _, err := file.Write(data); err != nil {
-						return err
-					}
-				}
+	return snapshotID, nil
+}
+
+// writeLists writes the list section of pools lo to hi-1: in prefix order,
+// whatever the index depth, a count per prefix followed by its records.
+// Prefixes without records get an empty count. written and records count
+// what precedes the section; the running totals are reported to t after
+// each finished pool. If offsets is not nil, the file offset of the count
+// of every prefix [i j 00] is stored in it at i<<8|j.
+func (fb *FastBase) writeLists(w io.Writer, lo, hi, countSize int, written int64, records int, offsets []uint64, t *progressTracker) error {
+	var werr error
+	countBuf := make([]byte, 4)
+	zeros := make([]byte, 256*countSize)
+	next := lo << 16 // Prefix, as a 24-bit number, whose count comes next
+	mark := func() {
+		if offsets != nil && next%256 == 0 {
+			offsets[next>>8] = uint64(written)
+		}
+	}
+	advance := func(n int) {
+		written += int64(n * countSize)
+		next += n
+		if next%(1<<16) == 0 {
+			werr = t.step(written, records)
+		}
+	}
+	skipTo := func(end int) {
+		for next < end && werr == nil {
+			n := min(end-next, 256-next%256)
+			mark()
+			if _, werr = w.Write(zeros[:n*countSize]); werr == nil {
+				advance(n)
 			}
 		}
 	}
 
-	return nil
+	var merged []uint32
+	fb.eachPrefixIn(lo, hi, func(prefix [3]byte, runs [][]uint32) bool {
+		if skipTo(int(prefix[0])<<16 | int(prefix[1])<<8 | int(prefix[2])); werr != nil {
+			return false
+		}
+
+		// At depth 4 a prefix spans lists split by a later byte; merge them
+		// so the file list is sorted as at depth 3
+		pool := &fb.Pools[prefix[0]]
+		ptrs := fb.mergeRuns(prefix, runs, &merged)
+
+		// Write count in little-endian format, then the data blocks
+		mark()
+		binary.LittleEndian.PutUint32(countBuf, uint32(len(ptrs)))
+		if _, werr = w.Write(countBuf[:countSize]); werr != nil {
+			return false
+		}
+		for _, ptr := range ptrs {
+			if _, werr = w.Write(pool.GetRecordPtr(ptr)); werr != nil {
+				return false
+			}
+		}
+		written += int64(len(ptrs)) * int64(fb.layout.RecordLength)
+		records += len(ptrs)
+		advance(1)
+		return werr == nil
+	})
+	if werr == nil {
+		skipTo(hi << 16)
+	}
+	if werr == nil {
+		werr = fb.TierError()
+	}
+	return werr
+
+}
+
+// commitSnapshot makes a written snapshot the base of later delta saves
+func (fb *FastBase) commitSnapshot(snapshotID uint64) {
+	binary.LittleEndian.PutUint64(fb.Header[HeaderSnapshot:], snapshotID)
+	fb.resetSnapshots(snapshotID)
+
+	// The pools that held tracked records may spill now. A pool that fails
+	// to stays in memory, to be tried again as pages grow.
+	if fb.tier != nil {
+		fb.tier.grown = true
+		if err := fb.spillOver(); err != nil {
+			fb.logger().Warn("spilling pools after a save failed", "err", err)
+		}
+	}
 }
 
 // LoadFromFile loads the FastBase from a file
 func (fb *FastBase) LoadFromFile(filename string) error {
-	file, err := os.Open(filename)
+	return fb.LoadFromFileCtx(context.Background(), filename, nil)
+}
+
+// load replaces the contents of the FastBase with a database read from r.
+// size is the length of the input if known, or zero. Progress is reported
+// to t after each pool.
+func (fb *FastBase) load(r io.Reader, size int64, t *progressTracker) error {
+	countSize, err := fb.readHeader(r)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if r, err = fb.decryptingReader(r); err != nil {
+		return err
+	}
+
+	// Read lists
+	if err := fb.readLists(r, countSize, size, &RecoverReport{}, t); err != nil {
+		return err
+	}
+
+	fb.resetSnapshots(binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+
+	return nil
+}
 
+// readHeader clears the FastBase and reads a file header into it, adopting
+// the record layout it describes. It returns the size of list counts.
+func (fb *FastBase) readHeader(r io.Reader) (int, error) {
 	fb.Clear()
 
-	// Read header
-	if _, err := io.ReadFull(file, fb.Header[:]); err != nil {
-		return fmt.Errorf("error reading header: %v", err)
+	if _, err := io.ReadFull(r, fb.Header[:]); err != nil {
+		return 0, fmt.Errorf("error reading header: %v", err)
 	}
 
-	// Read lists
-	countBuf := make([]byte, 2)
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				list := fb.Lists[i][j][k]
-
-				// Read count in little-endian format
-				if _, err := io.ReadFull(file, countBuf); err != nil {
-					if err == io.EOF {
-						return fmt.Errorf("unexpected EOF at position [%d][%d][%d]", i, j, k)
-					}
-					return fmt.Errorf("error reading count at [%d][%d][%d]: %v", i, j, k, err)
-				}
-				count := uint16(countBuf[0]) | uint16(countBuf[1])<<8
-
-				list.Count = count
-				if count > 0 {
-					// Calculate capacity with growth factor
-					grow := uint16(count / 2)
-					if grow < DBMinGrowCount {
-						grow = DBMinGrowCount
-					}
-					newCap := count + grow
-					if newCap > MaxListSize {
-						newCap = MaxListSize
-					}
-
-					// Allocate slice for data pointers
-					list.Data = make([]uint32, count, newCap)
-					list.Capacity = newCap
-
-					// Read each data block
-					dataBuf := make([]byte, DBRecordLength)
-					for m := uint16(0); m < count; m++ {
-						// Allocate memory for the data block
-						ptr, mem, err := fb.Pools[i].allocRecord()
-						if err != nil {
-							return fmt.Errorf("error allocating memory at [%02x][%02x][%02x]: %v", i, j, k, err)
-						}
-
-						// Store the pointer
-						list.Data[m] = ptr
-
-						// Read the data block
-						if _, err := io.ReadFull(file, dataBuf); err != nil {
-							return fmt.Errorf("error reading data block at [%02x][%02x][%02x]: %v", i, j, k, err)
-						}
-						copy(mem, dataBuf)
-					}
-				}
-			}
-		}
+	var countSize int
+	switch fb.Header[HeaderVersion] {
+	case FormatV1:
+		countSize = 2
+	case FormatV2:
+		countSize = 4
+	default:
+		return 0, fmt.Errorf("unsupported file format version %d", fb.Header[HeaderVersion])
+	}
+	if err := fb.checkEncryption(&fb.Header); err != nil {
+		return 0, err
+	}
+	schema, err := SchemaByID(fb.Header[HeaderSchema])
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	layout, err := layoutFromHeader(fb.Header)
+	if err != nil {
+		return 0, err
+	}
+	if layout.RecordLength < schema.RecordLength {
+		return 0, fmt.Errorf("%d-byte records are too short for the %s schema", layout.RecordLength, schema.Name)
+	}
+	fb.setLayout(layout)
+
+	return countSize, nil
 }
 
 // AddRecord adds a record to the FastBase at the specified prefix location if it doesn't already exist
 func (fb *FastBase) AddRecord(i, j, k byte, data []byte) (bool, error) {
-	if len(data) != DBRecordLength {
-		return false, fmt.Errorf("data length must be %d bytes", DBRecordLength)
+	if len(data) != fb.layout.RecordLength {
+		return false, recordLengthError(len(data), fb.layout.RecordLength)
 	}
 
 	// Get the list for the 3-byte prefix
-	list := fb.Lists[i][j][k]
-
-	// Check if record already exists
-	pos := fb.lowerBound(list, i, data)
-	if pos < int(list.Count) {
-		// Get the record at this position and compare
-		existingPtr := list.Data[pos]
-		existingData := fb.Pools[i].GetRecordPtr(existingPtr)
-
-		// Compare x coordinates (first 12 bytes)
-		if bytes.Equal(data[:12], existingData[:12]) {
-			// Same x coordinate, check if types are different
-			if data[31] != existingData[31] {
-				// Print both records in the same format as showRecordsByPrefix
-				fmt.Printf("\nFound records with same x coordinate but different types:\n")
-				fmt.Printf("Record 1: x=%x d=%x type=%s\n",
-					existingData[:12],
-					existingData[12:31],
-					getPointTypeName(existingData[31]))
-				fmt.Printf("Record 2: x=%x d=%x type=%s\n",
-					data[:12],
-					data[12:31],
-					getPointTypeName(data[31]))
-			}
+	prefix := [3]byte{i, j, k}
+	if skip, err := fb.admit(data); skip || err != nil {
+		return false, err
+	}
+	if err := fb.modify(i); err != nil {
+		return false, err
+	}
+	list := fb.index.getOrCreate(prefix, data)
 
-			// Compare the entire record (excluding type field which is at position 31)
-			if bytes.Equal(data[:31], existingData[:31]) {
-				// Record already exists, no need to add it
-				return false, nil
-			}
+	// Check if record already exists, comparing everything but the type
+	if existingData := fb.findDuplicate(list, prefix, data); existingData != nil {
+		if fb.hooks.OnDuplicate != nil {
+			fb.hooks.OnDuplicate(prefix, existingData)
 		}
+		return false, nil
 	}
+	pos := fb.lowerBound(list, prefix, data)
 
-	// Allocate memory for the data block
-	ptr, mem, err := fb.Pools[i].allocRecord()
+	// Look for the other half of a collision before the insert moves things
+	var other, near []byte
+	if fb.hooks.OnCollision != nil {
+		other = fb.partner(fb.Schema(), prefix, data, false)
+	}
+	if fb.hooks.OnNearCollision != nil {
+		near = fb.partner(fb.Schema(), prefix, data, true)
+	}
+
+	// Copy the data into its pool
+	ptr, mem, err := fb.storeRecord(prefix, data)
 	if err != nil {
 		return false, err
 	}
 
-	// Copy the data
-	copy(mem, data)
+	if err := insertPtr(list, pos, ptr); err != nil {
+		return false, err
+	}
+	fb.track(prefix, ptr, mem)
+	fb.bloomAdd(i, mem)
 
-	// If we need to grow the list
-	if list.Count >= list.Capacity {
-		grow := uint16(list.Count / 2)
-		if grow < DBMinGrowCount {
-			grow = DBMinGrowCount
-		}
-		newCap := list.Count + grow
-		if newCap > MaxListSize {
-			newCap = MaxListSize
+	if other != nil {
+		fb.hooks.OnCollision(Collision{Prefix: prefix, First: other, Second: append([]byte(nil), data...)})
+	}
+	if near != nil {
+		fb.hooks.OnNearCollision(Collision{Prefix: prefix, First: near, Second: append([]byte(nil), data...)})
+	}
+
+	if err := fb.spillOver(); err != nil {
+		return true, err
+	}
+	return true, fb.enforceLimit()
+}
+
+// recordCount returns the number of records across all lists
+func (fb *FastBase) recordCount() int {
+	records := 0
+	for i := 0; i < 256; i++ {
+		if fb.isCold(byte(i)) {
+			records += int(fb.tier.segs[i].start[65536])
+			continue
 		}
-		if newCap <= list.Count {
-			return false, fmt.Errorf("list capacity exceeded")
+		fb.eachListIn(byte(i), func(list *ListRecord) {
+			records += int(list.Count)
+		})
+	}
+	return records
+}
+
+// maxListCount returns the record count of the fullest prefix of pools lo
+// to hi-1, which is what a saved file stores as a list count
+func (fb *FastBase) maxListCount(lo, hi int) uint32 {
+	maxCount := 0
+	for i := lo; i < hi; i++ {
+		if fb.isCold(byte(i)) {
+			maxCount = max(maxCount, int(fb.tier.segs[i].maxList))
+			continue
 		}
+		fb.eachPrefixRaw(i, i+1, func(prefix [3]byte, runs [][]uint32) bool {
+			count := 0
+			for _, run := range runs {
+				count += len(run)
+			}
+			maxCount = max(maxCount, count)
+			return true
+		})
+	}
+	return uint32(min(maxCount, int(MaxListSize)))
+}
 
-		// Create new slice with increased capacity
-		newData := make([]uint32, list.Count, newCap)
-		copy(newData, list.Data)
-		list.Data = newData
-		list.Capacity = newCap
+// setLayout sets the size of the records the pool hands out, of the index
+// tag kept after each and of its pages. The pool must be empty; recycled
+// pages of another size are dropped.
+func (mp *MemPool) setLayout(l Layout) {
+	if uint32(l.PageSize) != mp.pageSize {
+		mp.free = nil
 	}
+	mp.recordLength = uint32(l.RecordLength)
+	mp.tagLength = uint32(l.tagLength())
+	mp.pageSize = uint32(l.PageSize)
+	mp.perPage = mp.pageSize / (mp.recordLength + mp.tagLength)
+}
 
-	// Insert the pointer at the correct position to maintain order
-	list.Data = append(list.Data, 0) // Make space for the new element
-	if pos < int(list.Count) {
-		// Shift elements to make room for the new one
-		copy(list.Data[pos+1:], list.Data[pos:list.Count])
+// size returns the record length of the pool
+func (mp *MemPool) size() uint32 {
+	if mp.recordLength == 0 {
+		mp.setLayout(DefaultLayout)
 	}
-	list.Data[pos] = ptr
-	list.Count++
+	return mp.recordLength
+}
 
-	return true, nil
+// stride returns the bytes a record takes in a page, tag included
+func (mp *MemPool) stride() uint32 {
+	return mp.size() + mp.tagLength
 }
 
-// getPointTypeName returns a string representation of the point type
-func getPointTypeName(pointType byte) string {
-	switch pointType {
-	case 0:
-		return "tame"
-	case 1:
-		return "wild1"
-	case 2:
-		return "wild2"
-	default:
-		return fmt.Sprintf("unknown(%d)", pointType)
-	}
+// MaxPages returns the most pages the pool may hold, as record pointers
+// count records across pages in 32 bits
+func (mp *MemPool) MaxPages() int {
+	mp.size()
+	return int(1 << 32 / uint64(mp.perPage))
 }
 
 // allocRecord allocates a new record in the memory pool
 func (mp *MemPool) allocRecord() (uint32, []byte, error) {
-	if len(mp.Pages) == 0 || mp.Ptr+DBRecordLength > MemPageSize {
-		if len(mp.Pages) >= MaxPageCount {
-			return 0, nil, errors.New("memory pool overflow")
+	size, stride := mp.size(), mp.stride()
+	if len(mp.Pages) == 0 || mp.Ptr+stride > mp.pageSize {
+		if len(mp.Pages) >= mp.MaxPages() {
+			return 0, nil, fmt.Errorf("%w: %d pages, the most 32-bit record pointers reach", ErrPoolOverflow, len(mp.Pages))
 		}
-		mp.Pages = append(mp.Pages, make([]byte, MemPageSize))
+		mp.Pages = append(mp.Pages, mp.newPage())
 		mp.Ptr = 0
 	}
 
 	pageIndex := len(mp.Pages) - 1
-	mem := mp.Pages[pageIndex][mp.Ptr : mp.Ptr+DBRecordLength]
-	ptr := uint32(pageIndex*RecordsPerPage) | uint32(mp.Ptr/DBRecordLength)
-	mp.Ptr += DBRecordLength
+	mem := mp.Pages[pageIndex][mp.Ptr : mp.Ptr+size]
+	ptr := uint32(pageIndex)*mp.perPage + mp.Ptr/stride
+	mp.Ptr += stride
 
 	return ptr, mem, nil
 }
 
+// newPage returns a zeroed page, taken from the freelist when possible
+func (mp *MemPool) newPage() []byte {
+	if n := len(mp.free); n > 0 {
+		page := mp.free[n-1]
+		mp.free[n-1] = nil
+		mp.free = mp.free[:n-1]
+		clear(page)
+		return page
+	}
+	return make([]byte, mp.pageSize)
+}
+
+// recycle moves all pages to the freelist and resets the pool
+func (mp *MemPool) recycle() {
+	mp.free = append(mp.free, mp.Pages...)
+	clear(mp.Pages)
+	mp.Pages = mp.Pages[:0]
+	mp.Ptr = 0
+}
+
 // GetRecordPtr returns a pointer to the record data for a given pointer value
 func (mp *MemPool) GetRecordPtr(ptr uint32) []byte {
-	pageIndex := ptr / uint32(RecordsPerPage)
-	offset := (ptr % uint32(RecordsPerPage)) * DBRecordLength
-	return mp.Pages[pageIndex][offset : offset+DBRecordLength]
+	size := mp.size()
+	pageIndex := ptr / mp.perPage
+	offset := (ptr % mp.perPage) * (size + mp.tagLength)
+	return mp.Pages[pageIndex][offset : offset+size]
 }
 
-// lowerBound performs a binary search to find the insertion point for a data block
-func (fb *FastBase) lowerBound(list *ListRecord, poolIndex byte, data []byte) int {
-	left, right := 0, int(list.Count)
+// tag returns the index tag of a record, or zero if the pool keeps none
+func (mp *MemPool) tag(ptr uint32) byte {
+	if mp.tagLength == 0 {
+		return 0
+	}
+	offset := (ptr%mp.perPage)*(mp.recordLength+mp.tagLength) + mp.recordLength
+	return mp.Pages[ptr/mp.perPage][offset]
+}
 
-	for left < right {
-		mid := (left + right) / 2
-		ptr := list.Data[mid]
-		mem := fb.Pools[poolIndex].GetRecordPtr(ptr)
+// setTag sets the index tag of a record if the pool keeps tags
+func (mp *MemPool) setTag(ptr uint32, t byte) {
+	if mp.tagLength == 0 {
+		return
+	}
+	offset := (ptr%mp.perPage)*(mp.recordLength+mp.tagLength) + mp.recordLength
+	mp.Pages[ptr/mp.perPage][offset] = t
+}
 
-		// Compare data
-		cmp := 0
-		for i := 0; i < DBFindLength && cmp == 0; i++ {
-			cmp = int(mem[i]) - int(data[i])
+// insertPtr inserts a record pointer into a list at pos, growing the list
+// if it is full
+func insertPtr(list *ListRecord, pos int, ptr uint32) error {
+	if list.Count >= list.Capacity {
+		grow := list.Count / 2
+		if grow < DBMinGrowCount {
+			grow = DBMinGrowCount
 		}
+		newCap := list.Count + grow
+		if uint64(list.Count)+uint64(grow) > uint64(MaxListSize) {
+			newCap = MaxListSize
+		}
+		if newCap <= list.Count {
+			return ErrListFull
+		}
+
+		// Create new slice with increased capacity
+		newData := make([]uint32, list.Count, newCap)
+		copy(newData, list.Data)
+		list.Data = newData
+		list.Capacity = newCap
+	}
+
+	// Shift the pointers after pos to make room for the new one
+	list.Data = append(list.Data, 0)
+	if pos < int(list.Count) {
+		copy(list.Data[pos+1:], list.Data[pos:list.Count])
+	}
+	list.Data[pos] = ptr
+	list.Count++
+	return nil
+}
+
+// lowerBound performs a binary search to find the insertion point for a
+// data block filed under prefix
+func (fb *FastBase) lowerBound(list *ListRecord, prefix [3]byte, data []byte) int {
+	left, right := 0, int(list.Count)
 
-		if cmp < 0 {
+	for left < right {
+		mid := (left + right) / 2
+		if fb.compareEntry(list.Data[mid], prefix, data) < 0 {
 			left = mid + 1
 		} else {
 			right = mid
@@ -423,3 +768,33 @@ func (fb *FastBase) lowerBound(list *ListRecord, poolIndex byte, data []byte) in
 
 	return left
 }
+
+// compareKey compares the first n bytes of a and b, returning -1, 0 or +1.
+// It works on 8-byte big-endian words, which orders the same way as a
+// byte-by-byte comparison, and finishes the remainder bytewise.
+func compareKey(a, b []byte, n int) int {
+	a, b = a[:n], b[:n]
+
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		x := binary.BigEndian.Uint64(a[i:])
+		y := binary.BigEndian.Uint64(b[i:])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	for ; i < n; i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
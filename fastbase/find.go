@@ -0,0 +1,161 @@
+package fastbase
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Match is a record found by a lookup, together with the list it lives in
+type Match struct {
+	Prefix [3]byte // Prefix of the list holding the record
+	Record []byte  // The record itself
+}
+
+// FindX returns every record whose truncated x-coordinate equals x. Records
+// are filed under the first three bytes of x, so only that list is searched.
+func (fb *FastBase) FindX(x []byte) ([]Match, error) {
+	if n := fb.Schema().XLength; len(x) != n {
+		return nil, fmt.Errorf("x-coordinate must be %d bytes", n)
+	}
+	return fb.findXInList([3]byte{x[0], x[1], x[2]}, x), nil
+}
+
+// ScanX is like FindX but searches every list, for databases whose records
+// are not filed under their own x prefix
+func (fb *FastBase) ScanX(x []byte) ([]Match, error) {
+	if n := fb.Schema().XLength; len(x) != n {
+		return nil, fmt.Errorf("x-coordinate must be %d bytes", n)
+	}
+
+	var matches []Match
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		matches = append(matches, fb.findXInList(prefix, x)...)
+		return true
+	})
+	return matches, nil
+}
+
+// findXInList returns the run of records filed under prefix starting with
+// x, which may be any leading bytes of a record up to the compare length
+func (fb *FastBase) findXInList(prefix [3]byte, x []byte) []Match {
+	key := make([]byte, fb.layout.RecordLength)
+	copy(key, x)
+
+	if records, cold := fb.coldList(prefix); cold {
+		var matches []Match
+		n := fb.layout.RecordLength
+		for off := fb.coldLowerBound(records, key) * n; off < len(records); off += n {
+			if !bytes.Equal(records[off:off+len(x)], x) {
+				break
+			}
+			matches = append(matches, Match{Prefix: prefix, Record: records[off : off+n]})
+		}
+		return matches
+	}
+
+	list := fb.index.get(prefix, key)
+	if list == nil {
+		return nil
+	}
+
+	pool := &fb.Pools[prefix[0]]
+	var matches []Match
+	for pos := fb.lowerBound(list, prefix, key); pos < int(list.Count); pos++ {
+		mem := pool.GetRecordPtr(list.Data[pos])
+		if !bytes.Equal(mem[:len(x)], x) || !fb.filedUnder(list.Data[pos], prefix) {
+			break
+		}
+		matches = append(matches, Match{Prefix: prefix, Record: mem})
+	}
+	return matches
+}
+
+// FindAll returns every record matching data, laid out as for
+// FindDataBlock: a 3-byte prefix followed by at least the x-coordinate of
+// a record. FindDataBlock returns the first record whose compare length of
+// bytes matches, while FindAll returns all records whose leading bytes
+// match those given, up to the compare length. A full key finds every
+// record sharing it, and an x-coordinate alone finds every record of that
+// x, such as a tame and a wild record that collide. Records are returned
+// in list order and, like FindDataBlock's, are only valid until the next
+// insert.
+func (fb *FastBase) FindAll(data []byte) [][]byte {
+	if len(data) < 3+fb.Schema().XLength {
+		return nil
+	}
+	key := data[3:min(len(data), 3+fb.layout.CompareLength)]
+	if len(key) == fb.layout.CompareLength && !fb.bloomMayContain(data[0], key) {
+		return nil
+	}
+
+	matches := fb.findXInList([3]byte{data[0], data[1], data[2]}, key)
+	if len(matches) == 0 {
+		return nil
+	}
+	records := make([][]byte, len(matches))
+	for i, m := range matches {
+		records[i] = m.Record
+	}
+	return records
+}
+
+// FindMany looks up many keys at once. Each key is laid out as for
+// FindDataBlock: a 3-byte prefix followed by at least the compare length
+// of record bytes. The result holds the matching record for each key, in
+// the order given, or nil where there is none. Queries are sorted so each
+// list is visited once and searched only past the previous hit, which
+// keeps lookups in cache for large batches.
+func (fb *FastBase) FindMany(keys [][]byte) [][]byte {
+	results := make([][]byte, len(keys))
+	cmp := fb.layout.CompareLength
+
+	order := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if len(key) >= 3+cmp {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return bytes.Compare(keys[order[a]][:3+cmp], keys[order[b]][:3+cmp]) < 0
+	})
+
+	var list *ListRecord
+	lo := 0
+	for _, idx := range order {
+		prefix := [3]byte{keys[idx][0], keys[idx][1], keys[idx][2]}
+		key := keys[idx][3:]
+
+		if records, cold := fb.coldList(prefix); cold {
+			n := fb.layout.RecordLength
+			if pos := fb.coldLowerBound(records, key); pos*n < len(records) && compareKey(records[pos*n:], key, cmp) == 0 {
+				results[idx] = records[pos*n : (pos+1)*n]
+			}
+			list = nil
+			continue
+		}
+
+		// Keys only grow, so within a list the search can start where the
+		// last one ended
+		if l := fb.index.get(prefix, key); l != list {
+			list, lo = l, 0
+		}
+		if list == nil || !fb.bloomMayContain(prefix[0], key) {
+			continue
+		}
+
+		hi := int(list.Count)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if fb.compareEntry(list.Data[mid], prefix, key) < 0 {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		if lo < int(list.Count) && fb.compareEntry(list.Data[lo], prefix, key) == 0 {
+			results[idx] = fb.Pools[prefix[0]].GetRecordPtr(list.Data[lo])
+		}
+	}
+	return results
+}
@@ -0,0 +1,28 @@
+package fastbase
+
+import "math"
+
+// EstimateMemory returns roughly how many bytes a FastBase in layout l
+// takes to hold the given number of records with random prefixes, as
+// after a load: the records in their pools, the list pointers with the
+// room lists are given to grow, and the index entries of the lists that
+// are not empty. Go runtime overhead is not included.
+func (l Layout) EstimateMemory(records float64) float64 {
+	if records <= 0 {
+		return 0
+	}
+
+	// Lists the records spread over, and how many of them they occupy
+	lists := math.Pow(256, float64(l.PrefixDepth))
+	occupied := lists * -math.Expm1(-records/lists)
+	perList := records / occupied
+
+	pools := records * float64(l.RecordLength+l.tagLength())
+	pointers := occupied * (perList + math.Max(perList/2, DBMinGrowCount)) * 4
+	listRecords := occupied * (32 + 8) // ListRecord and its index slot
+
+	// Index tables, one per leading two key bytes that holds a list
+	tables := 65536 * -math.Expm1(-records/65536) * math.Pow(256, float64(l.PrefixDepth-2)) * 8
+
+	return pools + pointers + listRecords + tables
+}
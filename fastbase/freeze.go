@@ -0,0 +1,72 @@
+package fastbase
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Frozen is a copy of a FastBase taken by Freeze, to save in full with any
+// of its save methods while the original takes more records
+type Frozen struct {
+	*FastBase
+
+	src    *FastBase
+	resets uint64 // Resets of the source's tracked records at the freeze
+	added  int    // Records the source had tracked at the freeze
+}
+
+// Freeze copies the FastBase, as Clone does, for a save that must not
+// hold up inserts. The caller holds its lock on the FastBase over the
+// freeze, which only copies memory, and over Commit, but not over the
+// save of the copy in between, which writes the whole database. The copy
+// takes as much memory as the records in memory and spilled pools, and is
+// taken whole rather than pool by pool as they change, so inserts wait for
+// it: about a second for every 200 MiB MemoryUsage counts.
+func (fb *FastBase) Freeze() (*Frozen, error) {
+	started := time.Now()
+	c, err := fb.Clone()
+	if err != nil {
+		return nil, err
+	}
+	fb.logger().Debug("database frozen", "records", c.recordCount(), "elapsed", time.Since(started))
+	return &Frozen{FastBase: c, src: fb, resets: fb.resets, added: len(fb.added)}, nil
+}
+
+// Commit makes the full save of the frozen copy the last full save of the
+// FastBase it was taken from, once that save is safely stored, as if the
+// original had been saved at the freeze: later deltas build on it, and only
+// the records added since the freeze remain tracked, along with deltas
+// saved since. The journal of the original, if open, is checkpointed, so
+// the save must have replaced the file the journal extends.
+func (f *Frozen) Commit() error {
+	fb := f.src
+	id := binary.LittleEndian.Uint64(f.Header[HeaderSnapshot:])
+	if id == binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]) {
+		return errors.New("the frozen copy was not saved in full")
+	}
+	if fb.resets != f.resets {
+		return errors.New("the database was saved, loaded or compacted since it was frozen")
+	}
+
+	binary.LittleEndian.PutUint64(fb.Header[HeaderSnapshot:], id)
+	fb.added = append([]recordRef(nil), fb.added[f.added:]...)
+	snapshots := []snapshot{{id: id}}
+	for _, s := range fb.snapshots {
+		if s.added > f.added {
+			snapshots = append(snapshots, snapshot{id: s.id, added: s.added - f.added})
+		}
+	}
+	fb.snapshots = snapshots
+	fb.resets++
+
+	// As after a save of the original, pools that held records tracked at
+	// the freeze may spill
+	if fb.tier != nil {
+		fb.tier.grown = true
+		if err := fb.spillOver(); err != nil {
+			fb.logger().Warn("spilling pools after a save failed", "err", err)
+		}
+	}
+	return fb.CheckpointJournal()
+}
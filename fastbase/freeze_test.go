@@ -0,0 +1,77 @@
+package fastbase
+
+import "testing"
+
+// journalRecords returns the base snapshot of the journal at filename and
+// how many records it holds
+func journalRecords(t *testing.T, filename string) (uint64, int) {
+	t.Helper()
+	info, _, err := TailJournal(filename, 0, func([3]byte, []byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.BaseID, info.Records
+}
+
+func TestFreezeSaveCommit(t *testing.T) {
+	path := savedTestDB(t, 10)
+	journal := path + JournalSuffix
+	fb := loadTestDB(t, path)
+	first := fb.SnapshotID()
+	if _, err := fb.OpenJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	defer fb.CloseJournal()
+	addTestRecords(t, fb, 10, 20)
+
+	frozen, err := fb.Freeze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, fb, 20, 30)
+	if err := frozen.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until the commit the journal still extends the first save, as a crash
+	// here could leave a file older than the frozen snapshot
+	if err := fb.FlushJournal(false); err != nil {
+		t.Fatal(err)
+	}
+	if base, n := journalRecords(t, journal); base != first || n != 20 {
+		t.Errorf("before the commit the journal extends %016x with %d records; want %016x with 20", base, n, first)
+	}
+
+	if err := frozen.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	saved := loadTestDB(t, path)
+	if base, n := journalRecords(t, journal); base != saved.SnapshotID() || n != 10 {
+		t.Errorf("after the commit the journal extends %016x with %d records; want the frozen save %016x with 10",
+			base, n, saved.SnapshotID())
+	}
+	if !hasTestRecords(t, saved, 0, 20) || hasTestRecords(t, saved, 20, 21) {
+		t.Error("the saved file does not hold exactly the records frozen")
+	}
+	if n := fb.Unsaved(); n != 10 {
+		t.Errorf("%d records tracked after the commit; want the 10 added while frozen", n)
+	}
+
+	// Reloading the file and replaying the journal gives back everything
+	addTestRecords(t, fb, 30, 35)
+	if err := fb.CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+	fb = loadTestDB(t, path)
+	info, err := fb.OpenJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.CloseJournal()
+	if info.Records != 15 || info.Recovered != 15 || info.Stale() {
+		t.Errorf("replayed %d records, recovering %d, stale %v; want 15, 15, false", info.Records, info.Recovered, info.Stale())
+	}
+	if !hasTestRecords(t, fb, 0, 35) {
+		t.Error("reloaded database lacks records frozen or added while frozen")
+	}
+}
@@ -0,0 +1,48 @@
+package fastbase
+
+import "bytes"
+
+// Hooks receives events from inserts into a FastBase. Nil hooks are
+// skipped, so embedders only pay for the events they ask for.
+type Hooks struct {
+	// OnCollision is called after AddRecord inserts a record sharing its
+	// x-coordinate with a record of another type, the ingredient of a
+	// solved key. Both records are copies the hook may keep.
+	OnCollision func(c Collision)
+
+	// OnNearCollision is called after an insert adds a record sharing its
+	// x-coordinate and kangaroo type with a record at another distance:
+	// two kangaroos of one herd reached the same point class. Both records
+	// are copies the hook may keep.
+	OnNearCollision func(c Collision)
+
+	// OnDuplicate is called when AddRecord skips a record already present.
+	// rec is only valid during the call.
+	OnDuplicate func(prefix [3]byte, rec []byte)
+}
+
+// SetHooks replaces the event hooks of the FastBase
+func (fb *FastBase) SetHooks(h Hooks) {
+	fb.hooks = h
+}
+
+// partner returns a copy of a record filed under prefix that makes a
+// collision with rec, or with near a near collision, or nil if there is none
+func (fb *FastBase) partner(schema Schema, prefix [3]byte, rec []byte, near bool) []byte {
+	for _, m := range fb.findXInList(prefix, schema.X(rec)) {
+		if pairs(schema, m.Record, rec, near) {
+			return append([]byte(nil), m.Record...)
+		}
+	}
+	return nil
+}
+
+// pairs reports whether two records on one x-coordinate make a collision,
+// having different kangaroo types, or with near a near collision, having
+// the same type at different distances
+func pairs(schema Schema, a, b []byte, near bool) bool {
+	if schema.Type(a) != schema.Type(b) {
+		return !near
+	}
+	return near && !bytes.Equal(schema.Distance(a), schema.Distance(b))
+}
@@ -0,0 +1,134 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ImportResult summarizes a text import
+type ImportResult struct {
+	Lines      int // Non-empty, non-comment lines read
+	Added      int // Records inserted
+	Duplicates int // Records already present
+}
+
+// ImportText reads records from a text dump and inserts the new ones. Each
+// line holds hex fields separated by commas or whitespace, either
+// "x distance type" (the prefix is then the first three bytes of x) or
+// "prefix x distance type" as written by ExportTo in CSV form. NDJSON lines
+// from ExportTo are accepted too. The type may be numeric or a name such as
+// "tame". Empty lines, '#' comments and a CSV header are skipped.
+func (fb *FastBase) ImportText(r io.Reader) (ImportResult, error) {
+	var res ImportResult
+	schema := fb.Schema()
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "prefix,") {
+			continue
+		}
+		res.Lines++
+
+		prefix, rec, err := ParseTextRecord(schema, line)
+		if err != nil {
+			return res, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+
+		added, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], fb.Widen(rec))
+		if err != nil {
+			return res, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if added {
+			res.Added++
+		} else {
+			res.Duplicates++
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+// ParseTextRecord decodes one line of a text dump, in any of the forms
+// ImportText accepts, into a prefix and record
+func ParseTextRecord(schema Schema, line string) ([3]byte, []byte, error) {
+	var prefix [3]byte
+	var fields []string
+
+	if strings.HasPrefix(line, "{") {
+		var obj struct {
+			Prefix   string          `json:"prefix"`
+			X        string          `json:"x"`
+			Distance string          `json:"distance"`
+			Type     json.RawMessage `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return prefix, nil, err
+		}
+		fields = []string{obj.X, obj.Distance, strings.Trim(string(obj.Type), `"`)}
+		if obj.Prefix != "" {
+			fields = append([]string{obj.Prefix}, fields...)
+		}
+	} else {
+		fields = strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == ';'
+		})
+	}
+
+	if len(fields) != 3 && len(fields) != 4 {
+		return prefix, nil, fmt.Errorf("expected 3 or 4 fields, got %d", len(fields))
+	}
+
+	if len(fields) == 4 {
+		p, err := hex.DecodeString(fields[0])
+		if err != nil || len(p) != 3 {
+			return prefix, nil, fmt.Errorf("invalid prefix %q", fields[0])
+		}
+		copy(prefix[:], p)
+		fields = fields[1:]
+	}
+
+	x, err := hex.DecodeString(strings.TrimPrefix(fields[0], "0x"))
+	if err != nil || len(x) != schema.XLength {
+		return prefix, nil, fmt.Errorf("x must be %d hex bytes, got %q", schema.XLength, fields[0])
+	}
+	d, err := hex.DecodeString(strings.TrimPrefix(fields[1], "0x"))
+	if err != nil || len(d) != schema.DistanceLength {
+		return prefix, nil, fmt.Errorf("distance must be %d hex bytes, got %q", schema.DistanceLength, fields[1])
+	}
+	typ, err := parseTypeField(fields[2])
+	if err != nil {
+		return prefix, nil, err
+	}
+
+	if len(fields) == 3 {
+		copy(prefix[:], x)
+	}
+
+	rec := make([]byte, schema.RecordLength)
+	copy(rec, x)
+	copy(rec[schema.XLength:], d)
+	rec[len(rec)-1] = typ
+	return prefix, rec, nil
+}
+
+// parseTypeField accepts a numeric kangaroo type or its name
+func parseTypeField(field string) (byte, error) {
+	for t := TypeTame; t <= TypeWild2; t++ {
+		if strings.EqualFold(field, t.String()) {
+			return byte(t), nil
+		}
+	}
+	v, err := strconv.ParseUint(field, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid type %q", field)
+	}
+	return byte(v), nil
+}
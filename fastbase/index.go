@@ -0,0 +1,327 @@
+package fastbase
+
+import "sort"
+
+// splitByte is the record byte that picks among the lists of a prefix at
+// prefix depth 4. For records carrying their own x-coordinate, as filed by
+// AddRecords, it is the fourth byte of x.
+const splitByte = 3
+
+// listIndex maps the key of a record, the 3-byte prefix it is filed under
+// followed by its own bytes, to the list holding it. The prefix depth says
+// how many leading key bytes select a list:
+//
+//   - 2: one list per two-byte prefix. Records keep the third prefix byte
+//     as a tag in their pool and lists sort by tag first.
+//   - 3: one list per prefix, the layout of the C++ RCKangaroo.
+//   - 4: one list per prefix and value of the record's splitByte.
+//
+// Lists are created on first use, in tables of 256^(depth-2) lists per
+// leading two key bytes, so a sparse database only pays for what it holds.
+type listIndex struct {
+	depth  int
+	tables [65536][]*ListRecord
+}
+
+// slot returns the table and position within it of the list for a record
+func (x *listIndex) slot(prefix [3]byte, rec []byte) (int, int) {
+	top := int(prefix[0])<<8 | int(prefix[1])
+	switch x.depth {
+	case 2:
+		return top, 0
+	case 4:
+		return top, int(prefix[2])<<8 | int(rec[splitByte])
+	default:
+		return top, int(prefix[2])
+	}
+}
+
+// get returns the list for a record, or nil if it was never created
+func (x *listIndex) get(prefix [3]byte, rec []byte) *ListRecord {
+	top, pos := x.slot(prefix, rec)
+	if x.tables[top] == nil {
+		return nil
+	}
+	return x.tables[top][pos]
+}
+
+// List returns the list the records filed under prefix are kept in, for
+// code written against the Lists array FastBase once exported, or nil if
+// no record was ever filed there. Its Data points into Pools[prefix[0]],
+// which is loaded first if it was spilled. Only prefix depth 3, the layout
+// of the C++ RCKangaroo, keeps a list per prefix: at depth 2 lists are
+// shared by 256 prefixes and at depth 4 a prefix spans up to 256, so List
+// returns nil; ListRecords and ForEach work at every depth.
+func (fb *FastBase) List(prefix [3]byte) *ListRecord {
+	if fb.index.depth != 3 {
+		return nil
+	}
+	fb.touch(prefix[0])
+	return fb.index.get(prefix, nil)
+}
+
+// getOrCreate returns the list for a record, creating it if needed
+func (x *listIndex) getOrCreate(prefix [3]byte, rec []byte) *ListRecord {
+	top, pos := x.slot(prefix, rec)
+	if x.tables[top] == nil {
+		x.tables[top] = make([]*ListRecord, 1<<(8*(x.depth-2)))
+	}
+	list := x.tables[top][pos]
+	if list == nil {
+		list = &ListRecord{}
+		x.tables[top][pos] = list
+	}
+	return list
+}
+
+// reset drops every list
+func (x *listIndex) reset(depth int) {
+	x.depth = depth
+	clear(x.tables[:])
+}
+
+// eachList calls fn for every non-empty list in key order, with the pool
+// holding its records
+func (fb *FastBase) eachList(fn func(pool byte, list *ListRecord)) {
+	for i := 0; i < 256; i++ {
+		fb.eachListIn(byte(i), func(list *ListRecord) { fn(byte(i), list) })
+	}
+}
+
+// eachListIn calls fn for every non-empty list whose records live in pool
+// i, in key order, loading the pool if it was spilled
+func (fb *FastBase) eachListIn(i byte, fn func(list *ListRecord)) {
+	fb.touch(i)
+	for top := int(i) << 8; top < int(i)<<8+256; top++ {
+		for _, list := range fb.index.tables[top] {
+			if list != nil && list.Count > 0 {
+				fn(list)
+			}
+		}
+	}
+}
+
+// eachPrefix calls fn for every prefix holding records, in prefix order,
+// with the pointers of its records as runs sorted by key. Depth 4 gives a
+// run per list the prefix spans; other depths a single run. The runs are
+// only valid during the call. Iteration stops when fn returns false.
+func (fb *FastBase) eachPrefix(fn func(prefix [3]byte, runs [][]uint32) bool) {
+	fb.eachPrefixIn(0, 256, fn)
+}
+
+// eachPrefixIn is eachPrefix for the prefixes of pools lo to hi-1. Spilled
+// pools are loaded in turn, and the budget enforced after each, so the
+// record slices of a tiered FastBase are only valid during the call.
+func (fb *FastBase) eachPrefixIn(lo, hi int, fn func(prefix [3]byte, runs [][]uint32) bool) {
+	if fb.tier == nil {
+		fb.eachPrefixRaw(lo, hi, fn)
+		return
+	}
+	for i := lo; i < hi; i++ {
+		if fb.touch(byte(i)) != nil {
+			continue
+		}
+		more := true
+		fb.eachPrefixRaw(i, i+1, func(prefix [3]byte, runs [][]uint32) bool {
+			more = fn(prefix, runs)
+			return more
+		})
+		if !more {
+			return
+		}
+		if err := fb.spillOver(); err != nil && fb.tier.err == nil {
+			fb.tier.err = err
+		}
+	}
+}
+
+// eachPrefixRaw is eachPrefixIn for pools in memory, leaving spilled ones
+// out
+func (fb *FastBase) eachPrefixRaw(lo, hi int, fn func(prefix [3]byte, runs [][]uint32) bool) {
+	var runs [][]uint32
+	for top := lo << 8; top < hi<<8; top++ {
+		table := fb.index.tables[top]
+		if table == nil {
+			continue
+		}
+		i, j := byte(top>>8), byte(top)
+		pool := &fb.Pools[i]
+
+		switch fb.index.depth {
+		case 2:
+			list := table[0]
+			if list == nil {
+				continue
+			}
+			data := list.Data[:list.Count]
+			for lo := 0; lo < len(data); {
+				k := pool.tag(data[lo])
+				hi := lo + 1
+				for hi < len(data) && pool.tag(data[hi]) == k {
+					hi++
+				}
+				if !fn([3]byte{i, j, k}, append(runs[:0], data[lo:hi])) {
+					return
+				}
+				lo = hi
+			}
+		case 4:
+			for k := 0; k < 256; k++ {
+				runs = runs[:0]
+				for _, list := range table[k<<8 : (k+1)<<8] {
+					if list != nil && list.Count > 0 {
+						runs = append(runs, list.Data[:list.Count])
+					}
+				}
+				if len(runs) > 0 && !fn([3]byte{i, j, byte(k)}, runs) {
+					return
+				}
+			}
+		default:
+			for k, list := range table {
+				if list != nil && list.Count > 0 && !fn([3]byte{i, j, byte(k)}, append(runs[:0], list.Data[:list.Count])) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// prefixRuns returns the pointers of the records filed under prefix as
+// runs sorted by key, as eachPrefix passes them
+func (fb *FastBase) prefixRuns(prefix [3]byte) [][]uint32 {
+	fb.touch(prefix[0])
+	table := fb.index.tables[int(prefix[0])<<8|int(prefix[1])]
+	if table == nil {
+		return nil
+	}
+
+	var runs [][]uint32
+	switch fb.index.depth {
+	case 2:
+		list := table[0]
+		if list == nil {
+			return nil
+		}
+		pool := &fb.Pools[prefix[0]]
+		data := list.Data[:list.Count]
+		lo, hi := 0, len(data)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if pool.tag(data[mid]) < prefix[2] {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		end := lo
+		for end < len(data) && pool.tag(data[end]) == prefix[2] {
+			end++
+		}
+		if end > lo {
+			runs = append(runs, data[lo:end])
+		}
+	case 4:
+		k := int(prefix[2])
+		for _, list := range table[k<<8 : (k+1)<<8] {
+			if list != nil && list.Count > 0 {
+				runs = append(runs, list.Data[:list.Count])
+			}
+		}
+	default:
+		if list := table[prefix[2]]; list != nil && list.Count > 0 {
+			runs = append(runs, list.Data[:list.Count])
+		}
+	}
+	return runs
+}
+
+// prefixCount returns the number of records filed under prefix
+func (fb *FastBase) prefixCount(prefix [3]byte) int {
+	n := 0
+	for _, run := range fb.prefixRuns(prefix) {
+		n += len(run)
+	}
+	return n
+}
+
+// compareEntry orders the record at ptr in the pool of prefix against rec
+// filed under prefix, by tag first at depth 2 and then by key
+func (fb *FastBase) compareEntry(ptr uint32, prefix [3]byte, rec []byte) int {
+	pool := &fb.Pools[prefix[0]]
+	if fb.index.depth == 2 {
+		if t := pool.tag(ptr); t != prefix[2] {
+			if t < prefix[2] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareKey(pool.GetRecordPtr(ptr), rec, fb.layout.CompareLength)
+}
+
+// filedUnder reports whether the record at ptr in the pool of prefix is
+// filed under prefix, which only depth 2 lists can get wrong
+func (fb *FastBase) filedUnder(ptr uint32, prefix [3]byte) bool {
+	return fb.index.depth != 2 || fb.Pools[prefix[0]].tag(ptr) == prefix[2]
+}
+
+// compareEntries orders two records of the same pool as their list does
+func (fb *FastBase) compareEntries(pool *MemPool, a, b uint32) int {
+	if fb.index.depth == 2 {
+		if ta, tb := pool.tag(a), pool.tag(b); ta != tb {
+			if ta < tb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareKey(pool.GetRecordPtr(a), pool.GetRecordPtr(b), fb.layout.CompareLength)
+}
+
+// storeRecord copies rec filed under prefix into its pool
+func (fb *FastBase) storeRecord(prefix [3]byte, rec []byte) (uint32, []byte, error) {
+	pool := &fb.Pools[prefix[0]]
+	pages := len(pool.Pages)
+	ptr, mem, err := pool.allocRecord()
+	if err != nil {
+		return 0, nil, err
+	}
+	if fb.tier != nil && len(pool.Pages) > pages {
+		fb.tier.grown = true
+	}
+	if fb.limit != nil {
+		fb.limit.stored++
+		fb.limit.grown = fb.limit.grown || len(pool.Pages) > pages
+	}
+	copy(mem, rec)
+	pool.setTag(ptr, prefix[2])
+	return ptr, mem, nil
+}
+
+// mergeRuns returns the pointers of runs from the pool of prefix as one run
+// sorted by key. Runs that must be merged are merged into *buf, which may be
+// nil for a one-off call. Equal keys share a list, so there are no ties.
+func (fb *FastBase) mergeRuns(prefix [3]byte, runs [][]uint32, buf *[]uint32) []uint32 {
+	switch len(runs) {
+	case 0:
+		return nil
+	case 1:
+		return runs[0]
+	}
+	var merged []uint32
+	if buf != nil {
+		merged = (*buf)[:0]
+	}
+	for _, run := range runs {
+		merged = append(merged, run...)
+	}
+	pool := &fb.Pools[prefix[0]]
+	sort.Slice(merged, func(a, b int) bool {
+		return compareKey(pool.GetRecordPtr(merged[a]), pool.GetRecordPtr(merged[b]), fb.layout.CompareLength) < 0
+	})
+	if buf != nil {
+		*buf = merged
+	}
+	return merged
+}
@@ -0,0 +1,36 @@
+package fastbase
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	for _, depth := range []int{2, 3, 4} {
+		fb := NewFastBase(WithPrefixDepth(depth))
+		addTestRecords(t, fb, 0, 100)
+		for n := 0; n < 100; n++ {
+			prefix, _ := testRecord(t, n, KangarooType(n%3))
+			list := fb.List(prefix)
+			if depth != 3 {
+				if list != nil {
+					t.Fatalf("depth %d: List(%x) is not nil", depth, prefix)
+				}
+				continue
+			}
+			if list == nil || int(list.Count) != len(list.Data) {
+				t.Fatalf("List(%x) = %+v", prefix, list)
+			}
+			var recs [][]byte
+			for _, ptr := range list.Data {
+				recs = append(recs, fb.Pools[prefix[0]].GetRecordPtr(ptr))
+			}
+			if want := fb.ListRecords(prefix); !reflect.DeepEqual(recs, want) {
+				t.Errorf("List(%x) holds %x; want %x", prefix, recs, want)
+			}
+		}
+		if list := fb.List([3]byte{0xff, 0xff, 0xff}); list != nil {
+			t.Errorf("depth %d: List of an empty prefix is %+v; want nil", depth, list)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package fastbase
+
+// Filter selects records during iteration
+type Filter func(prefix [3]byte, record []byte) bool
+
+// TypeFilter selects records of the given kangaroo types. The type byte
+// ends the record in every schema and layout.
+func TypeFilter(types ...KangarooType) Filter {
+	var want [256]bool
+	for _, t := range types {
+		want[t] = true
+	}
+	return func(prefix [3]byte, record []byte) bool {
+		return want[record[len(record)-1]]
+	}
+}
+
+// matchAll reports whether a record passes every filter
+func matchAll(filters []Filter, prefix [3]byte, record []byte) bool {
+	for _, f := range filters {
+		if !f(prefix, record) {
+			return false
+		}
+	}
+	return true
+}
+
+// ForEach calls fn for every record passing all filters, in prefix order
+// and, within a list, in sorted order. Iteration stops early when fn
+// returns false. The record slice points into pool memory: copy it if it
+// must outlive the call or the FastBase is modified.
+func (fb *FastBase) ForEach(fn func(prefix [3]byte, record []byte) bool, filters ...Filter) {
+	var merged []uint32
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		ptrs := fb.mergeRuns(prefix, runs, &merged)
+		for _, ptr := range ptrs {
+			rec := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+			if !matchAll(filters, prefix, rec) {
+				continue
+			}
+			if !fn(prefix, rec) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// ListRecords returns the records of one list passing all filters, in
+// sorted order. The slices point into pool memory.
+func (fb *FastBase) ListRecords(prefix [3]byte, filters ...Filter) [][]byte {
+	var records [][]byte
+	for _, ptr := range fb.mergeRuns(prefix, fb.prefixRuns(prefix), nil) {
+		rec := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+		if matchAll(filters, prefix, rec) {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// EachPrefix calls fn for every prefix holding records, in prefix order,
+// with the number of records filed under it. Iteration stops early when fn
+// returns false.
+func (fb *FastBase) EachPrefix(fn func(prefix [3]byte, count int) bool) {
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		n := 0
+		for _, run := range runs {
+			n += len(run)
+		}
+		return fn(prefix, n)
+	})
+}
@@ -0,0 +1,363 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JournalSuffix names the journal kept beside a database file
+const JournalSuffix = ".journal"
+
+// journalMagic starts every journal file
+var journalMagic = [8]byte{'R', 'C', 'K', 'J', 'R', 'N', 'L', '1'}
+
+// A journal is an append-only log of the records added to a FastBase since
+// its file was last saved whole, so a crash between saves loses only what
+// was not yet flushed to it. After the magic come the database header, as
+// in a delta file, and the snapshot ID of the file the journal extends;
+// then each record as it is added, after its 3-byte prefix. A record cut
+// short by a crash ends the journal.
+type journal struct {
+	name string
+	file *os.File
+	w    *bufio.Writer
+	err  error // First failure to write, returned by the next flush
+}
+
+// JournalTornSuffix names the file beside a journal that the bytes of a
+// record cut short at its end are set aside in
+const JournalTornSuffix = ".torn"
+
+// JournalInfo describes a journal file
+type JournalInfo struct {
+	BaseID  uint64 // Snapshot of the database file the journal extends
+	Records int    // Complete records read
+
+	// Set by OpenJournal for the journal it replayed
+	Recovered       int                  // Records replayed that the database lacked
+	RecoveredByType map[KangarooType]int // Recovered, by the type of each record
+	Saved           int                  // Records replayed that the database already held
+	Lost            int                  // Records cut short at the end, whose type is unknown
+	BytesDiscarded  int64                // Bytes of a record cut short at the end
+	DiscardedTo     string               // File those bytes were set aside in, if any
+	LoadedID        uint64               // Snapshot of the loaded database file
+}
+
+// Stale reports whether the journal extends another file than the one
+// loaded. If the loaded file is older, as when restored from a backup, the
+// records of the saves in between that the journal does not hold are
+// lost, and no count of them survives.
+func (info JournalInfo) Stale() bool {
+	return info.BaseID != info.LoadedID
+}
+
+// OpenJournal replays the records of the journal at filename, if it
+// exists, into the FastBase, and from then on logs every record added to
+// it there. Replaying skips records already present, as applying a delta
+// does, so a journal whose records made it into a save before a crash is
+// replayed harmlessly. A record cut short at the end, as by a power loss
+// mid-write, ends the replay: its bytes are appended to the file named
+// with JournalTornSuffix and reported as lost rather than failing the
+// open. The info returned compares the journal with the loaded file,
+// counting the records it recovered by type and those it lost. The
+// journal is then rewritten to extend the loaded file, holding every
+// record added since. Encrypted databases cannot keep a journal, which
+// would hold their records in the clear.
+func (fb *FastBase) OpenJournal(filename string) (JournalInfo, error) {
+	var info JournalInfo
+	if fb.Encrypted() {
+		return info, errors.New("encrypted databases cannot keep a journal")
+	}
+	if fb.journal != nil {
+		return info, errors.New("a journal is already open")
+	}
+
+	loaded := binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:])
+	file, err := os.Open(filename)
+	switch {
+	case err == nil:
+		var stats MergeStats
+		var tail []byte
+		byType := make(map[KangarooType]int)
+		schema := fb.Schema()
+		info, tail, err = readJournal(bufio.NewReaderSize(file, 1<<20), func(header [256]byte) error {
+			return fb.checkJournalHeader(header)
+		}, func(prefix [3]byte, rec []byte) error {
+			return fb.mergeRecord(schema, prefix, rec, &stats, func(_ [3]byte, rec []byte) {
+				byType[schema.Type(rec)]++
+			})
+		})
+		file.Close()
+		info.LoadedID = loaded
+		if err != nil {
+			return info, fmt.Errorf("replaying %s: %w", filename, err)
+		}
+		info.Recovered, info.RecoveredByType, info.Saved = stats.Added, byType, stats.Duplicates
+		if len(tail) > 0 {
+			if err := setAside(filename+JournalTornSuffix, tail); err != nil {
+				return info, fmt.Errorf("setting aside the end of %s: %w", filename, err)
+			}
+			info.Lost, info.BytesDiscarded, info.DiscardedTo = 1, int64(len(tail)), filename+JournalTornSuffix
+			fb.logger().Warn("journal cut short", "file", filename, "recovered", info.Recovered,
+				"lost", info.Lost, "discarded_bytes", info.BytesDiscarded, "set_aside", info.DiscardedTo)
+		}
+		if info.Stale() {
+			fb.logger().Warn("journal extends another snapshot than the loaded file", "file", filename,
+				"journal_base", fmt.Sprintf("%016x", info.BaseID), "loaded", fmt.Sprintf("%016x", info.LoadedID))
+		}
+	case os.IsNotExist(err):
+		info.BaseID, info.LoadedID = loaded, loaded
+	default:
+		return info, err
+	}
+
+	if err := fb.writeJournal(filename); err != nil {
+		return info, err
+	}
+	fb.logger().Info("journal opened", "file", filename, "replayed", info.Records, "recovered", info.Recovered,
+		"tame", info.RecoveredByType[TypeTame], "wild1", info.RecoveredByType[TypeWild1],
+		"wild2", info.RecoveredByType[TypeWild2], "already_saved", info.Saved)
+	return info, nil
+}
+
+// setAside appends the bytes of a record cut short to filename, keeping
+// those of earlier crashes
+func setAside(filename string, tail []byte) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(tail); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkJournalHeader reports whether a journal header describes records of
+// the FastBase's schema and length
+func (fb *FastBase) checkJournalHeader(header [256]byte) error {
+	schema := fb.Schema()
+	if s, err := SchemaByID(header[HeaderSchema]); err != nil {
+		return err
+	} else if s.ID != schema.ID {
+		return fmt.Errorf("journal holds %s records, not %s", s.Name, schema.Name)
+	}
+	layout, err := layoutFromHeader(header)
+	if err != nil {
+		return err
+	}
+	if layout.RecordLength != fb.layout.RecordLength {
+		return fmt.Errorf("journal holds %d-byte records, not %d", layout.RecordLength, fb.layout.RecordLength)
+	}
+	return nil
+}
+
+// writeJournal replaces the journal at filename with one extending the
+// last full save or load, holding the records added since, and keeps it
+// open for appending. The new journal is written beside the old one and
+// renamed over it, so a crash leaves one or the other whole.
+func (fb *FastBase) writeJournal(filename string) error {
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(file, 1<<16)
+	header := fb.Header
+	fb.layout.putHeader(&header)
+	w.Write(journalMagic[:])
+	w.Write(header[:])
+	var base [8]byte
+	binary.LittleEndian.PutUint64(base[:], binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+	w.Write(base[:])
+	for _, ref := range fb.added {
+		w.Write(ref.prefix[:])
+		w.Write(fb.Pools[ref.prefix[0]].GetRecordPtr(ref.ptr))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if fb.journal != nil {
+		fb.journal.file.Close()
+	}
+	fb.journal = &journal{name: filename, file: file, w: w}
+	return nil
+}
+
+// logRecord appends a record just added to the journal, if one is open
+func (fb *FastBase) logRecord(prefix [3]byte, rec []byte) {
+	j := fb.journal
+	if j == nil || j.err != nil {
+		return
+	}
+	if _, err := j.w.Write(prefix[:]); err != nil {
+		j.err = err
+		return
+	}
+	if _, err := j.w.Write(rec); err != nil {
+		j.err = err
+	}
+}
+
+// FlushJournal writes the records logged so far to the journal file, where
+// they survive the process crashing, and with sync to the disk, where they
+// survive the machine crashing. It returns the first failure to write to
+// the journal since it was opened. Without a journal it does nothing.
+func (fb *FastBase) FlushJournal(sync bool) error {
+	j := fb.journal
+	if j == nil {
+		return nil
+	}
+	if j.err != nil {
+		return fmt.Errorf("writing the journal: %w", j.err)
+	}
+	if err := j.w.Flush(); err != nil {
+		j.err = err
+		return fmt.Errorf("writing the journal: %w", err)
+	}
+	if sync {
+		return j.file.Sync()
+	}
+	return nil
+}
+
+// CheckpointJournal empties the journal once the database file it extends
+// has been saved whole, as with SaveToFile, so the file holds its records.
+// Saving to a stream or another file must not be followed by a checkpoint,
+// or a crash would lose the records only the journal held. Without a
+// journal it does nothing.
+func (fb *FastBase) CheckpointJournal() error {
+	j := fb.journal
+	if j == nil {
+		return nil
+	}
+	if err := fb.writeJournal(j.name); err != nil {
+		return fmt.Errorf("checkpointing the journal: %w", err)
+	}
+	fb.logger().Debug("journal checkpointed", "file", j.name, "records", len(fb.added))
+	return nil
+}
+
+// CloseJournal flushes and closes the journal, leaving its file for the
+// next OpenJournal. Records added afterwards are not logged.
+func (fb *FastBase) CloseJournal() error {
+	j := fb.journal
+	if j == nil {
+		return nil
+	}
+	err := fb.FlushJournal(false)
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	fb.journal = nil
+	return err
+}
+
+// readJournal reads a journal from r, passing its header to check and then
+// each complete record to fn, and stops at the first error either returns.
+// The record slice is only valid during the call. The bytes of a record
+// cut short at the end are returned as the tail.
+func readJournal(r io.Reader, check func(header [256]byte) error, fn func(prefix [3]byte, rec []byte) error) (JournalInfo, []byte, error) {
+	var info JournalInfo
+	var magic [8]byte
+	var header [256]byte
+	var base [8]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != journalMagic {
+		return info, nil, errors.New("not a journal file")
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return info, nil, fmt.Errorf("error reading journal header: %v", err)
+	}
+	if _, err := io.ReadFull(r, base[:]); err != nil {
+		return info, nil, fmt.Errorf("error reading journal header: %v", err)
+	}
+	info.BaseID = binary.LittleEndian.Uint64(base[:])
+	if err := check(header); err != nil {
+		return info, nil, err
+	}
+	layout, err := layoutFromHeader(header)
+	if err != nil {
+		return info, nil, err
+	}
+
+	buf := make([]byte, 3+layout.RecordLength)
+	for {
+		if n, err := io.ReadFull(r, buf); err != nil {
+			// A record cut short by a crash was never flushed whole
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return info, buf[:n], nil
+			}
+			return info, nil, err
+		}
+		if err := fn([3]byte{buf[0], buf[1], buf[2]}, buf[3:]); err != nil {
+			return info, nil, err
+		}
+		info.Records++
+	}
+}
+
+// journalHeaderSize is the length of everything in a journal file that
+// precedes the records
+const journalHeaderSize = int64(len(journalMagic)) + 256 + 8
+
+// TailJournal reads the records of the journal at filename from byte
+// offset on, calling fn for each, and returns the offset after the last
+// complete one, to start from next time; an offset of 0 reads them all.
+// A checkpoint replaces the journal with one extending the new save,
+// which shows as a new BaseID.
+func TailJournal(filename string, offset int64, fn func(prefix [3]byte, rec []byte)) (JournalInfo, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return JournalInfo{}, offset, err
+	}
+	defer file.Close()
+
+	var recordLength int
+	info, _, err := readJournal(io.LimitReader(file, journalHeaderSize), func(header [256]byte) error {
+		layout, err := layoutFromHeader(header)
+		recordLength = layout.RecordLength
+		return err
+	}, func([3]byte, []byte) error { return nil })
+	if err != nil {
+		return info, offset, err
+	}
+
+	// Start at the first record, or at the record boundary at or before
+	// offset
+	entry := int64(3 + recordLength)
+	if offset < journalHeaderSize {
+		offset = journalHeaderSize
+	}
+	offset -= (offset - journalHeaderSize) % entry
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return info, offset, err
+	}
+	buf := make([]byte, entry)
+	r := bufio.NewReaderSize(file, 1<<20)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return info, offset, nil
+			}
+			return info, offset, err
+		}
+		fn([3]byte{buf[0], buf[1], buf[2]}, buf[3:])
+		info.Records++
+		offset += entry
+	}
+}
@@ -0,0 +1,311 @@
+package fastbase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRecord returns the n-th of a series of distinct records of type typ
+func testRecord(t *testing.T, n int, typ KangarooType) (prefix [3]byte, rec []byte) {
+	t.Helper()
+	var x [32]byte
+	binary.BigEndian.PutUint32(x[:], uint32(n)*2654435761)
+	binary.BigEndian.PutUint32(x[4:], uint32(n))
+	rec, err := SchemaStandard.NewRecord(x, big.NewInt(int64(n)+1), typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return [3]byte{x[0], x[1], x[2]}, rec
+}
+
+// addTestRecords adds records from..to-1 of the series to fb, cycling
+// through the kangaroo types
+func addTestRecords(t *testing.T, fb *FastBase, from, to int) {
+	t.Helper()
+	for n := from; n < to; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		if ok, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], rec); err != nil || !ok {
+			t.Fatalf("adding record %d: %v, %v", n, ok, err)
+		}
+	}
+}
+
+// hasTestRecords reports whether fb holds records from..to-1 of the series
+func hasTestRecords(t *testing.T, fb *FastBase, from, to int) bool {
+	t.Helper()
+	for n := from; n < to; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		if fb.FindDataBlock(append(prefix[:], rec...)) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// savedTestDB saves a FastBase holding the first n records of the series
+// to a new directory, returning its path
+func savedTestDB(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	fb := NewFastBase()
+	addTestRecords(t, fb, 0, n)
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestDB loads the database at path
+func loadTestDB(t *testing.T, path string) *FastBase {
+	t.Helper()
+	fb := NewFastBase()
+	if err := fb.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return fb
+}
+
+func TestOpenJournalTornTail(t *testing.T) {
+	path := savedTestDB(t, 10)
+	journal := path + JournalSuffix
+
+	fb := loadTestDB(t, path)
+	if _, err := fb.OpenJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, fb, 10, 20)
+	if err := fb.CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the last record short, as a power loss mid-write would
+	fi, err := os.Stat(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const kept = 5
+	if err := os.Truncate(journal, fi.Size()-int64(fb.layout.RecordLength)+kept); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(journal)
+	tail := data[len(data)-3-kept:]
+
+	fb = loadTestDB(t, path)
+	info, err := fb.OpenJournal(journal)
+	if err != nil {
+		t.Fatalf("OpenJournal of a torn journal failed: %v", err)
+	}
+	defer fb.CloseJournal()
+	if info.Records != 9 || info.Recovered != 9 || info.Saved != 0 || info.Lost != 1 || info.Stale() {
+		t.Errorf("replayed %d records, recovering %d with %d saved and %d lost, stale %v; want 9, 9, 0, 1, false",
+			info.Records, info.Recovered, info.Saved, info.Lost, info.Stale())
+	}
+	for _, typ := range []KangarooType{TypeTame, TypeWild1, TypeWild2} {
+		if n := info.RecoveredByType[typ]; n != 3 {
+			t.Errorf("recovered %d %s records; want 3", n, typ)
+		}
+	}
+	if info.BytesDiscarded != 3+kept || info.DiscardedTo != journal+JournalTornSuffix {
+		t.Errorf("discarded %d bytes to %q; want %d to %q", info.BytesDiscarded, info.DiscardedTo, 3+kept, journal+JournalTornSuffix)
+	}
+	if aside, err := os.ReadFile(info.DiscardedTo); err != nil || !bytes.Equal(aside, tail) {
+		t.Errorf("set aside %x, %v; want %x", aside, err, tail)
+	}
+	if !hasTestRecords(t, fb, 0, 19) || hasTestRecords(t, fb, 19, 20) {
+		t.Error("database does not hold exactly the records before the torn one")
+	}
+
+	// The rewritten journal ends cleanly
+	fb.CloseJournal()
+	fb = loadTestDB(t, path)
+	if info, err = fb.OpenJournal(journal); err != nil || info.BytesDiscarded != 0 || info.Recovered != 9 {
+		t.Errorf("reopening: %+v, %v; want 9 recovered and nothing discarded", info, err)
+	}
+	fb.CloseJournal()
+}
+
+func TestOpenJournalSavedNotCheckpointed(t *testing.T) {
+	path := savedTestDB(t, 10)
+	journal := path + JournalSuffix
+
+	// A save that completed without the checkpoint that follows it
+	fb := loadTestDB(t, path)
+	if _, err := fb.OpenJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, fb, 10, 16)
+	if err := fb.FlushJournal(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	fb.CloseJournal()
+
+	fb = loadTestDB(t, path)
+	info, err := fb.OpenJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.CloseJournal()
+	if info.Records != 6 || info.Recovered != 0 || info.Saved != 6 || info.Lost != 0 {
+		t.Errorf("%+v; want 6 records replayed, all already saved", info)
+	}
+	if !info.Stale() || info.LoadedID != fb.SnapshotID() {
+		t.Errorf("journal base %016x, loaded %016x, snapshot %016x; want the journal stale", info.BaseID, info.LoadedID, fb.SnapshotID())
+	}
+}
+
+func TestJournal(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// prepare leaves the journal of a database saved with records
+		// 0..9 as the case has it, with fb open on it
+		prepare   func(t *testing.T, fb *FastBase, path, journal string)
+		wantErr   bool
+		records   int  // Records replayed
+		recovered int  // Of them, records the saved file lacked
+		lost      int  // Records cut short at the end
+		holds     int  // The database holds records 0..holds-1 and no more
+		rebased   bool // The journal extends a later save than the first
+	}{
+		{
+			name: "append",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				addTestRecords(t, fb, 10, 20)
+				closeTestJournal(t, fb)
+			},
+			records: 10, recovered: 10, holds: 20,
+		},
+		{
+			name: "checkpoint then reopen",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				addTestRecords(t, fb, 10, 20)
+				if err := fb.SaveToFile(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := fb.CheckpointJournal(); err != nil {
+					t.Fatal(err)
+				}
+				addTestRecords(t, fb, 20, 25)
+				closeTestJournal(t, fb)
+			},
+			records: 5, recovered: 5, holds: 25, rebased: true,
+		},
+		{
+			name: "replay after a crash",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				addTestRecords(t, fb, 10, 20)
+				if err := fb.FlushJournal(true); err != nil {
+					t.Fatal(err)
+				}
+				// Records never flushed die with the process
+				addTestRecords(t, fb, 20, 25)
+				fb.journal.file.Close()
+				fb.journal = nil
+			},
+			records: 10, recovered: 10, holds: 20,
+		},
+		{
+			name: "torn final record",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				addTestRecords(t, fb, 10, 20)
+				closeTestJournal(t, fb)
+				fi, err := os.Stat(journal)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(journal, fi.Size()-1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			records: 9, recovered: 9, lost: 1, holds: 19,
+		},
+		{
+			name: "other journal version",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				closeTestJournal(t, fb)
+				patchTestFile(t, journal, 0, []byte("RCKJRNL2"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a journal",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				closeTestJournal(t, fb)
+				patchTestFile(t, journal, 0, []byte("RCKDELTA"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty file",
+			prepare: func(t *testing.T, fb *FastBase, path, journal string) {
+				closeTestJournal(t, fb)
+				if err := os.Truncate(journal, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := savedTestDB(t, 10)
+			journal := path + JournalSuffix
+			fb := loadTestDB(t, path)
+			first := fb.SnapshotID()
+			if _, err := fb.OpenJournal(journal); err != nil {
+				t.Fatal(err)
+			}
+			tc.prepare(t, fb, path, journal)
+
+			fb = loadTestDB(t, path)
+			info, err := fb.OpenJournal(journal)
+			if tc.wantErr {
+				if err == nil {
+					fb.CloseJournal()
+					t.Fatalf("replayed %d records; want an error", info.Records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fb.CloseJournal()
+			if info.Records != tc.records || info.Recovered != tc.recovered || info.Lost != tc.lost || info.Stale() {
+				t.Errorf("replayed %d records, recovering %d and losing %d, stale %v; want %d, %d, %d, false",
+					info.Records, info.Recovered, info.Lost, info.Stale(), tc.records, tc.recovered, tc.lost)
+			}
+			if rebased := info.BaseID != first; rebased != tc.rebased {
+				t.Errorf("journal extends %016x, the first save %016x; want rebased %v", info.BaseID, first, tc.rebased)
+			}
+			if !hasTestRecords(t, fb, 0, tc.holds) || hasTestRecords(t, fb, tc.holds, tc.holds+1) {
+				t.Errorf("database does not hold exactly records 0 to %d", tc.holds-1)
+			}
+		})
+	}
+}
+
+// closeTestJournal closes the journal of fb
+func closeTestJournal(t *testing.T, fb *FastBase) {
+	t.Helper()
+	if err := fb.CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// patchTestFile overwrites the bytes of filename at offset with b
+func patchTestFile(t *testing.T, filename string, offset int64, b []byte) {
+	t.Helper()
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,34 @@
+package fastbase
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+)
+
+// SetLogger sends structured logs of loads, saves, merges, tiering and the
+// memory limit to l: Info for each load, save and merge done, Debug for the
+// steps within them, and Warn for trouble that does not fail the call, such
+// as a Bloom filter file that could not be saved or a pool that could not be
+// read back. Errors returned are not logged again. A nil l, the default,
+// discards the logs.
+func (fb *FastBase) SetLogger(l *slog.Logger) {
+	fb.log = l
+}
+
+// logger returns the logger set by SetLogger, or one discarding everything
+func (fb *FastBase) logger() *slog.Logger {
+	if fb.log == nil {
+		return discardLogger
+	}
+	return fb.log
+}
+
+// discardLogger drops every record, enabling no level so none is formatted
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
+// snapshotAttr logs a snapshot ID as the hex string files and tools show
+func snapshotAttr(id uint64) slog.Attr {
+	return slog.String("snapshot", fmt.Sprintf("%016x", id))
+}
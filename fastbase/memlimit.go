@@ -0,0 +1,268 @@
+package fastbase
+
+import (
+	"fmt"
+	"math"
+)
+
+// MemoryPolicy says what a FastBase does once its memory reaches the limit
+// set by WithMemoryLimit
+type MemoryPolicy int
+
+const (
+	// MemoryRefuse fails inserts with a *MemoryLimitError until memory is
+	// freed, by Compact, a save releasing spillable pools, or Clear
+	MemoryRefuse MemoryPolicy = iota
+
+	// MemorySpill lowers the RAM budget of tiering until the FastBase fits
+	// the limit, spilling more pools to disk; it needs WithTiering. Pools
+	// holding records added since the last full save cannot spill, so
+	// inserts are refused while those alone exceed the limit.
+	MemorySpill
+
+	// MemoryRaiseDP raises the DP bits of the FastBase by one, dropping the
+	// records no longer distinguished and skipping such records from then
+	// on. A run collecting DPs at the raised bits can only collide with
+	// records distinguished at them, so nothing it could find is lost; the
+	// run merely needs twice the operations per DP found. Pools spilled to
+	// disk keep their records.
+	MemoryRaiseDP
+)
+
+// ParseMemoryPolicy parses a policy by the name String gives it
+func ParseMemoryPolicy(name string) (MemoryPolicy, error) {
+	for p := MemoryRefuse; p <= MemoryRaiseDP; p++ {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown memory policy %q; use refuse, spill or raise-dp", name)
+}
+
+func (p MemoryPolicy) String() string {
+	switch p {
+	case MemoryRefuse:
+		return "refuse"
+	case MemorySpill:
+		return "spill"
+	case MemoryRaiseDP:
+		return "raise-dp"
+	}
+	return fmt.Sprintf("MemoryPolicy(%d)", int(p))
+}
+
+// MemoryLimitError is returned by inserts refused under the memory limit
+type MemoryLimitError struct {
+	Limit int64 // Limit set by WithMemoryLimit
+	Used  int64 // Memory in use, as MemoryUsage counts it
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory limit reached: %d MiB in use, %d MiB allowed", e.Used>>20, e.Limit>>20)
+}
+
+// WithMemoryLimit bounds the memory MemoryUsage counts at about limit
+// bytes, acting by policy once inserts reach it. Loads are not bounded;
+// the first insert after one acts on the limit. A FastBase with policy
+// MemorySpill but no WithTiering panics in NewFastBase.
+func WithMemoryLimit(limit int64, policy MemoryPolicy) Option {
+	return func(c *config) {
+		c.memLimit = limit
+		c.memPolicy = policy
+	}
+}
+
+// memLimit is the memory limit state of a FastBase. Walking every list to
+// measure memory is slow, so between measurements usage is extrapolated
+// from the pool pages allocated and the records stored since, each taking
+// the list memory per record found by the last measurement.
+type memLimit struct {
+	limit  int64
+	policy MemoryPolicy
+
+	measured  bool
+	used      int64   // Memory in use at the last measurement
+	paged     int64   // Pool page bytes then
+	records   int64   // Records stored in the pools then
+	perRecord float64 // List memory per record then
+	pagedNow  int64   // Pool page bytes as of the last page allocation
+	grown     bool    // Pages were allocated since pagedNow was counted
+	stored    int64   // Records stored since the last measurement
+
+	over   int64 // Memory in use when inserts started being refused, or 0
+	dpBits int   // DP bits records must have once raised, or 0
+	raises int
+}
+
+// MemoryLimitStats reports how a FastBase fares under its memory limit
+type MemoryLimitStats struct {
+	Limit   int64        // Limit set by WithMemoryLimit, or 0 without one
+	Policy  MemoryPolicy // Policy set by WithMemoryLimit
+	Refused bool         // Inserts are being refused
+	DPBits  int          // DP bits required of new records, once raised
+	Raises  int          // Times the DP bits were raised
+}
+
+// MemoryLimitStats returns the memory limit state, all zero without
+// WithMemoryLimit
+func (fb *FastBase) MemoryLimitStats() MemoryLimitStats {
+	m := fb.limit
+	if m == nil {
+		return MemoryLimitStats{}
+	}
+	return MemoryLimitStats{Limit: m.limit, Policy: m.policy, Refused: m.over > 0, DPBits: m.dpBits, Raises: m.raises}
+}
+
+// pagedBytes returns the bytes held by the pages of every pool
+func (fb *FastBase) pagedBytes() int64 {
+	var total int64
+	for i := range fb.Pools {
+		total += fb.poolBytes(i)
+	}
+	return total
+}
+
+// measureMemory returns the memory in use, and keeps what later estimates
+// start from
+func (fb *FastBase) measureMemory() int64 {
+	m := fb.limit
+	m.used = fb.MemoryUsage()
+	m.paged = fb.pagedBytes()
+	m.records = 0
+	for i := range fb.Pools {
+		if pool := &fb.Pools[i]; len(pool.Pages) > 0 {
+			m.records += int64(len(pool.Pages)-1)*int64(pool.perPage) + int64(pool.Ptr/pool.stride())
+		}
+	}
+	m.perRecord = 0
+	if m.records > 0 {
+		m.perRecord = float64(m.used-m.paged) / float64(m.records)
+	}
+	m.measured, m.pagedNow, m.grown, m.stored = true, m.paged, false, 0
+	return m.used
+}
+
+// estimateMemory extrapolates the memory in use from the last measurement
+func (fb *FastBase) estimateMemory() int64 {
+	m := fb.limit
+	if m.grown {
+		m.pagedNow, m.grown = fb.pagedBytes(), false
+	}
+	return m.used + m.pagedNow - m.paged + int64(float64(m.stored)*m.perRecord)
+}
+
+// admit decides whether rec, about to be inserted, may be: skip is set for
+// a record below raised DP bits, and an error returned while inserts are
+// refused
+func (fb *FastBase) admit(rec []byte) (skip bool, err error) {
+	m := fb.limit
+	if m == nil {
+		return false, nil
+	}
+	if m.dpBits > 0 && !fb.Schema().IsDP(rec, m.dpBits) {
+		return true, nil
+	}
+	if m.over == 0 {
+		return false, nil
+	}
+
+	// Only freed pages can bring memory back under the limit, or let more
+	// pools spill
+	if fb.pagedBytes() < m.paged {
+		used := fb.measureMemory()
+		if m.policy == MemorySpill && used >= m.limit {
+			if used, err = fb.spillToLimit(used); err != nil {
+				return false, err
+			}
+		}
+		if used < m.limit {
+			m.over = 0
+			fb.logger().Info("memory back under the limit, accepting inserts", "used", used, "limit", m.limit)
+			return false, nil
+		}
+	}
+	return false, &MemoryLimitError{Limit: m.limit, Used: m.over}
+}
+
+// enforceLimit acts on the memory limit after an insert. An estimate at the
+// limit is only measured once the records stored since the last
+// measurement grow by a sixty-fourth, so inserts close to the limit do not
+// each walk the lists; memory may overshoot the limit by as much.
+func (fb *FastBase) enforceLimit() error {
+	m := fb.limit
+	if m == nil || m.stored == 0 {
+		return nil
+	}
+	if m.measured && (fb.estimateMemory() < m.limit || m.stored <= m.records/64) {
+		return nil
+	}
+	used := fb.measureMemory()
+	if used < m.limit {
+		return nil
+	}
+
+	switch m.policy {
+	case MemorySpill:
+		var err error
+		if used, err = fb.spillToLimit(used); err != nil {
+			return err
+		}
+	case MemoryRaiseDP:
+		// Still over the limit, the bits are raised again as pages grow
+		if used, raised := fb.raiseDP(); raised || used < m.limit {
+			return nil
+		}
+	}
+	if used >= m.limit {
+		m.over = used
+		fb.logger().Warn("memory limit reached, refusing inserts", "used", used, "limit", m.limit, "policy", m.policy)
+	}
+	return nil
+}
+
+// spillToLimit lowers the RAM budget of tiering by the share of memory
+// over the limit and spills, until memory fits the limit or no more pools
+// may spill, and returns the memory then in use
+func (fb *FastBase) spillToLimit(used int64) (int64, error) {
+	m, t := fb.limit, fb.tier
+	for used >= m.limit {
+		// Memory exactly at the limit is over it too, so the budget drops
+		// below the pages in use whatever the share
+		paged := fb.pagedBytes()
+		t.budget = min(t.budget, int64(float64(paged)*float64(m.limit)/float64(used)), paged-1)
+		t.grown = true
+		if err := fb.spillOver(); err != nil {
+			return used, err
+		}
+		if fb.pagedBytes() == paged {
+			break
+		}
+		used = fb.measureMemory()
+	}
+	return used, nil
+}
+
+// raiseDP raises the DP bits by one, dropping the records in memory that
+// are no longer distinguished, and returns the memory then in use. raised
+// is false if the x-coordinates have no more bits to require. Deltas
+// cannot drop records, so the snapshots are forgotten and the next save
+// must be a full one.
+func (fb *FastBase) raiseDP() (used int64, raised bool) {
+	m := fb.limit
+	schema := fb.Schema()
+	bits := max(m.dpBits, int(fb.Header[HeaderDPBits])) + 1
+	if bits > min(8*schema.XLength, math.MaxUint8) {
+		return fb.measureMemory(), false
+	}
+	m.dpBits = bits
+	m.raises++
+	fb.Header[HeaderDPBits] = byte(bits)
+
+	fb.compact(func(rec []byte) bool {
+		return schema.IsDP(rec, bits)
+	})
+	fb.resetSnapshots(0)
+	used = fb.measureMemory()
+	fb.logger().Warn("memory limit reached, raised the DP bits", "bits", bits, "used", used, "limit", m.limit)
+	return used, true
+}
@@ -0,0 +1,191 @@
+package fastbase
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// memLimitTestLimit is the memory limit of the tests, which 4K pages reach
+// after some tens of thousands of records
+const memLimitTestLimit = 2 << 20
+
+// addUntilRefused adds records from of the series to fb until one is
+// refused under the memory limit, or to, and returns the first not added
+func addUntilRefused(t *testing.T, fb *FastBase, from, to int) int {
+	t.Helper()
+	for n := from; n < to; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		_, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], rec)
+		var limitErr *MemoryLimitError
+		if errors.As(err, &limitErr) {
+			return n
+		}
+		if err != nil {
+			t.Fatalf("adding record %d: %v", n, err)
+		}
+	}
+	return to
+}
+
+func TestMemoryLimitRefuse(t *testing.T) {
+	fb := NewFastBase(WithPageSize(1<<12), WithMemoryLimit(memLimitTestLimit, MemoryRefuse))
+	n := addUntilRefused(t, fb, 0, 1000000)
+	if n == 1000000 {
+		t.Fatal("inserts never refused")
+	}
+	if !fb.MemoryLimitStats().Refused {
+		t.Error("stats do not report inserts refused")
+	}
+	// Estimates between measurements may overshoot by a sixty-fourth
+	if used := fb.MemoryUsage(); used > memLimitTestLimit*65/64+256<<12 {
+		t.Errorf("%d bytes in use after refusing; want about the limit of %d", used, memLimitTestLimit)
+	}
+	if !hasTestRecords(t, fb, 0, n) {
+		t.Error("records added before the limit are missing")
+	}
+	if addUntilRefused(t, fb, n, n+1) != n {
+		t.Error("inserts accepted again without memory freed")
+	}
+
+	// Freeing memory lets inserts in again
+	fb.Clear()
+	if got := addUntilRefused(t, fb, 0, 100); got != 100 {
+		t.Errorf("refused record %d after clearing", got)
+	}
+}
+
+func TestMemoryLimitSpill(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	// A budget far above the limit, which the policy lowers
+	fb := NewFastBase(WithPageSize(1<<12), WithTiering(filepath.Join(dir, "spill"), 1<<30),
+		WithMemoryLimit(memLimitTestLimit, MemorySpill))
+	t.Cleanup(fb.ReleaseMemory)
+
+	// Pools holding unsaved records cannot spill, so inserts are refused
+	// until a save, as follow makes on refusal
+	n := addUntilRefused(t, fb, 0, 1000000)
+	if n == 1000000 {
+		t.Fatal("inserts never refused")
+	}
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	ts := fb.TierStats()
+	if ts.ColdPools == 0 || ts.Budget >= 1<<30 {
+		t.Fatalf("%d pools spilled, budget %d after saving; want the budget lowered and pools spilled", ts.ColdPools, ts.Budget)
+	}
+
+	// Records of the series land in every pool, each loading one back that
+	// cannot spill again until saved
+	total := n + 2000
+	for saves := 1; n < total; saves++ {
+		next := addUntilRefused(t, fb, n, total)
+		if next == n {
+			t.Fatalf("record %d refused straight after save %d", n, saves)
+		}
+		if err := fb.SaveToFile(path); err != nil {
+			t.Fatal(err)
+		}
+		n = next
+	}
+	if used := fb.MemoryUsage(); used > memLimitTestLimit*65/64+256<<12 {
+		t.Errorf("%d bytes in use; want about the limit of %d", used, memLimitTestLimit)
+	}
+	if !hasTestRecords(t, fb, 0, total) {
+		t.Error("records missing after spilling to the limit")
+	}
+}
+
+// addRandomRecords adds n records with random x-coordinates to fb and
+// returns the number the FastBase took
+func addRandomRecords(t *testing.T, fb *FastBase, rng *rand.Rand, n int) int {
+	t.Helper()
+	added := 0
+	for i := 0; i < n; i++ {
+		var x [32]byte
+		rng.Read(x[:])
+		rec, err := SchemaStandard.NewRecord(x, big.NewInt(int64(i)+1), KangarooType(i%3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := fb.AddRecord(x[0], x[1], x[2], rec)
+		if err != nil {
+			t.Fatalf("adding record %d: %v", i, err)
+		}
+		if ok {
+			added++
+		}
+	}
+	return added
+}
+
+func TestMemoryLimitRaiseDP(t *testing.T) {
+	fb := NewFastBase(WithPageSize(1<<12), WithMemoryLimit(memLimitTestLimit, MemoryRaiseDP))
+	fb.Header[HeaderDPBits] = 4
+	rng := rand.New(rand.NewSource(1))
+	added := addRandomRecords(t, fb, rng, 200000)
+
+	ls := fb.MemoryLimitStats()
+	if ls.Raises == 0 || ls.Refused {
+		t.Fatalf("%d raises, refused %v; want the DP bits raised rather than inserts refused", ls.Raises, ls.Refused)
+	}
+	if ls.DPBits != 4+ls.Raises || int(fb.Header[HeaderDPBits]) != ls.DPBits {
+		t.Errorf("DP bits %d, header %d after %d raises from 4", ls.DPBits, fb.Header[HeaderDPBits], ls.Raises)
+	}
+	if added == 200000 {
+		t.Error("no record skipped below the raised DP bits")
+	}
+	schema := fb.Schema()
+	kept := 0
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		if !schema.IsDP(rec, ls.DPBits) {
+			t.Fatalf("record %x kept below the raised DP bits", rec)
+		}
+		kept++
+		return true
+	})
+	if kept == 0 || kept >= added {
+		t.Errorf("%d records kept of %d added; want those below the raised bits dropped", kept, added)
+	}
+}
+
+func TestMemoryLimitRaiseDPAfterFreeze(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	fb := NewFastBase(WithPageSize(1<<12), WithMemoryLimit(memLimitTestLimit, MemoryRaiseDP))
+	rng := rand.New(rand.NewSource(1))
+	addRandomRecords(t, fb, rng, 1000)
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	addRandomRecords(t, fb, rng, 1000)
+
+	frozen, err := fb.Freeze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fb.MemoryLimitStats().Raises == 0 {
+		addRandomRecords(t, fb, rng, 10000)
+	}
+	if err := frozen.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Raising the bits dropped records the frozen save holds and forgot
+	// the tracked ones, so the save cannot become the base of later deltas
+	if err := frozen.Commit(); err == nil {
+		t.Fatal("frozen save committed after the DP bits were raised")
+	}
+	if saved := loadTestDB(t, path); saved.Stats().TotalRecords != 2000 {
+		t.Errorf("frozen save holds %d records; want the 2000 frozen", saved.Stats().TotalRecords)
+	}
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.SaveDeltaSince(fb.SnapshotID(), path+".delta"); err != nil {
+		t.Errorf("delta after a full save following the raise: %v", err)
+	}
+}
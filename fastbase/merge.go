@@ -0,0 +1,78 @@
+package fastbase
+
+import (
+	"fmt"
+	"time"
+)
+
+// MergeStats summarizes a merge of one FastBase into another
+type MergeStats struct {
+	Records    int // Source records considered
+	Added      int // Records that were new to the destination
+	Duplicates int // Records already present in the destination
+	Collisions int // Added records sharing an x-coordinate with a record of another type
+
+	Found []Collision // The collisions, with copies of the existing and the added record
+}
+
+// Add accumulates the counts of another merge
+func (s *MergeStats) Add(o MergeStats) {
+	s.Records += o.Records
+	s.Added += o.Added
+	s.Duplicates += o.Duplicates
+	s.Collisions += o.Collisions
+	s.Found = append(s.Found, o.Found...)
+}
+
+// Merge adds every record of src passing all filters to fb, skipping records
+// fb already holds. Both databases must use the same record schema.
+func (fb *FastBase) Merge(src *FastBase, filters ...Filter) (MergeStats, error) {
+	var stats MergeStats
+
+	schema := fb.Schema()
+	if s := src.Schema(); s.ID != schema.ID {
+		return stats, fmt.Errorf("cannot merge a %s database into a %s database", s.Name, schema.Name)
+	}
+	if n := src.layout.RecordLength; n != fb.layout.RecordLength {
+		return stats, fmt.Errorf("cannot merge %d-byte records into a database of %d-byte records", n, fb.layout.RecordLength)
+	}
+
+	started := time.Now()
+	var err error
+	src.ForEach(func(prefix [3]byte, rec []byte) bool {
+		err = fb.mergeRecord(schema, prefix, rec, &stats, nil)
+		return err == nil
+	}, filters...)
+	if err == nil {
+		fb.logger().Info("databases merged", "records", stats.Records, "added", stats.Added,
+			"duplicates", stats.Duplicates, "collisions", stats.Collisions, "elapsed", time.Since(started))
+	}
+
+	return stats, err
+}
+
+// mergeRecord adds one record, counting it in stats and passing it to
+// added, if not nil, when it was new
+func (fb *FastBase) mergeRecord(schema Schema, prefix [3]byte, rec []byte, stats *MergeStats, added func(prefix [3]byte, rec []byte)) error {
+	stats.Records++
+
+	other := fb.partner(schema, prefix, rec, false)
+
+	ok, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], rec)
+	if err != nil {
+		return fmt.Errorf("adding record at [%02x][%02x][%02x]: %w", prefix[0], prefix[1], prefix[2], err)
+	}
+	if !ok {
+		stats.Duplicates++
+		return nil
+	}
+	stats.Added++
+	if added != nil {
+		added(prefix, rec)
+	}
+	if other != nil {
+		stats.Collisions++
+		stats.Found = append(stats.Found, Collision{Prefix: prefix, First: other, Second: append([]byte(nil), rec...)})
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package fastbase
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoOffsetTable is returned by OpenMirror for a file without an offset
+// table, whose lists could only be found by reading up to them
+var ErrNoOffsetTable = errors.New("file has no offset table")
+
+// Mirror answers lookups on a database file from disk rather than from
+// memory, for read-only mirrors of databases larger than the memory of
+// the host serving them. Each lookup reads the one list it needs with
+// LoadPrefix, through the file's offset table; statistics are gathered
+// once, when the mirror is opened. The file must not change while the
+// mirror is open.
+type Mirror struct {
+	filename string
+	schema   Schema
+	stats    StatsReport
+	top      []ListStats
+}
+
+// OpenMirror reads a database file through once, a band of 256 x 256
+// lists at a time, to gather its statistics and its top fullest lists,
+// and returns a Mirror of it. Encrypted files and files without an offset
+// table are refused.
+func OpenMirror(filename string, top int) (*Mirror, error) {
+	if ok, err := HasOffsetTable(filename); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%s: %w", filename, ErrNoOffsetTable)
+	}
+
+	m := &Mirror{filename: filename}
+	m.stats = StatsReport{TotalLists: 256 * 256 * 256, ListSizeHistogram: newListSizeHistogram()}
+	band := NewFastBase()
+	for i := 0; i < 256; i++ {
+		if err := band.LoadPrefixRange(filename, PrefixRange{Lo: [3]byte{byte(i), 0, 0}, Hi: [3]byte{byte(i), 0xff, 0xff}}); err != nil {
+			return nil, err
+		}
+		m.stats.add(band.Stats())
+		m.top = append(m.top, band.TopLists(top)...)
+	}
+	m.schema = band.Schema()
+	band.ReleaseMemory()
+
+	// Fullest first, and of lists as full, the lower prefix, as TopLists
+	sort.Slice(m.top, func(a, b int) bool {
+		if m.top[a].Count != m.top[b].Count {
+			return m.top[a].Count > m.top[b].Count
+		}
+		return bytes.Compare(m.top[a].Prefix[:], m.top[b].Prefix[:]) < 0
+	})
+	if len(m.top) > top {
+		m.top = m.top[:top]
+	}
+	return m, nil
+}
+
+// add merges the statistics of a band of lists into r. Lists outside the
+// band count as empty in o, so its first histogram bucket is recounted.
+func (r *StatsReport) add(o StatsReport) {
+	r.NonEmptyLists += o.NonEmptyLists
+	r.TotalRecords += o.TotalRecords
+	if o.MaxListSize > r.MaxListSize {
+		r.MaxListSize, r.MaxListPrefix = o.MaxListSize, o.MaxListPrefix
+	}
+	for t := range r.Types {
+		r.Types[t].Count += o.Types[t].Count
+		if o.Types[t].MaxListSize > r.Types[t].MaxListSize {
+			r.Types[t].MaxListSize, r.Types[t].MaxListPrefix = o.Types[t].MaxListSize, o.Types[t].MaxListPrefix
+		}
+	}
+	nonEmpty := 0
+	for b := 1; b < len(r.ListSizeHistogram); b++ {
+		r.ListSizeHistogram[b].Lists += o.ListSizeHistogram[b].Lists
+		nonEmpty += r.ListSizeHistogram[b].Lists
+	}
+	r.ListSizeHistogram[0].Lists = r.TotalLists - nonEmpty
+	for i := range r.Pools {
+		r.Pools[i].Records += o.Pools[i].Records
+	}
+}
+
+// Schema returns the record schema of the mirrored file
+func (m *Mirror) Schema() Schema {
+	return m.schema
+}
+
+// Stats returns the statistics of the mirrored file. Pools hold no pages,
+// as nothing stays in memory.
+func (m *Mirror) Stats() StatsReport {
+	return m.stats
+}
+
+// TopLists returns up to the n fullest lists, as many as OpenMirror was
+// asked to keep at most
+func (m *Mirror) TopLists(n int) []ListStats {
+	return m.top[:min(n, len(m.top))]
+}
+
+// load reads the list of one prefix from the file
+func (m *Mirror) load(prefix [3]byte) (*FastBase, error) {
+	fb := NewFastBase()
+	if err := fb.LoadPrefix(m.filename, prefix); err != nil {
+		return nil, err
+	}
+	return fb, nil
+}
+
+// ListRecords returns the records filed under prefix that pass all
+// filters, read from the file
+func (m *Mirror) ListRecords(prefix [3]byte, filters ...Filter) ([][]byte, error) {
+	fb, err := m.load(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return fb.ListRecords(prefix, filters...), nil
+}
+
+// FindX is FastBase.FindX on the mirrored file
+func (m *Mirror) FindX(x []byte) ([]Match, error) {
+	if n := m.schema.XLength; len(x) != n {
+		return nil, fmt.Errorf("x-coordinate must be %d bytes", n)
+	}
+	fb, err := m.load([3]byte{x[0], x[1], x[2]})
+	if err != nil {
+		return nil, err
+	}
+	return fb.FindX(x)
+}
@@ -0,0 +1,57 @@
+package fastbase
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMirrorMatchesLoadedFile(t *testing.T) {
+	path := savedTestDB(t, 3000)
+	fb := loadTestDB(t, path)
+
+	m, err := OpenMirror(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fb.Stats()
+	for i := range want.Pools {
+		want.Pools[i].Pages = 0
+	}
+	if got := m.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mirror stats %+v; want %+v", got, want)
+	}
+	if got, want := m.TopLists(10), fb.TopLists(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("mirror top lists %v; want %v", got, want)
+	}
+
+	for n := 0; n < 3000; n += 97 {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		got, err := m.ListRecords(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fb.ListRecords(prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("list %x: %x; want %x", prefix, got, want)
+		}
+		matches, err := m.FindX(SchemaStandard.X(rec))
+		if err != nil || len(matches) != 1 || !reflect.DeepEqual(matches[0].Record, rec) {
+			t.Errorf("FindX of record %d: %v, %v", n, matches, err)
+		}
+	}
+}
+
+func TestMirrorNeedsOffsetTable(t *testing.T) {
+	path := savedTestDB(t, 10)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-offsetTableSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMirror(path, 10); !errors.Is(err, ErrNoOffsetTable) {
+		t.Errorf("OpenMirror of a file without an offset table: %v; want ErrNoOffsetTable", err)
+	}
+}
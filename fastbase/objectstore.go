@@ -0,0 +1,64 @@
+package fastbase
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"rckangaroo/objstore"
+)
+
+// SaveToObjectStore uploads the FastBase to an s3:// or gs:// URL, streaming
+// it up in parts so no local copy is needed. Credentials come from the
+// environment, see objstore.Open. The snapshot only becomes the base of
+// later delta saves once the upload has completed.
+func (fb *FastBase) SaveToObjectStore(ctx context.Context, url string) error {
+	obj, err := objstore.Open(url)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan uint64, 1)
+	go func() {
+		w := bufio.NewWriterSize(pw, 1<<20)
+		id, err := fb.writeSnapshot(w, newProgressTracker(ctx, nil, 0))
+		if err == nil {
+			err = w.Flush()
+		}
+		written <- id
+		pw.CloseWithError(err)
+	}()
+
+	err = obj.Put(ctx, pr)
+	// Unblock the writer if the upload gave up early
+	pr.CloseWithError(io.ErrClosedPipe)
+	id := <-written
+	if err != nil {
+		return err
+	}
+
+	fb.commitSnapshot(id)
+	return nil
+}
+
+// LoadFromObjectStore replaces the contents of the FastBase with a database
+// streamed from an s3:// or gs:// URL
+func (fb *FastBase) LoadFromObjectStore(ctx context.Context, url string) error {
+	obj, err := objstore.Open(url)
+	if err != nil {
+		return err
+	}
+
+	body, size, err := obj.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := fb.load(bufio.NewReaderSize(body, 1<<20), max(size, 0), newProgressTracker(ctx, nil, max(size, 0))); err != nil {
+		return err
+	}
+	fb.rebuildBlooms()
+	return nil
+}
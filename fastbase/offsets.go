@@ -0,0 +1,197 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// offsetsMagic ends a database file that carries an offset table
+var offsetsMagic = [8]byte{'R', 'C', 'K', 'O', 'F', 'F', 'S', '1'}
+
+// offsetTableSize is the size of the trailer written after the last list:
+// the file offset of the count of every prefix [i j 00], little-endian,
+// then the offset of the table itself and offsetsMagic. Readers that stop
+// after the last list, like the C++ RCKangaroo, never see it.
+const offsetTableSize = 65536*8 + 8 + 8
+
+// writeOffsetTable writes the trailer for the given offsets of a file
+// whose lists end at tableOffset
+func writeOffsetTable(w io.Writer, offsets []uint64, tableOffset int64) error {
+	buf := make([]byte, offsetTableSize)
+	for n, off := range offsets {
+		binary.LittleEndian.PutUint64(buf[n*8:], off)
+	}
+	binary.LittleEndian.PutUint64(buf[65536*8:], uint64(tableOffset))
+	copy(buf[65536*8+8:], offsetsMagic[:])
+	_, err := w.Write(buf)
+	return err
+}
+
+// findOffsetTable returns where the offset table of a file of the given
+// size starts, and false if the file has none
+func findOffsetTable(r io.ReaderAt, size int64) (int64, bool) {
+	if size < int64(256+offsetTableSize) {
+		return 0, false
+	}
+	var footer [16]byte
+	if _, err := r.ReadAt(footer[:], size-16); err != nil {
+		return 0, false
+	}
+	tableOffset := int64(binary.LittleEndian.Uint64(footer[:8]))
+	if [8]byte(footer[8:]) != offsetsMagic || tableOffset != size-offsetTableSize {
+		return 0, false
+	}
+	return tableOffset, true
+}
+
+// HasOffsetTable reports whether a database file carries an offset table,
+// so LoadPrefix can seek straight to a list
+func HasOffsetTable(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	_, ok := findOffsetTable(file, fi.Size())
+	return ok, nil
+}
+
+// LoadPrefix replaces the contents of the FastBase with the list of one
+// prefix of a database file, leaving every other list empty. Files with an
+// offset table are read from the start of the prefix's two-byte group, so
+// a lookup costs a few small reads whatever the size of the file; others
+// are scanned up to the prefix.
+func (fb *FastBase) LoadPrefix(filename string, prefix [3]byte) error {
+	return fb.LoadPrefixRange(filename, SinglePrefix(prefix))
+}
+
+// LoadPrefixRange is LoadPrefix for every list of a band of prefixes,
+// which lie one after another in the file. Lists of an encrypted file
+// cannot be found in place, so it is decrypted and loaded whole, with the
+// secret set by SetSecret, and only the lists of the band are kept.
+func (fb *FastBase) LoadPrefixRange(filename string, pr PrefixRange) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	countSize, err := fb.readHeader(file)
+	if err != nil {
+		return err
+	}
+	if fb.Encrypted() {
+		file.Close()
+		return fb.loadEncryptedRange(filename, pr)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	lo := pr.Lo
+	start, skip := int64(len(fb.Header)), prefixIndex(lo)
+	if tableOffset, ok := findOffsetTable(file, fi.Size()); ok {
+		var entry [8]byte
+		if _, err := file.ReadAt(entry[:], tableOffset+8*int64(int(lo[0])<<8|int(lo[1]))); err != nil {
+			return fmt.Errorf("error reading offset table: %v", err)
+		}
+		off := int64(binary.LittleEndian.Uint64(entry[:]))
+		if off < start || off >= tableOffset {
+			return fmt.Errorf("offset table entry for [%02x %02x] is out of range", lo[0], lo[1])
+		}
+		start, skip = off, int(lo[2])
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Skip the lists before the range, then read its own
+	r := bufio.NewReaderSize(file, 1<<16)
+	countBuf := make([]byte, 4)
+	readCount := func() (int, error) {
+		if _, err := io.ReadFull(r, countBuf[:countSize]); err != nil {
+			return 0, fmt.Errorf("error reading count: %v", err)
+		}
+		count := binary.LittleEndian.Uint32(countBuf)
+		if countSize == 2 {
+			count &= 0xFFFF
+		}
+		return int(count), nil
+	}
+	for ; skip > 0; skip-- {
+		count, err := readCount()
+		if err != nil {
+			return err
+		}
+		if _, err := r.Discard(count * fb.layout.RecordLength); err != nil {
+			return fmt.Errorf("error skipping list: %v", err)
+		}
+	}
+	dataBuf := make([]byte, fb.layout.RecordLength)
+	for n := prefixIndex(lo); n <= prefixIndex(pr.Hi); n++ {
+		prefix := [3]byte{byte(n >> 16), byte(n >> 8), byte(n)}
+		count, err := readCount()
+		if err != nil {
+			fb.Clear()
+			return err
+		}
+		for m := 0; m < count; m++ {
+			if _, err := io.ReadFull(r, dataBuf); err != nil {
+				fb.Clear()
+				return fmt.Errorf("error reading data block at [%02x][%02x][%02x]: %v", prefix[0], prefix[1], prefix[2], err)
+			}
+			ptr, _, err := fb.storeRecord(prefix, dataBuf)
+			if err != nil {
+				fb.Clear()
+				return err
+			}
+			list := fb.index.getOrCreate(prefix, dataBuf)
+			list.Data = append(list.Data, ptr)
+			list.Count = uint32(len(list.Data))
+			list.Capacity = uint32(cap(list.Data))
+		}
+	}
+
+	fb.rebuildBlooms()
+	fb.resetSnapshots(binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+	return nil
+}
+
+// loadEncryptedRange is LoadPrefixRange for an encrypted file, whose
+// header fb already holds
+func (fb *FastBase) loadEncryptedRange(filename string, pr PrefixRange) error {
+	whole := NewFastBase()
+	whole.SetSecret(fb.secret)
+	if err := whole.LoadFromFile(filename); err != nil {
+		return err
+	}
+	var err error
+	whole.ForEachInRange(pr, func(prefix [3]byte, records [][]byte) bool {
+		for _, rec := range records {
+			var ptr uint32
+			if ptr, _, err = fb.storeRecord(prefix, rec); err != nil {
+				return false
+			}
+			list := fb.index.getOrCreate(prefix, rec)
+			list.Data = append(list.Data, ptr)
+			list.Count = uint32(len(list.Data))
+			list.Capacity = uint32(cap(list.Data))
+		}
+		return true
+	})
+	if err != nil {
+		fb.Clear()
+		return err
+	}
+	fb.rebuildBlooms()
+	fb.resetSnapshots(binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+	return nil
+}
@@ -0,0 +1,71 @@
+package fastbase
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fastPBKDF2 lowers the PBKDF2 work factor for the rest of the test, which
+// would otherwise spend seconds under the race detector on every key
+func fastPBKDF2(t *testing.T) {
+	saved := pbkdf2Iterations
+	pbkdf2Iterations = 1000
+	t.Cleanup(func() { pbkdf2Iterations = saved })
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914, section 11
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"
+	if got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1)); got != want {
+		t.Errorf("PBKDF2-HMAC-SHA256 = %s; want %s", got, want)
+	}
+}
+
+func TestLoadPrefixRangeEncrypted(t *testing.T) {
+	fastPBKDF2(t)
+	path := filepath.Join(t.TempDir(), "secret.db")
+	fb := NewFastBase()
+	addTestRecords(t, fb, 0, 500)
+	fb.SetSecret(Passphrase("hunter2"))
+	if err := fb.SetEncrypted(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix, _ := testRecord(t, 42, KangarooType(42%3))
+	pr := PrefixRange{Lo: [3]byte{prefix[0], 0, 0}, Hi: [3]byte{prefix[0], 0xff, 0xff}}
+
+	if err := NewFastBase().LoadPrefixRange(path, pr); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("loading without the secret: %v; want ErrEncrypted", err)
+	}
+
+	band := NewFastBase()
+	band.SetSecret(Passphrase("hunter2"))
+	if err := band.LoadPrefixRange(path, pr); err != nil {
+		t.Fatal(err)
+	}
+	if !band.Encrypted() {
+		t.Error("band of an encrypted file is not marked encrypted")
+	}
+	want := 0
+	for n := 0; n < 500; n++ {
+		p, _ := testRecord(t, n, KangarooType(n%3))
+		if !pr.Contains(p) {
+			continue
+		}
+		want++
+		if got, all := band.ListRecords(p), fb.ListRecords(p); !reflect.DeepEqual(got, all) {
+			t.Errorf("list %x: %x; want %x", p, got, all)
+		}
+	}
+	got := 0
+	band.ForEach(func([3]byte, []byte) bool { got++; return true })
+	if want == 0 || got != want {
+		t.Errorf("band holds %d records; want %d", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package fastbase
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Header bytes describing the record layout. Zero means the default, so
+// files written by the C++ RCKangaroo read as the standard layout.
+const (
+	// HeaderRecordLength holds the record length in bytes
+	HeaderRecordLength = 4
+
+	// HeaderCompareLength holds how many leading record bytes order records
+	HeaderCompareLength = 5
+
+	// HeaderPrefixDepth holds how many leading x-coordinate bytes index lists
+	HeaderPrefixDepth = 6
+
+	// HeaderProvenance is 1 when records carry their provenance in their
+	// last metadata bytes; see WithProvenance
+	HeaderProvenance = 7
+
+	// HeaderPageShift holds the pool page size as a power of two
+	HeaderPageShift = 33
+)
+
+// Layout describes how records are stored: their size, the leading bytes
+// that order them within a list, and the prefix depth of the list index
+type Layout struct {
+	RecordLength  int // Bytes per record, ending with the kangaroo type byte
+	CompareLength int // Leading record bytes that order records in a list
+	PrefixDepth   int // Leading key bytes that select a list; see listIndex
+	PageSize      int // Bytes per pool page; see WithPageSize
+}
+
+// DefaultLayout is the layout of the C++ RCKangaroo
+var DefaultLayout = Layout{RecordLength: DBRecordLength, CompareLength: DBFindLength, PrefixDepth: 3, PageSize: MemPageSize}
+
+// Option configures a FastBase created by NewFastBase
+type Option func(*config)
+
+// config collects the settings of NewFastBase
+type config struct {
+	layout     Layout
+	bloomBits  int    // Bloom filter bits per record; 0 disables the filter
+	provenance bool   // Records carry their provenance; see WithProvenance
+	tierDir    string // Spill directory; see WithTiering
+	tierBudget int64
+	memLimit   int64 // Memory limit; see WithMemoryLimit
+	memPolicy  MemoryPolicy
+}
+
+// WithRecordLength sets the record length. Records longer than the schema
+// needs keep the type byte last, leaving room for metadata before it.
+func WithRecordLength(n int) Option {
+	return func(c *config) { c.layout.RecordLength = n }
+}
+
+// WithCompareLength sets how many leading record bytes order the records
+// of a list and identify a record in FindDataBlock
+func WithCompareLength(n int) Option {
+	return func(c *config) { c.layout.CompareLength = n }
+}
+
+// WithPrefixDepth sets how many leading key bytes select a list: 2 keeps
+// the index small for small databases, 4 keeps lists short in huge ones.
+// Files are laid out by 3-byte prefix whatever the depth; the header only
+// records the depth so a load rebuilds the same index.
+func WithPrefixDepth(n int) Option {
+	return func(c *config) { c.layout.PrefixDepth = n }
+}
+
+// WithPageSize sets the size of the pages pools allocate records from, a
+// power of two from 4KB to 256MB. Each pool takes a page as soon as it
+// holds a record, so small pages keep tiny databases small, while large
+// ones mean fewer allocations for huge runs. Record pointers address
+// 2^32 records of a pool whatever the page size. Files record the size
+// so a load allocates the same pages; it does not change what is saved.
+func WithPageSize(n int) Option {
+	return func(c *config) { c.layout.PageSize = n }
+}
+
+// maxRecordLength keeps records addressable within a memory page
+const maxRecordLength = 255
+
+// Page sizes WithPageSize accepts
+const (
+	minPageSize = 1 << 12
+	maxPageSize = 1 << 28
+)
+
+// Validate checks that the layout can be stored
+func (l Layout) Validate() error {
+	if l.RecordLength < DBRecordLength || l.RecordLength > maxRecordLength {
+		return fmt.Errorf("record length %d is outside %d..%d", l.RecordLength, DBRecordLength, maxRecordLength)
+	}
+	if l.CompareLength < 4 || l.CompareLength >= l.RecordLength {
+		return fmt.Errorf("compare length %d must be at least 4 and less than the record length %d", l.CompareLength, l.RecordLength)
+	}
+	if l.PrefixDepth < 2 || l.PrefixDepth > 4 {
+		return fmt.Errorf("prefix depth %d is outside 2..4", l.PrefixDepth)
+	}
+	if l.PageSize < minPageSize || l.PageSize > maxPageSize || l.PageSize&(l.PageSize-1) != 0 {
+		return fmt.Errorf("page size %d is not a power of two from %d to %d", l.PageSize, minPageSize, maxPageSize)
+	}
+	return nil
+}
+
+// layoutFromHeader decodes and validates the layout recorded in a header
+func layoutFromHeader(header [256]byte) (Layout, error) {
+	l := DefaultLayout
+	if v := header[HeaderRecordLength]; v != 0 {
+		l.RecordLength = int(v)
+	}
+	if v := header[HeaderCompareLength]; v != 0 {
+		l.CompareLength = int(v)
+	}
+	if v := header[HeaderPrefixDepth]; v != 0 {
+		l.PrefixDepth = int(v)
+	}
+	if v := header[HeaderPageShift]; v != 0 {
+		l.PageSize = 1 << v
+	}
+	if err := l.Validate(); err != nil {
+		return l, fmt.Errorf("invalid record layout in header: %v", err)
+	}
+	return l, nil
+}
+
+// putHeader records the layout in a header, leaving default fields zeroed
+// so files in the default layout stay byte-compatible with the C++ tool
+func (l Layout) putHeader(header *[256]byte) {
+	put := func(offset, v, def int) {
+		header[offset] = 0
+		if v != def {
+			header[offset] = byte(v)
+		}
+	}
+	put(HeaderRecordLength, l.RecordLength, DefaultLayout.RecordLength)
+	put(HeaderCompareLength, l.CompareLength, DefaultLayout.CompareLength)
+	put(HeaderPrefixDepth, l.PrefixDepth, DefaultLayout.PrefixDepth)
+	put(HeaderPageShift, bits.Len(uint(l.PageSize))-1, bits.Len(uint(DefaultLayout.PageSize))-1)
+}
+
+// Layout returns the record layout of the FastBase
+func (fb *FastBase) Layout() Layout {
+	return fb.layout
+}
+
+// Widen stretches a default-length record to the record length of the
+// FastBase, keeping the type byte last and zeroing the metadata bytes
+func (fb *FastBase) Widen(rec []byte) []byte {
+	n := fb.layout.RecordLength
+	if len(rec) >= n {
+		return rec
+	}
+	out := make([]byte, n)
+	copy(out, rec[:len(rec)-1])
+	out[n-1] = rec[len(rec)-1]
+	return out
+}
+
+// Narrow undoes Widen, cutting a record of the FastBase to the record
+// length of its schema as a Batch holds it, without the metadata bytes
+func (fb *FastBase) Narrow(rec []byte) []byte {
+	n := fb.Schema().RecordLength
+	if len(rec) <= n {
+		return rec
+	}
+	out := make([]byte, n)
+	copy(out, rec[:n-1])
+	out[n-1] = rec[len(rec)-1]
+	return out
+}
+
+// setLayout switches the FastBase to another layout. It must be empty,
+// unless the prefix depth stays the same.
+func (fb *FastBase) setLayout(l Layout) {
+	if l.PrefixDepth != fb.index.depth {
+		fb.index.reset(l.PrefixDepth)
+	}
+	fb.layout = l
+	l.putHeader(&fb.Header)
+	for i := range fb.Pools {
+		fb.Pools[i].setLayout(l)
+	}
+}
+
+// tagLength returns the bytes kept after each record for the index
+func (l Layout) tagLength() int {
+	if l.PrefixDepth == 2 {
+		return 1
+	}
+	return 0
+}
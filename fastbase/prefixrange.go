@@ -0,0 +1,119 @@
+package fastbase
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// PrefixRange is the band of 3-byte prefixes from Lo to Hi inclusive
+type PrefixRange struct {
+	Lo, Hi [3]byte
+}
+
+// SinglePrefix returns the range holding just one prefix
+func SinglePrefix(prefix [3]byte) PrefixRange {
+	return PrefixRange{Lo: prefix, Hi: prefix}
+}
+
+// ParsePrefixRange parses a prefix band given as six hex digits, as six
+// hex digits ending in '?' wildcards ("00f1??"), or as two prefixes
+// joined by a dash ("00f1f0-00f1ff"). Spaces and 0x prefixes are ignored.
+func ParsePrefixRange(s string) (PrefixRange, error) {
+	s = strings.ReplaceAll(s, " ", "")
+	if lo, hi, ok := strings.Cut(s, "-"); ok {
+		var r PrefixRange
+		var err error
+		if r.Lo, err = parsePrefixHex(lo); err != nil {
+			return r, err
+		}
+		if r.Hi, err = parsePrefixHex(hi); err != nil {
+			return r, err
+		}
+		if bytes.Compare(r.Lo[:], r.Hi[:]) > 0 {
+			return r, fmt.Errorf("prefix range %s-%s ends before it starts", lo, hi)
+		}
+		return r, nil
+	}
+
+	// Wildcards may only end the prefix, so the band stays contiguous
+	s = strings.TrimPrefix(s, "0x")
+	fixed := strings.TrimRight(s, "?")
+	if strings.Contains(fixed, "?") {
+		return PrefixRange{}, fmt.Errorf("wildcards in prefix %q must all come at the end", s)
+	}
+	wild := len(s) - len(fixed)
+	lo, err := parsePrefixHex(fixed + strings.Repeat("0", wild))
+	if err != nil {
+		return PrefixRange{}, err
+	}
+	hi, _ := parsePrefixHex(fixed + strings.Repeat("f", wild))
+	return PrefixRange{Lo: lo, Hi: hi}, nil
+}
+
+// parsePrefixHex parses a single prefix of exactly six hex digits
+func parsePrefixHex(s string) ([3]byte, error) {
+	var prefix [3]byte
+	s = strings.TrimPrefix(s, "0x")
+	if len(s) != 6 {
+		return prefix, fmt.Errorf("prefix must be exactly 6 hex characters (3 bytes), got %d characters", len(s))
+	}
+	if _, err := hex.Decode(prefix[:], []byte(s)); err != nil {
+		return prefix, fmt.Errorf("invalid hex string: %v", err)
+	}
+	return prefix, nil
+}
+
+// Single reports whether the range holds just one prefix
+func (r PrefixRange) Single() bool {
+	return r.Lo == r.Hi
+}
+
+// Contains reports whether a prefix lies in the range
+func (r PrefixRange) Contains(prefix [3]byte) bool {
+	return bytes.Compare(prefix[:], r.Lo[:]) >= 0 && bytes.Compare(prefix[:], r.Hi[:]) <= 0
+}
+
+// Count returns the number of prefixes in the range
+func (r PrefixRange) Count() int {
+	return prefixIndex(r.Hi) - prefixIndex(r.Lo) + 1
+}
+
+// String formats the range as it is parsed, as one prefix or lo-hi
+func (r PrefixRange) String() string {
+	if r.Single() {
+		return hex.EncodeToString(r.Lo[:])
+	}
+	return hex.EncodeToString(r.Lo[:]) + "-" + hex.EncodeToString(r.Hi[:])
+}
+
+// prefixIndex returns the position of a prefix's list in a database file
+func prefixIndex(prefix [3]byte) int {
+	return int(prefix[0])<<16 | int(prefix[1])<<8 | int(prefix[2])
+}
+
+// ForEachInRange calls fn, in prefix order, with the records of every list
+// in the range that has records passing all filters. Only the pools the
+// range spans are visited. Iteration stops early when fn returns false.
+// The record slices point into pool memory.
+func (fb *FastBase) ForEachInRange(r PrefixRange, fn func(prefix [3]byte, records [][]byte) bool, filters ...Filter) {
+	var merged []uint32
+	fb.eachPrefixIn(int(r.Lo[0]), int(r.Hi[0])+1, func(prefix [3]byte, runs [][]uint32) bool {
+		if !r.Contains(prefix) {
+			// Before the range keep going; past it, stop
+			return bytes.Compare(prefix[:], r.Lo[:]) < 0
+		}
+		var records [][]byte
+		for _, ptr := range fb.mergeRuns(prefix, runs, &merged) {
+			rec := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+			if matchAll(filters, prefix, rec) {
+				records = append(records, rec)
+			}
+		}
+		if len(records) == 0 {
+			return true
+		}
+		return fn(prefix, records)
+	})
+}
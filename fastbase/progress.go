@@ -0,0 +1,114 @@
+package fastbase
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"time"
+)
+
+// Progress reports how far a load or save has got
+type Progress struct {
+	Bytes   int64 // Bytes read or written so far
+	Total   int64 // Bytes in all, or zero if unknown
+	Records int   // Records read or written so far
+	Done    bool  // Set on the final report of a successful load or save
+}
+
+// ProgressFunc receives progress reports. It is called from the loading or
+// saving goroutine after each pool, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// progressTracker checks for cancellation and reports progress between
+// pools. A nil tracker does neither.
+type progressTracker struct {
+	ctx     context.Context
+	fn      ProgressFunc
+	total   int64
+	bytes   int64
+	records int
+}
+
+func newProgressTracker(ctx context.Context, fn ProgressFunc, total int64) *progressTracker {
+	return &progressTracker{ctx: ctx, fn: fn, total: total}
+}
+
+// step reports progress and returns the context error once cancelled
+func (t *progressTracker) step(bytes int64, records int) error {
+	if t == nil {
+		return nil
+	}
+	t.bytes, t.records = bytes, records
+	if t.fn != nil {
+		t.fn(Progress{Bytes: bytes, Total: t.total, Records: records})
+	}
+	return t.ctx.Err()
+}
+
+// done sends the final report, repeating the last step
+func (t *progressTracker) done() {
+	if t != nil && t.fn != nil {
+		t.fn(Progress{Bytes: t.bytes, Total: t.total, Records: t.records, Done: true})
+	}
+}
+
+// LoadFromFileCtx loads the FastBase from a file like LoadFromFile, calling
+// progress (if not nil) after each of the 256 pools. Cancelling ctx stops
+// the load and leaves the FastBase empty.
+func (fb *FastBase) LoadFromFileCtx(ctx context.Context, filename string, progress ProgressFunc) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Loading stops before any offset table, so progress ends short of it
+	var size int64
+	if fi, err := file.Stat(); err == nil {
+		size = fi.Size()
+		if tableOffset, ok := findOffsetTable(file, size); ok {
+			size = tableOffset
+		}
+	}
+	started := time.Now()
+	t := newProgressTracker(ctx, progress, size)
+	if err := fb.load(bufio.NewReaderSize(file, 1<<20), size, t); err != nil {
+		if ctx.Err() != nil {
+			fb.Clear()
+		}
+		return err
+	}
+	if fb.blooms != nil {
+		if err := fb.loadBlooms(filename+BloomSuffix, fb.SnapshotID()); err != nil {
+			fb.logger().Debug("rebuilding bloom filters", "file", filename+BloomSuffix, "reason", err)
+			fb.rebuildBlooms()
+		}
+	}
+	t.done()
+	fb.logger().Info("database loaded", "file", filename, "records", t.records, snapshotAttr(fb.SnapshotID()), "elapsed", time.Since(started))
+	return nil
+}
+
+// SaveToFileCtx saves the FastBase to a file like SaveToFile, calling
+// progress (if not nil) after each of the 256 pools. The database is written
+// to a temporary file that replaces filename only once complete, so
+// cancelling ctx leaves any existing file untouched.
+func (fb *FastBase) SaveToFileCtx(ctx context.Context, filename string, progress ProgressFunc) error {
+	started := time.Now()
+	tmp := filename + ".tmp"
+	t := newProgressTracker(ctx, progress, 0)
+	snapshotID, err := fb.saveFile(tmp, t)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fb.commitSnapshot(snapshotID)
+	t.done()
+	fb.saved(filename, snapshotID, started)
+	return nil
+}
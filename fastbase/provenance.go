@@ -0,0 +1,108 @@
+package fastbase
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+// ProvenanceLength is the metadata a record's provenance takes: a 4-byte
+// worker ID and 4-byte Unix time, both little-endian, just before the type
+const ProvenanceLength = 8
+
+// Provenance says which worker submitted a record and when. Records added
+// without one, such as imported ones or those of workers without an API
+// key, carry worker 0, and imported ones the zero time.
+type Provenance struct {
+	Worker uint32
+	Time   time.Time
+}
+
+// WorkerID returns the worker ID recorded for a worker name, such as the
+// name of its API key
+func WorkerID(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+// WithProvenance makes records carry their provenance, growing them by
+// ProvenanceLength metadata bytes unless WithRecordLength left room
+func WithProvenance() Option {
+	return func(c *config) { c.provenance = true }
+}
+
+// HasProvenance reports whether records carry their provenance
+func (fb *FastBase) HasProvenance() bool {
+	return fb.Header[HeaderProvenance] == 1 && fb.layout.RecordLength >= fb.Schema().RecordLength+ProvenanceLength
+}
+
+// provenanceField returns the provenance bytes of a record
+func provenanceField(rec []byte) []byte {
+	return rec[len(rec)-1-ProvenanceLength : len(rec)-1]
+}
+
+// Provenance returns the provenance of a record, or false if the FastBase
+// does not record provenance
+func (fb *FastBase) Provenance(rec []byte) (Provenance, bool) {
+	if !fb.HasProvenance() {
+		return Provenance{}, false
+	}
+	field := provenanceField(rec)
+	p := Provenance{Worker: binary.LittleEndian.Uint32(field)}
+	if secs := binary.LittleEndian.Uint32(field[4:]); secs != 0 {
+		p.Time = time.Unix(int64(secs), 0)
+	}
+	return p, true
+}
+
+// SetProvenance stores p in a record of the FastBase's layout. It does
+// nothing if the FastBase does not record provenance.
+func (fb *FastBase) SetProvenance(rec []byte, p Provenance) {
+	if !fb.HasProvenance() {
+		return
+	}
+	field := provenanceField(rec)
+	binary.LittleEndian.PutUint32(field, p.Worker)
+	var secs uint32
+	if !p.Time.IsZero() {
+		secs = uint32(p.Time.Unix())
+	}
+	binary.LittleEndian.PutUint32(field[4:], secs)
+}
+
+// WorkerFilter selects records submitted by a worker. It selects nothing
+// if the FastBase does not record provenance.
+func (fb *FastBase) WorkerFilter(worker uint32) Filter {
+	return func(prefix [3]byte, rec []byte) bool {
+		p, ok := fb.Provenance(rec)
+		return ok && p.Worker == worker
+	}
+}
+
+// SinceFilter selects records submitted at or after t. It selects nothing
+// if the FastBase does not record provenance.
+func (fb *FastBase) SinceFilter(t time.Time) Filter {
+	return func(prefix [3]byte, rec []byte) bool {
+		p, ok := fb.Provenance(rec)
+		return ok && !p.Time.IsZero() && !p.Time.Before(t)
+	}
+}
+
+// UntilFilter selects records submitted before t. It selects nothing if
+// the FastBase does not record provenance.
+func (fb *FastBase) UntilFilter(t time.Time) Filter {
+	return func(prefix [3]byte, rec []byte) bool {
+		p, ok := fb.Provenance(rec)
+		return ok && !p.Time.IsZero() && p.Time.Before(t)
+	}
+}
+
+// identityLength returns how many leading record bytes tell records apart:
+// all but the type, and the provenance, which says nothing about the point
+func (fb *FastBase) identityLength() int {
+	if fb.HasProvenance() {
+		return fb.layout.RecordLength - 1 - ProvenanceLength
+	}
+	return fb.layout.RecordLength - 1
+}
@@ -0,0 +1,111 @@
+package fastbase
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// KangarooType is the herd a distinguished point was found by, stored in
+// the last byte of a record
+type KangarooType byte
+
+// Kangaroo types as written by the C++ solver
+const (
+	TypeTame  KangarooType = 0
+	TypeWild1 KangarooType = 1
+	TypeWild2 KangarooType = 2
+)
+
+var kangarooTypeNames = [...]string{"tame", "wild1", "wild2"}
+
+// String returns the type's name, as used in text dumps and queries
+func (t KangarooType) String() string {
+	if int(t) < len(kangarooTypeNames) {
+		return kangarooTypeNames[t]
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
+
+// ParseKangarooType accepts a numeric kangaroo type or its name
+func ParseKangarooType(s string) (KangarooType, error) {
+	t, err := parseTypeField(s)
+	if err != nil {
+		return 0, err
+	}
+	if t > byte(TypeWild2) {
+		return 0, fmt.Errorf("invalid type %q", s)
+	}
+	return KangarooType(t), nil
+}
+
+// NewRecord builds a record in the standard schema from a full big-endian
+// x-coordinate, a signed distance and a kangaroo type. The record is filed
+// under the prefix formed by its first three bytes.
+func NewRecord(x [32]byte, distance *big.Int, typ KangarooType) ([DBRecordLength]byte, error) {
+	var rec [DBRecordLength]byte
+	b, err := SchemaStandard.NewRecord(x, distance, typ)
+	copy(rec[:], b)
+	return rec, err
+}
+
+// NewRecord builds a record laid out according to s, truncating x to the
+// schema's x-coordinate length and encoding the distance as the storage
+// layer expects. Records of other schemas differ in length, so it returns
+// a slice rather than the array NewRecord does.
+func (s Schema) NewRecord(x [32]byte, distance *big.Int, typ KangarooType) ([]byte, error) {
+	if typ > TypeWild2 {
+		return nil, fmt.Errorf("invalid kangaroo type %d", typ)
+	}
+	d, err := s.EncodeDistance(distance)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := make([]byte, s.RecordLength)
+	copy(rec, x[:s.XLength])
+	copy(rec[s.XLength:], d)
+	rec[len(rec)-1] = byte(typ)
+	return rec, nil
+}
+
+// Record is a decoded record in the standard schema layout
+type Record struct {
+	X        [12]byte     // Truncated big-endian x-coordinate
+	Distance [19]byte     // Little-endian distance, negative if the top byte is 0xFF
+	Type     KangarooType // Herd that found the point
+}
+
+// ParseRecord decodes a raw record in the standard schema layout
+func ParseRecord(b []byte) (Record, error) {
+	var r Record
+	if len(b) != DBRecordLength {
+		return r, fmt.Errorf("record must be %d bytes, got %d", DBRecordLength, len(b))
+	}
+	if t := KangarooType(b[DBRecordLength-1]); t > TypeWild2 {
+		return r, fmt.Errorf("invalid kangaroo type %d", t)
+	}
+
+	copy(r.X[:], b[:len(r.X)])
+	copy(r.Distance[:], b[len(r.X):len(r.X)+len(r.Distance)])
+	r.Type = KangarooType(b[DBRecordLength-1])
+	return r, nil
+}
+
+// Bytes encodes the record in the raw form stored in a FastBase
+func (r *Record) Bytes() []byte {
+	b := make([]byte, DBRecordLength)
+	copy(b, r.X[:])
+	copy(b[len(r.X):], r.Distance[:])
+	b[DBRecordLength-1] = byte(r.Type)
+	return b
+}
+
+// Prefix returns the list the record is filed under
+func (r *Record) Prefix() [3]byte {
+	return [3]byte{r.X[0], r.X[1], r.X[2]}
+}
+
+// DistanceInt returns the signed distance
+func (r *Record) DistanceInt() *big.Int {
+	return SchemaStandard.DecodeDistance(r.Distance[:])
+}
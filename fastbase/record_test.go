@@ -0,0 +1,45 @@
+package fastbase
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestNewRecord(t *testing.T) {
+	var x [32]byte
+	for i := range x {
+		x[i] = byte(i + 1)
+	}
+	for _, tc := range []struct {
+		distance *big.Int
+		typ      KangarooType
+		wantErr  bool
+	}{
+		{big.NewInt(0x0102), TypeTame, false},
+		{big.NewInt(-5), TypeWild1, false},
+		{new(big.Int).Lsh(big.NewInt(1), 140), TypeWild2, false},
+		{new(big.Int).Lsh(big.NewInt(1), 160), TypeTame, true},
+		{big.NewInt(1), 3, true},
+	} {
+		rec, err := NewRecord(x, tc.distance, tc.typ)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("NewRecord(%v, %s): %v; want an error %v", tc.distance, tc.typ, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want, _ := SchemaStandard.NewRecord(x, tc.distance, tc.typ)
+		if !bytes.Equal(rec[:], want) {
+			t.Errorf("NewRecord(%v, %s) = %x; want %x as SchemaStandard builds it", tc.distance, tc.typ, rec, want)
+		}
+		r, err := ParseRecord(rec[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r.X[:], x[:12]) || r.DistanceInt().Cmp(tc.distance) != 0 || r.Type != tc.typ {
+			t.Errorf("NewRecord(%v, %s) parses as x %x, distance %v, %s", tc.distance, tc.typ, r.X, r.DistanceInt(), r.Type)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RecoverReport describes how much of a database file could be read
+type RecoverReport struct {
+	Complete      bool    // Every list was read without error
+	Lists         int     // Non-empty lists salvaged, including a partial one
+	Records       int     // Records salvaged
+	CorruptOffset int64   // File offset where reading failed
+	CorruptPrefix [3]byte // List being read when reading failed
+	LostRecords   int     // Records the failing list claimed but could not be read
+	Err           error   // What went wrong
+}
+
+// Recover loads as much of a damaged database file as possible. Every list
+// and record read completely is kept; reading stops at the first error and
+// the report says where. An error is returned only if the header itself is
+// unusable.
+func (fb *FastBase) Recover(filename string) (RecoverReport, error) {
+	var rep RecoverReport
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return rep, err
+	}
+	defer file.Close()
+
+	countSize, err := fb.readHeader(file)
+	if err != nil {
+		return rep, err
+	}
+
+	var size int64
+	if fi, err := file.Stat(); err == nil {
+		size = fi.Size()
+	}
+	r, err := fb.decryptingReader(file)
+	if err != nil {
+		return rep, err
+	}
+	rep.Err = fb.readLists(bufio.NewReader(r), countSize, size, &rep, nil)
+	rep.Complete = rep.Err == nil
+	if !rep.Complete {
+		fb.logger().Warn("database damaged, kept what could be read", "file", filename, "records", rep.Records,
+			"offset", rep.CorruptOffset, "lost", rep.LostRecords, "err", rep.Err)
+	}
+	fb.rebuildBlooms()
+
+	fb.resetSnapshots(binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+
+	return rep, nil
+}
+
+// readLists reads the list section of a database file of the given size,
+// which starts after the header. Progress is tracked in rep and reported to
+// t after each pool. On failure the
+// list being read keeps the records read completely and later lists stay
+// empty, so the FastBase is consistent either way.
+func (fb *FastBase) readLists(r io.Reader, countSize int, size int64, rep *RecoverReport, t *progressTracker) error {
+	offset := int64(len(fb.Header))
+	for i := 0; i < 256; i++ {
+		var err error
+		if offset, err = fb.readPool(r, byte(i), countSize, offset, size, rep); err != nil {
+			return err
+		}
+		if err := fb.spillOver(); err != nil {
+			return err
+		}
+		if err := t.step(offset, rep.Records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPool reads the lists of pool i from r, which is at the given offset
+// of an input of the given size, and returns the offset after them.
+// Progress is tracked in rep.
+func (fb *FastBase) readPool(r io.Reader, i byte, countSize int, offset, size int64, rep *RecoverReport) (int64, error) {
+	recordLength := int64(fb.layout.RecordLength)
+
+	countBuf := make([]byte, 4)
+	for j := 0; j < 256; j++ {
+		for k := 0; k < 256; k++ {
+			prefix := [3]byte{i, byte(j), byte(k)}
+			rep.CorruptOffset = offset
+			rep.CorruptPrefix = prefix
+
+			// Read count in little-endian format
+			if _, err := io.ReadFull(r, countBuf[:countSize]); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return offset, &CorruptFileError{Offset: offset, Prefix: prefix, Err: err}
+			}
+			offset += int64(countSize)
+			count := binary.LittleEndian.Uint32(countBuf)
+			if countSize == 2 {
+				count &= 0xFFFF
+			}
+			if count == 0 {
+				continue
+			}
+
+			// Calculate capacity with growth factor, but never allocate
+			// for more records than the rest of the file can hold
+			grow := count / 2
+			if grow < DBMinGrowCount {
+				grow = DBMinGrowCount
+			}
+			newCap := count + grow
+			if uint64(count)+uint64(grow) > uint64(MaxListSize) {
+				newCap = MaxListSize
+			}
+			if avail := (size - offset) / recordLength; size > 0 && int64(newCap) > avail {
+				newCap = uint32(max(avail, 0))
+			}
+
+			// Allocate room for the data pointers where the prefix has
+			// a list of its own, or one shared with its neighbours at
+			// depth 2. At depth 4 the records pick among many lists.
+			if fb.index.depth != 4 {
+				list := fb.index.getOrCreate(prefix, nil)
+				if need := int(list.Count) + int(newCap); need > cap(list.Data) {
+					data := make([]uint32, list.Count, need)
+					copy(data, list.Data)
+					list.Data = data
+				}
+			}
+
+			// Read each data block
+			dataBuf := make([]byte, recordLength)
+			for m := uint32(0); m < count; m++ {
+				if _, err := io.ReadFull(r, dataBuf); err != nil {
+					rep.CorruptOffset = offset
+					rep.LostRecords = int(count - m)
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return offset, &CorruptFileError{Offset: offset, Prefix: prefix, Err: err}
+				}
+				offset += recordLength
+
+				// Copy the data block into its pool
+				ptr, _, err := fb.storeRecord(prefix, dataBuf)
+				if err != nil {
+					rep.CorruptOffset = offset
+					rep.LostRecords = int(count - m)
+					return offset, fmt.Errorf("error allocating memory at [%02x][%02x][%02x]: %w", i, j, k, err)
+				}
+
+				if m == 0 {
+					rep.Lists++
+				}
+				list := fb.index.getOrCreate(prefix, dataBuf)
+				list.Data = append(list.Data, ptr)
+				list.Count = uint32(len(list.Data))
+				list.Capacity = uint32(cap(list.Data))
+				rep.Records++
+			}
+		}
+	}
+
+	return offset, nil
+}
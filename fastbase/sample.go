@@ -0,0 +1,45 @@
+package fastbase
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Sample returns n records passing all filters, chosen uniformly at random
+// from the whole database in a single pass (reservoir sampling), or every
+// such record if there are no more than n. The matches are in iteration
+// order and hold copies of the records.
+func (fb *FastBase) Sample(n int, rng *rand.Rand, filters ...Filter) []Match {
+	if n <= 0 {
+		return nil
+	}
+	type entry struct {
+		pos int // Position in iteration order
+		m   Match
+	}
+	var reservoir []entry
+	seen := 0
+	fb.ForEach(func(prefix [3]byte, record []byte) bool {
+		seen++
+		slot := len(reservoir)
+		if slot >= n {
+			if slot = rng.Intn(seen); slot >= n {
+				return true
+			}
+		}
+		e := entry{pos: seen, m: Match{Prefix: prefix, Record: append([]byte(nil), record...)}}
+		if slot == len(reservoir) {
+			reservoir = append(reservoir, e)
+		} else {
+			reservoir[slot] = e
+		}
+		return true
+	}, filters...)
+
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].pos < reservoir[j].pos })
+	matches := make([]Match, len(reservoir))
+	for i, e := range reservoir {
+		matches[i] = e.m
+	}
+	return matches
+}
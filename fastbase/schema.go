@@ -0,0 +1,275 @@
+package fastbase
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Schema describes how a record is split into a truncated x-coordinate,
+// a little-endian distance and the trailing kangaroo type byte
+type Schema struct {
+	ID             byte   // Identifier stored in the file header
+	Name           string // Human-readable name
+	XLength        int    // Bytes of x-coordinate at the start of the record
+	DistanceLength int    // Bytes of distance following the x-coordinate
+	RecordLength   int    // Bytes per record, including the type byte
+}
+
+var (
+	// SchemaStandard is the original layout: x[12], distance[19], type
+	SchemaStandard = Schema{ID: 0, Name: "standard", XLength: 12, DistanceLength: 19, RecordLength: 32}
+
+	// SchemaWideDistance trades x-coordinate bytes for a 24-byte distance,
+	// for ranges whose distances overflow 19 bytes: x[7], distance[24], type
+	SchemaWideDistance = Schema{ID: 1, Name: "wide-distance", XLength: 7, DistanceLength: 24, RecordLength: 32}
+
+	// SchemaLong keeps the standard x-coordinate and grows records to 40
+	// bytes for a 27-byte distance, for ranges above 2^135 where losing
+	// x-coordinate bytes would make false collisions likely:
+	// x[12], distance[27], type
+	SchemaLong = Schema{ID: 2, Name: "long", XLength: 12, DistanceLength: 27, RecordLength: 40}
+
+	schemas = []Schema{SchemaStandard, SchemaWideDistance, SchemaLong}
+)
+
+// SchemaByID returns the schema stored under a header identifier
+func SchemaByID(id byte) (Schema, error) {
+	for _, s := range schemas {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("unknown record schema %d", id)
+}
+
+// SchemaNames returns the names of all schemas, for usage messages
+func SchemaNames() []string {
+	names := make([]string, len(schemas))
+	for i, s := range schemas {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// SchemaByName returns the schema with the given name
+func SchemaByName(name string) (Schema, error) {
+	for _, s := range schemas {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Schema{}, fmt.Errorf("unknown record schema %q", name)
+}
+
+// X returns the x-coordinate part of a record
+func (s Schema) X(rec []byte) []byte {
+	return rec[:s.XLength]
+}
+
+// Distance returns the distance part of a record
+func (s Schema) Distance(rec []byte) []byte {
+	return rec[s.XLength : s.XLength+s.DistanceLength]
+}
+
+// IsDP reports whether the x-coordinate starting rec, as truncated by the
+// schema, ends in dpBits zero bits; see kangaroo.IsDP
+func (s Schema) IsDP(rec []byte, dpBits int) bool {
+	stored := rec[:s.XLength]
+	for i := len(stored) - 1; dpBits > 0; i-- {
+		mask := byte(0xFF)
+		if dpBits < 8 {
+			mask = byte(1<<dpBits - 1)
+		}
+		if stored[i]&mask != 0 {
+			return false
+		}
+		dpBits -= 8
+	}
+	return true
+}
+
+// Type returns the kangaroo type of a record
+func (s Schema) Type(rec []byte) KangarooType {
+	return KangarooType(rec[len(rec)-1])
+}
+
+// maxDistance returns the bound that distance magnitudes must stay below.
+// The top byte is reserved so negative distances can be marked with 0xFF,
+// as the C++ solver does.
+func (s Schema) maxDistance() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(8*(s.DistanceLength-1)))
+}
+
+// EncodeDistance encodes d as a little-endian distance field, failing if
+// it does not fit the schema
+func (s Schema) EncodeDistance(d *big.Int) ([]byte, error) {
+	limit := s.maxDistance()
+	if d.Cmp(limit) >= 0 || d.Cmp(new(big.Int).Neg(limit)) < 0 {
+		return nil, fmt.Errorf("distance %s does not fit the %d-byte distance field of the %s schema",
+			d.String(), s.DistanceLength, s.Name)
+	}
+
+	v := new(big.Int).Set(d)
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), uint(8*s.DistanceLength)))
+	}
+	be := v.FillBytes(make([]byte, s.DistanceLength))
+	out := make([]byte, s.DistanceLength)
+	for i := range be {
+		out[len(be)-1-i] = be[i]
+	}
+	return out, nil
+}
+
+// DecodeDistance decodes a little-endian distance field. A top byte of
+// 0xFF marks a negative distance.
+func (s Schema) DecodeDistance(field []byte) *big.Int {
+	be := make([]byte, len(field))
+	for i := range field {
+		be[len(field)-1-i] = field[i]
+	}
+	v := new(big.Int).SetBytes(be)
+	if len(field) > 0 && field[len(field)-1] == 0xFF {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*len(field))))
+	}
+	return v
+}
+
+// Schema returns the record schema recorded in the header
+func (fb *FastBase) Schema() Schema {
+	s, err := SchemaByID(fb.Header[HeaderSchema])
+	if err != nil {
+		return SchemaStandard
+	}
+	return s
+}
+
+// Migrate re-encodes every record into another schema and re-sorts the
+// lists. Moving to a wider distance keeps every distance intact but drops
+// the trailing x-coordinate bytes that no longer fit; moving to a narrower
+// distance fails without changing anything if any distance would overflow.
+// When the schemas differ in record length, records move to new pools of
+// the new length, keeping any metadata bytes of the layout.
+func (fb *FastBase) Migrate(to Schema) error {
+	from := fb.Schema()
+	if from.ID == to.ID {
+		return nil
+	}
+	if to.XLength > from.XLength {
+		return fmt.Errorf("cannot migrate from %s to %s: x-coordinate bytes were already truncated", from.Name, to.Name)
+	}
+
+	// Check every distance fits before touching anything
+	var err error
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		_, err = to.EncodeDistance(from.DecodeDistance(from.Distance(rec)))
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every record changes, so spilled pools come back for good, and all
+	// stay in memory until the migration is done
+	if t := fb.tier; t != nil {
+		for i := 0; i < 256; i++ {
+			if err := fb.modify(byte(i)); err != nil {
+				return err
+			}
+		}
+		fb.tier = nil
+		defer func() {
+			fb.tier = t
+			t.grown = true
+		}()
+	}
+
+	// convert re-encodes src into dst, which may be the same record
+	convert := func(dst, src []byte) {
+		d, _ := to.EncodeDistance(from.DecodeDistance(from.Distance(src)))
+		meta := src[from.RecordLength-1 : len(src)-1]
+		typ := src[len(src)-1]
+		copy(dst, src[:to.XLength])
+		copy(dst[to.XLength:], d)
+		copy(dst[to.RecordLength-1:], meta)
+		dst[len(dst)-1] = typ
+	}
+
+	if to.RecordLength == from.RecordLength {
+		fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+			convert(rec, rec)
+			return true
+		})
+	} else {
+		layout := fb.layout
+		layout.RecordLength += to.RecordLength - from.RecordLength
+		layout.CompareLength += to.RecordLength - from.RecordLength
+		if err := fb.repack(layout, convert); err != nil {
+			return err
+		}
+	}
+	fb.Header[HeaderSchema] = to.ID
+
+	// The key bytes changed, so restore the sort order lookups rely on. The
+	// x-coordinate, and so the list of each record, stays the same.
+	fb.eachList(fb.sortList)
+	fb.rebuildBlooms()
+
+	return nil
+}
+
+// repack moves every record into new pools laid out for layout, passing
+// each old record and its new home to convert. It fails without changing
+// anything if the new pools could not hold the records. Records tracked
+// for delta saves move too, so the delta log is restarted.
+func (fb *FastBase) repack(layout Layout, convert func(dst, src []byte)) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	var probe MemPool
+	probe.setLayout(layout)
+	perPage, maxPages := int(probe.perPage), probe.MaxPages()
+	for i := 0; i < 256; i++ {
+		records := 0
+		fb.eachListIn(byte(i), func(list *ListRecord) {
+			records += int(list.Count)
+		})
+		if pages := (records + perPage - 1) / perPage; pages > maxPages {
+			return fmt.Errorf("pool %02x would need %d pages, more than the %d allowed", i, pages, maxPages)
+		}
+	}
+
+	for i := 0; i < 256; i++ {
+		old := fb.Pools[i]
+		fb.Pools[i] = MemPool{}
+		pool := &fb.Pools[i]
+		pool.setLayout(layout)
+
+		fb.eachListIn(byte(i), func(list *ListRecord) {
+			for m := uint32(0); m < list.Count; m++ {
+				// Checked above, so allocation cannot run out of pages
+				ptr, mem, _ := pool.allocRecord()
+				convert(mem, old.GetRecordPtr(list.Data[m]))
+				pool.setTag(ptr, old.tag(list.Data[m]))
+				list.Data[m] = ptr
+			}
+		})
+	}
+
+	fb.setLayout(layout)
+	fb.resetSnapshots(fb.SnapshotID())
+	return nil
+}
+
+// sortList sorts the record pointers of a list of pool i by record key
+func (fb *FastBase) sortList(i byte, list *ListRecord) {
+	if list.Count < 2 {
+		return
+	}
+	pool := &fb.Pools[i]
+	data := list.Data[:list.Count]
+	sort.Slice(data, func(a, b int) bool {
+		return fb.compareEntries(pool, data[a], data[b]) < 0
+	})
+}
@@ -0,0 +1,288 @@
+package fastbase
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// ManifestName is the file of a shard directory that describes its shards
+const ManifestName = "manifest.json"
+
+// ShardManifest describes a database saved by SaveSharded: one shard file
+// per pool, each holding a database header and the lists of its pool as in
+// a database file
+type ShardManifest struct {
+	Header   string      `json:"header"`   // Hex database header, with zero snapshot ID
+	Snapshot string      `json:"snapshot"` // Snapshot ID of the save, in hex
+	Records  int         `json:"records"`  // Records in all shards
+	Shards   []ShardInfo `json:"shards"`   // One entry per pool, in pool order
+}
+
+// ShardInfo describes one shard file
+type ShardInfo struct {
+	Pool    int    `json:"pool"`    // Pool, the first prefix byte of every record
+	File    string `json:"file"`    // File name within the shard directory
+	Records int    `json:"records"` // Records in the shard
+	Size    int64  `json:"size"`    // File size in bytes
+	SHA256  string `json:"sha256"`  // Hex SHA-256 of the file
+}
+
+// shardFileName returns the name of the shard file of pool i
+func shardFileName(i int) string {
+	return fmt.Sprintf("pool-%02x.shard", i)
+}
+
+// ReadShardManifest reads the manifest of a shard directory
+func ReadShardManifest(dir string) (ShardManifest, error) {
+	var m ShardManifest
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid shard manifest: %v", err)
+	}
+	if len(m.Shards) != 256 {
+		return m, fmt.Errorf("invalid shard manifest: %d shards instead of 256", len(m.Shards))
+	}
+	for i, s := range m.Shards {
+		if s.Pool != i || s.File != filepath.Base(s.File) {
+			return m, fmt.Errorf("invalid shard manifest: bad entry for pool %02x", i)
+		}
+	}
+	return m, nil
+}
+
+// SaveSharded saves the FastBase to dir as 256 shard files, one per pool,
+// written in parallel, plus a manifest. Shards carry no snapshot ID, so a
+// pool that has not changed since the last save produces the same file,
+// which is then left untouched for rsync and the like to skip. The manifest
+// is replaced last; a save interrupted before then leaves shards that no
+// longer match the old manifest's checksums, which LoadSharded reports.
+func (fb *FastBase) SaveSharded(dir string) error {
+	if fb.Encrypted() {
+		return errors.New("encrypted databases can only be saved whole")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	old, _ := ReadShardManifest(dir)
+
+	header := fb.Header
+	fb.layout.putHeader(&header)
+	binary.LittleEndian.PutUint64(header[HeaderSnapshot:], 0)
+	header[HeaderVersion] = FormatV1
+
+	m := ShardManifest{Header: hex.EncodeToString(header[:]), Shards: make([]ShardInfo, 256)}
+	err := fb.eachPool(func(i int) error {
+		var prev *ShardInfo
+		if len(old.Shards) == 256 {
+			prev = &old.Shards[i]
+		}
+		info, err := fb.saveShard(dir, i, header, prev)
+		m.Shards[i] = info
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshotID := newSnapshotID()
+	m.Snapshot = strconv.FormatUint(snapshotID, 16)
+	for _, s := range m.Shards {
+		m.Records += s.Records
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestName+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, ManifestName)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fb.commitSnapshot(snapshotID)
+	return nil
+}
+
+// saveShard writes the shard of pool i under header, keeping the existing
+// file when prev says it already has the same content
+func (fb *FastBase) saveShard(dir string, i int, header [256]byte, prev *ShardInfo) (ShardInfo, error) {
+	info := ShardInfo{Pool: i, File: shardFileName(i)}
+	path := filepath.Join(dir, info.File)
+
+	countSize := 2
+	if fb.maxListCount(i, i+1) > MaxListSizeV1 {
+		header[HeaderVersion] = FormatV2
+		countSize = 4
+	}
+	fb.eachListIn(byte(i), func(list *ListRecord) {
+		info.Records += int(list.Count)
+	})
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return info, err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	h := sha256.New()
+	w := bufio.NewWriterSize(io.MultiWriter(file, h), 1<<20)
+	w.Write(header[:])
+	if err := fb.writeLists(w, i, i+1, countSize, 0, 0, nil, nil); err != nil {
+		return info, err
+	}
+	if err := w.Flush(); err != nil {
+		return info, err
+	}
+	if err := file.Close(); err != nil {
+		return info, err
+	}
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	info.Size = int64(len(header)) + 1<<16*int64(countSize) + int64(info.Records)*int64(fb.layout.RecordLength)
+
+	if prev != nil && prev.SHA256 == info.SHA256 {
+		if fi, err := os.Stat(path); err == nil && fi.Size() == info.Size {
+			return info, nil
+		}
+	}
+	return info, os.Rename(tmp, path)
+}
+
+// LoadSharded replaces the contents of the FastBase with a database saved
+// by SaveSharded, reading shards in parallel. When pools are given only
+// their shards are loaded and the other pools stay empty; saving such a
+// partial FastBase over the full database would empty those pools. Every
+// shard read is checked against the size and checksum in the manifest.
+func (fb *FastBase) LoadSharded(dir string, pools ...byte) error {
+	m, err := ReadShardManifest(dir)
+	if err != nil {
+		return err
+	}
+	header, err := hex.DecodeString(m.Header)
+	if err != nil || len(header) != len(fb.Header) {
+		return fmt.Errorf("invalid shard manifest: bad header")
+	}
+	snapshotID, err := strconv.ParseUint(m.Snapshot, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid shard manifest: bad snapshot %q", m.Snapshot)
+	}
+	if _, err := fb.readHeader(bytes.NewReader(header)); err != nil {
+		return err
+	}
+
+	want := make([]bool, 256)
+	for _, i := range pools {
+		want[i] = true
+	}
+	err = fb.eachPool(func(i int) error {
+		if len(pools) > 0 && !want[i] {
+			return nil
+		}
+		return fb.loadShard(dir, m.Shards[i])
+	})
+	if err != nil {
+		fb.Clear()
+		return err
+	}
+
+	fb.rebuildBlooms()
+	binary.LittleEndian.PutUint64(fb.Header[HeaderSnapshot:], snapshotID)
+	fb.resetSnapshots(snapshotID)
+	return nil
+}
+
+// loadShard reads one shard file into its pool
+func (fb *FastBase) loadShard(dir string, info ShardInfo) error {
+	file, err := os.Open(filepath.Join(dir, info.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if fi, err := file.Stat(); err != nil {
+		return err
+	} else if fi.Size() != info.Size {
+		return fmt.Errorf("shard %s is %d bytes, the manifest says %d", info.File, fi.Size(), info.Size)
+	}
+
+	h := sha256.New()
+	r := bufio.NewReaderSize(io.TeeReader(file, h), 1<<20)
+
+	// The shard header is the manifest's apart from the count size
+	var header [256]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("shard %s: error reading header: %v", info.File, err)
+	}
+	var countSize int
+	switch header[HeaderVersion] {
+	case FormatV1:
+		countSize = 2
+	case FormatV2:
+		countSize = 4
+	default:
+		return fmt.Errorf("shard %s: unsupported file format version %d", info.File, header[HeaderVersion])
+	}
+	header[HeaderVersion] = fb.Header[HeaderVersion]
+	if header != fb.Header {
+		return fmt.Errorf("shard %s: header does not match the manifest", info.File)
+	}
+
+	var rep RecoverReport
+	if _, err := fb.readPool(r, byte(info.Pool), countSize, int64(len(header)), info.Size, &rep); err != nil {
+		return fmt.Errorf("shard %s: %w", info.File, err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != info.SHA256 {
+		return fmt.Errorf("shard %s does not match its checksum in the manifest", info.File)
+	}
+	return nil
+}
+
+// forEachPool calls fn for each pool index from as many goroutines as there
+// are CPUs, returning the first error. Calls for different pools touch
+// disjoint parts of a FastBase, so they may run concurrently.
+func forEachPool(fn func(i int) error) error {
+	next := make(chan int)
+	errs := make(chan error, 256)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(i); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for i := 0; i < 256; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
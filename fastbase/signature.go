@@ -0,0 +1,187 @@
+package fastbase
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SignatureSuffix is appended to a database file name for the file holding
+// its signature. The database itself is left as is, so signed files stay
+// readable by the C++ RCKangaroo.
+const SignatureSuffix = ".sig"
+
+// signatureContext separates database signatures from anything else the
+// same key might sign
+const signatureContext = "rckangaroo fastbase"
+
+// ErrUnsigned reports a database file without a signature file
+var ErrUnsigned = errors.New("database is not signed")
+
+// TrustedKeys maps the names of the keys whose signatures are accepted to
+// their public keys
+type TrustedKeys map[string]ed25519.PublicKey
+
+// LoadTrustedKeys reads public keys from a file of "name hexkey" lines, as
+// printed when a key is generated. Empty lines and lines starting with '#'
+// are skipped.
+func LoadTrustedKeys(path string) (TrustedKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(TrustedKeys)
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"name hexkey\"", path, lineNo)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: key must be %d hex bytes", path, lineNo, ed25519.PublicKeySize)
+		}
+		keys[fields[0]] = key
+	}
+	return keys, scanner.Err()
+}
+
+// name returns the name of a trusted key
+func (tk TrustedKeys) name(key ed25519.PublicKey) (string, bool) {
+	for name, k := range tk {
+		if k.Equal(key) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// GenerateSigningKey creates a key for signing databases and writes it to
+// path as a PKCS #8 PEM file readable only by its owner. It returns the
+// public key, which verifiers list in their trusted keys.
+func GenerateSigningKey(path string) (ed25519.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	return pub, file.Close()
+}
+
+// LoadSigningKey reads a key written by GenerateSigningKey
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// SignFile signs a database file, writing the signature and the public key
+// that checks it to filename+SignatureSuffix. The whole file is signed, so
+// sign it again after every save.
+func SignFile(filename string, key ed25519.PrivateKey) error {
+	digest, err := fileDigest(filename)
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign(nil, digest, &ed25519.Options{Hash: crypto.SHA512, Context: signatureContext})
+	if err != nil {
+		return err
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	line := fmt.Sprintf("ed25519 %x %x\n", pub, sig)
+	return os.WriteFile(filename+SignatureSuffix, []byte(line), 0644)
+}
+
+// VerifyFile checks the signature of a database file against the trusted
+// keys and returns the name of the key that signed it. It returns
+// ErrUnsigned if the file has no signature file, and an error if it was
+// signed by an unknown key or changed since it was signed.
+func VerifyFile(filename string, trusted TrustedKeys) (string, error) {
+	data, err := os.ReadFile(filename + SignatureSuffix)
+	if os.IsNotExist(err) {
+		return "", ErrUnsigned
+	}
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 || fields[0] != "ed25519" {
+		return "", fmt.Errorf("%s%s is not a signature file", filename, SignatureSuffix)
+	}
+	pub, err1 := hex.DecodeString(fields[1])
+	sig, err2 := hex.DecodeString(fields[2])
+	if err1 != nil || err2 != nil || len(pub) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("%s%s is not a signature file", filename, SignatureSuffix)
+	}
+
+	name, ok := trusted.name(pub)
+	if !ok {
+		return "", fmt.Errorf("signed by untrusted key %x", pub)
+	}
+	digest, err := fileDigest(filename)
+	if err != nil {
+		return "", err
+	}
+	opts := &ed25519.Options{Hash: crypto.SHA512, Context: signatureContext}
+	if err := ed25519.VerifyWithOptions(pub, digest, sig, opts); err != nil {
+		return "", fmt.Errorf("signature by %s does not match; the file was changed after signing", name)
+	}
+	return name, nil
+}
+
+// fileDigest returns the SHA-512 digest of a file
+func fileDigest(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := sha512.New()
+	if _, err := io.Copy(h, bufio.NewReaderSize(file, 1<<20)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,152 @@
+package fastbase
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signedTestDB saves a database of n records, signs it with a new key and
+// returns its path with the keys trusted to verify it
+func signedTestDB(t *testing.T, n int) (string, TrustedKeys) {
+	t.Helper()
+	path := savedTestDB(t, n)
+	keyPath := filepath.Join(t.TempDir(), "signing.pem")
+	pub, err := GenerateSigningKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadSigningKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	return path, TrustedKeys{"alice": pub}
+}
+
+// flipSignatureByte flips one bit of the signature in the signature file of
+// filename, leaving the file well-formed
+func flipSignatureByte(t *testing.T, filename string) {
+	t.Helper()
+	data, err := os.ReadFile(filename + SignatureSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(data))
+	sig, err := hex.DecodeString(fields[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[len(sig)/2] ^= 0x01
+	line := fmt.Sprintf("%s %s %x\n", fields[0], fields[1], sig)
+	if err := os.WriteFile(filename+SignatureSuffix, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	path, trusted := signedTestDB(t, 100)
+	name, err := VerifyFile(path, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "alice" {
+		t.Errorf("signed by %q; want alice", name)
+	}
+
+	// A key written by GenerateSigningKey is never overwritten
+	keyPath := filepath.Join(t.TempDir(), "signing.pem")
+	if _, err := GenerateSigningKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateSigningKey(keyPath); err == nil {
+		t.Error("GenerateSigningKey overwrote an existing key")
+	}
+}
+
+func TestVerifyFileTampered(t *testing.T) {
+	info, err := os.Stat(savedTestDB(t, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		tamper func(t *testing.T, path string)
+	}{
+		{"header", func(t *testing.T, path string) { flipTestByte(t, path, 100) }},
+		{"body", func(t *testing.T, path string) { flipTestByte(t, path, info.Size()/2) }},
+		{"last byte", func(t *testing.T, path string) { flipTestByte(t, path, info.Size()-1) }},
+		{"signature", flipSignatureByte},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, trusted := signedTestDB(t, 100)
+			tc.tamper(t, path)
+			if name, err := VerifyFile(path, trusted); err == nil {
+				t.Errorf("tampered file verified as signed by %s", name)
+			}
+		})
+	}
+}
+
+func TestVerifyFileUntrusted(t *testing.T) {
+	path, _ := signedTestDB(t, 100)
+	_, other := signedTestDB(t, 1)
+	if name, err := VerifyFile(path, other); err == nil {
+		t.Errorf("file signed by an untrusted key verified as signed by %s", name)
+	}
+	if name, err := VerifyFile(path, nil); err == nil {
+		t.Errorf("file verified as signed by %s with no trusted keys", name)
+	}
+
+	// Replacing the public key in the signature file with a trusted one
+	// does not make the signature match
+	data, err := os.ReadFile(path + SignatureSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(string(data))
+	line := fmt.Sprintf("%s %x %s\n", fields[0], other["alice"], fields[2])
+	if err := os.WriteFile(path+SignatureSuffix, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := VerifyFile(path, other); err == nil {
+		t.Errorf("signature under a swapped public key verified as signed by %s", name)
+	}
+}
+
+func TestVerifyFileUnsigned(t *testing.T) {
+	path := savedTestDB(t, 10)
+	if _, err := VerifyFile(path, nil); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("verifying an unsigned file gave %v; want ErrUnsigned", err)
+	}
+	if err := os.WriteFile(path+SignatureSuffix, []byte("not a signature\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyFile(path, nil); err == nil || errors.Is(err, ErrUnsigned) {
+		t.Errorf("verifying a malformed signature file gave %v; want an error", err)
+	}
+}
+
+// flipTestByte flips one bit of the byte of filename at offset
+func flipTestByte(t *testing.T, filename string, offset int64) {
+	t.Helper()
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0x01
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+package fastbase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"rckangaroo/sqlite"
+)
+
+// sqliteSchema creates the tables of a FastBase kept in SQLite. Records
+// are indexed by the prefix they are filed under and split into columns so
+// they can be queried with SQL; the whole record is kept too, so loading
+// gives back the database as saved.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS header (
+	id   INTEGER PRIMARY KEY CHECK (id = 0),
+	data BLOB NOT NULL -- The 256-byte header of a database file
+);
+CREATE TABLE IF NOT EXISTS records (
+	prefix   INTEGER NOT NULL, -- The 3 prefix bytes as a big-endian number
+	x        BLOB NOT NULL,    -- Truncated x-coordinate, starting with the prefix
+	distance BLOB NOT NULL,    -- Little-endian, negative in two's complement
+	type     INTEGER NOT NULL, -- 0 tame, 1 wild1, 2 wild2
+	worker   INTEGER,          -- Provenance, in databases that record it
+	time     INTEGER,          -- Unix seconds
+	record   BLOB NOT NULL     -- The record as the database lays it out
+);
+CREATE INDEX IF NOT EXISTS records_prefix ON records (prefix);
+`
+
+// SaveToSQLite replaces the contents of the SQLite database file at path
+// with the FastBase in one transaction, creating the file if missing, so
+// it holds either the previous save or this one whole. The snapshot only
+// becomes the base of later delta saves once the transaction commits.
+// Encrypted databases can't be kept in SQLite.
+func (fb *FastBase) SaveToSQLite(path string) (err error) {
+	if fb.Encrypted() {
+		return errors.New("encrypted databases cannot be saved to SQLite")
+	}
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Exec(sqliteSchema + "BEGIN IMMEDIATE;"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			db.Exec("ROLLBACK;")
+		}
+	}()
+
+	header := fb.Header
+	fb.layout.putHeader(&header)
+	snapshotID := newSnapshotID()
+	binary.LittleEndian.PutUint64(header[HeaderSnapshot:], snapshotID)
+	header[HeaderVersion] = FormatV2
+	if err := db.Exec("DELETE FROM header; DELETE FROM records;"); err != nil {
+		return err
+	}
+	if err := execOnce(db, "INSERT INTO header (id, data) VALUES (0, ?)", header[:]); err != nil {
+		return err
+	}
+
+	insert, err := db.Prepare("INSERT INTO records (prefix, x, distance, type, worker, time, record) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	schema := fb.Schema()
+	fb.ForEach(func(prefix [3]byte, rec []byte) bool {
+		var worker, secs interface{}
+		if p, ok := fb.Provenance(rec); ok {
+			worker = p.Worker
+			if !p.Time.IsZero() {
+				secs = p.Time.Unix()
+			}
+		}
+		err = insert.Exec(prefixIndex(prefix), schema.X(rec), schema.Distance(rec), int(schema.Type(rec)), worker, secs, rec)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Exec("COMMIT;"); err != nil {
+		return err
+	}
+	fb.commitSnapshot(snapshotID)
+	return nil
+}
+
+// LoadFromSQLite replaces the contents of the FastBase with a database
+// saved to the SQLite database file at path by SaveToSQLite
+func (fb *FastBase) LoadFromSQLite(path string) error {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var header []byte
+	err = queryOnce(db, "SELECT data FROM header WHERE id = 0", func(r sqlite.Row) error {
+		header = r.Blob(0)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s holds no FastBase: %v", path, err)
+	}
+	if len(header) != len(fb.Header) {
+		return fmt.Errorf("%s holds no FastBase header", path)
+	}
+	if _, err := fb.readHeader(bytes.NewReader(header)); err != nil {
+		return err
+	}
+
+	// Records come in prefix order, so each one extends its list
+	n := fb.layout.RecordLength
+	err = queryOnce(db, "SELECT prefix, record FROM records ORDER BY prefix, record", func(r sqlite.Row) error {
+		p := r.Int(0)
+		rec := r.Blob(1)
+		if len(rec) != n {
+			return fmt.Errorf("record of prefix %06x is %d bytes, not %d", p, len(rec), n)
+		}
+		_, err := fb.AddRecord(byte(p>>16), byte(p>>8), byte(p), rec)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fb.resetSnapshots(binary.LittleEndian.Uint64(fb.Header[HeaderSnapshot:]))
+	return nil
+}
+
+// execOnce prepares and runs a statement with args
+func execOnce(db *sqlite.Conn, sql string, args ...interface{}) error {
+	return queryOnce(db, sql, nil, args...)
+}
+
+// queryOnce prepares and runs a query, calling fn for each result row
+func queryOnce(db *sqlite.Conn, sql string, fn func(r sqlite.Row) error, args ...interface{}) error {
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	return stmt.Query(fn, args...)
+}
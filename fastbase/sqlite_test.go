@@ -0,0 +1,69 @@
+//go:build sqlite
+
+package fastbase
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"provenance", []Option{WithProvenance()}},
+		{"small pages", []Option{WithPageSize(1 << 12)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stamp := Provenance{Worker: WorkerID("alice"), Time: time.Unix(1700000000, 0)}
+			build := func(records int) *FastBase {
+				fb := NewFastBase(tc.opts...)
+				for n := 0; n < records; n++ {
+					prefix, rec := testRecord(t, n, KangarooType(n%3))
+					rec = fb.Widen(rec)
+					fb.SetProvenance(rec, stamp)
+					if _, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], rec); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return fb
+			}
+			fb := build(2000)
+			fb.Header[HeaderRange] = 76
+
+			path := filepath.Join(t.TempDir(), "test.sqlite")
+			if err := fb.SaveToSQLite(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewFastBase()
+			if err := loaded.LoadFromSQLite(path); err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.Equal(fb) {
+				t.Error("database loaded from SQLite differs from the one saved")
+			}
+			if loaded.Header[HeaderRange] != 76 {
+				t.Errorf("range %d after loading; want 76", loaded.Header[HeaderRange])
+			}
+			if loaded.SnapshotID() == 0 {
+				t.Error("database loaded from SQLite has no snapshot ID")
+			}
+
+			// Saving again replaces what the file held
+			less := build(10)
+			if err := less.SaveToSQLite(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded = NewFastBase()
+			if err := loaded.LoadFromSQLite(path); err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.Equal(less) {
+				t.Error("saving over a SQLite database kept records of the earlier save")
+			}
+		})
+	}
+}
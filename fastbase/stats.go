@@ -0,0 +1,216 @@
+package fastbase
+
+import (
+	"bytes"
+	"container/heap"
+)
+
+// TypeStats holds statistics for a single kangaroo type
+type TypeStats struct {
+	Count         int     // Number of records of this type
+	MaxListSize   uint32  // Most records of this type found in a single list
+	MaxListPrefix [3]byte // Prefix of the list holding MaxListSize records
+}
+
+// HistogramBucket counts the lists whose size falls within [Min, Max]
+type HistogramBucket struct {
+	Min   uint32 // Smallest list size in the bucket
+	Max   uint32 // Largest list size in the bucket
+	Lists int    // Number of lists in the bucket
+}
+
+// PoolStats holds statistics for the memory pool of one first-byte prefix
+type PoolStats struct {
+	Records int // Records stored in the pool
+	Pages   int // Memory pages allocated by the pool
+}
+
+// StatsReport summarizes the contents of a FastBase
+type StatsReport struct {
+	TotalLists        int               // Number of prefix lists (256^3)
+	NonEmptyLists     int               // Lists holding at least one record
+	TotalRecords      int               // Records across all lists
+	MaxListSize       uint32            // Size of the fullest list
+	MaxListPrefix     [3]byte           // Prefix of the fullest list
+	Types             [3]TypeStats      // Per-type statistics (tame, wild1, wild2)
+	ListSizeHistogram []HistogramBucket // Distribution of list sizes
+	Pools             [256]PoolStats    // Per-pool record and page counts
+}
+
+// AverageListSize returns the mean number of records per non-empty list
+func (r *StatsReport) AverageListSize() float64 {
+	if r.NonEmptyLists == 0 {
+		return 0
+	}
+	return float64(r.TotalRecords) / float64(r.NonEmptyLists)
+}
+
+// listSizeBuckets are the upper bounds of the list-size histogram buckets
+var listSizeBuckets = []uint32{0, 10, 100, 1000, 10000, MaxListSizeV1, MaxListSize}
+
+// newListSizeHistogram returns empty histogram buckets
+func newListSizeHistogram() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(listSizeBuckets))
+	lower := uint32(0)
+	for i, upper := range listSizeBuckets {
+		buckets[i] = HistogramBucket{Min: lower, Max: upper}
+		lower = upper + 1
+	}
+	return buckets
+}
+
+// Stats walks every list and returns a summary of the FastBase contents.
+// With filters, only records passing all of them are counted, and list
+// sizes are the number of matching records in each list.
+func (fb *FastBase) Stats(filters ...Filter) StatsReport {
+	report := StatsReport{
+		TotalLists:        256 * 256 * 256,
+		ListSizeHistogram: newListSizeHistogram(),
+	}
+
+	for i := range fb.Pools {
+		report.Pools[i].Pages = len(fb.Pools[i].Pages)
+	}
+
+	visited := 0
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		visited++
+
+		// Count kangaroos by type in this list
+		count := uint32(0)
+		typeCountsInList := [3]uint32{0, 0, 0}
+		for _, run := range runs {
+			for _, ptr := range run {
+				mem := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+				if !matchAll(filters, prefix, mem) {
+					continue
+				}
+				count++
+				kangType := mem[len(mem)-1]
+				if kangType < 3 {
+					typeCountsInList[kangType]++
+					report.Types[kangType].Count++
+				}
+			}
+		}
+
+		for b := range report.ListSizeHistogram {
+			if count <= report.ListSizeHistogram[b].Max {
+				report.ListSizeHistogram[b].Lists++
+				break
+			}
+		}
+
+		if count == 0 {
+			return true
+		}
+
+		report.NonEmptyLists++
+		report.TotalRecords += int(count)
+		report.Pools[prefix[0]].Records += int(count)
+		if count > report.MaxListSize {
+			report.MaxListSize = count
+			report.MaxListPrefix = prefix
+		}
+
+		// Update max lists for each type
+		for t := 0; t < 3; t++ {
+			if typeCountsInList[t] > report.Types[t].MaxListSize {
+				report.Types[t].MaxListSize = typeCountsInList[t]
+				report.Types[t].MaxListPrefix = prefix
+			}
+		}
+		return true
+	})
+
+	// Prefixes without records all fall in the first bucket
+	report.ListSizeHistogram[0].Lists += report.TotalLists - visited
+
+	return report
+}
+
+// ListStats describes a single prefix list
+type ListStats struct {
+	Prefix     [3]byte   // Prefix of the list
+	Count      uint32    // Records in the list
+	TypeCounts [3]uint32 // Records per type (tame, wild1, wild2)
+}
+
+// listHeap is a min-heap of lists ordered by size, used to keep the N largest
+type listHeap []ListStats
+
+func (h listHeap) Len() int { return len(h) }
+func (h listHeap) Less(a, b int) bool {
+	if h[a].Count != h[b].Count {
+		return h[a].Count < h[b].Count
+	}
+	// On equal size, prefer keeping the lower prefix
+	return bytes.Compare(h[a].Prefix[:], h[b].Prefix[:]) > 0
+}
+func (h listHeap) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *listHeap) Push(x interface{}) { *h = append(*h, x.(ListStats)) }
+func (h *listHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// TopLists returns the n fullest lists, largest first, with per-type
+// counts. With filters, lists are ranked by their matching records only.
+func (fb *FastBase) TopLists(n int, filters ...Filter) []ListStats {
+	if n <= 0 {
+		return nil
+	}
+
+	h := make(listHeap, 0, n+1)
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		count := fb.countMatching(prefix, runs, filters)
+		if count == 0 || (len(h) == n && count <= h[0].Count) {
+			return true
+		}
+		heap.Push(&h, ListStats{Prefix: prefix, Count: count})
+		if len(h) > n {
+			heap.Pop(&h)
+		}
+		return true
+	})
+
+	top := make([]ListStats, len(h))
+	for idx := len(top) - 1; idx >= 0; idx-- {
+		top[idx] = heap.Pop(&h).(ListStats)
+	}
+
+	// Per-type breakdown only for the lists that made the cut
+	for idx := range top {
+		p := top[idx].Prefix
+		for _, run := range fb.prefixRuns(p) {
+			for _, ptr := range run {
+				mem := fb.Pools[p[0]].GetRecordPtr(ptr)
+				if t := mem[len(mem)-1]; t < 3 && matchAll(filters, p, mem) {
+					top[idx].TypeCounts[t]++
+				}
+			}
+		}
+	}
+
+	return top
+}
+
+// countMatching returns the number of records of a prefix, given as runs,
+// passing all filters
+func (fb *FastBase) countMatching(prefix [3]byte, runs [][]uint32, filters []Filter) uint32 {
+	count := uint32(0)
+	for _, run := range runs {
+		if len(filters) == 0 {
+			count += uint32(len(run))
+			continue
+		}
+		for _, ptr := range run {
+			if matchAll(filters, prefix, fb.Pools[prefix[0]].GetRecordPtr(ptr)) {
+				count++
+			}
+		}
+	}
+	return count
+}
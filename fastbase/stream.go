@@ -0,0 +1,93 @@
+package fastbase
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// FastBase implements io.WriterTo, io.ReaderFrom, encoding.BinaryMarshaler
+// and encoding.BinaryUnmarshaler in the file format SaveToFile writes, so
+// a database can go through gob, a network connection or a compressing
+// writer like any other Go value.
+var (
+	_ io.WriterTo   = (*FastBase)(nil)
+	_ io.ReaderFrom = (*FastBase)(nil)
+)
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the FastBase to w as SaveToFile writes a file, and
+// returns the bytes written. Once it has been written whole, the copy is
+// the base of later delta saves, as a saved file is.
+func (fb *FastBase) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, 1<<20)
+	snapshotID, err := fb.writeSnapshot(bw, nil)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return cw.n, err
+	}
+	fb.commitSnapshot(snapshotID)
+	return cw.n, nil
+}
+
+// ReadFrom replaces the contents of the FastBase with a database read from
+// r until EOF, and returns the bytes read. The offset table ending an
+// unencrypted file is read past, as a stream has no use for it.
+func (fb *FastBase) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReaderSize(cr, 1<<20)
+	if err := fb.load(br, 0, nil); err != nil {
+		return cr.n, err
+	}
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return cr.n, err
+	}
+	fb.rebuildBlooms()
+	return cr.n, nil
+}
+
+// MarshalBinary returns the FastBase in the file format, as WriteTo
+// writes it
+func (fb *FastBase) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := fb.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the contents of the FastBase with a database in
+// the file format
+func (fb *FastBase) UnmarshalBinary(data []byte) error {
+	if err := fb.load(bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		return err
+	}
+	fb.rebuildBlooms()
+	return nil
+}
@@ -0,0 +1,81 @@
+package fastbase
+
+// SummaryBucket counts the records filed in a band of prefixes, with a
+// digest of them that two databases holding the same records agree on
+// whatever order the records came in. Comparing buckets finds the bands
+// where two databases differ without shipping their records.
+type SummaryBucket struct {
+	Range  PrefixRange
+	Count  int
+	Digest uint64 // Sum of the record hashes, so independent of order
+}
+
+// AllPrefixes is the range of every prefix
+var AllPrefixes = PrefixRange{Hi: [3]byte{0xff, 0xff, 0xff}}
+
+// Split divides the range into at most n bands of nearly equal width, in
+// order. A range narrower than n prefixes is split into single prefixes.
+func (r PrefixRange) Split(n int) []PrefixRange {
+	count := r.Count()
+	if n > count {
+		n = count
+	}
+	if n < 1 {
+		n = 1
+	}
+	lo := prefixIndex(r.Lo)
+	bands := make([]PrefixRange, n)
+	for i := range bands {
+		start := lo + count*i/n
+		end := lo + count*(i+1)/n - 1
+		bands[i] = PrefixRange{Lo: prefixAt(start), Hi: prefixAt(end)}
+	}
+	return bands
+}
+
+// prefixAt returns the prefix whose list is at position i of a database
+// file, undoing prefixIndex
+func prefixAt(i int) [3]byte {
+	return [3]byte{byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+// RecordHash hashes the x-coordinate and distance of a record, the part
+// that makes it a duplicate of another, so records hash the same in any
+// layout and with or without provenance
+func RecordHash(schema Schema, rec []byte) uint64 {
+	h := bloomHash(schema.X(rec))
+	for _, c := range schema.Distance(rec) {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	// FNV mixes the last bytes poorly into the high bits, which a sum
+	// of hashes would carry
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
+
+// Summarize splits r into at most n bands as Split does and returns the
+// count and digest of the records in each
+func (fb *FastBase) Summarize(r PrefixRange, n int) []SummaryBucket {
+	bands := r.Split(n)
+	buckets := make([]SummaryBucket, len(bands))
+	for i, band := range bands {
+		buckets[i].Range = band
+	}
+
+	schema := fb.Schema()
+	i := 0
+	fb.ForEachInRange(r, func(prefix [3]byte, records [][]byte) bool {
+		for !buckets[i].Range.Contains(prefix) {
+			i++
+		}
+		buckets[i].Count += len(records)
+		for _, rec := range records {
+			buckets[i].Digest += RecordHash(schema, rec)
+		}
+		return true
+	})
+	return buckets
+}
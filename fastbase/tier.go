@@ -0,0 +1,394 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Tiering keeps a FastBase larger than the memory it may use. Pools, the
+// records of one first prefix byte, move as a whole: when the pool pages
+// outgrow the RAM budget, the pools used least recently are written to
+// segment files in a spill directory and dropped from memory. Lookups
+// (FindDataBlock, FindMany, FindX and the collision check of inserts) read
+// the list they need from a cold pool's segment without loading it, while
+// inserts and iteration load the pool back. A pool loaded only to be read
+// keeps its segment, so spilling it again costs nothing.
+//
+// A segment holds the lists of its pool as a database file of format v2
+// does: a 4-byte count for each of the 65536 prefixes of the pool followed
+// by its records in key order. Its index, the records before each list, is
+// kept in memory.
+
+// WithTiering keeps at most about budget bytes of pool pages in memory,
+// spilling the coldest pools to segment files in dir, which the FastBase
+// owns as scratch space; Clear removes them. Pools holding records added
+// since the last full save stay in memory so SaveDeltaSince can write
+// them, and encrypted databases never spill, as segments are not
+// encrypted. Since reads move pools too, a tiered FastBase must not be
+// read from several goroutines at once, not even under a read lock; the
+// pool server, which reads that way, cannot use it.
+func WithTiering(dir string, budget int64) Option {
+	return func(c *config) {
+		c.tierDir = dir
+		c.tierBudget = budget
+	}
+}
+
+// TierStats reports how a tiered FastBase splits between memory and disk
+type TierStats struct {
+	Budget      int64 // RAM budget for pool pages
+	HotBytes    int64 // Bytes of pool pages in memory
+	ColdPools   int   // Pools whose records are only on disk
+	ColdRecords int   // Records in cold pools
+	Spills      int   // Pools written out or dropped since creation
+	Loads       int   // Pools read back since creation
+}
+
+// tierSet is the tiering state of a FastBase
+type tierSet struct {
+	dir    string
+	budget int64
+
+	clock uint64
+	used  [256]uint64   // Clock of the last use of each pool
+	cold  [256]bool     // The pool's records are only in its segment
+	segs  [256]*segment // Segment of each pool, while it matches the pool
+	grown bool          // Pages were allocated since the budget was checked
+	err   error         // First failure to load a pool where none can be returned
+
+	spills, loads int
+}
+
+// segment is an open segment file
+type segment struct {
+	file    *os.File
+	start   []uint32 // Records before the list of each prefix of the pool, and in all
+	maxList uint32   // Records in the longest list
+}
+
+// offset returns where the records of the list of prefix p start
+func (seg *segment) offset(p, recordLength int) int64 {
+	return int64(p+1)*4 + int64(seg.start[p])*int64(recordLength)
+}
+
+// size returns the length of the segment file
+func (seg *segment) size(recordLength int) int64 {
+	return 65536*4 + int64(seg.start[65536])*int64(recordLength)
+}
+
+// reset drops every segment, as the FastBase is cleared
+func (t *tierSet) reset() {
+	for i, seg := range t.segs {
+		if seg != nil {
+			seg.remove()
+			t.segs[i] = nil
+		}
+	}
+	t.cold = [256]bool{}
+	t.err = nil
+}
+
+// remove closes and deletes the segment file
+func (seg *segment) remove() {
+	seg.file.Close()
+	os.Remove(seg.file.Name())
+}
+
+// isCold reports whether the records of pool i are only on disk
+func (fb *FastBase) isCold(i byte) bool {
+	return fb.tier != nil && fb.tier.cold[i]
+}
+
+// TierStats returns the tiering state, all zero without WithTiering
+func (fb *FastBase) TierStats() TierStats {
+	t := fb.tier
+	if t == nil {
+		return TierStats{}
+	}
+	st := TierStats{Budget: t.budget, Spills: t.spills, Loads: t.loads}
+	for i := range fb.Pools {
+		st.HotBytes += fb.poolBytes(i)
+		if t.cold[i] {
+			st.ColdPools++
+			st.ColdRecords += int(t.segs[i].start[65536])
+		}
+	}
+	return st
+}
+
+// TierError returns the first failure to read back a spilled pool during
+// iteration, which skips the pool. Saves fail with it, as they would
+// otherwise lose the pool.
+func (fb *FastBase) TierError() error {
+	if fb.tier == nil {
+		return nil
+	}
+	return fb.tier.err
+}
+
+// poolBytes returns the memory held by the pages of pool i
+func (fb *FastBase) poolBytes(i int) int64 {
+	return int64(len(fb.Pools[i].Pages)+len(fb.Pools[i].free)) * int64(fb.layout.PageSize)
+}
+
+// touch makes sure the records of pool i are in memory, for a use that
+// may read them through pool pointers, and marks the pool recently used
+func (fb *FastBase) touch(i byte) error {
+	t := fb.tier
+	if t == nil {
+		return nil
+	}
+	t.clock++
+	t.used[i] = t.clock
+	if !t.cold[i] {
+		return nil
+	}
+	if err := fb.loadPool(i); err != nil {
+		if t.err == nil {
+			t.err = err
+			fb.logger().Warn("reading back a spilled pool failed", "pool", i, "err", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// modify is touch for a use that changes pool i, after which its segment
+// no longer matches it
+func (fb *FastBase) modify(i byte) error {
+	if err := fb.touch(i); err != nil {
+		return err
+	}
+	if t := fb.tier; t != nil && t.segs[i] != nil {
+		t.segs[i].remove()
+		t.segs[i] = nil
+	}
+	return nil
+}
+
+// loadPool reads the records of cold pool i back from its segment
+func (fb *FastBase) loadPool(i byte) error {
+	t := fb.tier
+	seg := t.segs[i]
+	size := seg.size(fb.layout.RecordLength)
+	r := bufio.NewReaderSize(io.NewSectionReader(seg.file, 0, size), 1<<20)
+	if _, err := fb.readPool(r, i, 4, 0, size, &RecoverReport{}); err != nil {
+		fb.dropPool(i)
+		return fmt.Errorf("reading spilled pool %02x: %w", i, err)
+	}
+	t.cold[i] = false
+	t.grown = true
+	t.loads++
+	fb.logger().Debug("pool loaded", "pool", i, "records", seg.start[65536])
+	return nil
+}
+
+// dropPool drops the records of pool i from memory, pages and lists
+func (fb *FastBase) dropPool(i byte) {
+	pool := &fb.Pools[i]
+	pool.Pages, pool.free, pool.Ptr = nil, nil, 0
+	clear(fb.index.tables[int(i)<<8 : int(i)<<8+256])
+}
+
+// spillOver spills the pools used least recently until the pool pages fit
+// the budget again, if pages were allocated since it last looked
+func (fb *FastBase) spillOver() error {
+	t := fb.tier
+	if t == nil || !t.grown || fb.Encrypted() {
+		return nil
+	}
+	t.grown = false
+	var used int64
+	for i := range fb.Pools {
+		used += fb.poolBytes(i)
+	}
+	if used <= t.budget {
+		return nil
+	}
+
+	var tracked [256]bool
+	for _, ref := range fb.added {
+		tracked[ref.prefix[0]] = true
+	}
+	for used > t.budget {
+		victim := -1
+		for i := range fb.Pools {
+			if t.cold[i] || tracked[i] || fb.poolBytes(i) == 0 {
+				continue
+			}
+			if victim < 0 || t.used[i] < t.used[victim] {
+				victim = i
+			}
+		}
+		if victim < 0 {
+			return nil
+		}
+		used -= fb.poolBytes(victim)
+		if err := fb.spill(byte(victim)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spill writes pool i to its segment, unless the segment still matches it,
+// and drops it from memory
+func (fb *FastBase) spill(i byte) error {
+	t := fb.tier
+	if len(fb.Pools[i].Pages) == 0 {
+		// Only recycled pages, which hold no records
+		fb.Pools[i].free = nil
+		return nil
+	}
+	if t.segs[i] == nil {
+		seg, err := fb.writeSegment(i)
+		if err != nil {
+			return fmt.Errorf("spilling pool %02x: %v", i, err)
+		}
+		t.segs[i] = seg
+	}
+	fb.logger().Debug("pool spilled", "pool", i, "bytes", fb.poolBytes(int(i)), "records", t.segs[i].start[65536])
+	fb.dropPool(i)
+	t.cold[i] = true
+	t.spills++
+	return nil
+}
+
+// writeSegment writes the lists of pool i to a new segment file
+func (fb *FastBase) writeSegment(i byte) (*segment, error) {
+	if err := os.MkdirAll(fb.tier.dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(fb.tier.dir, fmt.Sprintf("pool-%02x.seg", i)))
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{file: file, start: make([]uint32, 65537)}
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	var werr error
+	var countBuf [4]byte
+	records := uint32(0)
+	next := 0 // Prefix of the pool whose count comes next
+	skipTo := func(end int) {
+		var zero [4]byte
+		for ; next < end && werr == nil; next++ {
+			seg.start[next] = records
+			_, werr = w.Write(zero[:])
+		}
+	}
+	var merged []uint32
+	pool := &fb.Pools[i]
+	fb.eachPrefixRaw(int(i), int(i)+1, func(prefix [3]byte, runs [][]uint32) bool {
+		if skipTo(int(prefix[1])<<8 | int(prefix[2])); werr != nil {
+			return false
+		}
+		ptrs := fb.mergeRuns(prefix, runs, &merged)
+		seg.start[next] = records
+		binary.LittleEndian.PutUint32(countBuf[:], uint32(len(ptrs)))
+		if _, werr = w.Write(countBuf[:]); werr != nil {
+			return false
+		}
+		for _, ptr := range ptrs {
+			if _, werr = w.Write(pool.GetRecordPtr(ptr)); werr != nil {
+				return false
+			}
+		}
+		records += uint32(len(ptrs))
+		seg.maxList = max(seg.maxList, uint32(len(ptrs)))
+		next++
+		return true
+	})
+	skipTo(65536)
+	seg.start[65536] = records
+	if werr == nil {
+		werr = w.Flush()
+	}
+	if werr != nil {
+		seg.remove()
+		return nil, werr
+	}
+	return seg, nil
+}
+
+// coldList returns the records of the list of prefix, back to back, when
+// its pool is cold. cold is false if the pool is in memory. A failed read
+// is kept as the tier error and reads as an empty list.
+func (fb *FastBase) coldList(prefix [3]byte) (records []byte, cold bool) {
+	if !fb.isCold(prefix[0]) {
+		return nil, false
+	}
+	seg := fb.tier.segs[prefix[0]]
+	p := int(prefix[1])<<8 | int(prefix[2])
+	n := int(seg.start[p+1] - seg.start[p])
+	if n == 0 {
+		return nil, true
+	}
+	records = make([]byte, n*fb.layout.RecordLength)
+	if _, err := seg.file.ReadAt(records, seg.offset(p, fb.layout.RecordLength)); err != nil {
+		if fb.tier.err == nil {
+			fb.tier.err = fmt.Errorf("reading spilled pool %02x: %w", prefix[0], err)
+			fb.logger().Warn("reading a spilled list failed", "pool", prefix[0], "err", err)
+		}
+		return nil, true
+	}
+	return records, true
+}
+
+// coldLowerBound returns the index of the first record of a cold list
+// not ordered before key
+func (fb *FastBase) coldLowerBound(records []byte, key []byte) int {
+	n := fb.layout.RecordLength
+	lo, hi := 0, len(records)/n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if compareKey(records[mid*n:], key, fb.layout.CompareLength) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// eachColdRecord calls fn for every record of cold pool i, in key order
+func (fb *FastBase) eachColdRecord(i byte, fn func(rec []byte)) error {
+	seg := fb.tier.segs[i]
+	n := fb.layout.RecordLength
+	r := bufio.NewReaderSize(io.NewSectionReader(seg.file, 0, seg.size(n)), 1<<20)
+	var countBuf [4]byte
+	rec := make([]byte, n)
+	for p := 0; p < 65536; p++ {
+		if _, err := io.ReadFull(r, countBuf[:]); err != nil {
+			return err
+		}
+		for m := binary.LittleEndian.Uint32(countBuf[:]); m > 0; m-- {
+			if _, err := io.ReadFull(r, rec); err != nil {
+				return err
+			}
+			fn(rec)
+		}
+	}
+	return nil
+}
+
+// eachPool is forEachPool, but one pool at a time for a tiered FastBase,
+// whose pools move between memory and disk, spilling between pools
+func (fb *FastBase) eachPool(fn func(i int) error) error {
+	if fb.tier == nil {
+		return forEachPool(fn)
+	}
+	for i := 0; i < 256; i++ {
+		if err := fn(i); err != nil {
+			return err
+		}
+		if err := fb.spillOver(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package fastbase
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// tieredTestDB returns a tiered FastBase holding the first n records of
+// the series, saved to a new file so its pools may spill, with the path
+func tieredTestDB(t *testing.T, n int, budget int64) (*FastBase, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fb := NewFastBase(WithPageSize(1<<12), WithTiering(filepath.Join(dir, "spill"), budget))
+	t.Cleanup(fb.ReleaseMemory)
+	addTestRecords(t, fb, 0, n)
+	path := filepath.Join(dir, "test.db")
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return fb, path
+}
+
+func TestTieringFindsSpilledRecords(t *testing.T) {
+	fb, _ := tieredTestDB(t, 20000, 256<<10)
+	ts := fb.TierStats()
+	if ts.ColdPools == 0 || ts.HotBytes > ts.Budget {
+		t.Fatalf("%d pools spilled, %d bytes in memory; want pools spilled to fit %d bytes", ts.ColdPools, ts.HotBytes, ts.Budget)
+	}
+
+	// Lookups read the lists of cold pools from their segments
+	if !hasTestRecords(t, fb, 0, 20000) {
+		t.Error("FindDataBlock misses records of spilled pools")
+	}
+	if hasTestRecords(t, fb, 20000, 20001) {
+		t.Error("FindDataBlock finds a record never added")
+	}
+	var keys [][]byte
+	for n := 0; n < 20000; n++ {
+		prefix, rec := testRecord(t, n, KangarooType(n%3))
+		keys = append(keys, append(prefix[:], rec...))
+	}
+	for n, rec := range fb.FindMany(keys) {
+		if rec == nil {
+			t.Fatalf("FindMany misses record %d", n)
+		}
+	}
+	_, rec := testRecord(t, 42, KangarooType(42%3))
+	if m, err := fb.FindX(SchemaStandard.X(rec)); err != nil || len(m) != 1 {
+		t.Errorf("FindX of record 42 gives %d matches, %v; want 1", len(m), err)
+	}
+	if ts := fb.TierStats(); ts.Loads != 0 {
+		t.Errorf("lookups loaded %d pools back; want them read in place", ts.Loads)
+	}
+
+	// Adding to a cold pool loads it back, and a record it already holds
+	// is still a duplicate
+	prefix, rec := testRecord(t, 7, KangarooType(7%3))
+	if ok, err := fb.AddRecord(prefix[0], prefix[1], prefix[2], rec); err != nil || ok {
+		t.Errorf("adding a spilled record again: %v, %v; want a duplicate", ok, err)
+	}
+	addTestRecords(t, fb, 20000, 21000)
+	if !hasTestRecords(t, fb, 0, 21000) {
+		t.Error("records missing after adding to spilled pools")
+	}
+	count := 0
+	fb.ForEach(func([3]byte, []byte) bool { count++; return true })
+	if count != 21000 {
+		t.Errorf("ForEach visits %d records; want 21000", count)
+	}
+	if err := fb.TierError(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTieringSavesSpilledRecords(t *testing.T) {
+	fb, path := tieredTestDB(t, 20000, 256<<10)
+	addTestRecords(t, fb, 20000, 20500)
+	if err := fb.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if fb.TierStats().ColdPools == 0 {
+		t.Fatal("no pools spilled")
+	}
+
+	plain := NewFastBase(WithPageSize(1 << 12))
+	addTestRecords(t, plain, 0, 20500)
+	if saved := loadTestDB(t, path); !saved.Equal(plain) {
+		t.Error("file saved with pools spilled differs from one never tiered")
+	}
+	if !fb.Equal(plain) {
+		t.Error("tiered database differs from one never tiered")
+	}
+}
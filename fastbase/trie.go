@@ -0,0 +1,83 @@
+package fastbase
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// TrieNode is a node of the occupied part of the prefix trie
+type TrieNode struct {
+	Prefix   string     `json:"prefix"`             // Hex prefix leading to this node
+	Lists    int        `json:"lists"`              // Non-empty lists below this node
+	Records  int        `json:"records"`            // Records below this node
+	Children []TrieNode `json:"children,omitempty"` // Occupied child nodes
+}
+
+// Trie returns the first two levels of the prefix trie, keeping only
+// nodes that have at least one record below them
+func (fb *FastBase) Trie() TrieNode {
+	root := TrieNode{}
+
+	// Lists and records below every second-level node
+	var counts [256][256]struct{ lists, records int }
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		c := &counts[prefix[0]][prefix[1]]
+		c.lists++
+		for _, run := range runs {
+			c.records += len(run)
+		}
+		return true
+	})
+
+	for i := 0; i < 256; i++ {
+		level1 := TrieNode{Prefix: fmt.Sprintf("%02x", i)}
+		for j := 0; j < 256; j++ {
+			level2 := TrieNode{Prefix: fmt.Sprintf("%02x%02x", i, j)}
+			level2.Lists = counts[i][j].lists
+			level2.Records = counts[i][j].records
+			if level2.Records > 0 {
+				level1.Lists += level2.Lists
+				level1.Records += level2.Records
+				level1.Children = append(level1.Children, level2)
+			}
+		}
+		if level1.Records > 0 {
+			root.Lists += level1.Lists
+			root.Records += level1.Records
+			root.Children = append(root.Children, level1)
+		}
+	}
+
+	return root
+}
+
+// WriteTrieJSON writes the occupied prefix trie as an indented JSON tree
+func (fb *FastBase) WriteTrieJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fb.Trie())
+}
+
+// WriteTrieDOT writes the occupied prefix trie as a Graphviz DOT graph
+func (fb *FastBase) WriteTrieDOT(w io.Writer) error {
+	root := fb.Trie()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "digraph fastbase {\n")
+	fmt.Fprintf(bw, "  rankdir=LR;\n")
+	fmt.Fprintf(bw, "  node [shape=box, fontname=\"monospace\"];\n")
+	fmt.Fprintf(bw, "  root [label=\"root\\n%d records\\n%d lists\"];\n", root.Records, root.Lists)
+	for _, level1 := range root.Children {
+		fmt.Fprintf(bw, "  p%s [label=\"%s\\n%d records\\n%d lists\"];\n", level1.Prefix, level1.Prefix, level1.Records, level1.Lists)
+		fmt.Fprintf(bw, "  root -> p%s;\n", level1.Prefix)
+		for _, level2 := range level1.Children {
+			fmt.Fprintf(bw, "  p%s [label=\"%s\\n%d records\\n%d lists\"];\n", level2.Prefix, level2.Prefix, level2.Records, level2.Lists)
+			fmt.Fprintf(bw, "  p%s -> p%s;\n", level1.Prefix, level2.Prefix)
+		}
+	}
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,148 @@
+package fastbase
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxValidationProblems caps how many problems a ValidationError lists
+const maxValidationProblems = 100
+
+// ValidationProblem is one inconsistency found by Validate
+type ValidationProblem struct {
+	Prefix  [3]byte // List the problem was found in
+	Index   int     // Record index within the list, or -1 for a problem with the whole file
+	Message string  // What is wrong
+}
+
+// ValidationError lists the problems found by Validate or LoadFromFileStrict
+type ValidationError struct {
+	Problems []ValidationProblem // The first problems found
+	Total    int                 // Number of problems found, listed or not
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "validation failed with %d problems", e.Total)
+	for _, p := range e.Problems {
+		if p.Index < 0 {
+			fmt.Fprintf(&sb, "\n  file: %s", p.Message)
+		} else {
+			fmt.Fprintf(&sb, "\n  [%02x %02x %02x] record %d: %s", p.Prefix[0], p.Prefix[1], p.Prefix[2], p.Index, p.Message)
+		}
+	}
+	if e.Total > len(e.Problems) {
+		fmt.Fprintf(&sb, "\n  ... %d more", e.Total-len(e.Problems))
+	}
+	return sb.String()
+}
+
+func (e *ValidationError) add(prefix [3]byte, index int, format string, args ...interface{}) {
+	e.Total++
+	if len(e.Problems) < maxValidationProblems {
+		e.Problems = append(e.Problems, ValidationProblem{Prefix: prefix, Index: index, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Validate checks that every list is sorted and every record has a known
+// kangaroo type and a correctly sign-extended distance. With checkPrefix it
+// also checks that each record starts with the prefix of its list, which
+// holds for databases built from full x-coordinates but not for files from
+// the C++ solver, whose records omit the prefix bytes. It returns a
+// *ValidationError describing every problem, or nil.
+func (fb *FastBase) Validate(checkPrefix bool) error {
+	verr := &ValidationError{}
+	fb.validateLists(checkPrefix, verr)
+	if verr.Total > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (fb *FastBase) validateLists(checkPrefix bool, verr *ValidationError) {
+	schema := fb.Schema()
+	fb.eachPrefix(func(prefix [3]byte, runs [][]uint32) bool {
+		m := 0 // Index of the record within its prefix
+		for _, run := range runs {
+			var prev []byte
+			for _, ptr := range run {
+				rec := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+
+				if prev != nil && compareKey(prev, rec, fb.layout.CompareLength) > 0 {
+					verr.add(prefix, m, "out of order")
+				}
+				prev = rec
+
+				if t := schema.Type(rec); t > TypeWild2 {
+					verr.add(prefix, m, "unknown kangaroo type %d", t)
+				}
+				if top := schema.Distance(rec)[schema.DistanceLength-1]; top != 0 && top != 0xFF {
+					verr.add(prefix, m, "distance top byte %02x is neither 00 nor ff", top)
+				}
+				if checkPrefix && schema.XLength >= 3 && !bytes.Equal(rec[:3], prefix[:]) {
+					verr.add(prefix, m, "x-coordinate %x does not belong under this prefix", schema.X(rec))
+				}
+				m++
+			}
+		}
+		return true
+	})
+}
+
+// LoadFromFileStrict loads a file like LoadFromFile and then checks it
+// with ValidateFile. Problems are returned as a *ValidationError; the data
+// is left loaded so it can be inspected.
+func (fb *FastBase) LoadFromFileStrict(filename string, checkPrefix bool) error {
+	if err := fb.LoadFromFile(filename); err != nil {
+		return err
+	}
+	return fb.ValidateFile(filename, checkPrefix)
+}
+
+// ValidateFile runs Validate and also checks that the size of the file the
+// data was loaded from matches the list counts and any offset table exactly
+func (fb *FastBase) ValidateFile(filename string, checkPrefix bool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	verr := &ValidationError{}
+	expected := fb.expectedFileSize()
+	if _, ok := findOffsetTable(file, fi.Size()); ok {
+		expected += offsetTableSize
+	}
+	if fi.Size() != expected {
+		verr.add([3]byte{}, -1, "file is %d bytes but its list counts account for %d (%d trailing bytes)",
+			fi.Size(), expected, fi.Size()-expected)
+	}
+
+	fb.validateLists(checkPrefix, verr)
+	if verr.Total > 0 {
+		return verr
+	}
+	return nil
+}
+
+// expectedFileSize returns the size of a file holding the current records
+// in the format version recorded in the header
+func (fb *FastBase) expectedFileSize() int64 {
+	countSize := int64(2)
+	if fb.Header[HeaderVersion] == FormatV2 {
+		countSize = 4
+	}
+	records := int64(fb.recordCount())
+	body := 256*256*256*countSize + records*int64(fb.layout.RecordLength)
+	if fb.Encrypted() {
+		body = encryptedSize(body)
+	}
+	return int64(len(fb.Header)) + body
+}
@@ -0,0 +1,162 @@
+//go:build cuda
+
+// Bridge between the Go gpu package and the RCKangaroo CUDA solver: runs
+// RCGpuKang on the chosen devices and collects the DPs they report, in
+// place of the main loop of RCKangaroo.cpp
+// License: GPLv3, see "LICENSE.TXT" file
+
+#include <mutex>
+#include <thread>
+#include <vector>
+#include <stdio.h>
+#include <string.h>
+#include "cuda_runtime.h"
+
+#include "GpuKang.h"
+#include "bridge.h"
+
+// Globals GpuKang.cpp expects from RCKangaroo.cpp
+bool gGenMode = false;
+u32 gTotalErrors = 0;
+
+static EcJMP EcJumps1[JMP_CNT];
+static EcJMP EcJumps2[JMP_CNT];
+static EcJMP EcJumps3[JMP_CNT];
+
+static std::vector<RCGpuKang*> kangs;
+static std::vector<std::thread> threads;
+
+static std::mutex csPoints;
+static std::vector<u8> points;
+static u64 pointsOps;
+
+// Called by RCGpuKang::Execute with the DPs of each kernel run
+void AddPointsToList(u32* data, int pnt_cnt, u64 ops_cnt)
+{
+	std::lock_guard<std::mutex> lock(csPoints);
+	pointsOps += ops_cnt;
+	if (points.size() / GPU_DP_SIZE + pnt_cnt > MAX_CNT_LIST)
+	{
+		gTotalErrors++; // Go is not taking them fast enough
+		return;
+	}
+	points.insert(points.end(), (u8*)data, (u8*)data + pnt_cnt * GPU_DP_SIZE);
+}
+
+// prepareJumps fills the jump tables as RCKangaroo.cpp does, from seed 0
+// so DPs meet those of its tames files
+static void prepareJumps(int Range)
+{
+	EcJMP* tables[3] = { EcJumps1, EcJumps2, EcJumps3 };
+	int shifts[3] = { Range / 2 + 3, Range - 10, Range - 10 - 2 };
+	EcInt minjump, t;
+
+	SetRndSeed(0);
+	for (int j = 0; j < 3; j++)
+	{
+		minjump.Set(1);
+		minjump.ShiftLeft(shifts[j]);
+		for (int i = 0; i < JMP_CNT; i++)
+		{
+			tables[j][i].dist = minjump;
+			t.RndMax(minjump);
+			tables[j][i].dist.Add(t);
+			tables[j][i].dist.data[0] &= 0xFFFFFFFFFFFFFFFE; //must be even
+			tables[j][i].p = Ec::MultiplyG(tables[j][i].dist);
+		}
+	}
+	SetRndSeed(GetTickCount64());
+}
+
+int rck_gpu_count(void)
+{
+	int cnt = 0;
+	if (cudaGetDeviceCount(&cnt) != cudaSuccess)
+		return 0;
+	return cnt;
+}
+
+int rck_gpu_prepare(const int* devices, int count, const char* point, int range, int dp, char* err, int err_len)
+{
+	InitEc();
+	EcPoint PntToSolve;
+	if (!PntToSolve.SetHexStr(point))
+	{
+		snprintf(err, err_len, "invalid point to solve");
+		return -1;
+	}
+	prepareJumps(range);
+	points.clear();
+	pointsOps = 0;
+
+	int total = 0;
+	for (int i = 0; i < count; i++)
+	{
+		int dev = devices[i];
+		cudaDeviceProp deviceProp;
+		if (cudaSetDevice(dev) != cudaSuccess || cudaGetDeviceProperties(&deviceProp, dev) != cudaSuccess)
+		{
+			snprintf(err, err_len, "GPU %d: cannot be used", dev);
+			rck_gpu_stop();
+			return -1;
+		}
+		if (deviceProp.major < 6)
+		{
+			snprintf(err, err_len, "GPU %d: %s is not supported, compute capability 6.0 or higher is needed", dev, deviceProp.name);
+			rck_gpu_stop();
+			return -1;
+		}
+		cudaSetDeviceFlags(cudaDeviceScheduleBlockingSync);
+
+		RCGpuKang* kang = new RCGpuKang();
+		kang->CudaIndex = dev;
+		kang->persistingL2CacheMaxSize = deviceProp.persistingL2CacheMaxSize;
+		kang->mpCnt = deviceProp.multiProcessorCount;
+		kang->IsOldGpu = deviceProp.l2CacheSize < 16 * 1024 * 1024;
+		kangs.push_back(kang);
+		if (!kang->Prepare(PntToSolve, range, dp, EcJumps1, EcJumps2, EcJumps3))
+		{
+			snprintf(err, err_len, "GPU %d: preparing the kangaroos failed", dev);
+			rck_gpu_stop();
+			return -1;
+		}
+		total += kang->KangCnt;
+	}
+	return total;
+}
+
+void rck_gpu_run(void)
+{
+	for (RCGpuKang* kang : kangs)
+		threads.emplace_back([kang] { kang->Execute(); });
+}
+
+int rck_gpu_take(unsigned char* buf, int max, unsigned long long* ops)
+{
+	std::lock_guard<std::mutex> lock(csPoints);
+	int cnt = (int)(points.size() / GPU_DP_SIZE);
+	if (cnt > max)
+		cnt = max;
+	memcpy(buf, points.data(), cnt * GPU_DP_SIZE);
+	points.erase(points.begin(), points.begin() + cnt * GPU_DP_SIZE);
+	*ops += pointsOps;
+	pointsOps = 0;
+	return cnt;
+}
+
+unsigned int rck_gpu_errors(void)
+{
+	return gTotalErrors;
+}
+
+void rck_gpu_stop(void)
+{
+	for (RCGpuKang* kang : kangs)
+		kang->Stop();
+	for (std::thread& thr : threads)
+		thr.join();
+	threads.clear();
+	for (RCGpuKang* kang : kangs)
+		delete kang;
+	kangs.clear();
+}
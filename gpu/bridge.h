@@ -0,0 +1,35 @@
+// Bridge between the Go gpu package and the RCKangaroo CUDA solver
+// License: GPLv3, see "LICENSE.TXT" file
+
+#pragma once
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// rck_gpu_count returns the number of CUDA devices present
+int rck_gpu_count(void);
+
+// rck_gpu_prepare sets up the kangaroos on the given devices to solve a
+// point, given as uncompressed SEC1 hex, already shifted to a range
+// starting at zero. It returns the number of kangaroos, or -1 with a
+// message in err.
+int rck_gpu_prepare(const int* devices, int count, const char* point, int range, int dp, char* err, int err_len);
+
+// rck_gpu_run starts a thread per device walking the kangaroos
+void rck_gpu_run(void);
+
+// rck_gpu_take moves up to max DPs of GPU_DP_SIZE bytes into buf and
+// returns their number, adding the jumps made since the last call to ops
+int rck_gpu_take(unsigned char* buf, int max, unsigned long long* ops);
+
+// rck_gpu_errors returns the number of kernel failures and DPs lost
+unsigned int rck_gpu_errors(void);
+
+// rck_gpu_stop stops the threads and frees the devices. The DPs they
+// reported stay to be taken.
+void rck_gpu_stop(void);
+
+#ifdef __cplusplus
+}
+#endif
@@ -0,0 +1,118 @@
+//go:build cuda
+
+package gpu
+
+/*
+#cgo CXXFLAGS: -O3 -I${SRCDIR}/.. -I/usr/local/cuda/include
+#cgo LDFLAGS: -L${SRCDIR}/.. -lrckgpu -L/usr/local/cuda/lib64 -lcudart -lstdc++ -lpthread
+#include <stdlib.h>
+#include "bridge.h"
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// pollInterval is how often DPs are taken from the device threads
+const pollInterval = 200 * time.Millisecond
+
+// takeMax is how many DPs are taken at once, and so the largest batch
+const takeMax = 64 * 1024
+
+// running is set while a Solver holds the devices, as the bridge keeps
+// one set of them per process
+var running atomic.Bool
+
+// DeviceCount returns the number of CUDA devices present
+func DeviceCount() (int, error) {
+	return int(C.rck_gpu_count()), nil
+}
+
+// NewSolver prepares the kangaroos on the configured devices. They start
+// walking when Run is called, which also frees the devices when done.
+func NewSolver(cfg Config) (*Solver, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	devices := cfg.Devices
+	if len(devices) == 0 {
+		for i := 0; i < int(C.rck_gpu_count()); i++ {
+			devices = append(devices, i)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no CUDA devices found")
+	}
+	if !running.CompareAndSwap(false, true) {
+		return nil, errors.New("the GPUs are already in use by another solver")
+	}
+
+	cdevices := make([]C.int, len(devices))
+	for i, d := range devices {
+		cdevices[i] = C.int(d)
+	}
+	point := C.CString(hex.EncodeToString(cfg.Target.Point.Uncompressed()))
+	defer C.free(unsafe.Pointer(point))
+	var msg [256]C.char
+	n := C.rck_gpu_prepare(&cdevices[0], C.int(len(cdevices)), point,
+		C.int(cfg.Target.Range.Bits), C.int(cfg.DPBits), &msg[0], C.int(len(msg)))
+	if n < 0 {
+		running.Store(false)
+		return nil, fmt.Errorf("GPU: %s", C.GoString(&msg[0]))
+	}
+	return &Solver{cfg: cfg, kangaroos: int(n)}, nil
+}
+
+// Run walks the kangaroos until ctx is cancelled, passing the records of
+// the DPs they reach to out in batches, those reported after cancellation
+// too, so out must be read until Run returns. It frees the devices, so a
+// Solver runs only once.
+func (s *Solver) Run(ctx context.Context, out chan<- [][]byte) {
+	defer running.Store(false)
+	C.rck_gpu_run()
+	buf := make([]byte, takeMax*DPSize)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.take(buf, out)
+		case <-ctx.Done():
+			C.rck_gpu_stop()
+			s.take(buf, out)
+			return
+		}
+	}
+}
+
+// take passes on every DP reported so far
+func (s *Solver) take(buf []byte, out chan<- [][]byte) {
+	for {
+		var ops C.ulonglong
+		n := int(C.rck_gpu_take((*C.uchar)(unsafe.Pointer(&buf[0])), C.int(takeMax), &ops))
+		s.ops.Add(uint64(ops))
+		if n == 0 {
+			return
+		}
+		batch := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			if rec, err := Record(s.cfg.Schema, buf[i*DPSize:(i+1)*DPSize]); err == nil {
+				batch = append(batch, rec)
+			}
+		}
+		s.dps.Add(uint64(n))
+		out <- batch
+	}
+}
+
+// Errors returns the number of kernel failures and DPs lost so far
+func (s *Solver) Errors() uint64 {
+	return uint64(C.rck_gpu_errors())
+}
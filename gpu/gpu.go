@@ -0,0 +1,138 @@
+// Package gpu walks kangaroos on CUDA devices with the kernels of the
+// original RCKangaroo, leaving DP storage, networking and collision
+// solving to the Go side. The kernels are linked in by building with
+// -tags cuda after "make librckgpu.a"; otherwise NewSolver reports
+// ErrNoCUDA.
+//
+// The kernels choose jumps from their own tables, seeded as RCKangaroo.cpp
+// seeds them, so their DPs meet those of the C++ solver and of earlier GPU
+// runs, such as its tames files, but not those of the CPU solver.
+package gpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+// DPSize is the size of a distinguished point as the kernels report it:
+// the low 16 bytes of the x-coordinate, a 24-byte distance and the type
+// in a 32-bit word, all little-endian, padded to 48 bytes
+const DPSize = 48
+
+// Range widths and DP bits the kernels support, as RCKangaroo.cpp checks
+// them
+const (
+	MinRange = 32
+	MaxRange = 170
+	MinDP    = 14
+	MaxDP    = 60
+)
+
+// TypicalKangaroos is about how many kangaroos one current GPU walks, for
+// choosing DP bits before the devices are prepared
+const TypicalKangaroos = 1 << 20
+
+// ErrNoCUDA is returned by NewSolver in builds without the kernels
+var ErrNoCUDA = errors.New("built without CUDA support; run \"make librckgpu.a\" and build with -tags cuda")
+
+// Config configures a Solver
+type Config struct {
+	Devices []int           // CUDA device indexes; all if empty
+	Target  kangaroo.Target // Key to solve
+	DPBits  int             // Distinguished point bits
+	Schema  fastbase.Schema // Record schema of the DPs passed on
+}
+
+// check validates the configuration against the kernels' limits
+func (cfg Config) check() error {
+	if bits := cfg.Target.Range.Bits; bits < MinRange || bits > MaxRange {
+		return fmt.Errorf("GPU range width must be in %d...%d bits", MinRange, MaxRange)
+	}
+	if cfg.DPBits < MinDP || cfg.DPBits > MaxDP {
+		return fmt.Errorf("GPU DP bits must be in %d...%d", MinDP, MaxDP)
+	}
+	return nil
+}
+
+// Solver walks kangaroos on CUDA devices. It implements kangaroo.Walker,
+// but its kangaroos stay on the devices, so Snapshot has none to save.
+// Only one Solver can run at a time.
+type Solver struct {
+	cfg       Config
+	kangaroos int
+	ops       atomic.Uint64
+	dps       atomic.Uint64
+}
+
+// Kangaroos returns the number of kangaroos on all devices together
+func (s *Solver) Kangaroos() int {
+	return s.kangaroos
+}
+
+// Ops returns the jumps made so far
+func (s *Solver) Ops() uint64 {
+	return s.ops.Load()
+}
+
+// DPs returns the distinguished points reached so far
+func (s *Solver) DPs() uint64 {
+	return s.dps.Load()
+}
+
+// Cycles returns 0: the kernels escape cycles themselves without counting
+func (s *Solver) Cycles() uint64 {
+	return 0
+}
+
+// Snapshot returns nil, as the kangaroos cannot be read back from the
+// devices
+func (s *Solver) Snapshot(ctx context.Context) (*kangaroo.State, error) {
+	return nil, nil
+}
+
+// Record converts a distinguished point as the kernels report it to a
+// record of schema, taking the 12 bytes of x-coordinate and 22 bytes of
+// distance the C++ solver keeps in its records
+func Record(schema fastbase.Schema, dp []byte) ([]byte, error) {
+	if len(dp) != DPSize {
+		return nil, fmt.Errorf("GPU DP must be %d bytes, got %d", DPSize, len(dp))
+	}
+	var x [32]byte
+	copy(x[:], dp[:12])
+	return schema.NewRecord(x, decodeDistance(dp[16:38]), fastbase.KangarooType(dp[40]))
+}
+
+// DBRecord converts a record of a database the C++ solver saved, filed
+// under prefix, to a record of schema. The C++ solver stores its records
+// from the fourth byte on, the first three being the prefix: x[3..11], 22
+// bytes of distance and the type, so a DP gives the same record here as
+// through Record.
+func DBRecord(schema fastbase.Schema, prefix [3]byte, rec []byte) ([]byte, error) {
+	if len(rec) != fastbase.DBRecordLength {
+		return nil, fmt.Errorf("C++ database record must be %d bytes, got %d", fastbase.DBRecordLength, len(rec))
+	}
+	var x [32]byte
+	copy(x[:], prefix[:])
+	copy(x[3:], rec[:9])
+	return schema.NewRecord(x, decodeDistance(rec[9:31]), fastbase.KangarooType(rec[31]))
+}
+
+// decodeDistance decodes 22 bytes of little-endian distance, negative if
+// the top one is 0xFF
+func decodeDistance(le []byte) *big.Int {
+	be := make([]byte, len(le))
+	for i, b := range le {
+		be[len(be)-1-i] = b
+	}
+	d := new(big.Int).SetBytes(be)
+	if le[len(le)-1] == 0xFF {
+		d.Sub(d, new(big.Int).Lsh(big.NewInt(1), uint(8*len(le))))
+	}
+	return d
+}
@@ -0,0 +1,102 @@
+package gpu
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"rckangaroo/fastbase"
+)
+
+// testDP returns a DP as the kernels report it, with a distance of
+// magnitude below 2^100, negated if neg is set
+func testDP(rng *rand.Rand, typ fastbase.KangarooType, neg bool) []byte {
+	dp := make([]byte, DPSize)
+	rng.Read(dp[:16])
+	d := dp[16:40]
+	rng.Read(d[:12])
+	d[12] = byte(rng.Intn(16))
+	if neg {
+		carry := 1
+		for i := range d {
+			v := int(^d[i]) + carry
+			d[i], carry = byte(v), v>>8
+		}
+	}
+	dp[40] = byte(typ)
+	return dp
+}
+
+func TestDBRecordMatchesRecord(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var dps [][]byte
+	for i := 0; i < 30; i++ {
+		dps = append(dps, testDP(rng, fastbase.KangarooType(i%3), i%2 == 1))
+	}
+
+	// Store the DPs as the C++ solver does: its DBRec of x[0..11], d[0..21]
+	// and the type, filed under x[0..2] with the rest as the record
+	cpp := fastbase.NewFastBase()
+	for _, dp := range dps {
+		var rec []byte
+		rec = append(rec, dp[:12]...)
+		rec = append(rec, dp[16:38]...)
+		rec = append(rec, dp[40])
+		if _, err := cpp.AddRecord(rec[0], rec[1], rec[2], rec[3:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "cpp.db")
+	if err := cpp.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := fastbase.NewFastBase()
+	if err := loaded.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fastbase.SchemaStandard
+	want := make(map[string]bool)
+	for _, dp := range dps {
+		rec, err := Record(schema, dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[string(rec)] = true
+	}
+	n := 0
+	loaded.ForEach(func(prefix [3]byte, stored []byte) bool {
+		rec, err := DBRecord(schema, prefix, stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !want[string(rec)] {
+			t.Errorf("C++ record %x%x converts to %x, which no DP gives", prefix, stored, rec)
+		}
+		n++
+		return true
+	})
+	if n != len(dps) {
+		t.Errorf("C++ database holds %d records; want %d", n, len(dps))
+	}
+}
+
+func TestDBRecordDistanceOverflow(t *testing.T) {
+	rec := make([]byte, fastbase.DBRecordLength)
+	rec[9+20] = 1 // Distance of 2^160, beyond the 19 bytes of the standard schema
+	if _, err := DBRecord(fastbase.SchemaStandard, [3]byte{}, rec); err == nil {
+		t.Error("distance overflowing the schema converted without error")
+	}
+	if _, err := DBRecord(fastbase.SchemaStandard, [3]byte{}, rec[:31]); err == nil {
+		t.Error("short record converted without error")
+	}
+	rec[9+20] = 0
+	got, err := DBRecord(fastbase.SchemaStandard, [3]byte{1, 2, 3}, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:3], []byte{1, 2, 3}) {
+		t.Errorf("record starts %x; want the prefix 010203", got[:3])
+	}
+}
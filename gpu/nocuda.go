@@ -0,0 +1,23 @@
+//go:build !cuda
+
+package gpu
+
+import "context"
+
+// DeviceCount returns the number of CUDA devices present
+func DeviceCount() (int, error) {
+	return 0, ErrNoCUDA
+}
+
+// NewSolver returns ErrNoCUDA: this build has no kernels to run
+func NewSolver(cfg Config) (*Solver, error) {
+	return nil, ErrNoCUDA
+}
+
+// Run does nothing, as no Solver exists without the kernels
+func (s *Solver) Run(ctx context.Context, out chan<- [][]byte) {}
+
+// Errors returns 0, as nothing runs without the kernels
+func (s *Solver) Errors() uint64 {
+	return 0
+}
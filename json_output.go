@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	"rckangaroo/fastbase"
+)
+
+type jsonTypeStats struct {
+	Type          string `json:"type"`
+	Count         int    `json:"count"`
+	MaxListSize   uint32 `json:"max_list_size"`
+	MaxListPrefix string `json:"max_list_prefix,omitempty"`
+}
+
+type jsonStats struct {
+	TotalLists     int             `json:"total_lists"`
+	NonEmptyLists  int             `json:"non_empty_lists"`
+	TotalRecords   int             `json:"total_records"`
+	AverageRecords float64         `json:"average_records_per_list"`
+	MaxListSize    uint32          `json:"max_list_size"`
+	MaxListPrefix  string          `json:"max_list_prefix"`
+	Types          []jsonTypeStats `json:"types"`
+	TopLists       []jsonListStats `json:"top_lists,omitempty"`
+}
+
+type jsonListStats struct {
+	Prefix string `json:"prefix"`
+	Count  uint32 `json:"count"`
+	Tame   uint32 `json:"tame"`
+	Wild1  uint32 `json:"wild1"`
+	Wild2  uint32 `json:"wild2"`
+}
+
+type jsonRecord struct {
+	X        string `json:"x"`
+	Distance string `json:"distance"`
+	Type     string `json:"type"`
+}
+
+type jsonPrefix struct {
+	Prefix  string       `json:"prefix"`
+	Count   uint32       `json:"count"`
+	Records []jsonRecord `json:"records"`
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func prefixHex(prefix [3]byte) string {
+	return hex.EncodeToString(prefix[:])
+}
+
+func printStatsJSON(fb *fastbase.FastBase, top int, filters ...fastbase.Filter) error {
+	report := fb.Stats(filters...)
+
+	out := jsonStats{
+		TotalLists:     report.TotalLists,
+		NonEmptyLists:  report.NonEmptyLists,
+		TotalRecords:   report.TotalRecords,
+		AverageRecords: report.AverageListSize(),
+		MaxListSize:    report.MaxListSize,
+		MaxListPrefix:  prefixHex(report.MaxListPrefix),
+	}
+	for t, ts := range report.Types {
+		jts := jsonTypeStats{
+			Type:        fastbase.KangarooType(t).String(),
+			Count:       ts.Count,
+			MaxListSize: ts.MaxListSize,
+		}
+		if ts.Count > 0 {
+			jts.MaxListPrefix = prefixHex(ts.MaxListPrefix)
+		}
+		out.Types = append(out.Types, jts)
+	}
+	for _, ls := range fb.TopLists(top, filters...) {
+		out.TopLists = append(out.TopLists, jsonListStats{
+			Prefix: prefixHex(ls.Prefix),
+			Count:  ls.Count,
+			Tame:   ls.TypeCounts[0],
+			Wild1:  ls.TypeCounts[1],
+			Wild2:  ls.TypeCounts[2],
+		})
+	}
+
+	return writeJSON(out)
+}
+
+func showRecordsByPrefixJSON(fb *fastbase.FastBase, pr fastbase.PrefixRange, page *recordPage, filters ...fastbase.Filter) error {
+	if pr.Single() {
+		records := prefixRecords(fb, pr.Lo, filters)
+		_, shown := page.take(records)
+		return writeJSON(prefixJSON(fb, pr.Lo, len(records), shown))
+	}
+
+	// A band is an array of the lists holding records in the page
+	out := []jsonPrefix{}
+	fb.ForEachInRange(pr, func(prefix [3]byte, records [][]byte) bool {
+		if _, shown := page.take(records); len(shown) > 0 {
+			out = append(out, prefixJSON(fb, prefix, len(records), shown))
+		}
+		return true
+	}, filters...)
+	return writeJSON(out)
+}
+
+// prefixJSON describes a list of count records, of which only those given
+// are included
+func prefixJSON(fb *fastbase.FastBase, prefix [3]byte, count int, records [][]byte) jsonPrefix {
+	out := jsonPrefix{
+		Prefix:  prefixHex(prefix),
+		Count:   uint32(count),
+		Records: make([]jsonRecord, 0, len(records)),
+	}
+	schema := fb.Schema()
+	for _, mem := range records {
+		out.Records = append(out.Records, jsonRecord{
+			X:        hex.EncodeToString(schema.X(mem)),
+			Distance: hex.EncodeToString(schema.Distance(mem)),
+			Type:     schema.Type(mem).String(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+// Package kangaroo turns distinguished-point collisions stored in a FastBase
+// into private key candidates and verifies them against a target public key
+package kangaroo
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/secp256k1"
+)
+
+// Range is the key search interval [Start, Start + 2^Bits)
+type Range struct {
+	Start *big.Int // First key of the range
+	Bits  int      // Width of the range in bits
+}
+
+// NewRange parses a hex range start and validates the bit width
+func NewRange(startHex string, bits int) (Range, error) {
+	start, ok := new(big.Int).SetString(startHex, 16)
+	if !ok || start.Sign() < 0 {
+		return Range{}, fmt.Errorf("invalid range start %q", startHex)
+	}
+	if bits < 1 || bits > 256 {
+		return Range{}, fmt.Errorf("range bits must be in 1...256, got %d", bits)
+	}
+	return Range{Start: start, Bits: bits}, nil
+}
+
+// HalfRange returns 2^(Bits-1), the offset the solver centers its kangaroos on
+func (r Range) HalfRange() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(r.Bits-1))
+}
+
+// Target is the public key being solved, shifted so that the range starts at zero
+type Target struct {
+	PubKey secp256k1.Point // Public key as given by the user
+	Range  Range           // Range the private key lies in
+	Point  secp256k1.Point // PubKey - Start*G, the point the kangaroos actually solve
+}
+
+// NewTarget prepares a public key within a range for candidate verification
+func NewTarget(pubKey secp256k1.Point, r Range) Target {
+	shift := secp256k1.ScalarBaseMult(r.Start)
+	return Target{PubKey: pubKey, Range: r, Point: pubKey.Sub(shift)}
+}
+
+// Verify checks whether the range-relative candidate k solves the target and
+// returns the full private key if it does
+func (t Target) Verify(k *big.Int) (*big.Int, bool) {
+	if !secp256k1.ScalarBaseMult(k).Equal(t.Point) {
+		return nil, false
+	}
+	key := new(big.Int).Add(k, t.Range.Start)
+	return key.Mod(key, secp256k1.N), true
+}
+
+// Strategy derives range-relative private key candidates from the distances
+// of two colliding records
+type Strategy interface {
+	// Name identifies the strategy in reports
+	Name() string
+
+	// Candidates returns the keys worth verifying for a collision between
+	// records of types typeA and typeB with distances da and db
+	Candidates(da *big.Int, typeA fastbase.KangarooType, db *big.Int, typeB fastbase.KangarooType, half *big.Int) []*big.Int
+}
+
+// Naive applies the classic formulas from the record documentation only:
+// tame - wild + HalfRange, and (tame - wild)/2 + HalfRange for wild pairs
+type Naive struct{}
+
+// Name implements Strategy
+func (Naive) Name() string { return "naive" }
+
+// Candidates implements Strategy
+func (Naive) Candidates(da *big.Int, typeA fastbase.KangarooType, db *big.Int, typeB fastbase.KangarooType, half *big.Int) []*big.Int {
+	t, w, tameWild := orderPair(da, typeA, db, typeB)
+	k := new(big.Int).Sub(t, w)
+	if !tameWild {
+		k.Abs(k)
+		k.Rsh(k, 1)
+	}
+	return []*big.Int{k.Add(k, half)}
+}
+
+// Symmetric mirrors the C++ Collision_SOTA: the SOTA method walks on the
+// x-coordinate only, so both signs of the first distance and of the
+// resulting offset have to be tried
+type Symmetric struct{}
+
+// Name implements Strategy
+func (Symmetric) Name() string { return "symmetric" }
+
+// Candidates implements Strategy
+func (Symmetric) Candidates(da *big.Int, typeA fastbase.KangarooType, db *big.Int, typeB fastbase.KangarooType, half *big.Int) []*big.Int {
+	t, w, tameWild := orderPair(da, typeA, db, typeB)
+
+	var candidates []*big.Int
+	for _, neg := range []bool{false, true} {
+		tt := new(big.Int).Set(t)
+		if neg {
+			tt.Neg(tt)
+		}
+		k := new(big.Int).Sub(tt, w)
+		if !tameWild {
+			k.Abs(k)
+			k.Rsh(k, 1)
+		}
+		candidates = append(candidates,
+			new(big.Int).Add(k, half),
+			new(big.Int).Sub(half, k))
+	}
+	return candidates
+}
+
+// orderPair puts the tame distance first when one of the records is tame
+// and reports whether the pair is a tame/wild collision
+func orderPair(da *big.Int, typeA fastbase.KangarooType, db *big.Int, typeB fastbase.KangarooType) (t, w *big.Int, tameWild bool) {
+	if typeB == fastbase.TypeTame {
+		return db, da, typeA != fastbase.TypeTame
+	}
+	return da, db, typeA == fastbase.TypeTame
+}
+
+// Solve runs a strategy on a pair of records laid out according to schema
+// and verifies every candidate against the target. It returns the private
+// key, or an error if no candidate verified.
+func Solve(s Strategy, t Target, schema fastbase.Schema, recA, recB []byte) (*big.Int, error) {
+	if len(recA) < fastbase.DBRecordLength || len(recB) < fastbase.DBRecordLength {
+		return nil, fmt.Errorf("records must be at least %d bytes", fastbase.DBRecordLength)
+	}
+	half := t.Range.HalfRange()
+	da := schema.DecodeDistance(schema.Distance(recA))
+	db := schema.DecodeDistance(schema.Distance(recB))
+	for _, k := range s.Candidates(da, schema.Type(recA), db, schema.Type(recB), half) {
+		if key, ok := t.Verify(k); ok {
+			return key, nil
+		}
+	}
+	return nil, errors.New("no candidate key verified")
+}
@@ -0,0 +1,64 @@
+package kangaroo
+
+import "math"
+
+// SOTAFactor is the expected number of jumps of the solver's SOTA method
+// in units of the square root of the range width, as RCKangaroo.cpp
+// estimates it
+const SOTAFactor = 1.15
+
+// ExpectedOps returns the expected number of jumps to solve a range of the
+// given width in bits
+func ExpectedOps(rangeBits int) float64 {
+	return SOTAFactor * math.Pow(2, float64(rangeBits)/2)
+}
+
+// ExpectedDPs returns how many distinguished points with the given DP bits
+// a solve of the range is expected to collect
+func ExpectedDPs(rangeBits, dpBits int) float64 {
+	return ExpectedOps(rangeBits) / math.Pow(2, float64(dpBits))
+}
+
+// Estimate judges how far a solve has got from its DP counts
+type Estimate struct {
+	ExpectedOps  float64 // Jumps a whole solve takes on average
+	ExpectedDPs  float64 // DPs a whole solve collects on average
+	OpsDone      float64 // Jumps the DP counts are worth, see EstimateProgress
+	OpsRemaining float64 // Expected further jumps, given no collision yet
+	Probability  float64 // Chance that a collision should have occurred by now
+}
+
+// Progress returns the jumps done as a fraction of the expected total
+func (e Estimate) Progress() float64 {
+	return e.OpsDone / e.ExpectedOps
+}
+
+// EstimateProgress estimates how far a solve of a range with the given DP
+// bits has got after collecting the given DPs of each type.
+//
+// The time to a collision is modelled as Rayleigh distributed, as birthday
+// collisions are, with the mean at ExpectedOps. A collision needs two DPs
+// of different types, so the counts are weighed by the cross-type pairs
+// they form: balanced herds are worth their total, lopsided ones less, and
+// a single herd nothing.
+func EstimateProgress(rangeBits, dpBits int, tame, wild1, wild2 int) Estimate {
+	e := Estimate{ExpectedOps: ExpectedOps(rangeBits), ExpectedDPs: ExpectedDPs(rangeBits, dpBits)}
+
+	t, w1, w2 := float64(tame), float64(wild1), float64(wild2)
+	dps := math.Sqrt(3 * (t*w1 + t*w2 + w1*w2))
+	e.OpsDone = dps * math.Pow(2, float64(dpBits))
+
+	// P(T <= n) = 1 - exp(-n²/2σ²) with mean σ·sqrt(π/2) = ExpectedOps
+	sigma := e.ExpectedOps / math.Sqrt(math.Pi/2)
+	x := e.OpsDone / sigma
+	e.Probability = -math.Expm1(-x * x / 2)
+
+	// E[T - n | T > n] = σ·sqrt(π/2)·erfc(x/√2)·exp(x²/2), which tends to
+	// σ/x once the terms over- and underflow
+	if z := x / math.Sqrt2; z < 25 {
+		e.OpsRemaining = sigma * math.Sqrt(math.Pi/2) * math.Erfc(z) * math.Exp(z*z)
+	} else {
+		e.OpsRemaining = sigma / x
+	}
+	return e
+}
@@ -0,0 +1,56 @@
+package kangaroo
+
+import (
+	"math/big"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// ExperimentResult records how one strategy fared over a set of collisions
+type ExperimentResult struct {
+	Strategy   string        // Strategy name
+	Pairs      int           // Collision pairs examined
+	Candidates int           // Candidate keys generated
+	Verified   int           // Pairs for which a candidate verified
+	Keys       []*big.Int    // Distinct private keys found
+	Runtime    time.Duration // Time spent deriving and verifying
+}
+
+// RunExperiment applies each strategy to the same collisions against the
+// same target so their effectiveness and cost can be compared directly
+func RunExperiment(collisions []fastbase.Collision, schema fastbase.Schema, t Target, strategies ...Strategy) []ExperimentResult {
+	half := t.Range.HalfRange()
+	results := make([]ExperimentResult, 0, len(strategies))
+
+	for _, s := range strategies {
+		res := ExperimentResult{Strategy: s.Name(), Pairs: len(collisions)}
+		seen := make(map[string]bool)
+
+		start := time.Now()
+		for _, c := range collisions {
+			da := schema.DecodeDistance(schema.Distance(c.First))
+			db := schema.DecodeDistance(schema.Distance(c.Second))
+			candidates := s.Candidates(da, schema.Type(c.First), db, schema.Type(c.Second), half)
+			res.Candidates += len(candidates)
+
+			for _, k := range candidates {
+				key, ok := t.Verify(k)
+				if !ok {
+					continue
+				}
+				res.Verified++
+				if !seen[key.String()] {
+					seen[key.String()] = true
+					res.Keys = append(res.Keys, key)
+				}
+				break
+			}
+		}
+		res.Runtime = time.Since(start)
+
+		results = append(results, res)
+	}
+
+	return results
+}
@@ -0,0 +1,45 @@
+package kangaroo
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Puzzle is one of the Bitcoin puzzle transactions. The key of puzzle N
+// lies in [2^(N-1), 2^N), a range of N-1 bits as the C++ -range counts.
+type Puzzle struct {
+	Number int
+	PubKey string // Compressed public key in hex, empty while unpublished
+}
+
+// puzzlePubKeys holds the public keys revealed by spends from the puzzle
+// addresses, for the puzzles a kangaroo can reach
+var puzzlePubKeys = map[int]string{
+	85:  "0329c4574a4fd8c810b7e42a4b398882b381bcd85e40c6883712912d167c83e73a",
+	120: "02ceb6cbbcdbdf5ef7150682150f4ce2c6f4807b349827dcdbdd1f2efa885a2630",
+	125: "0233709eb11e0d4439a729f21c2c443dedb727528229713f0065721ba8fa46f00e",
+	130: "03633cbe3ec02b9401c5effa144c5b4d22f87940259634858fc7e59b1c09937852",
+	135: "02145d2611c823a396ef6712ce0f712f09b9b4f3135e3e0aa3230fb9b6d08d1e16",
+	140: "031f6a332d3c5c4f2de2378c012f429cd109ba07d69690c6c701b6bb87860d6640",
+	145: "03afdda497369e219a2c1c369954a930e4d3740968e5e4352475bcffce3140dae5",
+	150: "03137807790ea7dc6e97901c2bc87411f45ed74a5629315c4e4b03a0a102250c49",
+	155: "035cd1854cae45391ca4ec428cc7e6c7d9984424b954209a8eea197b9e364c05f6",
+	160: "02e0a8b039282faf6fe0fd769cfbc4b6b4cf8758ba68220eac420e32b91ddfa673",
+}
+
+// LookupPuzzle returns the preset of puzzle n, in 1...160
+func LookupPuzzle(n int) (Puzzle, error) {
+	if n < 1 || n > 160 {
+		return Puzzle{}, fmt.Errorf("puzzle must be in 1...160, got %d", n)
+	}
+	return Puzzle{Number: n, PubKey: puzzlePubKeys[n]}, nil
+}
+
+// Range returns the key range of the puzzle
+func (p Puzzle) Range() Range {
+	if p.Number == 1 {
+		// The only key of 1 bit; a 0-bit range has no half
+		return Range{Start: big.NewInt(0), Bits: 1}
+	}
+	return Range{Start: new(big.Int).Lsh(big.NewInt(1), uint(p.Number-1)), Bits: p.Number - 1}
+}
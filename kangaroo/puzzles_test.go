@@ -0,0 +1,32 @@
+package kangaroo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"rckangaroo/secp256k1"
+)
+
+func TestPuzzlePubKeys(t *testing.T) {
+	for _, n := range []int{85, 120, 125, 130, 135, 140, 145, 150, 155, 160} {
+		p, err := LookupPuzzle(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.PubKey == "" {
+			t.Errorf("puzzle %d has no public key", n)
+			continue
+		}
+		data, err := hex.DecodeString(p.PubKey)
+		if err != nil {
+			t.Errorf("puzzle %d: %v", n, err)
+			continue
+		}
+		if _, err := secp256k1.ParsePubKey(data); err != nil {
+			t.Errorf("puzzle %d: %v", n, err)
+		}
+	}
+	if p, _ := LookupPuzzle(71); p.PubKey != "" {
+		t.Errorf("puzzle 71 has public key %s; want none, as it was never spent from", p.PubKey)
+	}
+}
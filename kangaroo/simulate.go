@@ -0,0 +1,115 @@
+package kangaroo
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"math/rand"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/secp256k1"
+)
+
+// SimConfig configures Simulate
+type SimConfig struct {
+	Bits      int      // Width of the range of the planted key
+	DPBits    int      // Distinguished point bits
+	Workers   int      // Goroutines walking kangaroos; GOMAXPROCS if 0
+	Kangaroos int      // Kangaroos per worker; DefaultKangaroos if 0
+	MaxOps    float64  // Give up after this many times the expected jumps
+	Strategy  Strategy // Derives the key from collisions; Symmetric if nil
+}
+
+// SimResult is the outcome of one simulated solve
+type SimResult struct {
+	Key        *big.Int      // Key planted
+	Found      *big.Int      // Key derived, nil if none was
+	Ops        uint64        // Jumps made
+	DPs        uint64        // Distinguished points reached
+	Cycles     uint64        // Kangaroos restarted after cycling
+	Collisions int           // Collisions met, including those deriving nothing
+	Runtime    time.Duration // Time from placing the kangaroos to the end
+}
+
+// Solved reports whether the key derived is the one planted
+func (r SimResult) Solved() bool {
+	return r.Found != nil && r.Found.Cmp(r.Key) == 0
+}
+
+// Simulate plants a random key in a random range of the configured width
+// and solves it through the whole pipeline a real run takes: the solver's
+// DPs go into a database, whose collisions the strategy derives the key
+// from. Solving small ranges this way checks changes to any stage in
+// seconds, against a key known in advance.
+func Simulate(ctx context.Context, cfg SimConfig, rng *rand.Rand) (SimResult, error) {
+	if cfg.Strategy == nil {
+		cfg.Strategy = Symmetric{}
+	}
+	start := new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), 64))
+	r := Range{Start: start, Bits: cfg.Bits}
+	res := SimResult{Key: new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), uint(cfg.Bits)))}
+	res.Key.Add(res.Key, start)
+	target := NewTarget(secp256k1.ScalarBaseMult(res.Key), r)
+
+	fb := fastbase.NewFastBase()
+	schema := fb.Schema()
+	solver, err := NewSolver(SolverConfig{
+		Range: r, Targets: []Target{target}, DPBits: cfg.DPBits, Schema: schema,
+		Workers: cfg.Workers, Kangaroos: cfg.Kangaroos, Seed: rng.Int63(),
+	})
+	if err != nil {
+		return res, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := make(chan [][]byte, solver.Workers())
+	done := make(chan struct{})
+	began := time.Now()
+	go func() {
+		solver.Run(ctx, out)
+		close(done)
+	}()
+
+	maxOps := cfg.MaxOps * ExpectedOps(cfg.Bits)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for res.Found == nil && err == nil && ctx.Err() == nil {
+		select {
+		case batch := <-out:
+			var collisions []fastbase.Collision
+			if _, collisions, err = fb.AddRecords(batch); err != nil {
+				break
+			}
+			res.Collisions += len(collisions)
+			for _, c := range collisions {
+				if k, serr := Solve(cfg.Strategy, target, schema, c.First, c.Second); serr == nil {
+					res.Found = k
+					break
+				}
+			}
+		case <-ticker.C:
+			if maxOps > 0 && float64(solver.Ops()) >= maxOps {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	// The workers pass on what they hold when stopped; nothing needs it
+	cancel()
+	for running := true; running; {
+		select {
+		case <-out:
+		case <-done:
+			running = false
+		}
+	}
+	res.Ops, res.DPs, res.Cycles = solver.Ops(), solver.DPs(), solver.Cycles()
+	res.Runtime = time.Since(began)
+	if err == nil && res.Found != nil && !res.Solved() {
+		err = errors.New("derived a key verifying against the target, but not the key planted")
+	}
+	return res, err
+}
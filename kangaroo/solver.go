@@ -0,0 +1,322 @@
+package kangaroo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/secp256k1"
+)
+
+// DefaultKangaroos is how many kangaroos each worker of a Solver walks.
+// More share the cost of each inversion but take longer to start.
+const DefaultKangaroos = 512
+
+// dpBatchSize is how many DP records a worker collects before passing them on
+const dpBatchSize = 256
+
+// SolverConfig configures a Solver
+type SolverConfig struct {
+	Range     Range           // Range of the keys; only its width matters for tames
+	Targets   []Target        // Keys in Range to solve, sharing the tames; none to walk tames only
+	DPBits    int             // Distinguished point bits, see IsDP
+	Schema    fastbase.Schema // Record schema of the DP records
+	Workers   int             // Goroutines walking kangaroos; GOMAXPROCS if 0
+	Kangaroos int             // Kangaroos per worker; DefaultKangaroos if 0
+	Seed      int64           // Seed of the start positions; random if 0
+}
+
+// Walker walks kangaroos and passes on the records of the distinguished
+// points they reach. Solver walks them on the CPU; the gpu package on CUDA
+// devices.
+type Walker interface {
+	// Run walks until ctx is cancelled; see Solver.Run
+	Run(ctx context.Context, out chan<- [][]byte)
+
+	Ops() uint64
+	DPs() uint64
+	Cycles() uint64
+
+	// Snapshot returns where the kangaroos stand, or nil if they cannot
+	// be saved
+	Snapshot(ctx context.Context) (*State, error)
+}
+
+// Solver walks herds of kangaroos on the CPU and reports the distinguished
+// points they reach as records. With targets it walks tames, wild1 and
+// wild2 in equal numbers, the wilds shared out between the targets and the
+// tames serving all of them; without any only tames, to build a tames
+// database for later solves of ranges of the same width.
+type Solver struct {
+	cfg    SolverConfig
+	jumps  Jumps
+	ops    atomic.Uint64
+	dps    atomic.Uint64
+	cycles atomic.Uint64
+
+	half, spread *big.Int             // Tame and wild start distances
+	wilds        [][2]secp256k1.Point // Where wild1 and wild2 of each target start from
+
+	herds   []*lockedHerd // One per worker, once placed or resumed
+	started chan struct{} // Closed once herds is set
+	start   sync.Once
+}
+
+// lockedHerd is a worker's herd, locked while it steps and passes on its
+// DPs so Snapshot sees it between batches
+type lockedHerd struct {
+	mu      sync.Mutex
+	h       *herd
+	rng     *rand.Rand // Start positions of kangaroos placed anew
+	targets []int      // Target each wild is placed around
+}
+
+// NewSolver checks the configuration and prepares the jump table
+func NewSolver(cfg SolverConfig) (*Solver, error) {
+	if cfg.Range.Bits < 16 || cfg.Range.Bits > 8*(cfg.Schema.DistanceLength-1)-4 {
+		return nil, fmt.Errorf("range width must be in 16...%d bits for the %s schema", 8*(cfg.Schema.DistanceLength-1)-4, cfg.Schema.Name)
+	}
+	if cfg.DPBits < 0 || cfg.DPBits > 8*(cfg.Schema.XLength-3) {
+		return nil, fmt.Errorf("DP bits must be in 0...%d for the %s schema", 8*(cfg.Schema.XLength-3), cfg.Schema.Name)
+	}
+	for _, t := range cfg.Targets {
+		if t.Range.Bits != cfg.Range.Bits || t.Range.Start.Cmp(cfg.Targets[0].Range.Start) != 0 {
+			return nil, errors.New("target range differs from the solver range")
+		}
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.Kangaroos <= 0 {
+		cfg.Kangaroos = DefaultKangaroos
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = rand.Int63()
+	}
+	s := &Solver{cfg: cfg, jumps: NewJumps(cfg.Range.Bits), started: make(chan struct{})}
+	s.half = cfg.Range.HalfRange()
+	s.spread = new(big.Int).Rsh(s.half, 3)
+	hG := secp256k1.ScalarBaseMult(s.half)
+	for _, t := range cfg.Targets {
+		// Target.Point = k*G; wilds start near (k-H)*G and its negation
+		w := t.Point.Sub(hG)
+		s.wilds = append(s.wilds, [2]secp256k1.Point{w, w.Neg()})
+	}
+	return s, nil
+}
+
+// Resume continues the walks of a saved state instead of placing new
+// kangaroos when Run starts. The state must be of the same range, target,
+// DP bits and schema; its herds replace the configured workers and
+// kangaroos. It must be called before Run.
+func (s *Solver) Resume(st *State) error {
+	if err := st.check(s.cfg); err != nil {
+		return err
+	}
+	herds := make([]*lockedHerd, len(st.Herds))
+	for w, ks := range st.Herds {
+		if len(ks) == 0 {
+			return fmt.Errorf("herd %d has no kangaroos", w)
+		}
+		h := newHerd(&s.jumps, s.cfg.Schema, s.cfg.DPBits, make([]fastbase.KangarooType, len(ks)))
+		if err := h.restore(ks); err != nil {
+			return fmt.Errorf("herd %d: %v", w, err)
+		}
+		herds[w] = &lockedHerd{h: h, rng: rand.New(rand.NewSource(s.cfg.Seed + int64(w))), targets: s.herdTargets(w, len(ks))}
+	}
+	s.herds = herds
+	s.cfg.Workers, s.cfg.Kangaroos = len(herds), len(st.Herds[0])
+	s.ops.Store(st.Ops)
+	s.dps.Store(st.DPs)
+	s.cycles.Store(st.Cycles)
+	return nil
+}
+
+// Cycles returns how many kangaroos were found cycling and placed anew
+func (s *Solver) Cycles() uint64 {
+	return s.cycles.Load()
+}
+
+// Workers returns the number of herds walked, one per goroutine
+func (s *Solver) Workers() int {
+	return s.cfg.Workers
+}
+
+// Kangaroos returns the number of kangaroos per herd
+func (s *Solver) Kangaroos() int {
+	return s.cfg.Kangaroos
+}
+
+// Snapshot returns where every kangaroo stands, waiting for Run to place
+// them first. While Run goes on, each herd is taken between two batches:
+// every DP it reached before has already been passed to out, so a
+// database holding all records read from out afterwards, saved with the
+// snapshot, resumes without losing any.
+func (s *Solver) Snapshot(ctx context.Context) (*State, error) {
+	select {
+	case <-s.started:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	st := s.cfg.state()
+	st.Ops, st.DPs, st.Cycles = s.ops.Load(), s.dps.Load(), s.cycles.Load()
+	st.Herds = make([][]KangarooState, len(s.herds))
+	for w, lh := range s.herds {
+		lh.mu.Lock()
+		st.Herds[w] = lh.h.save()
+		lh.mu.Unlock()
+	}
+	return &st, nil
+}
+
+// Ops returns the jumps made so far
+func (s *Solver) Ops() uint64 {
+	return s.ops.Load()
+}
+
+// DPs returns the distinguished points reached so far
+func (s *Solver) DPs() uint64 {
+	return s.dps.Load()
+}
+
+// Run walks the kangaroos until ctx is cancelled, passing the records of
+// the distinguished points they reach to out in batches. On cancellation
+// each worker still passes on the records it holds, so out must be read
+// until Run returns. It returns once every worker has stopped; out is not
+// closed.
+func (s *Solver) Run(ctx context.Context, out chan<- [][]byte) {
+	s.start.Do(func() {
+		if s.herds == nil {
+			s.placeHerds()
+		}
+		close(s.started)
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < s.cfg.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			s.work(ctx, w, out)
+		}(w)
+	}
+	wg.Wait()
+}
+
+// placeHerds starts the herd of every worker, in parallel
+func (s *Solver) placeHerds() {
+	herds := make([]*lockedHerd, s.cfg.Workers)
+	var wg sync.WaitGroup
+	for w := range herds {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			herds[w] = s.startHerd(w, rand.New(rand.NewSource(s.cfg.Seed+int64(w))))
+		}(w)
+	}
+	wg.Wait()
+	s.herds = herds
+}
+
+// work steps the herd of worker w until ctx is cancelled
+func (s *Solver) work(ctx context.Context, w int, out chan<- [][]byte) {
+	lh := s.herds[w]
+	h := lh.h
+
+	var batch [][]byte
+	emit := func(rec []byte) { batch = append(batch, rec) }
+	for ctx.Err() == nil {
+		lh.mu.Lock()
+		steps := 0
+		for ; steps < 64 && len(batch) < dpBatchSize; steps++ {
+			h.step(emit)
+			if len(h.stuck) > 0 {
+				s.restart(lh)
+			}
+		}
+		s.ops.Add(uint64(steps * len(h.x)))
+		if len(batch) > 0 {
+			s.dps.Add(uint64(len(batch)))
+			select {
+			case out <- batch:
+				batch = nil
+			case <-ctx.Done():
+			}
+		}
+		lh.mu.Unlock()
+	}
+	if len(batch) > 0 {
+		out <- batch
+	}
+}
+
+// startHerd places the kangaroos of worker w, a third of each type if
+// there are targets
+func (s *Solver) startHerd(w int, rng *rand.Rand) *lockedHerd {
+	n := s.cfg.Kangaroos
+	types := make([]fastbase.KangarooType, n)
+	if len(s.cfg.Targets) > 0 {
+		for i := range types {
+			types[i] = fastbase.KangarooType(i % 3)
+		}
+	}
+	lh := &lockedHerd{
+		h:       newHerd(&s.jumps, s.cfg.Schema, s.cfg.DPBits, types),
+		rng:     rng,
+		targets: s.herdTargets(w, n),
+	}
+	for i := range types {
+		s.placeKangaroo(lh, i)
+	}
+	return lh
+}
+
+// herdTargets returns the target of each kangaroo of worker w's herd of
+// n. Each wild1 and wild2 pair goes to the next target in turn, counting
+// across herds, so every target gets its share however few each herd has.
+func (s *Solver) herdTargets(w, n int) []int {
+	targets := make([]int, n)
+	if len(s.cfg.Targets) == 0 {
+		return targets
+	}
+	for i := range targets {
+		targets[i] = (w*n + i) / 3 % len(s.cfg.Targets)
+	}
+	return targets
+}
+
+// placeKangaroo puts kangaroo i of a herd at a random start for its type:
+// a tame at a random distance across the half range, a wild around its
+// target or the target's negation
+func (s *Solver) placeKangaroo(lh *lockedHerd, i int) {
+	h := lh.h
+	d := new(big.Int)
+	base := secp256k1.Infinity()
+	switch typ := h.types[i]; typ {
+	case fastbase.TypeTame:
+		d.Rand(lh.rng, s.half)
+	case fastbase.TypeWild1, fastbase.TypeWild2:
+		d.Rand(lh.rng, s.spread)
+		d.Sub(d, new(big.Int).Rsh(s.spread, 1))
+		d.SetBit(d, 0, 0)
+		base = s.wilds[lh.targets[i]][typ-fastbase.TypeWild1]
+	}
+	h.place(i, base, d)
+}
+
+// restart places the kangaroos the last step found cycling anew, since
+// they would otherwise cycle forever
+func (s *Solver) restart(lh *lockedHerd) {
+	h := lh.h
+	for _, i := range h.stuck {
+		s.placeKangaroo(lh, i)
+	}
+	s.cycles.Add(uint64(len(h.stuck)))
+	h.stuck = h.stuck[:0]
+}
@@ -0,0 +1,147 @@
+package kangaroo
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/secp256k1"
+)
+
+// State is where every kangaroo of a Solver stands, so a later run can
+// continue the same walks instead of starting new ones. Without it each
+// restart throws away the jumps every kangaroo made since its last DP.
+type State struct {
+	RangeBits int      `json:"range_bits"`
+	DPBits    int      `json:"dp_bits"`
+	Schema    string   `json:"schema"`
+	Targets   []string `json:"targets,omitempty"` // Compressed public keys, none for tames
+	Start     string   `json:"start,omitempty"`   // Range start in hex
+	Ops       uint64   `json:"ops"`
+	DPs       uint64   `json:"dps"`
+	Cycles    uint64   `json:"cycles,omitempty"`
+
+	Herds [][]KangarooState `json:"herds"` // One per worker
+}
+
+// KangarooState is the position of one kangaroo. Its point always has an
+// even y-coordinate, so the x-coordinate alone fixes it.
+type KangarooState struct {
+	X        string                `json:"x"`
+	Distance string                `json:"d"` // Signed, in hex
+	Type     fastbase.KangarooType `json:"type"`
+	Last     int                   `json:"last"` // Previous jump, -1 if none
+}
+
+// LoadState reads a state saved by Save
+func LoadState(filename string) (*State, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &st, nil
+}
+
+// Save writes the state as JSON, replacing filename only once the whole
+// state is written
+func (st *State) Save(filename string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Kangaroos returns the number of kangaroos in the state
+func (st *State) Kangaroos() int {
+	n := 0
+	for _, h := range st.Herds {
+		n += len(h)
+	}
+	return n
+}
+
+// state describes a solver configuration as a State without kangaroos
+func (cfg SolverConfig) state() State {
+	st := State{RangeBits: cfg.Range.Bits, DPBits: cfg.DPBits, Schema: cfg.Schema.Name}
+	for _, t := range cfg.Targets {
+		st.Targets = append(st.Targets, hex.EncodeToString(t.PubKey.Compressed()))
+		st.Start = t.Range.Start.Text(16)
+	}
+	return st
+}
+
+// check reports how a state was walked differently from cfg
+func (st *State) check(cfg SolverConfig) error {
+	want := cfg.state()
+	switch {
+	case st.RangeBits != want.RangeBits:
+		return fmt.Errorf("kangaroos walked a %d-bit range, not %d bits", st.RangeBits, want.RangeBits)
+	case st.DPBits != want.DPBits:
+		return fmt.Errorf("kangaroos walked with DP %d, not %d", st.DPBits, want.DPBits)
+	case st.Schema != want.Schema:
+		return fmt.Errorf("kangaroos walked for the %s schema, not %s", st.Schema, want.Schema)
+	case !slices.Equal(st.Targets, want.Targets) || st.Start != want.Start:
+		return errors.New("kangaroos walked for different targets")
+	case len(st.Herds) == 0:
+		return errors.New("no kangaroos saved")
+	}
+	return nil
+}
+
+// save returns the positions of the herd's kangaroos
+func (h *herd) save() []KangarooState {
+	ks := make([]KangarooState, len(h.x))
+	for i := range ks {
+		h.x[i].FillBytes(h.xb[:])
+		ks[i] = KangarooState{
+			X:        hex.EncodeToString(h.xb[:]),
+			Distance: h.d[i].Text(16),
+			Type:     h.types[i],
+			Last:     h.last[i],
+		}
+	}
+	return ks
+}
+
+// restore puts the herd's kangaroos back where save found them
+func (h *herd) restore(ks []KangarooState) error {
+	for i, k := range ks {
+		xb, err := hex.DecodeString(k.X)
+		if err != nil || len(xb) != 32 {
+			return fmt.Errorf("kangaroo %d: invalid x-coordinate %q", i, k.X)
+		}
+		p, err := secp256k1.ParsePubKey(append([]byte{0x02}, xb...))
+		if err != nil {
+			return fmt.Errorf("kangaroo %d: %v", i, err)
+		}
+		d, ok := new(big.Int).SetString(k.Distance, 16)
+		if !ok {
+			return fmt.Errorf("kangaroo %d: invalid distance %q", i, k.Distance)
+		}
+		if k.Type > fastbase.TypeWild2 || k.Last < -1 || k.Last >= JumpCount {
+			return fmt.Errorf("kangaroo %d: invalid type or jump", i)
+		}
+		h.x[i].Set(p.X)
+		h.y[i].Set(p.Y)
+		h.d[i].Set(d)
+		h.types[i] = k.Type
+		h.last[i] = k.Last
+		h.resetCycle(i)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package kangaroo
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/secp256k1"
+)
+
+// JumpCount is the number of jumps a kangaroo chooses from by its
+// x-coordinate
+const JumpCount = 512
+
+// MaxCycle is the longest cycle a kangaroo is checked for jump by jump.
+// Walks on points up to sign fall into short cycles now and then; longer
+// ones are caught by going too long without a DP.
+const MaxCycle = 1 << 10
+
+// droughtDPs is how many times the jumps expected between two DPs a
+// kangaroo may go without reaching one before it counts as cycling
+const droughtDPs = 32
+
+// Jumps is the table kangaroos choose their jumps from. It depends only on
+// the range width, so tames collected by one run meet the wilds of any
+// later run on a range of the same width, as with the C++ -tames option.
+type Jumps struct {
+	Dist   []*big.Int        // Even jump distances
+	Points []secp256k1.Point // Dist[i] * G
+}
+
+// NewJumps returns the jump table of a range width. Jumps average about
+// 2^(bits/2+9), which suits a few thousand kangaroos, the most a CPU run
+// keeps busy, but stay below 2^(bits-7) so small ranges are not skipped.
+func NewJumps(rangeBits int) Jumps {
+	rng := rand.New(rand.NewSource(int64(rangeBits)))
+	minJump := new(big.Int).Lsh(big.NewInt(1), uint(max(min(rangeBits/2+9, rangeBits-8), 2)))
+	j := Jumps{Dist: make([]*big.Int, JumpCount), Points: make([]secp256k1.Point, JumpCount)}
+	for i := range j.Dist {
+		d := new(big.Int).Rand(rng, minJump)
+		d.Add(d, minJump)
+		d.SetBit(d, 0, 0)
+		j.Dist[i] = d
+		j.Points[i] = secp256k1.ScalarBaseMult(d)
+	}
+	return j
+}
+
+// IsDP reports whether a point with the given big-endian x-coordinate is
+// distinguished: the last dpBits bits of its x-coordinate as truncated by
+// the schema are zero. Judging the bits records keep, rather than bits
+// they drop, lets a database be re-filtered to more DP bits later.
+func IsDP(schema fastbase.Schema, x []byte, dpBits int) bool {
+	return schema.IsDP(x, dpBits)
+}
+
+// DPFilter selects records whose x-coordinate is distinguished at dpBits,
+// such as the records of a database collected at fewer DP bits that a run
+// at dpBits would also have kept
+func DPFilter(schema fastbase.Schema, dpBits int) fastbase.Filter {
+	return func(prefix [3]byte, record []byte) bool {
+		return IsDP(schema, record, dpBits)
+	}
+}
+
+// herd is a set of kangaroos stepped together, sharing one modular
+// inversion per step between all of them.
+//
+// Kangaroos walk on points up to sign: after every jump a point with an
+// odd y-coordinate is negated, together with its distance, so points with
+// the same x-coordinate continue on the same path. A tame at distance d
+// stands on d*G; a wild on ±(k-H)*G + d*G, with k the range-relative key
+// and H half the range. Symmetric derives the key from either kind of
+// collision.
+type herd struct {
+	jumps  *Jumps
+	schema fastbase.Schema
+	dpBits int
+
+	x, y, d []*big.Int
+	types   []fastbase.KangarooType
+	last    []int // Index of the previous jump, never repeated
+
+	// Cycle detection, see looped
+	mark        []*big.Int // x-coordinate the next ones are compared with
+	age, period []int      // Jumps since the mark, and when to move it
+	sinceDP     []uint64   // Jumps since the last DP
+	drought     uint64     // sinceDP at which a kangaroo counts as cycling
+	stuck       []int      // Kangaroos found cycling by the last step
+
+	jump          []int
+	dx, prod      []*big.Int
+	acc, inv, t   *big.Int
+	lambda, x3, u *big.Int
+	xb            [32]byte
+}
+
+func newHerd(jumps *Jumps, schema fastbase.Schema, dpBits int, types []fastbase.KangarooType) *herd {
+	n := len(types)
+	h := &herd{jumps: jumps, schema: schema, dpBits: dpBits, types: types, last: make([]int, n), jump: make([]int, n),
+		age: make([]int, n), period: make([]int, n), sinceDP: make([]uint64, n), drought: math.MaxUint64}
+	if dpBits < 48 {
+		h.drought = droughtDPs << dpBits
+	}
+	for _, s := range []*[]*big.Int{&h.x, &h.y, &h.d, &h.dx, &h.prod, &h.mark} {
+		*s = make([]*big.Int, n)
+		for i := range *s {
+			(*s)[i] = new(big.Int)
+		}
+	}
+	for _, v := range []**big.Int{&h.acc, &h.inv, &h.t, &h.lambda, &h.x3, &h.u} {
+		*v = new(big.Int)
+	}
+	for i := range h.last {
+		h.last[i] = -1
+	}
+	return h
+}
+
+// place puts kangaroo i on the point base + d*G, normalized to an even
+// y-coordinate
+func (h *herd) place(i int, base secp256k1.Point, d *big.Int) {
+	p := base.Add(secp256k1.ScalarBaseMult(d))
+	h.d[i].Set(d)
+	if p.IsInfinity() {
+		// Only the key itself lands here; nudge it off by one jump
+		p = h.jumps.Points[0]
+		h.d[i].Add(h.d[i], h.jumps.Dist[0])
+	}
+	h.x[i].Set(p.X)
+	h.y[i].Set(p.Y)
+	if h.y[i].Bit(0) == 1 {
+		h.y[i].Sub(secp256k1.P, h.y[i])
+		h.d[i].Neg(h.d[i])
+	}
+	h.last[i] = -1
+	h.resetCycle(i)
+}
+
+// resetCycle starts looking for a cycle of kangaroo i afresh from where
+// it stands
+func (h *herd) resetCycle(i int) {
+	h.mark[i].Set(h.x[i])
+	h.age[i], h.period[i], h.sinceDP[i] = 0, 1, 0
+}
+
+// looped reports whether kangaroo i is cycling after a jump. Brent-style,
+// its x-coordinate is compared with one marked a power of two jumps ago,
+// the power growing up to MaxCycle, which finds any cycle of up to
+// MaxCycle jumps within 2*MaxCycle jumps of entering it. Cycles too long
+// for that are caught by going droughtDPs times too long without a DP.
+func (h *herd) looped(i int, dp bool) bool {
+	if dp {
+		h.sinceDP[i] = 0
+	} else if h.sinceDP[i]++; h.sinceDP[i] >= h.drought {
+		return true
+	}
+	if h.x[i].Cmp(h.mark[i]) == 0 {
+		return true
+	}
+	if h.age[i]++; h.age[i] >= h.period[i] {
+		h.mark[i].Set(h.x[i])
+		h.age[i] = 0
+		h.period[i] = min(2*h.period[i], MaxCycle)
+	}
+	return false
+}
+
+// jumpIndex picks the jump of kangaroo i from its x-coordinate. Repeating
+// the previous jump could return a negated point to where it came from,
+// so the next one is taken instead.
+func (h *herd) jumpIndex(i int) int {
+	j := 0
+	if words := h.x[i].Bits(); len(words) > 0 {
+		j = int(words[0] & (JumpCount - 1))
+	}
+	if j == h.last[i] {
+		j = (j + 1) % JumpCount
+	}
+	return j
+}
+
+// step moves every kangaroo by one jump and calls dp with the record of
+// each distinguished point reached. Kangaroos found cycling are listed in
+// stuck, for the caller to place anew.
+func (h *herd) step(dp func(rec []byte)) {
+	P := secp256k1.P
+	n := len(h.x)
+
+	// dx = jx - x for every kangaroo, and their running products
+	h.acc.SetInt64(1)
+	for i := 0; i < n; i++ {
+		j := h.jumpIndex(i)
+		h.jump[i] = j
+		dx := h.dx[i]
+		dx.Sub(h.jumps.Points[j].X, h.x[i])
+		dx.Mod(dx, P)
+		h.prod[i].Set(h.acc)
+		h.acc.Mul(h.acc, dx)
+		h.acc.Mod(h.acc, P)
+	}
+	if h.acc.Sign() == 0 {
+		// A kangaroo sits on a jump point's x-coordinate; its jump would
+		// double or cancel. Step it by the next jump and start over.
+		for i := 0; i < n; i++ {
+			if h.dx[i].Sign() == 0 {
+				h.last[i] = h.jump[i]
+			}
+		}
+		h.step(dp)
+		return
+	}
+	h.inv.ModInverse(h.acc, P)
+
+	for i := n - 1; i >= 0; i-- {
+		// t = 1/dx[i], then drop dx[i] from the running inverse
+		h.t.Mul(h.inv, h.prod[i])
+		h.t.Mod(h.t, P)
+		h.inv.Mul(h.inv, h.dx[i])
+		h.inv.Mod(h.inv, P)
+
+		jp := &h.jumps.Points[h.jump[i]]
+		x, y := h.x[i], h.y[i]
+
+		// lambda = (jy - y) / (jx - x)
+		h.lambda.Sub(jp.Y, y)
+		h.lambda.Mul(h.lambda, h.t)
+		h.lambda.Mod(h.lambda, P)
+
+		// x3 = lambda^2 - x - jx, y3 = lambda (x - x3) - y
+		h.x3.Mul(h.lambda, h.lambda)
+		h.x3.Sub(h.x3, x)
+		h.x3.Sub(h.x3, jp.X)
+		h.x3.Mod(h.x3, P)
+		h.u.Sub(x, h.x3)
+		h.u.Mul(h.u, h.lambda)
+		h.u.Sub(h.u, y)
+		y.Mod(h.u, P)
+		x.Set(h.x3)
+
+		d := h.d[i]
+		d.Add(d, h.jumps.Dist[h.jump[i]])
+		if y.Bit(0) == 1 {
+			y.Sub(P, y)
+			d.Neg(d)
+		}
+		h.last[i] = h.jump[i]
+
+		x.FillBytes(h.xb[:])
+		isDP := IsDP(h.schema, h.xb[:], h.dpBits)
+		if isDP {
+			if rec, err := h.schema.NewRecord(h.xb, d, h.types[i]); err == nil {
+				dp(rec)
+			}
+		}
+		if h.looped(i, isDP) {
+			h.stuck = append(h.stuck, i)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Check appends the first four bytes of the double SHA-256 of data
+// and encodes the result in base58
+func base58Check(data []byte) string {
+	first := sha256.Sum256(data)
+	check := sha256.Sum256(first[:])
+	return base58(append(append([]byte(nil), data...), check[:4]...))
+}
+
+// base58 encodes bytes in the Bitcoin base58 alphabet, keeping leading
+// zero bytes as '1's
+func base58(data []byte) string {
+	var out []byte
+	n := new(big.Int).SetBytes(data)
+	radix, digit := big.NewInt(58), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, digit)
+		out = append(out, base58Alphabet[digit.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,60 @@
+package keys
+
+import "strings"
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Polymod is the BIP 173 checksum over 5-bit values
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// segwitV0 encodes a version 0 witness program as a bech32 address with
+// the given human-readable part
+func segwitV0(hrp string, program []byte) string {
+	// Regroup the program's 8-bit bytes into 5-bit values after the version
+	data := []byte{0}
+	acc, n := 0, 0
+	for _, b := range program {
+		acc = acc<<8 | int(b)
+		for n += 8; n >= 5; n -= 5 {
+			data = append(data, byte(acc>>(n-5)&31))
+		}
+	}
+	if n > 0 {
+		data = append(data, byte(acc<<(5-n)&31))
+	}
+
+	values := make([]byte, 0, 2*len(hrp)+1+len(data)+6)
+	for _, c := range []byte(hrp) {
+		values = append(values, c>>5)
+	}
+	values = append(values, 0)
+	for _, c := range []byte(hrp) {
+		values = append(values, c&31)
+	}
+	values = append(values, data...)
+	mod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[mod>>(5*(5-i))&31])
+	}
+	return sb.String()
+}
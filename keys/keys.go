@@ -0,0 +1,95 @@
+// Package keys turns the curve points and scalars the solver works with
+// into the forms wallets use: hex public keys in, WIF private keys and
+// P2PKH/P2WPKH addresses out
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"rckangaroo/secp256k1"
+)
+
+// ParsePubKey decodes a compressed or uncompressed public key given in hex,
+// with or without a 0x prefix
+func ParsePubKey(s string) (secp256k1.Point, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return secp256k1.Point{}, fmt.Errorf("invalid public key hex: %v", err)
+	}
+	p, err := secp256k1.ParsePubKey(data)
+	if err != nil {
+		return secp256k1.Point{}, fmt.Errorf("invalid public key: %v", err)
+	}
+	return p, nil
+}
+
+// WIF returns the mainnet Wallet Import Format encoding of a private key,
+// flagged for the compressed public key when compressed is set
+func WIF(key *big.Int, compressed bool) string {
+	payload := make([]byte, 33, 34)
+	payload[0] = 0x80
+	key.FillBytes(payload[1:])
+	if compressed {
+		payload = append(payload, 0x01)
+	}
+	return base58Check(payload)
+}
+
+// Hash160 returns RIPEMD-160(SHA-256(data)), the hash addresses commit to
+func Hash160(data []byte) [20]byte {
+	sum := sha256.Sum256(data)
+	return ripemd160(sum[:])
+}
+
+// P2PKH returns the mainnet pay-to-public-key-hash address of a public key
+// in its compressed or uncompressed encoding
+func P2PKH(pub secp256k1.Point, compressed bool) string {
+	enc := pub.Uncompressed()
+	if compressed {
+		enc = pub.Compressed()
+	}
+	h := Hash160(enc)
+	return base58Check(append([]byte{0x00}, h[:]...))
+}
+
+// P2WPKH returns the mainnet native segwit address of a public key, which
+// is always hashed compressed
+func P2WPKH(pub secp256k1.Point) string {
+	h := Hash160(pub.Compressed())
+	return segwitV0("bc", h[:])
+}
+
+// Key is a recovered private key with its public key
+type Key struct {
+	Private *big.Int
+	Public  secp256k1.Point
+}
+
+// NewKey derives the public key of a private key
+func NewKey(private *big.Int) Key {
+	return Key{Private: private, Public: secp256k1.ScalarBaseMult(private)}
+}
+
+// Format is one named encoding of a key, such as its WIF or an address
+type Format struct {
+	Name  string
+	Value string
+}
+
+// Formats returns the ways a wallet may want the key: hex, WIF, and the
+// addresses of both public key encodings
+func (k Key) Formats() []Format {
+	return []Format{
+		{"Private key", fmt.Sprintf("%064x", k.Private)},
+		{"WIF", WIF(k.Private, true)},
+		{"WIF (uncomp.)", WIF(k.Private, false)},
+		{"Public key", hex.EncodeToString(k.Public.Compressed())},
+		{"P2PKH", P2PKH(k.Public, true)},
+		{"P2PKH (uncomp.)", P2PKH(k.Public, false)},
+		{"P2WPKH", P2WPKH(k.Public)},
+	}
+}
@@ -0,0 +1,177 @@
+package lsm
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runPath names the next run file. The caller holds s.mu.
+func (s *Store) runPath() string {
+	path := filepath.Join(s.dir, fmt.Sprintf("%08d.run", s.nextRun))
+	s.nextRun++
+	return path
+}
+
+// createRun writes the records next returns as a run file at path and
+// opens it
+func (s *Store) createRun(path string, next func() ([]byte, error)) (*run, error) {
+	if _, err := writeRun(path, s.schema.RecordLength, s.schema.XLength, s.opts.BlockLength, next); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return openRun(path, s.schema.RecordLength, s.schema.XLength)
+}
+
+// newRun writes the records next returns as a new run file. The caller
+// holds s.mu.
+func (s *Store) newRun(next func() ([]byte, error)) (*run, error) {
+	return s.createRun(s.runPath(), next)
+}
+
+// compactor merges runs in the background each time a flush signals it,
+// until the store closes
+func (s *Store) compactor() {
+	defer close(s.done)
+	for range s.compact {
+		for {
+			merged, err := s.compactOnce()
+			if err != nil {
+				s.mu.Lock()
+				s.err = fmt.Errorf("merging runs: %v", err)
+				s.mu.Unlock()
+				return
+			}
+			if !merged {
+				break
+			}
+		}
+	}
+}
+
+// pickMerge chooses adjacent runs to merge, runs[i:j], or returns i < 0 if
+// none need merging. Runs are merged once an older one holds no more than
+// twice the records of the newer ones, so their sizes grow geometrically
+// and a store of n records has about log n runs for a lookup to read.
+func (s *Store) pickMerge() (i, j int) {
+	for i = 0; i < len(s.runs)-1; i++ {
+		sum := s.runs[i].count
+		for j = i + 1; j < len(s.runs) && s.runs[j].count <= 2*sum; j++ {
+			sum += s.runs[j].count
+		}
+		if j-i >= 2 {
+			return i, j
+		}
+	}
+	return -1, -1
+}
+
+// compactOnce merges one set of runs, if any need it. The merge is written
+// without holding s.mu, so adds carry on meanwhile; flushes only put runs
+// in front of the inputs, which stay adjacent for the swap.
+func (s *Store) compactOnce() (bool, error) {
+	s.mu.Lock()
+	i, j := s.pickMerge()
+	if i < 0 {
+		s.mu.Unlock()
+		return false, nil
+	}
+	inputs := append([]*run(nil), s.runs[i:j]...)
+	path := s.runPath()
+	s.mu.Unlock()
+
+	sources := make([]func() ([]byte, error), len(inputs))
+	for k, r := range inputs {
+		sources[k] = r.iterator()
+	}
+	next, err := mergeIterators(sources)
+	if err != nil {
+		return false, err
+	}
+	merged, err := s.createRun(path, next)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	k := 0
+	for s.runs[k] != inputs[0] {
+		k++
+	}
+	runs := append([]*run(nil), s.runs[:k]...)
+	runs = append(runs, merged)
+	s.runs = append(runs, s.runs[k+len(inputs):]...)
+	err = s.writeManifest()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range inputs {
+		r.close()
+		os.Remove(r.name)
+	}
+	return true, nil
+}
+
+// mergeIterators merges sorted record sources into one sorted source,
+// dropping records that more than one source holds
+func mergeIterators(sources []func() ([]byte, error)) (func() ([]byte, error), error) {
+	h := &mergeHeap{}
+	for _, next := range sources {
+		rec, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			h.items = append(h.items, mergeItem{rec, next})
+		}
+	}
+	heap.Init(h)
+
+	var last []byte
+	return func() ([]byte, error) {
+		for len(h.items) > 0 {
+			top := &h.items[0]
+			rec := top.rec
+			next, err := top.next()
+			if err != nil {
+				return nil, err
+			}
+			if next == nil {
+				heap.Pop(h)
+			} else {
+				top.rec = next
+				heap.Fix(h, 0)
+			}
+			if last != nil && bytes.Equal(rec, last) {
+				continue
+			}
+			last = rec
+			return rec, nil
+		}
+		return nil, nil
+	}, nil
+}
+
+type mergeItem struct {
+	rec  []byte
+	next func() ([]byte, error)
+}
+
+// mergeHeap orders the sources of a merge by their next record
+type mergeHeap struct {
+	items []mergeItem
+}
+
+func (h *mergeHeap) Len() int           { return len(h.items) }
+func (h *mergeHeap) Less(a, b int) bool { return bytes.Compare(h.items[a].rec, h.items[b].rec) < 0 }
+func (h *mergeHeap) Swap(a, b int)      { h.items[a], h.items[b] = h.items[b], h.items[a] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
@@ -0,0 +1,226 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A run file holds records sorted by their bytes, so by x-coordinate,
+// after an 8-byte magic. An index of the x-coordinate of the first record
+// of every block follows them, and a trailer ends the file:
+//
+//	count       uint64  Records in the run
+//	indexOffset uint64  Where the index starts
+//	recordLen   uint32
+//	blockLen    uint32  Records per index block
+//	magic       [8]byte
+//
+// all little-endian. Only the index is kept in memory; a lookup reads the
+// one or two blocks that may hold its x-coordinate.
+var runMagic = [8]byte{'R', 'C', 'K', 'L', 'S', 'M', '0', '1'}
+
+const runTrailerSize = 8 + 8 + 4 + 4 + 8
+
+// run is an open run file
+type run struct {
+	name  string
+	file  *os.File
+	count int64
+	rec   int    // Record length
+	xLen  int    // x-coordinate length
+	block int    // Records per index block
+	index []byte // x-coordinate of the first record of each block
+}
+
+// writeRun writes the records next returns, in sorted order until it
+// returns nil, as a run file at path, returning how many it wrote
+func writeRun(path string, recLen, xLen, block int, next func() ([]byte, error)) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriterSize(file, 1<<20)
+	w.Write(runMagic[:])
+
+	var count int64
+	var index []byte
+	for {
+		rec, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		if count%int64(block) == 0 {
+			index = append(index, rec[:xLen]...)
+		}
+		w.Write(rec)
+		count++
+	}
+
+	var trailer [runTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:], uint64(count))
+	binary.LittleEndian.PutUint64(trailer[8:], uint64(len(runMagic))+uint64(count)*uint64(recLen))
+	binary.LittleEndian.PutUint32(trailer[16:], uint32(recLen))
+	binary.LittleEndian.PutUint32(trailer[20:], uint32(block))
+	copy(trailer[24:], runMagic[:])
+	w.Write(index)
+	w.Write(trailer[:])
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return count, file.Close()
+}
+
+// openRun opens a run file and reads its index
+func openRun(path string, recLen, xLen int) (*run, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := readRun(file, recLen, xLen)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r.name = path
+	return r, nil
+}
+
+func readRun(file *os.File, recLen, xLen int) (*run, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var trailer [runTrailerSize]byte
+	if fi.Size() < int64(len(runMagic)+runTrailerSize) {
+		return nil, errors.New("not a run file")
+	}
+	if _, err := file.ReadAt(trailer[:], fi.Size()-runTrailerSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[24:], runMagic[:]) {
+		return nil, errors.New("not a run file, or one cut short")
+	}
+
+	r := &run{
+		file:  file,
+		count: int64(binary.LittleEndian.Uint64(trailer[0:])),
+		rec:   int(binary.LittleEndian.Uint32(trailer[16:])),
+		xLen:  xLen,
+		block: int(binary.LittleEndian.Uint32(trailer[20:])),
+	}
+	if r.rec != recLen {
+		return nil, fmt.Errorf("holds %d-byte records, not %d", r.rec, recLen)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer[8:]))
+	blocks := (r.count + int64(r.block) - 1) / int64(r.block)
+	if r.block <= 0 || indexOffset != int64(len(runMagic))+r.count*int64(r.rec) ||
+		indexOffset+blocks*int64(xLen)+runTrailerSize != fi.Size() {
+		return nil, errors.New("inconsistent run trailer")
+	}
+	r.index = make([]byte, blocks*int64(xLen))
+	if _, err := file.ReadAt(r.index, indexOffset); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// close closes the run file
+func (r *run) close() error {
+	return r.file.Close()
+}
+
+// blocks returns the number of index blocks
+func (r *run) blocks() int {
+	return len(r.index) / r.xLen
+}
+
+// readBlock reads the records of block b
+func (r *run) readBlock(b int) ([]byte, error) {
+	first := int64(b) * int64(r.block)
+	n := min(int64(r.block), r.count-first)
+	buf := make([]byte, n*int64(r.rec))
+	_, err := r.file.ReadAt(buf, int64(len(runMagic))+first*int64(r.rec))
+	return buf, err
+}
+
+// blockCache keeps the block of each run a batch of sorted lookups read
+// last, which the next lookup often needs again
+type blockCache map[*run]cachedBlock
+
+type cachedBlock struct {
+	b    int
+	data []byte
+}
+
+func (c blockCache) get(r *run, b int) ([]byte, error) {
+	if cb, ok := c[r]; ok && cb.b == b {
+		return cb.data, nil
+	}
+	data, err := r.readBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	c[r] = cachedBlock{b: b, data: data}
+	return data, nil
+}
+
+// lookup returns copies of the records of the run with x-coordinate x
+func (r *run) lookup(x []byte, cache blockCache) ([][]byte, error) {
+	// Records of x may start in the block before the first that starts at
+	// or after x
+	n := r.blocks()
+	b := sort.Search(n, func(i int) bool {
+		return bytes.Compare(r.index[i*r.xLen:(i+1)*r.xLen], x) >= 0
+	})
+	if b > 0 {
+		b--
+	}
+
+	var found [][]byte
+	for ; b < n; b++ {
+		data, err := cache.get(r, b)
+		if err != nil {
+			return nil, err
+		}
+		for off := 0; off < len(data); off += r.rec {
+			switch bytes.Compare(data[off:off+r.xLen], x) {
+			case 0:
+				found = append(found, append([]byte(nil), data[off:off+r.rec]...))
+			case 1:
+				return found, nil
+			}
+		}
+	}
+	return found, nil
+}
+
+// iterator returns a function reading the records of the run in order,
+// then nil
+func (r *run) iterator() func() ([]byte, error) {
+	br := bufio.NewReaderSize(io.NewSectionReader(r.file, int64(len(runMagic)), r.count*int64(r.rec)), 1<<20)
+	var read int64
+	return func() ([]byte, error) {
+		if read == r.count {
+			return nil, nil
+		}
+		rec := make([]byte, r.rec)
+		if _, err := io.ReadFull(br, rec); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.name, err)
+		}
+		read++
+		return rec, nil
+	}
+}
@@ -0,0 +1,466 @@
+// Package lsm keeps distinguished points on disk in a log-structured merge
+// tree, for pools whose DP sets outgrow the memory a FastBase needs. New
+// records collect in memory and in a write-ahead log, and are written out
+// as sorted, immutable run files that are merged in the background as
+// they pile up, so a store holds as many records as the disk does while
+// memory holds only the sparse index of each run. It is written for this
+// repository rather than built on Badger or Pebble, whose generality
+// records of fixed length don't need, to keep the module dependency-free.
+//
+// Records are those of a fastbase.Schema, without provenance, and a store
+// treats them as a FastBase does: a record equal to a stored one but for
+// its type is a duplicate and skipped, and one sharing its x-coordinate
+// with a stored record of another type is a collision, reported as it is
+// added.
+package lsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"rckangaroo/fastbase"
+)
+
+// Defaults for zero Options fields
+const (
+	DefaultMemRecords  = 1 << 20
+	DefaultBlockLength = 1024
+)
+
+const (
+	manifestName = "MANIFEST.json"
+	walName      = "wal.log"
+)
+
+// Options configures a Store
+type Options struct {
+	MemRecords  int  // Records held in memory before they are written out as a run
+	BlockLength int  // Records per block of a run's index; smaller costs memory, larger costs reads
+	SyncWrites  bool // Sync the write-ahead log after every Add, so no acknowledged record is lost in a crash
+}
+
+// Store is an LSM tree of the records of one schema in a directory
+type Store struct {
+	dir    string
+	schema fastbase.Schema
+	opts   Options
+
+	mu      sync.Mutex          // Guards the fields below
+	mem     map[string][][]byte // Records not yet in a run, by x-coordinate
+	memLen  int
+	wal     *os.File
+	runs    []*run // Newest first
+	nextRun int
+	err     error // Failure of a background merge, reported by the next Add
+	closed  bool
+
+	compact chan struct{}
+	done    chan struct{}
+}
+
+// manifest lists the runs of a store. A run file not listed is the output
+// of a flush or merge that never completed, and is removed.
+type manifest struct {
+	Schema  byte     `json:"schema"`
+	Runs    []string `json:"runs"` // Newest first
+	NextRun int      `json:"next_run"`
+}
+
+// SchemaOf returns the schema of the records of the store in dir, or an
+// error satisfying os.IsNotExist if there is none
+func SchemaOf(dir string) (fastbase.Schema, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return fastbase.Schema{}, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fastbase.Schema{}, fmt.Errorf("%s: %v", filepath.Join(dir, manifestName), err)
+	}
+	return fastbase.SchemaByID(m.Schema)
+}
+
+// Open opens the store in dir, creating it for records of schema if it
+// does not exist. Records logged but not yet in a run are read back.
+func Open(dir string, schema fastbase.Schema, opts Options) (*Store, error) {
+	if opts.MemRecords <= 0 {
+		opts.MemRecords = DefaultMemRecords
+	}
+	if opts.BlockLength <= 0 {
+		opts.BlockLength = DefaultBlockLength
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:     dir,
+		schema:  schema,
+		opts:    opts,
+		mem:     make(map[string][][]byte),
+		compact: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	m := manifest{Schema: schema.ID}
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err == nil {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Join(dir, manifestName), err)
+		}
+		if m.Schema != schema.ID {
+			other, _ := fastbase.SchemaByID(m.Schema)
+			return nil, fmt.Errorf("%s holds %s records, not %s", dir, other.Name, schema.Name)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	s.nextRun = m.NextRun
+
+	listed := make(map[string]bool)
+	for _, name := range m.Runs {
+		r, err := openRun(filepath.Join(dir, name), schema.RecordLength, schema.XLength)
+		if err != nil {
+			s.closeRuns()
+			return nil, err
+		}
+		s.runs = append(s.runs, r)
+		listed[name] = true
+	}
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.run"))
+	for _, path := range stale {
+		if !listed[filepath.Base(path)] {
+			os.Remove(path)
+		}
+	}
+
+	if err := s.replayWAL(); err != nil {
+		s.closeRuns()
+		return nil, err
+	}
+	if err := s.writeManifest(); err != nil {
+		s.closeRuns()
+		return nil, err
+	}
+
+	go s.compactor()
+	s.compact <- struct{}{}
+	return s, nil
+}
+
+// replayWAL adds the records logged since the last flush back, logging
+// them afresh. A record cut short by a crash is dropped, and so are those
+// a flush interrupted before it emptied the log had already written out.
+// The collisions they complete were reported when they were first added.
+func (s *Store) replayWAL() error {
+	path := filepath.Join(s.dir, walName)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if s.wal, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		return err
+	}
+	n := s.schema.RecordLength
+	var recs [][]byte
+	for off := 0; off+n <= len(data); off += n {
+		recs = append(recs, data[off:off+n])
+	}
+	_, err = s.add(recs)
+	return err
+}
+
+// writeManifest replaces the manifest with the current runs
+func (s *Store) writeManifest() error {
+	m := manifest{Schema: s.schema.ID, NextRun: s.nextRun}
+	for _, r := range s.runs {
+		m.Runs = append(m.Runs, filepath.Base(r.name))
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, manifestName)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// memAdd adds a record to the memory table
+func (s *Store) memAdd(rec []byte) {
+	x := string(s.schema.X(rec))
+	s.mem[x] = append(s.mem[x], rec)
+	s.memLen++
+}
+
+// Add adds records of the store's schema, skipping those already stored,
+// and returns what it did with the collisions the new records complete,
+// both with stored records and within the batch. Records are filed under
+// the first three bytes of their x-coordinate, as the solver files them.
+func (s *Store) Add(records [][]byte) (fastbase.MergeStats, error) {
+	var stats fastbase.MergeStats
+	n := s.schema.RecordLength
+	for i, rec := range records {
+		if len(rec) != n {
+			return stats, fmt.Errorf("record %d: data length must be %d bytes", i, n)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return stats, errClosed
+	}
+	if s.err != nil {
+		return stats, s.err
+	}
+	return s.add(records)
+}
+
+// add adds records of the right length. The caller holds s.mu.
+func (s *Store) add(records [][]byte) (fastbase.MergeStats, error) {
+	var stats fastbase.MergeStats
+	sorted := make([][]byte, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(a, b int) bool { return bytes.Compare(sorted[a], sorted[b]) < 0 })
+
+	id := s.schema.XLength + s.schema.DistanceLength
+	cache := make(blockCache)
+	var fresh []byte
+	for _, rec := range sorted {
+		stats.Records++
+		stored, err := s.lookup(s.schema.X(rec), cache)
+		if err != nil {
+			return stats, err
+		}
+		var other []byte
+		dup := false
+		for _, st := range stored {
+			if bytes.Equal(st[:id], rec[:id]) {
+				dup = true
+				break
+			}
+			if other == nil && s.schema.Type(st) != s.schema.Type(rec) {
+				other = st
+			}
+		}
+		if dup {
+			stats.Duplicates++
+			continue
+		}
+
+		rec = append([]byte(nil), rec...)
+		s.memAdd(rec)
+		fresh = append(fresh, rec...)
+		stats.Added++
+		if other != nil {
+			stats.Collisions++
+			stats.Found = append(stats.Found, fastbase.Collision{
+				Prefix: [3]byte{rec[0], rec[1], rec[2]},
+				First:  append([]byte(nil), other...),
+				Second: rec,
+			})
+		}
+	}
+
+	if len(fresh) > 0 {
+		if _, err := s.wal.Write(fresh); err != nil {
+			return stats, err
+		}
+		if s.opts.SyncWrites {
+			if err := s.wal.Sync(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if s.memLen >= s.opts.MemRecords {
+		return stats, s.flushLocked()
+	}
+	return stats, nil
+}
+
+// lookup returns the stored records with x-coordinate x
+func (s *Store) lookup(x []byte, cache blockCache) ([][]byte, error) {
+	found := append([][]byte(nil), s.mem[string(x)]...)
+	for _, r := range s.runs {
+		recs, err := r.lookup(x, cache)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, recs...)
+	}
+	return found, nil
+}
+
+// Lookup returns the stored records with x-coordinate x
+func (s *Store) Lookup(x []byte) ([][]byte, error) {
+	if len(x) != s.schema.XLength {
+		return nil, fmt.Errorf("x-coordinate must be %d bytes", s.schema.XLength)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(x, make(blockCache))
+}
+
+// Flush writes the records in memory out as a run, emptying the log
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *Store) flushLocked() error {
+	if s.memLen == 0 {
+		return nil
+	}
+	recs := make([][]byte, 0, s.memLen)
+	for _, list := range s.mem {
+		recs = append(recs, list...)
+	}
+	sort.Slice(recs, func(a, b int) bool { return bytes.Compare(recs[a], recs[b]) < 0 })
+
+	r, err := s.newRun(sliceIterator(recs))
+	if err != nil {
+		return err
+	}
+
+	// The run must be listed before the log it replaces is emptied
+	s.runs = append([]*run{r}, s.runs...)
+	if err := s.writeManifest(); err != nil {
+		return err
+	}
+	s.mem = make(map[string][][]byte)
+	s.memLen = 0
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if !s.closed {
+		select {
+		case s.compact <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// sliceIterator returns a function reading records from a slice, then nil
+func sliceIterator(recs [][]byte) func() ([]byte, error) {
+	i := 0
+	return func() ([]byte, error) {
+		if i == len(recs) {
+			return nil, nil
+		}
+		i++
+		return recs[i-1], nil
+	}
+}
+
+// Len returns the number of records stored
+func (s *Store) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := int64(s.memLen)
+	for _, r := range s.runs {
+		n += r.count
+	}
+	return n
+}
+
+// Runs returns the number of records in each run, newest first
+func (s *Store) Runs() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make([]int64, len(s.runs))
+	for i, r := range s.runs {
+		counts[i] = r.count
+	}
+	return counts
+}
+
+// ForEach calls fn for every stored record in sorted order, so by
+// x-coordinate, until fn returns false. Adds wait until it returns.
+func (s *Store) ForEach(fn func(rec []byte) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var recs [][]byte
+	for _, list := range s.mem {
+		recs = append(recs, list...)
+	}
+	sort.Slice(recs, func(a, b int) bool { return bytes.Compare(recs[a], recs[b]) < 0 })
+	sources := []func() ([]byte, error){sliceIterator(recs)}
+	for _, r := range s.runs {
+		sources = append(sources, r.iterator())
+	}
+
+	next, err := mergeIterators(sources)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := next()
+		if err != nil || rec == nil {
+			return err
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+}
+
+// Close writes the records in memory out as a run, waits for a merge in
+// progress, and closes the store
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.compact)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flushLocked()
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	s.closeRuns()
+	if err == nil {
+		err = s.err
+	}
+	return err
+}
+
+// errClosed reports use of a closed store
+var errClosed = errors.New("store is closed")
+
+func (s *Store) closeRuns() {
+	for _, r := range s.runs {
+		r.close()
+	}
+}
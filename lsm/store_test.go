@@ -0,0 +1,208 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rckangaroo/fastbase"
+)
+
+// testRecord returns the n-th of a series of records with distinct
+// x-coordinates, of type typ
+func testRecord(t *testing.T, n int, typ fastbase.KangarooType) []byte {
+	t.Helper()
+	var x [32]byte
+	binary.BigEndian.PutUint32(x[:], uint32(n)*2654435761)
+	binary.BigEndian.PutUint32(x[4:], uint32(n))
+	rec, err := fastbase.NewRecord(x, big.NewInt(int64(n)+1), typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec[:]
+}
+
+// addTestRecords adds records from..to-1 of the series to s in batches of
+// batch, failing unless all are new
+func addTestRecords(t *testing.T, s *Store, from, to, batch int) {
+	t.Helper()
+	for n := from; n < to; n += batch {
+		var recs [][]byte
+		for m := n; m < min(n+batch, to); m++ {
+			recs = append(recs, testRecord(t, m, fastbase.TypeTame))
+		}
+		stats, err := s.Add(recs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Added != len(recs) {
+			t.Fatalf("added %d of records %d..%d; want all %d", stats.Added, n, n+len(recs)-1, len(recs))
+		}
+	}
+}
+
+// checkTestRecords fails unless s holds exactly records 0..n-1 of the series
+func checkTestRecords(t *testing.T, s *Store, n int) {
+	t.Helper()
+	if got := s.Len(); got != int64(n) {
+		t.Errorf("store holds %d records; want %d", got, n)
+	}
+	schema := fastbase.SchemaStandard
+	missing := 0
+	for m := 0; m < n; m++ {
+		rec := testRecord(t, m, fastbase.TypeTame)
+		found, err := s.Lookup(schema.X(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || !bytes.Equal(found[0], rec) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		t.Errorf("%d of %d records not found", missing, n)
+	}
+}
+
+// openTestStore opens the store in dir, closing it when the test ends
+func openTestStore(t *testing.T, dir string, opts Options) *Store {
+	t.Helper()
+	s, err := Open(dir, fastbase.SchemaStandard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{MemRecords: 500, BlockLength: 16}
+	s := openTestStore(t, dir, opts)
+	addTestRecords(t, s, 0, 20000, 300)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing waits for the merges the flushes started
+	s = openTestStore(t, dir, opts)
+	if runs := s.Runs(); len(runs) > 10 {
+		t.Errorf("%d runs after 33 flushes: %v; want them merged", len(runs), runs)
+	}
+	checkTestRecords(t, s, 20000)
+
+	var prev []byte
+	count := 0
+	err := s.ForEach(func(rec []byte) bool {
+		if prev != nil && bytes.Compare(prev, rec) >= 0 {
+			t.Errorf("ForEach gave %x after %x", rec, prev)
+			return false
+		}
+		prev = append(prev[:0], rec...)
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 20000 {
+		t.Errorf("ForEach gave %d records; want 20000", count)
+	}
+
+	// Records already in some run are duplicates, and another type at a
+	// stored x-coordinate, reached at another distance, is a collision
+	wild := testRecord(t, 19999, fastbase.TypeWild1)
+	schema := fastbase.SchemaStandard
+	schema.Distance(wild)[0]++
+	stats, err := s.Add([][]byte{testRecord(t, 7, fastbase.TypeWild2), wild})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Duplicates != 1 || stats.Added != 1 || stats.Collisions != 1 {
+		t.Errorf("adding a stored record and a colliding one: %d duplicates, %d added, %d collisions; want 1 each",
+			stats.Duplicates, stats.Added, stats.Collisions)
+	}
+}
+
+// crashImage copies the files of the store in dir, as a crash would leave
+// them, to a new directory, without closing the store
+func crashImage(t *testing.T, dir string) string {
+	t.Helper()
+	image := t.TempDir()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(image, filepath.Base(path)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return image
+}
+
+func TestStoreCrashBetweenRuns(t *testing.T) {
+	// Flushes only by hand, so there is one run and nothing to merge
+	opts := Options{MemRecords: 1 << 20, BlockLength: 16, SyncWrites: true}
+	dir := t.TempDir()
+	s := openTestStore(t, dir, opts)
+	addTestRecords(t, s, 0, 3000, 1000)
+	wal, err := os.ReadFile(filepath.Join(dir, walName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, s, 3000, 5000, 1000)
+
+	t.Run("records in the log", func(t *testing.T) {
+		image := crashImage(t, dir)
+		checkTestRecords(t, openTestStore(t, image, opts), 5000)
+	})
+
+	t.Run("log not emptied after a flush", func(t *testing.T) {
+		// The records flushed are still in the log, ahead of later ones
+		image := crashImage(t, dir)
+		later, err := os.ReadFile(filepath.Join(image, walName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(image, walName), append(wal, later...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		checkTestRecords(t, openTestStore(t, image, opts), 5000)
+	})
+
+	t.Run("torn log and unlisted run", func(t *testing.T) {
+		// A record cut short by the crash, and a run written by a flush or
+		// merge that never made it into the manifest
+		image := crashImage(t, dir)
+		file, err := os.OpenFile(filepath.Join(image, walName), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(testRecord(t, 9999, fastbase.TypeTame)[:10])
+		file.Close()
+		stray := filepath.Join(image, "00000099.run")
+		if err := os.WriteFile(stray, []byte("half a run"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s := openTestStore(t, image, opts)
+		checkTestRecords(t, s, 5000)
+		if _, err := os.Stat(stray); !os.IsNotExist(err) {
+			t.Errorf("run missing from the manifest was kept: %v", err)
+		}
+		if got := len(s.Runs()); got != 1 {
+			t.Errorf("%d runs after the crash; want the 1 flushed", got)
+		}
+	})
+}
@@ -1,22 +1,42 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"encoding/hex"
 	"rckangaroo/fastbase"
 )
 
 func main() {
+	// Subcommands take precedence over the flag-only interface below
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
 	// Parse command line arguments
 	filename := flag.String("file", "", "Path to the first FastBase file to load")
 	filename2 := flag.String("file2", "", "Path to the second FastBase file to merge")
 	tameOnly := flag.Bool("tame-only", false, "Merge only tame kangaroos")
-	prefix := flag.String("prefix", "", "Show records with this 3-byte prefix (format: 00f1f5)")
+	prefix := flag.String("prefix", "", "Show records with this 3-byte prefix (format: 00f1f5), or a band of them (00f1?? or 00f1f0-00f1ff)")
+	where := flag.String("where", "", "Only include records matching this filter expression (e.g. \"type==wild1 && distbits>120\")")
+	top := flag.Int("top", 0, "Also list the N fullest prefixes with per-type breakdowns")
+	jsonOut := flag.Bool("json", false, "Print statistics or prefix records as JSON")
+	trieFile := flag.String("trie", "", "Export the occupied prefix trie to this file (.json for JSON, otherwise Graphviz DOT)")
+	limit := flag.Int("limit", 0, "Show at most this many records of -prefix; 0 shows all")
+	offset := flag.Int("offset", 0, "Skip this many records of -prefix before showing any")
+	format := flag.String("format", dumpFull, "How to print -prefix records: full, compact (one line each) or raw (hex only, for piping)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: rckangaroo -file <file> [flags]\n")
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 
 	if *filename == "" {
@@ -41,6 +61,13 @@ func main() {
 		fb1 := fastbase.NewFastBase()
 		fb2 := fastbase.NewFastBase()
 
+		for _, fb := range []*fastbase.FastBase{fb1, fb2} {
+			if err := applySecret(fb); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
 		// Load both files
 		fmt.Printf("Loading first FastBase file: %s\n", *filename)
 		if err := fb1.LoadFromFile(*filename); err != nil {
@@ -77,112 +104,150 @@ func main() {
 		os.Exit(1)
 	}
 
+	// If prefix is specified, show only those records. Only their lists are
+	// read, straight from the offset table when the file has one; an
+	// encrypted file is decrypted whole.
+	if *prefix != "" {
+		pr, err := fastbase.ParsePrefixRange(*prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		switch *format {
+		case dumpFull, dumpCompact, dumpRaw:
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unknown format %q (want full, compact or raw)\n", *format)
+			os.Exit(1)
+		}
+		if *limit < 0 || *offset < 0 {
+			fmt.Fprintf(os.Stderr, "Error: -limit and -offset must not be negative\n")
+			os.Exit(1)
+		}
+		if !*jsonOut && *format != dumpRaw {
+			fmt.Printf("Loading FastBase file: %s\n", *filename)
+		}
+		fb := fastbase.NewFastBase()
+		if err := applySecret(fb); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := fb.LoadPrefixRange(*filename, pr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading FastBase file: %v\n", err)
+			os.Exit(1)
+		}
+		filters, err := compileWhere(fb, *where)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		page := &recordPage{offset: *offset, limit: *limit}
+		if *jsonOut {
+			err = showRecordsByPrefixJSON(fb, pr, page, filters...)
+		} else {
+			err = showRecordsByPrefix(fb, pr, *format, page, filters...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create new FastBase instance
 	fb := fastbase.NewFastBase()
+	if err := applySecret(fb); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Load the file
-	fmt.Printf("Loading FastBase file: %s\n", *filename)
-	err := fb.LoadFromFile(*filename)
+	if !*jsonOut {
+		fmt.Printf("Loading FastBase file: %s\n", *filename)
+	}
+	start := time.Now()
+	bar := newProgressBar()
+	if *jsonOut {
+		bar = nil
+	}
+	err := fb.LoadFromFileCtx(context.Background(), *filename, bar.update)
+	bar.finish()
+	loadTime := time.Since(start)
 	if err != nil {
-		fmt.Printf("Error loading FastBase file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error loading FastBase file: %v\n", err)
 		os.Exit(1)
 	}
 
-	// If prefix is specified, show only those records
-	if *prefix != "" {
-		if err := showRecordsByPrefix(fb, *prefix); err != nil {
-			fmt.Printf("Error: %v\n", err)
+	filters, err := compileWhere(fb, *where)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// If trie export is requested, write it instead of statistics
+	if *trieFile != "" {
+		if err := exportTrie(fb, *trieFile); err != nil {
+			fmt.Printf("Error exporting trie: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Printf("Prefix trie written to: %s\n", *trieFile)
 		return
 	}
 
 	// Otherwise show general statistics
-	printStats(fb)
+	if *jsonOut {
+		if err := printStatsJSON(fb, *top, filters...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	printStats(fb, *filename, loadTime, *top, filters...)
 }
 
-func printStats(fb *fastbase.FastBase) {
-	fmt.Printf("\nFastBase Statistics for %s:\n", filepath.Base(flag.Arg(0)))
+func printStats(fb *fastbase.FastBase, filename string, loadTime time.Duration, top int, filters ...fastbase.Filter) {
+	fmt.Printf("\nFastBase Statistics for %s:\n", filepath.Base(filename))
 	fmt.Printf("----------------------------------------\n")
 
-	// Calculate statistics
-	totalLists := 256 * 256 * 256
-	nonEmptyLists := 0
-	totalRecords := 0
-	maxListSize := uint16(0)
-	var maxListPrefix [3]byte
-
-	// Track kangaroo counts and their largest lists
-	kangCounts := [3]int{0, 0, 0} // tame, wild1, wild2
-	maxKangListSizes := [3]uint16{0, 0, 0}
-	var maxKangListPrefixes [3][3]byte
-
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				list := fb.Lists[i][j][k]
-				if list.Count > 0 {
-					nonEmptyLists++
-					totalRecords += int(list.Count)
-					if list.Count > maxListSize {
-						maxListSize = list.Count
-						maxListPrefix = [3]byte{byte(i), byte(j), byte(k)}
-					}
-
-					// Count kangaroos by type in this list
-					typeCountsInList := [3]uint16{0, 0, 0}
-					for m := uint16(0); m < list.Count; m++ {
-						ptr := list.Data[m]
-						mem := fb.Pools[i].GetRecordPtr(ptr)
-						kangType := mem[31]
-						if kangType < 3 {
-							typeCountsInList[kangType]++
-							kangCounts[kangType]++
-						}
-					}
-
-					// Update max lists for each type
-					for t := 0; t < 3; t++ {
-						if typeCountsInList[t] > maxKangListSizes[t] {
-							maxKangListSizes[t] = typeCountsInList[t]
-							maxKangListPrefixes[t] = [3]byte{byte(i), byte(j), byte(k)}
-						}
-					}
-				}
-			}
-		}
-	}
+	report := fb.Stats(filters...)
+	maxListPrefix := report.MaxListPrefix
 
 	// Print general statistics
-	fmt.Printf("Total Lists:          %d\n", totalLists)
-	fmt.Printf("Non-empty Lists:      %d (%.2f%%)\n", nonEmptyLists, float64(nonEmptyLists)*100/float64(totalLists))
-	fmt.Printf("Total Records:        %d\n", totalRecords)
-	fmt.Printf("Average Records/List: %.2f\n", float64(totalRecords)/float64(nonEmptyLists))
-	fmt.Printf("Max List Size:        %d\n", maxListSize)
+	fmt.Printf("Total Lists:          %d\n", report.TotalLists)
+	fmt.Printf("Non-empty Lists:      %d (%.2f%%)\n", report.NonEmptyLists, float64(report.NonEmptyLists)*100/float64(report.TotalLists))
+	fmt.Printf("Total Records:        %d\n", report.TotalRecords)
+	fmt.Printf("Average Records/List: %.2f\n", report.AverageListSize())
+	fmt.Printf("Max List Size:        %d\n", report.MaxListSize)
 	fmt.Printf("Max List Prefix:      [%02x %02x %02x]\n", maxListPrefix[0], maxListPrefix[1], maxListPrefix[2])
+	fmt.Printf("Load Time:            %s", loadTime.Round(time.Millisecond))
+	if fi, err := os.Stat(filename); err == nil && loadTime > 0 {
+		fmt.Printf(" (%s/s)", formatBytes(int64(float64(fi.Size())/loadTime.Seconds())))
+	}
+	fmt.Println()
 
 	// Print kangaroo type statistics
 	fmt.Printf("\nKangaroo Type Statistics:\n")
 	fmt.Printf("----------------------------------------\n")
 	kangTypes := []string{"Tame", "Wild1", "Wild2"}
-	for t := 0; t < 3; t++ {
-		fmt.Printf("%s Kangaroos:      %d\n", kangTypes[t], kangCounts[t])
-		if kangCounts[t] > 0 {
+	for t, ts := range report.Types {
+		fmt.Printf("%s Kangaroos:      %d\n", kangTypes[t], ts.Count)
+		if ts.Count > 0 {
 			fmt.Printf("  Largest List:     %d points at [%02x %02x %02x]\n",
-				maxKangListSizes[t],
-				maxKangListPrefixes[t][0],
-				maxKangListPrefixes[t][1],
-				maxKangListPrefixes[t][2])
+				ts.MaxListSize,
+				ts.MaxListPrefix[0],
+				ts.MaxListPrefix[1],
+				ts.MaxListPrefix[2])
 		}
 	}
 
+	if top > 0 {
+		printTopLists(fb, top, filters...)
+	}
+
 	// Print records in largest list
 	fmt.Printf("\nRecords in largest list (Kangaroo Algorithm Points):\n")
 	fmt.Printf("----------------------------------------\n")
-	fmt.Printf("Format: Each 32-byte record contains:\n")
-	fmt.Printf("- x[12]: x-coordinate on secp256k1 curve (compressed)\n")
-	fmt.Printf("- d[19]: distance value in kangaroo algorithm\n")
-	fmt.Printf("- type[1]: point type (0=tame, 1=wild1, 2=wild2)\n")
+	printRecordFormat(fb)
 	fmt.Printf("\nKey Derivation:\n")
 	fmt.Printf("1. For tame points (type=0):\n")
 	fmt.Printf("   privKey = tame_distance - wild_distance + Int_HalfRange\n")
@@ -194,125 +259,184 @@ func printStats(fb *fastbase.FastBase) {
 	fmt.Printf("----------------------------------------\n")
 
 	// Print each record in the largest list
-	list := fb.Lists[maxListPrefix[0]][maxListPrefix[1]][maxListPrefix[2]]
-	for i := uint16(0); i < list.Count; i++ {
-		ptr := list.Data[i]
-		mem := fb.Pools[maxListPrefix[0]].GetRecordPtr(ptr)
-
-		fmt.Printf("Record %d:\n", i+1)
-		fmt.Printf("  x-coordinate: %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x \n",
-			mem[0], mem[1], mem[2], mem[3], mem[4], mem[5], mem[6], mem[7], mem[8], mem[9], mem[10], mem[11])
-		fmt.Printf("  distance:     %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x\n",
-			mem[12], mem[13], mem[14], mem[15], mem[16], mem[17], mem[18], mem[19], mem[20], mem[21], mem[22], mem[23],
-			mem[24], mem[25], mem[26], mem[27], mem[28], mem[29], mem[30])
-		fmt.Printf("  type:         %d (%s)\n", mem[31], getPointTypeName(mem[31]))
-		fmt.Printf("----------------------------------------\n")
+	for n, mem := range prefixRecords(fb, maxListPrefix, filters) {
+		printRecord(uint32(n+1), fb.Schema(), mem)
 	}
 }
 
-func getPointTypeName(pointType byte) string {
-	switch pointType {
-	case 0:
-		return "tame"
-	case 1:
-		return "wild1"
-	case 2:
-		return "wild2"
-	default:
-		return "unknown"
+func printTopLists(fb *fastbase.FastBase, n int, filters ...fastbase.Filter) {
+	fmt.Printf("\nTop %d Largest Lists:\n", n)
+	fmt.Printf("----------------------------------------\n")
+	fmt.Printf("%-4s %-10s %8s %8s %8s %8s\n", "#", "Prefix", "Total", "Tame", "Wild1", "Wild2")
+	for i, ls := range fb.TopLists(n, filters...) {
+		fmt.Printf("%-4d [%02x %02x %02x] %8d %8d %8d %8d\n", i+1,
+			ls.Prefix[0], ls.Prefix[1], ls.Prefix[2],
+			ls.Count, ls.TypeCounts[0], ls.TypeCounts[1], ls.TypeCounts[2])
 	}
 }
 
-func parsePrefix(prefix string) ([3]byte, error) {
-	var result [3]byte
+func printRecordFormat(fb *fastbase.FastBase) {
+	schema, layout := fb.Schema(), fb.Layout()
+	fmt.Printf("Format: Each %d-byte record contains (%s schema):\n", layout.RecordLength, schema.Name)
+	fmt.Printf("- x[%d]: x-coordinate on secp256k1 curve (compressed)\n", schema.XLength)
+	fmt.Printf("- d[%d]: distance value in kangaroo algorithm\n", schema.DistanceLength)
+	if extra := layout.RecordLength - schema.XLength - schema.DistanceLength - 1; extra > 0 {
+		fmt.Printf("- meta[%d]: metadata\n", extra)
+	}
+	fmt.Printf("- type[1]: point type (0=tame, 1=wild1, 2=wild2)\n")
+}
 
-	// Remove any spaces and 0x prefix
-	prefix = strings.ReplaceAll(prefix, " ", "")
-	prefix = strings.TrimPrefix(prefix, "0x")
+func printRecord(n uint32, schema fastbase.Schema, mem []byte) {
+	fmt.Printf("Record %d:\n", n)
+	fmt.Printf("  x-coordinate: %s \n", groupHex(schema.X(mem)))
+	fmt.Printf("  distance:     %s\n", groupHex(schema.Distance(mem)))
+	fmt.Printf("  type:         %d (%s)\n", schema.Type(mem), schema.Type(mem))
+	fmt.Printf("----------------------------------------\n")
+}
 
-	if len(prefix) != 6 {
-		return result, fmt.Errorf("prefix must be exactly 6 hex characters (3 bytes), got %d characters", len(prefix))
+// groupHex formats bytes as hex in space-separated groups of four bytes
+func groupHex(b []byte) string {
+	var sb strings.Builder
+	for i, v := range b {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02x", v)
 	}
+	return sb.String()
+}
 
-	// Convert from hex
-	decoded, err := hex.DecodeString(prefix)
-	if err != nil {
-		return result, fmt.Errorf("invalid hex string: %v", err)
+// Record dump styles for -format
+const (
+	dumpFull    = "full"    // One block per record with labelled fields
+	dumpCompact = "compact" // One line per record: number, x, distance, type
+	dumpRaw     = "raw"     // Each record as one line of hex, nothing else
+)
+
+// recordPage selects the records of a dump to show: offset records are
+// skipped, then at most limit are shown, or all if limit is 0. Both count
+// across every list of a band.
+type recordPage struct {
+	offset, limit  int
+	skipped, shown int
+}
+
+// take returns the records of the next list that fall in the page, and
+// the index of the first of them within the list
+func (p *recordPage) take(records [][]byte) (int, [][]byte) {
+	start := min(p.offset-p.skipped, len(records))
+	p.skipped += start
+	records = records[start:]
+	if p.limit > 0 {
+		records = records[:min(len(records), p.limit-p.shown)]
 	}
+	p.shown += len(records)
+	return start, records
+}
 
-	copy(result[:], decoded)
-	return result, nil
+// paged reports whether the page leaves out any records
+func (p *recordPage) paged() bool {
+	return p.offset > 0 || p.limit > 0
 }
 
-func showRecordsByPrefix(fb *fastbase.FastBase, prefixStr string) error {
-	// Parse the prefix
-	prefix, err := parsePrefix(prefixStr)
-	if err != nil {
-		return err
+func showRecordsByPrefix(fb *fastbase.FastBase, pr fastbase.PrefixRange, format string, page *recordPage, filters ...fastbase.Filter) error {
+	if pr.Single() {
+		prefix := pr.Lo
+		records := prefixRecords(fb, prefix, filters)
+		first, shown := page.take(records)
+		printPrefixRecords(fb, prefix, len(records), first, shown, format, page.paged())
+		return nil
 	}
 
-	// Get the list for this prefix
-	list := fb.Lists[prefix[0]][prefix[1]][prefix[2]]
+	// A band shows only the lists holding records in the page, but counts
+	// them all
+	lists, total := 0, 0
+	fb.ForEachInRange(pr, func(prefix [3]byte, records [][]byte) bool {
+		lists++
+		total += len(records)
+		if first, shown := page.take(records); len(shown) > 0 {
+			printPrefixRecords(fb, prefix, len(records), first, shown, format, page.paged())
+		}
+		return true
+	}, filters...)
+
+	if format != dumpRaw {
+		fmt.Printf("\nPrefixes %s: %d records in %d of %d lists\n", pr, total, lists, pr.Count())
+		if page.paged() {
+			fmt.Printf("Showing %d records from record %d\n", page.shown, page.offset+1)
+		}
+	}
+	return nil
+}
+
+// printPrefixRecords prints the records shown of one list of total
+// records, the first of them at index first
+func printPrefixRecords(fb *fastbase.FastBase, prefix [3]byte, total, first int, records [][]byte, format string, paged bool) {
+	schema := fb.Schema()
+	switch format {
+	case dumpRaw:
+		for _, mem := range records {
+			fmt.Printf("%x\n", mem)
+		}
+		return
+	case dumpCompact:
+		fmt.Printf("[%02x %02x %02x] %d records\n", prefix[0], prefix[1], prefix[2], total)
+		for i, mem := range records {
+			fmt.Printf("%6d  %x  %x  %s\n", first+i+1, schema.X(mem), schema.Distance(mem), schema.Type(mem))
+		}
+		return
+	}
 
 	fmt.Printf("\nRecords with prefix [%02x %02x %02x]:\n", prefix[0], prefix[1], prefix[2])
-	fmt.Printf("Total records: %d\n", list.Count)
+	fmt.Printf("Total records: %d\n", total)
+	if paged && len(records) > 0 {
+		fmt.Printf("Showing:       %d-%d\n", first+1, first+len(records))
+	}
 	fmt.Printf("----------------------------------------\n")
 
-	if list.Count == 0 {
-		return nil
+	if len(records) == 0 {
+		return
 	}
 
 	// Print format information
-	fmt.Printf("Format: Each 32-byte record contains:\n")
-	fmt.Printf("- x[12]: x-coordinate on secp256k1 curve (compressed)\n")
-	fmt.Printf("- d[19]: distance value in kangaroo algorithm\n")
-	fmt.Printf("- type[1]: point type (0=tame, 1=wild1, 2=wild2)\n")
+	printRecordFormat(fb)
 	fmt.Printf("----------------------------------------\n")
 
 	// Print each record
-	for i := uint16(0); i < list.Count; i++ {
-		ptr := list.Data[i]
-		mem := fb.Pools[prefix[0]].GetRecordPtr(ptr)
+	for i, mem := range records {
+		printRecord(uint32(first+i+1), schema, mem)
+	}
+}
 
-		fmt.Printf("Record %d:\n", i+1)
-		fmt.Printf("  x-coordinate: %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x \n",
-			mem[0], mem[1], mem[2], mem[3], mem[4], mem[5], mem[6], mem[7], mem[8], mem[9], mem[10], mem[11])
-		fmt.Printf("  distance:     %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x%02x %02x%02x%02x\n",
-			mem[12], mem[13], mem[14], mem[15], mem[16], mem[17], mem[18], mem[19], mem[20], mem[21], mem[22], mem[23],
-			mem[24], mem[25], mem[26], mem[27], mem[28], mem[29], mem[30])
-		fmt.Printf("  type:         %d (%s)\n", mem[31], getPointTypeName(mem[31]))
-		fmt.Printf("----------------------------------------\n")
+func exportTrie(fb *fastbase.FastBase, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	return nil
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = fb.WriteTrieJSON(file)
+	} else {
+		err = fb.WriteTrieDOT(file)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
 }
 
 func mergeFastBases(fb1, fb2 *fastbase.FastBase, tameOnly bool) (int, int) {
-	count := 0
-	countadded := 0
-	for i := 0; i < 256; i++ {
-		for j := 0; j < 256; j++ {
-			for k := 0; k < 256; k++ {
-				list := fb2.Lists[i][j][k]
-				if list.Count > 0 {
-					for m := uint16(0); m < list.Count; m++ {
-						ptr := list.Data[m]
-						mem := fb2.Pools[i].GetRecordPtr(ptr)
-						if tameOnly && mem[31] != 0 {
-							continue
-						}
-						added, err := fb1.AddRecord(byte(i), byte(j), byte(k), mem)
-						if err != nil {
-							fmt.Printf("Error adding record at [%02x][%02x][%02x]: %v\n", i, j, k, err)
-						}
-						if added {
-							countadded++
-						}
-						count++
-					}
-				}
-			}
-		}
+	var filters []fastbase.Filter
+	if tameOnly {
+		filters = append(filters, fastbase.TypeFilter(fastbase.TypeTame))
+	}
+	stats, err := fb1.Merge(fb2, filters...)
+	if err != nil {
+		fmt.Printf("Error merging: %v\n", err)
+	}
+	for i, c := range stats.Found {
+		printCollision(i+1, fb1.Schema(), c)
 	}
-	return count, countadded
+	return stats.Records, stats.Added
 }
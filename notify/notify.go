@@ -0,0 +1,186 @@
+// Package notify tells someone a key was found, so a run lasting months
+// does not depend on anyone watching its output: it can run a command,
+// post JSON to a webhook, or message a Telegram chat or Discord channel.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Event is a found key, or a collision that could not be verified, in the
+// forms a person or script receiving it may want
+type Event struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`            // Command that found it, e.g. "solve"
+	Message string    `json:"message"`           // One-line summary
+	Key     string    `json:"key,omitempty"`     // Private key in hex, empty if unverified
+	WIF     string    `json:"wif,omitempty"`     // Compressed WIF of the key
+	PubKey  string    `json:"pubkey,omitempty"`  // Target public key in hex
+	Address string    `json:"address,omitempty"` // Compressed P2PKH address
+	Details string    `json:"details,omitempty"` // Collision records or other context
+}
+
+// Text formats the event for a chat message
+func (e Event) Text() string {
+	var sb strings.Builder
+	sb.WriteString(e.Message)
+	for _, f := range [][2]string{{"Key", e.Key}, {"WIF", e.WIF}, {"Public key", e.PubKey}, {"Address", e.Address}} {
+		if f[1] != "" {
+			fmt.Fprintf(&sb, "\n%s: %s", f[0], f[1])
+		}
+	}
+	if e.Details != "" {
+		sb.WriteString("\n" + e.Details)
+	}
+	return sb.String()
+}
+
+// Notifier delivers an event somewhere
+type Notifier interface {
+	// Name identifies the notifier in errors
+	Name() string
+
+	Notify(ctx context.Context, e Event) error
+}
+
+// Retries is how many times Send tries each notifier before giving up
+const Retries = 3
+
+// Send delivers an event with every notifier, retrying failures with a
+// growing delay. It returns the errors of the notifiers that never
+// succeeded; the others were still notified.
+func Send(ctx context.Context, notifiers []Notifier, e Event) error {
+	var errs []error
+	for _, n := range notifiers {
+		var err error
+		for attempt := 0; attempt < Retries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(time.Duration(attempt) * 5 * time.Second):
+				case <-ctx.Done():
+					return errors.Join(append(errs, ctx.Err())...)
+				}
+			}
+			if err = n.Notify(ctx, e); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", n.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Command runs a shell command with the event as JSON on its standard
+// input and its fields in RCK_* environment variables
+type Command struct {
+	Shell string // Command line, run with sh -c
+}
+
+// Name implements Notifier
+func (c Command) Name() string { return "command" }
+
+// Notify implements Notifier
+func (c Command) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Shell)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"RCK_SOURCE="+e.Source,
+		"RCK_MESSAGE="+e.Message,
+		"RCK_KEY="+e.Key,
+		"RCK_WIF="+e.WIF,
+		"RCK_PUBKEY="+e.PubKey,
+		"RCK_ADDRESS="+e.Address,
+	)
+	return cmd.Run()
+}
+
+// Webhook POSTs the event as JSON to a URL
+type Webhook struct {
+	URL string
+}
+
+// Name implements Notifier
+func (w Webhook) Name() string { return "webhook" }
+
+// Notify implements Notifier
+func (w Webhook) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, w.URL, e)
+}
+
+// Telegram sends the event as a message from a bot to a chat
+type Telegram struct {
+	Token  string // Bot token from @BotFather
+	ChatID string
+}
+
+// Name implements Notifier
+func (t Telegram) Name() string { return "telegram" }
+
+// Notify implements Notifier
+func (t Telegram) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, "https://api.telegram.org/bot"+t.Token+"/sendMessage", map[string]string{
+		"chat_id": t.ChatID,
+		"text":    e.Text(),
+	})
+}
+
+// Discord posts the event to a channel through its webhook URL
+type Discord struct {
+	URL string
+}
+
+// Name implements Notifier
+func (d Discord) Name() string { return "discord" }
+
+// Notify implements Notifier
+func (d Discord) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, d.URL, map[string]string{"content": e.Text()})
+}
+
+// postJSON POSTs v as JSON and fails on any status but 2xx
+func postJSON(ctx context.Context, target string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL may carry a token; keep it out of logs
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return fmt.Errorf("%s: %v", uerr.Op, uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.Join(strings.Fields(string(msg)), " "))
+	}
+	return nil
+}
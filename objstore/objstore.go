@@ -0,0 +1,286 @@
+// Package objstore reads and writes objects in S3 and Google Cloud Storage
+// buckets over their S3-compatible XML APIs, signing requests with AWS
+// Signature Version 4. Google Cloud Storage is reached with HMAC keys.
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPartSize is the size of each part of a multipart upload. Parts
+// are buffered in memory, so this bounds the memory an upload needs.
+const DefaultPartSize = 16 << 20
+
+// minPartSize is the smallest part S3 accepts, except for the last one
+const minPartSize = 5 << 20
+
+// Store is an S3-compatible object store endpoint with its credentials
+type Store struct {
+	Endpoint     string // Base URL, e.g. https://storage.googleapis.com
+	Region       string // Signing region; "auto" for Google Cloud Storage
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // Temporary credentials token, if any
+	PathStyle    bool   // Address buckets as Endpoint/bucket instead of bucket.host
+	PartSize     int64  // Multipart upload part size; DefaultPartSize if zero
+
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// Object names one object of a store
+type Object struct {
+	Store  *Store
+	Bucket string
+	Key    string
+}
+
+// Open parses an s3://bucket/key or gs://bucket/key URL and configures its
+// store from the environment:
+//
+//	s3://  AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+//	       AWS_REGION (default us-east-1) and AWS_ENDPOINT_URL for
+//	       S3-compatible services such as MinIO
+//	gs://  GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY, an HMAC key of a
+//	       service account
+func Open(rawURL string) (*Object, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("object URL must look like %s://bucket/key", u.Scheme)
+	}
+
+	var s *Store
+	switch u.Scheme {
+	case "s3":
+		s = &Store{
+			Region:       os.Getenv("AWS_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			Endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		if s.Endpoint == "" {
+			s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+		} else {
+			// Self-hosted services rarely have wildcard DNS for buckets
+			s.PathStyle = true
+		}
+	case "gs":
+		s = &Store{
+			Endpoint:  "https://storage.googleapis.com",
+			Region:    "auto",
+			AccessKey: os.Getenv("GCS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("GCS_SECRET_ACCESS_KEY"),
+			PathStyle: true,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported object store scheme %q, want s3 or gs", u.Scheme)
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("no credentials for %s:// in the environment", u.Scheme)
+	}
+
+	return &Object{Store: s, Bucket: u.Host, Key: key}, nil
+}
+
+// IsURL reports whether name is an object store URL rather than a file name
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "s3://") || strings.HasPrefix(name, "gs://")
+}
+
+func (s *Store) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// objectURL returns the URL of an object, with query appended
+func (s *Store) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+// do signs and sends a request, returning an error for any non-2xx answer
+func (s *Store) do(ctx context.Context, method, bucket, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := s.objectURL(bucket, key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body)
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError turns an S3 error document into an error
+func responseError(resp *http.Response) error {
+	var doc struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &doc) == nil && doc.Code != "" {
+		return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, doc.Code, doc.Message)
+	}
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+// Get opens the object for streaming, returning its size, or -1 if the
+// store didn't say
+func (o *Object) Get(ctx context.Context) (io.ReadCloser, int64, error) {
+	resp, err := o.Store.do(ctx, http.MethodGet, o.Bucket, o.Key, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Put uploads everything read from r as the object. Data larger than one
+// part goes up as a multipart upload, which is aborted if anything fails,
+// so a failed Put never leaves a partial object behind.
+func (o *Object) Put(ctx context.Context, r io.Reader) error {
+	size := o.Store.PartSize
+	if size <= 0 {
+		size = DefaultPartSize
+	}
+	size = max(size, minPartSize)
+
+	part := make([]byte, size)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Small enough for a single request
+		resp, err := o.Store.do(ctx, http.MethodPut, o.Bucket, o.Key, nil, part[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := o.createMultipart(ctx)
+	if err != nil {
+		return err
+	}
+	if err := o.uploadParts(ctx, id, r, part); err != nil {
+		// Abort with a fresh context, as ctx may be what failed
+		if resp, aerr := o.Store.do(context.Background(), http.MethodDelete, o.Bucket, o.Key, url.Values{"uploadId": {id}}, nil); aerr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// completedPart is one entry of a CompleteMultipartUpload request
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (o *Object) createMultipart(ctx context.Context) (string, error) {
+	resp, err := o.Store.do(ctx, http.MethodPost, o.Bucket, o.Key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("starting multipart upload: %v", err)
+	}
+	if doc.UploadID == "" {
+		return "", fmt.Errorf("starting multipart upload: no upload ID")
+	}
+	return doc.UploadID, nil
+}
+
+// uploadParts sends the first, already read, part and the rest of r, then
+// completes the upload
+func (o *Object) uploadParts(ctx context.Context, id string, r io.Reader, part []byte) error {
+	var parts []completedPart
+	n := len(part)
+	for number := 1; n > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {id}}
+		resp, err := o.Store.do(ctx, http.MethodPut, o.Bucket, o.Key, query, part[:n])
+		if err != nil {
+			return fmt.Errorf("uploading part %d: %v", number, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		var err2 error
+		n, err2 = io.ReadFull(r, part)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return err2
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := o.Store.do(ctx, http.MethodPost, o.Bucket, o.Key, url.Values{"uploadId": {id}}, body)
+	if err != nil {
+		return fmt.Errorf("completing multipart upload: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// S3 reports some failures of CompleteMultipartUpload with a 200 status
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var doc struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &doc) == nil && doc.XMLName.Local == "Error" {
+		return fmt.Errorf("completing multipart upload: %s: %s", doc.Code, doc.Message)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// progressBarWidth is the number of cells in the bar itself
+const progressBarWidth = 30
+
+// progressBar draws load or save progress with throughput and ETA on stderr.
+// It is nil unless stderr is a terminal, so redirected output stays clean;
+// a nil progressBar ignores every call.
+type progressBar struct {
+	w     io.Writer
+	start time.Time
+	drawn time.Time // When the bar was last redrawn
+	open  bool      // The cursor is still on the bar's line
+}
+
+// newProgressBar returns a progress bar, or nil when stderr is not a
+// terminal
+func newProgressBar() *progressBar {
+	fi, err := os.Stderr.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{w: os.Stderr, start: time.Now()}
+}
+
+// update redraws the bar at most ten times a second, and always for the
+// final report
+func (b *progressBar) update(p fastbase.Progress) {
+	if b == nil {
+		return
+	}
+	now := time.Now()
+	if !p.Done && now.Sub(b.drawn) < 100*time.Millisecond {
+		return
+	}
+	b.drawn = now
+
+	elapsed := now.Sub(b.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.Bytes) / elapsed
+	}
+	frac := 0.0
+	if p.Total > 0 {
+		frac = min(float64(p.Bytes)/float64(p.Total), 1)
+	}
+	filled := int(frac * progressBarWidth)
+	eta := "--:--"
+	if rate > 0 && p.Total > 0 {
+		eta = formatClock(time.Duration(float64(p.Total-p.Bytes) / rate * float64(time.Second)))
+	}
+	if p.Done {
+		eta = formatClock(now.Sub(b.start))
+	}
+
+	fmt.Fprintf(b.w, "\r[%s%s] %5.1f%%  %s / %s  %s/s  ETA %s ",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		frac*100, formatBytes(p.Bytes), formatBytes(p.Total), formatBytes(int64(rate)), eta)
+	b.open = true
+	if p.Done {
+		b.finish()
+	}
+}
+
+// finish ends the bar's line, so a failed load doesn't leave later output
+// on it
+func (b *progressBar) finish() {
+	if b == nil || !b.open {
+		return
+	}
+	fmt.Fprintln(b.w)
+	b.open = false
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatClock renders a duration as h:mm:ss, or m:ss under an hour
+func formatClock(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
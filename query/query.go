@@ -0,0 +1,335 @@
+// Package query implements a small filter expression language over FastBase
+// records, for example:
+//
+//	type==wild1 && distbits>120 && prefix in 00..0f
+//
+// An expression is parsed once and compiled into a fastbase.Filter that is
+// applied to each record during iteration.
+//
+// Fields:
+//
+//	type      kangaroo type, by name (tame, wild1, wild2) or number
+//	prefix    hex list prefix; shorter values compare against its leading bytes
+//	x         hex x-coordinate; shorter values compare against its leading bytes
+//	distance  signed distance, decimal or 0x-prefixed hex
+//	distbits  bit length of the distance magnitude
+//
+// Comparisons use ==, !=, <, <=, >, >= or "in lo..hi" (inclusive), and
+// combine with &&, ||, ! and parentheses.
+package query
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"rckangaroo/fastbase"
+)
+
+// Compile parses an expression and returns a filter for records laid out
+// according to schema. An empty expression matches every record.
+func Compile(expr string, schema fastbase.Schema) (fastbase.Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return func([3]byte, []byte) bool { return true }, nil
+	}
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, schema: schema}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at end of expression", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+// tokenize splits an expression into words and operators
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case isWordChar(c) || (c == '-' && i+1 < len(expr) && unicode.IsDigit(rune(expr[i+1]))):
+			start := i
+			i++
+			for i < len(expr) && isWordChar(rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "..", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	schema fastbase.Schema
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok, nil
+}
+
+func (p *parser) parseOr() (fastbase.Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(prefix [3]byte, rec []byte) bool { return l(prefix, rec) || right(prefix, rec) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (fastbase.Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(prefix [3]byte, rec []byte) bool { return l(prefix, rec) && right(prefix, rec) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (fastbase.Filter, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(prefix [3]byte, rec []byte) bool { return !inner(prefix, rec) }, nil
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (fastbase.Filter, error) {
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.field(strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(op, "in") {
+		loTok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.next(); err != nil || tok != ".." {
+			return nil, fmt.Errorf("expected \"..\" in range for %s", name)
+		}
+		hiTok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		lo, err := f.parse(loTok)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := f.parse(hiTok)
+		if err != nil {
+			return nil, err
+		}
+		return func(prefix [3]byte, rec []byte) bool {
+			return f.compare(prefix, rec, lo) >= 0 && f.compare(prefix, rec, hi) <= 0
+		}, nil
+	}
+
+	var test func(int) bool
+	switch op {
+	case "==":
+		test = func(c int) bool { return c == 0 }
+	case "!=":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	case ">=":
+		test = func(c int) bool { return c >= 0 }
+	default:
+		return nil, fmt.Errorf("expected comparison operator after %s, got %q", name, op)
+	}
+
+	valTok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	val, err := f.parse(valTok)
+	if err != nil {
+		return nil, err
+	}
+	return func(prefix [3]byte, rec []byte) bool { return test(f.compare(prefix, rec, val)) }, nil
+}
+
+// field knows how to parse literals for one record field and compare the
+// field of a record against them
+type field struct {
+	parse   func(tok string) (interface{}, error)
+	compare func(prefix [3]byte, rec []byte, val interface{}) int
+}
+
+func (p *parser) field(name string) (field, error) {
+	schema := p.schema
+	switch name {
+	case "type":
+		return field{
+			parse: func(tok string) (interface{}, error) {
+				for t := fastbase.TypeTame; t <= fastbase.TypeWild2; t++ {
+					if strings.EqualFold(tok, t.String()) {
+						return int64(t), nil
+					}
+				}
+				return parseInt(tok)
+			},
+			compare: func(prefix [3]byte, rec []byte, val interface{}) int {
+				return cmpInt(int64(schema.Type(rec)), val.(int64))
+			},
+		}, nil
+
+	case "distbits":
+		return field{
+			parse: func(tok string) (interface{}, error) { return parseInt(tok) },
+			compare: func(prefix [3]byte, rec []byte, val interface{}) int {
+				d := schema.DecodeDistance(schema.Distance(rec))
+				return cmpInt(int64(new(big.Int).Abs(d).BitLen()), val.(int64))
+			},
+		}, nil
+
+	case "distance":
+		return field{
+			parse: func(tok string) (interface{}, error) {
+				v, ok := new(big.Int).SetString(tok, 0)
+				if !ok {
+					return nil, fmt.Errorf("invalid distance %q", tok)
+				}
+				return v, nil
+			},
+			compare: func(prefix [3]byte, rec []byte, val interface{}) int {
+				return schema.DecodeDistance(schema.Distance(rec)).Cmp(val.(*big.Int))
+			},
+		}, nil
+
+	case "prefix":
+		return field{
+			parse: func(tok string) (interface{}, error) { return parseHex(tok, 3) },
+			compare: func(prefix [3]byte, rec []byte, val interface{}) int {
+				v := val.([]byte)
+				return bytes.Compare(prefix[:len(v)], v)
+			},
+		}, nil
+
+	case "x":
+		return field{
+			parse: func(tok string) (interface{}, error) { return parseHex(tok, schema.XLength) },
+			compare: func(prefix [3]byte, rec []byte, val interface{}) int {
+				v := val.([]byte)
+				return bytes.Compare(schema.X(rec)[:len(v)], v)
+			},
+		}, nil
+	}
+
+	return field{}, fmt.Errorf("unknown field %q (want type, prefix, x, distance or distbits)", name)
+}
+
+func parseInt(tok string) (int64, error) {
+	v, err := strconv.ParseInt(tok, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", tok)
+	}
+	return v, nil
+}
+
+func parseHex(tok string, maxLen int) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(tok, "0x"))
+	if err != nil || len(b) == 0 || len(b) > maxLen {
+		return nil, fmt.Errorf("invalid hex value %q (1 to %d bytes)", tok, maxLen)
+	}
+	return b, nil
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+// Package secp256k1 implements the elliptic curve arithmetic needed to derive
+// and verify private keys found by the RCKangaroo algorithm
+package secp256k1
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// P is the field prime
+	P = mustHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+
+	// N is the order of the generator point
+	N = mustHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+
+	// G is the generator point
+	G = Point{
+		X: mustHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		Y: mustHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+	}
+
+	bigSeven = big.NewInt(7)
+)
+
+// Point is an affine point on the curve. The point at infinity has nil coordinates.
+type Point struct {
+	X, Y *big.Int
+}
+
+// Infinity returns the point at infinity
+func Infinity() Point {
+	return Point{}
+}
+
+// IsInfinity reports whether p is the point at infinity
+func (p Point) IsInfinity() bool {
+	return p.X == nil
+}
+
+// Equal reports whether p and q are the same point
+func (p Point) Equal(q Point) bool {
+	if p.IsInfinity() || q.IsInfinity() {
+		return p.IsInfinity() == q.IsInfinity()
+	}
+	return p.X.Cmp(q.X) == 0 && p.Y.Cmp(q.Y) == 0
+}
+
+// IsOnCurve reports whether p satisfies y^2 = x^3 + 7
+func (p Point) IsOnCurve() bool {
+	if p.IsInfinity() {
+		return true
+	}
+	lhs := new(big.Int).Mul(p.Y, p.Y)
+	lhs.Mod(lhs, P)
+	return lhs.Cmp(curveRHS(p.X)) == 0
+}
+
+// Neg returns -p
+func (p Point) Neg() Point {
+	if p.IsInfinity() {
+		return p
+	}
+	y := new(big.Int).Sub(P, p.Y)
+	y.Mod(y, P)
+	return Point{X: new(big.Int).Set(p.X), Y: y}
+}
+
+// Add returns p + q
+func (p Point) Add(q Point) Point {
+	if p.IsInfinity() {
+		return q
+	}
+	if q.IsInfinity() {
+		return p
+	}
+
+	if p.X.Cmp(q.X) == 0 {
+		if p.Y.Cmp(q.Y) == 0 && p.Y.Sign() != 0 {
+			return p.Double()
+		}
+		return Infinity()
+	}
+
+	// lambda = (qy - py) / (qx - px)
+	num := new(big.Int).Sub(q.Y, p.Y)
+	den := new(big.Int).Sub(q.X, p.X)
+	den.Mod(den, P)
+	den.ModInverse(den, P)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, P)
+
+	return p.finish(lambda, q.X)
+}
+
+// Double returns 2p
+func (p Point) Double() Point {
+	if p.IsInfinity() || p.Y.Sign() == 0 {
+		return Infinity()
+	}
+
+	// lambda = 3 * px^2 / (2 * py)
+	num := new(big.Int).Mul(p.X, p.X)
+	num.Mul(num, big.NewInt(3))
+	den := new(big.Int).Lsh(p.Y, 1)
+	den.Mod(den, P)
+	den.ModInverse(den, P)
+	lambda := num.Mul(num, den)
+	lambda.Mod(lambda, P)
+
+	return p.finish(lambda, p.X)
+}
+
+// finish completes an addition or doubling of p and a point with x-coordinate qx
+func (p Point) finish(lambda, qx *big.Int) Point {
+	// rx = lambda^2 - px - qx
+	rx := new(big.Int).Mul(lambda, lambda)
+	rx.Sub(rx, p.X)
+	rx.Sub(rx, qx)
+	rx.Mod(rx, P)
+
+	// ry = lambda * (px - rx) - py
+	ry := new(big.Int).Sub(p.X, rx)
+	ry.Mul(ry, lambda)
+	ry.Sub(ry, p.Y)
+	ry.Mod(ry, P)
+
+	return Point{X: rx, Y: ry}
+}
+
+// Sub returns p - q
+func (p Point) Sub(q Point) Point {
+	return p.Add(q.Neg())
+}
+
+// ScalarMult returns k * p. Negative scalars are reduced modulo N.
+func (p Point) ScalarMult(k *big.Int) Point {
+	e := new(big.Int).Mod(k, N)
+	result := Infinity()
+	addend := p
+	for i := 0; i < e.BitLen(); i++ {
+		if e.Bit(i) == 1 {
+			result = result.Add(addend)
+		}
+		addend = addend.Double()
+	}
+	return result
+}
+
+// ScalarBaseMult returns k * G
+func ScalarBaseMult(k *big.Int) Point {
+	return G.ScalarMult(k)
+}
+
+// ParsePubKey decodes a compressed (33-byte) or uncompressed (65-byte)
+// SEC1 public key and checks that it lies on the curve
+func ParsePubKey(data []byte) (Point, error) {
+	switch {
+	case len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03):
+		x := new(big.Int).SetBytes(data[1:])
+		if x.Cmp(P) >= 0 {
+			return Point{}, errors.New("x-coordinate out of range")
+		}
+		y := new(big.Int).ModSqrt(curveRHS(x), P)
+		if y == nil {
+			return Point{}, errors.New("x-coordinate is not on the curve")
+		}
+		if y.Bit(0) != uint(data[0]&1) {
+			y.Sub(P, y)
+		}
+		return Point{X: x, Y: y}, nil
+
+	case len(data) == 65 && data[0] == 0x04:
+		pt := Point{
+			X: new(big.Int).SetBytes(data[1:33]),
+			Y: new(big.Int).SetBytes(data[33:]),
+		}
+		if pt.X.Cmp(P) >= 0 || pt.Y.Cmp(P) >= 0 || !pt.IsOnCurve() {
+			return Point{}, errors.New("point is not on the curve")
+		}
+		return pt, nil
+
+	default:
+		return Point{}, fmt.Errorf("invalid public key length %d or prefix", len(data))
+	}
+}
+
+// Compressed returns the 33-byte SEC1 compressed encoding of p
+func (p Point) Compressed() []byte {
+	out := make([]byte, 33)
+	out[0] = 0x02 | byte(p.Y.Bit(0))
+	p.X.FillBytes(out[1:])
+	return out
+}
+
+// Uncompressed returns the 65-byte SEC1 uncompressed encoding of p
+func (p Point) Uncompressed() []byte {
+	out := make([]byte, 65)
+	out[0] = 0x04
+	p.X.FillBytes(out[1:33])
+	p.Y.FillBytes(out[33:])
+	return out
+}
+
+// curveRHS returns x^3 + 7 mod P
+func curveRHS(x *big.Int) *big.Int {
+	rhs := new(big.Int).Mul(x, x)
+	rhs.Mul(rhs, x)
+	rhs.Add(rhs, bigSeven)
+	return rhs.Mod(rhs, P)
+}
+
+func mustHex(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("secp256k1: bad constant " + s)
+	}
+	return v
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/sqlite"
+)
+
+type recordSideResponse struct {
+	Distance string `json:"distance"`
+	Type     string `json:"type"`
+}
+
+type collisionResponse struct {
+	Prefix string             `json:"prefix"`
+	X      string             `json:"x"`
+	First  recordSideResponse `json:"first"`
+	Second recordSideResponse `json:"second"`
+}
+
+type submitResponse struct {
+	Seq        int                 `json:"seq,omitempty"`
+	Records    int                 `json:"records"`
+	Added      int                 `json:"added"`
+	Duplicates int                 `json:"duplicates"`
+	Collisions []collisionResponse `json:"collisions"`
+	Error      string              `json:"error,omitempty"`
+	RetryAfter int                 `json:"retry_after,omitempty"` // Seconds until the upload rate allows the batch
+	Replayed   bool                `json:"replayed,omitempty"`    // The batch was applied before; this is the answer it got
+}
+
+func collisionJSON(schema fastbase.Schema, c fastbase.Collision) collisionResponse {
+	return collisionResponse{
+		Prefix: hex.EncodeToString(c.Prefix[:]),
+		X:      hex.EncodeToString(schema.X(c.First)),
+		First: recordSideResponse{
+			Distance: hex.EncodeToString(schema.Distance(c.First)),
+			Type:     schema.Type(c.First).String(),
+		},
+		Second: recordSideResponse{
+			Distance: hex.EncodeToString(schema.Distance(c.Second)),
+			Type:     schema.Type(c.Second).String(),
+		},
+	}
+}
+
+// handleSubmit applies a batch of distinguished points in the delta file
+// format written by FastBase.SaveDeltaSince or Batch.WriteTo, optionally
+// gzip-compressed with Content-Encoding: gzip
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	// Read the whole batch before locking so slow clients don't stall others
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBatchBytes()))
+	if err != nil {
+		s.rejected(r, err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "batch too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, status := s.applyBatch(r, body, r.Header.Get("Content-Encoding") == "gzip", false)
+	if resp.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfter))
+	}
+	writeJSON(w, status, resp)
+}
+
+// applyBatch applies one batch, decompressing it first if compressed, and
+// returns the response for it with its HTTP status. A batch over the upload
+// rate waits for it if wait is set, else is refused.
+func (s *Server) applyBatch(r *http.Request, body []byte, compressed, wait bool) (submitResponse, int) {
+	if compressed {
+		limit := s.maxBatchBytes()
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			s.rejected(r, err)
+			return submitResponse{Error: err.Error()}, http.StatusBadRequest
+		}
+		body, err = io.ReadAll(io.LimitReader(zr, limit+1))
+		if err != nil {
+			s.rejected(r, err)
+			return submitResponse{Error: err.Error()}, http.StatusBadRequest
+		}
+		if int64(len(body)) > limit {
+			s.rejected(r, errors.New("batch too large"))
+			return submitResponse{Error: "batch too large"}, http.StatusRequestEntityTooLarge
+		}
+	}
+
+	info, err := s.checkBatch(body)
+	if err != nil {
+		s.rejected(r, err)
+		return submitResponse{Error: err.Error()}, http.StatusBadRequest
+	}
+	if resp, ok := s.applied.lookup(info.ID); ok {
+		if name, ok := keyName(r); ok {
+			s.opts.Auth.update(name, func(st *KeyStats) { st.Replayed++ })
+		}
+		resp.Replayed = true
+		return resp, http.StatusOK
+	}
+	if resp, status, ok := s.throttle(r, info.Records, wait); !ok {
+		return resp, status
+	}
+
+	// Databases with provenance record the key and time of each record
+	stamp := fastbase.Provenance{Time: time.Now()}
+	if name, ok := keyName(r); ok {
+		stamp.Worker = fastbase.WorkerID(name)
+	}
+	schema, stats, err := s.addDelta(body, stamp)
+
+	if name, ok := keyName(r); ok {
+		s.opts.Auth.update(name, func(st *KeyStats) {
+			st.Batches++
+			st.Records += stats.Records
+			st.Added += stats.Added
+			st.Duplicates += stats.Duplicates
+			st.Collisions += stats.Collisions
+			st.added.add(time.Now(), stats.Added)
+			if err != nil {
+				st.Invalid++
+			}
+		})
+	}
+
+	resp := submitResponse{
+		Records:    stats.Records,
+		Added:      stats.Added,
+		Duplicates: stats.Duplicates,
+		Collisions: make([]collisionResponse, 0, len(stats.Found)),
+	}
+	for _, c := range stats.Found {
+		resp.Collisions = append(resp.Collisions, collisionJSON(schema, c))
+	}
+
+	name, _ := keyName(r)
+	if err != nil {
+		// Records before the error were applied and are reported as such
+		s.logger().Warn("batch failed part way", "client", clientIP(r), "key", name,
+			"records", stats.Records, "added", stats.Added, "err", err)
+		resp.Error = err.Error()
+		return resp, http.StatusBadRequest
+	}
+	s.logger().Debug("batch applied", "client", clientIP(r), "key", name, "batch", fmt.Sprintf("%016x", info.ID),
+		"records", stats.Records, "added", stats.Added, "duplicates", stats.Duplicates, "collisions", stats.Collisions)
+	s.applied.remember(info.ID, resp)
+	return resp, http.StatusOK
+}
+
+// addDelta applies a delta to the database, stamping its records with
+// stamp, and tells event subscribers and OnCollision what it added
+func (s *Server) addDelta(body []byte, stamp fastbase.Provenance) (fastbase.Schema, fastbase.MergeStats, error) {
+	var added func(prefix [3]byte, rec []byte)
+	var fresh []recordResponse
+	if s.feed.active() {
+		added = func(prefix [3]byte, rec []byte) {
+			fresh = append(fresh, recordJSON(s.fb.Schema(), prefix, rec))
+		}
+	}
+
+	s.mu.Lock()
+	schema := s.fb.Schema()
+	_, stats, err := s.fb.ApplyDeltaAs(bytes.NewReader(body), stamp, added)
+	// The records reach the journal, if any, before the batch is answered
+	if jerr := s.fb.FlushJournal(false); jerr != nil && err == nil {
+		err = jerr
+	}
+	if stats.Added > 0 {
+		s.dirty = true
+	}
+	s.collisions = append(s.collisions, stats.Found...)
+	s.mu.Unlock()
+
+	for _, rec := range fresh {
+		s.feed.publish("dp", rec)
+	}
+	for _, c := range stats.Found {
+		s.logger().Info("collision found", "prefix", hex.EncodeToString(c.Prefix[:]))
+		s.feed.publish("collision", collisionJSON(schema, c))
+	}
+
+	if s.opts.OnCollision != nil {
+		for _, c := range stats.Found {
+			s.opts.OnCollision(c)
+		}
+	}
+	return schema, stats, err
+}
+
+// rejected counts and logs a batch r sent that could not be read, or was
+// refused for err
+func (s *Server) rejected(r *http.Request, err error) {
+	name, ok := keyName(r)
+	if ok {
+		s.opts.Auth.update(name, func(st *KeyStats) { st.Invalid++ })
+	}
+	s.logger().Warn("batch rejected", "client", clientIP(r), "key", name, "err", err)
+}
+
+// maxBatchBytes returns the configured batch size limit
+func (s *Server) maxBatchBytes() int64 {
+	if s.opts.MaxBatchBytes <= 0 {
+		return DefaultMaxBatchBytes
+	}
+	return s.opts.MaxBatchBytes
+}
+
+func (s *Server) handleCollisions(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	resp := make([]collisionResponse, 0, len(s.collisions))
+	for _, c := range s.collisions {
+		resp = append(resp, collisionJSON(schema, c))
+	}
+	s.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Stats returns the statistics of the database the server aggregates into
+func (s *Server) Stats() fastbase.StatsReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.fb == nil {
+		return fastbase.StatsReport{}
+	}
+	return s.fb.Stats()
+}
+
+// Save writes the database to path if records were added since the last
+// save, reporting whether it did. The file is replaced atomically. With
+// Options.BackgroundSave, batches are applied while the save is written.
+func (s *Server) Save(path string) (bool, error) {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	var tried bool
+	var err error
+	if s.opts.BackgroundSave {
+		tried, err = s.saveFrozen(path)
+	} else {
+		s.mu.Lock()
+		if tried = s.dirty; tried {
+			if err = save(s.fb, path); err == nil {
+				s.dirty = false
+			}
+		}
+		s.mu.Unlock()
+	}
+	if !tried {
+		return false, nil
+	}
+
+	s.saveMu.Lock()
+	if s.saveErr = err; err == nil {
+		s.lastSave = time.Now()
+	}
+	s.saveMu.Unlock()
+	if err != nil {
+		s.logger().Error("saving the database failed", "file", path, "err", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// saveFrozen saves a copy of the database frozen under mu, without holding
+// mu while it is written, then makes it the database's last save, reporting
+// whether there was anything to save. A failed save leaves the records it
+// held unsaved.
+func (s *Server) saveFrozen(path string) (bool, error) {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return false, nil
+	}
+	frozen, err := s.fb.Freeze()
+	if err == nil {
+		s.dirty = false
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return true, err
+	}
+
+	err = save(frozen.FastBase, path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		err = frozen.Commit()
+	}
+	if err != nil {
+		s.dirty = true
+	}
+	return true, err
+}
+
+// save writes fb to a file beside path and renames it over path,
+// checkpointing the journal of a database keeping one, or commits it to an
+// sqlite: database
+func save(fb *fastbase.FastBase, path string) error {
+	if sqlite.IsURL(path) {
+		return fb.SaveToSQLite(sqlite.Path(path))
+	}
+	tmp := path + ".tmp"
+	if err := fb.SaveToFile(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return fb.CheckpointJournal()
+}
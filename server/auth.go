@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth holds the API keys workers authenticate with and counts what each
+// key has done
+type Auth struct {
+	mu    sync.Mutex
+	keys  map[[32]byte]string // Token hash to key name
+	stats map[string]*KeyStats
+}
+
+// KeyStats counts the activity of one API key
+type KeyStats struct {
+	Requests   int       `json:"requests"`
+	Batches    int       `json:"batches"`
+	Records    int       `json:"records"`
+	Added      int       `json:"added"`
+	Duplicates int       `json:"duplicates"`
+	Invalid    int       `json:"invalid"`  // Batches rejected as malformed or too large
+	Replayed   int       `json:"replayed"` // Batches sent again after being applied, not applied twice
+	Collisions int       `json:"collisions"`
+	Rate       float64   `json:"rate"` // Records added per second over about the last RateWindow
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+
+	added rateWindow
+}
+
+// NewAuth creates an Auth without any keys
+func NewAuth() *Auth {
+	return &Auth{keys: make(map[[32]byte]string), stats: make(map[string]*KeyStats)}
+}
+
+// AddKey accepts token as the API key called name. Several tokens may share
+// a name, which then shares statistics.
+func (a *Auth) AddKey(name, token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys[sha256.Sum256([]byte(token))] = name
+	if a.stats[name] == nil {
+		a.stats[name] = &KeyStats{}
+	}
+}
+
+// LoadKeys reads API keys from a file of "name token" lines. Empty lines
+// and lines starting with '#' are skipped.
+func (a *Auth) LoadKeys(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"name token\"", path, lineNo)
+		}
+		a.AddKey(fields[0], fields[1])
+	}
+	return scanner.Err()
+}
+
+// Stats returns a copy of the statistics of every key
+func (a *Auth) Stats() map[string]KeyStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]KeyStats, len(a.stats))
+	for name, st := range a.stats {
+		st.Rate = st.added.rate(now)
+		out[name] = *st
+	}
+	return out
+}
+
+// update applies fn to the statistics of a key
+func (a *Auth) update(name string, fn func(st *KeyStats)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if st := a.stats[name]; st != nil {
+		fn(st)
+	}
+}
+
+type keyNameContextKey struct{}
+
+// keyName returns the API key a request authenticated with, if any
+func keyName(r *http.Request) (string, bool) {
+	name, ok := r.Context().Value(keyNameContextKey{}).(string)
+	return name, ok
+}
+
+// require rejects requests without a valid "Authorization: Bearer <token>"
+// header with 401 Unauthorized
+func (a *Auth) require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var name string
+		if ok {
+			a.mu.Lock()
+			name, ok = a.keys[sha256.Sum256([]byte(token))]
+			a.mu.Unlock()
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rckangaroo"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+
+		a.update(name, func(st *KeyStats) {
+			st.Requests++
+			st.LastSeen = time.Now()
+			if st.FirstSeen.IsZero() {
+				st.FirstSeen = st.LastSeen
+			}
+		})
+		next(w, r.WithContext(context.WithValue(r.Context(), keyNameContextKey{}, name)))
+	}
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.Auth.Stats())
+}
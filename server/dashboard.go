@@ -0,0 +1,140 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// dashboardHistory is how many samples the dashboard charts
+const dashboardHistory = 360
+
+// dashboardTopLists is how many of the largest lists the dashboard shows
+const dashboardTopLists = 10
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+type dashboardSample struct {
+	Time    time.Time `json:"time"`
+	Records int       `json:"records"`
+	Types   [3]int    `json:"types"`
+	Memory  int64     `json:"memory"`
+}
+
+// dashboardAlert reports a collision without the distances, which would
+// give the key away to anyone who can reach the dashboard
+type dashboardAlert struct {
+	Time   time.Time `json:"time"`
+	Prefix string    `json:"prefix"`
+	X      string    `json:"x"`
+	Types  [2]string `json:"types"`
+}
+
+type dashboardResponse struct {
+	Samples       []dashboardSample   `json:"samples"`
+	Types         []typeStatsResponse `json:"types"`
+	NonEmptyLists int                 `json:"non_empty_lists"`
+	TopLists      []topListResponse   `json:"top_lists"`
+	Alerts        []dashboardAlert    `json:"alerts"`
+}
+
+// Dashboard serves an HTML page charting a server's database: DP counts per
+// type, insert rates, memory use, the largest lists and collision alerts.
+// It learns what it shows from Sample, which the caller runs periodically.
+type Dashboard struct {
+	srv *Server
+
+	mu      sync.Mutex // Guards the fields below
+	samples []dashboardSample
+	latest  fastbase.StatsReport
+	top     []fastbase.ListStats
+	alerts  []dashboardAlert
+	seen    int // Collisions of the server already turned into alerts
+}
+
+// NewDashboard creates a dashboard for s
+func NewDashboard(s *Server) *Dashboard {
+	return &Dashboard{srv: s}
+}
+
+// Sample records the current statistics of the database and raises an
+// alert for every collision since the previous sample
+func (d *Dashboard) Sample() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.srv
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	report := s.fb.Stats()
+	top := s.fb.TopLists(dashboardTopLists)
+	memory := s.fb.MemoryUsage()
+	found := s.collisions[d.seen:]
+	d.seen = len(s.collisions)
+	s.mu.RUnlock()
+
+	now := time.Now()
+	sample := dashboardSample{Time: now, Records: report.TotalRecords, Memory: memory}
+	for t, ts := range report.Types {
+		sample.Types[t] = ts.Count
+	}
+	d.samples = append(d.samples, sample)
+	if len(d.samples) > dashboardHistory {
+		d.samples = d.samples[len(d.samples)-dashboardHistory:]
+	}
+	d.latest, d.top = report, top
+
+	for _, c := range found {
+		d.alerts = append(d.alerts, dashboardAlert{
+			Time:   now,
+			Prefix: hex.EncodeToString(c.Prefix[:]),
+			X:      hex.EncodeToString(schema.X(c.First)),
+			Types:  [2]string{schema.Type(c.First).String(), schema.Type(c.Second).String()},
+		})
+	}
+}
+
+// Handler returns the dashboard page at / and its data at /dashboard.json
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /dashboard.json", d.handleData)
+	return mux
+}
+
+func (d *Dashboard) handleData(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	resp := dashboardResponse{
+		Samples:       append([]dashboardSample{}, d.samples...),
+		NonEmptyLists: d.latest.NonEmptyLists,
+		TopLists:      make([]topListResponse, 0, len(d.top)),
+		Alerts:        append([]dashboardAlert{}, d.alerts...),
+	}
+	for t, ts := range d.latest.Types {
+		tr := typeStatsResponse{Type: fastbase.KangarooType(t).String(), Count: ts.Count, MaxListSize: ts.MaxListSize}
+		if ts.Count > 0 {
+			tr.MaxListPrefix = hex.EncodeToString(ts.MaxListPrefix[:])
+		}
+		resp.Types = append(resp.Types, tr)
+	}
+	for _, l := range d.top {
+		resp.TopLists = append(resp.TopLists, topListResponse{
+			Prefix: hex.EncodeToString(l.Prefix[:]),
+			Count:  l.Count,
+			Tame:   l.TypeCounts[0],
+			Wild1:  l.TypeCounts[1],
+			Wild2:  l.TypeCounts[2],
+		})
+	}
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, resp)
+}
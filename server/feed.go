@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// feedBuffer is how many events a subscriber may fall behind before it is
+// disconnected
+const feedBuffer = 4096
+
+// feedHeartbeat is how often idle subscribers get a keep-alive comment
+const feedHeartbeat = 15 * time.Second
+
+type event struct {
+	name string // "dp" or "collision"
+	data []byte // JSON payload
+}
+
+// feed fans events out to Server-Sent Events subscribers
+type feed struct {
+	mu   sync.Mutex
+	subs map[chan event]bool
+}
+
+func newFeed() *feed {
+	return &feed{subs: make(map[chan event]bool)}
+}
+
+// active reports whether anyone is subscribed, so publishers can skip
+// building events nobody reads
+func (f *feed) active() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs) > 0
+}
+
+func (f *feed) subscribe() chan event {
+	ch := make(chan event, feedBuffer)
+	f.mu.Lock()
+	f.subs[ch] = true
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *feed) unsubscribe(ch chan event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[ch] {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event to every subscriber. A subscriber whose buffer is
+// full is dropped rather than allowed to stall uploads; its stream ends with
+// an overflow event so it knows to resynchronize.
+func (f *feed) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	ev := event{name: name, data: data}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// handleEvents streams newly accepted points ("dp" events) and collisions
+// ("collision" events) as Server-Sent Events. ?types=collision limits the
+// stream to the listed event types.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	want := map[string]bool{"dp": true, "collision": true}
+	if t := r.URL.Query().Get("types"); t != "" {
+		want = make(map[string]bool)
+		for _, name := range strings.Split(t, ",") {
+			want[strings.TrimSpace(name)] = true
+		}
+	}
+
+	ch := s.feed.subscribe()
+	defer s.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+		case ev, ok := <-ch:
+			if !ok {
+				fmt.Fprintf(w, "event: overflow\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			if !want[ev.name] {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// DefaultWedgeTimeout is how long /healthz waits for the database lock
+// unless Options.WedgeTimeout says otherwise
+const DefaultWedgeTimeout = 10 * time.Second
+
+// maxMemoryPressure is the share of the Go memory limit in use beyond which
+// /readyz turns batches away
+const maxMemoryPressure = 0.9
+
+type memoryResponse struct {
+	Used     uint64  `json:"used"`
+	Limit    uint64  `json:"limit,omitempty"`
+	Pressure float64 `json:"pressure,omitempty"`
+	Database int64   `json:"database,omitempty"`
+}
+
+type healthResponse struct {
+	Status    string         `json:"status"`
+	Loaded    bool           `json:"loaded"`
+	Uptime    float64        `json:"uptime_seconds"`
+	LastSave  *time.Time     `json:"last_save,omitempty"`
+	SaveError string         `json:"save_error,omitempty"`
+	Memory    memoryResponse `json:"memory"`
+}
+
+// Ready hands a server created without a database the one it serves, once
+// loaded
+func (s *Server) Ready(fb *fastbase.FastBase) {
+	s.mu.Lock()
+	s.fb = fb
+	// Records replayed from a journal have yet to be saved
+	s.dirty = fb.Unsaved() > 0
+	s.mu.Unlock()
+	s.loaded.Store(true)
+	s.logger().Info("database ready")
+}
+
+// whileLoading answers 503 until the database is loaded
+func (s *Server) whileLoading(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.loaded.Load() {
+			w.Header().Set("Retry-After", "10")
+			writeError(w, http.StatusServiceUnavailable, "database still loading")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// wedged reports whether the database lock could not be taken within the
+// wedge timeout, so a stuck batch or save holds it. Only one probe waits
+// at a time; while it does, the server counts as wedged.
+func (s *Server) wedged() bool {
+	if !s.probing.CompareAndSwap(false, true) {
+		return true
+	}
+	locked := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		s.mu.RUnlock()
+		s.probing.Store(false)
+		close(locked)
+	}()
+
+	timeout := s.opts.WedgeTimeout
+	if timeout <= 0 {
+		timeout = DefaultWedgeTimeout
+	}
+	select {
+	case <-locked:
+		return false
+	case <-time.After(timeout):
+		return true
+	}
+}
+
+// health reports the state of the server and whether it is alive, that is
+// not wedged, and ready for batches
+func (s *Server) health() (resp healthResponse, alive, ready bool) {
+	resp = healthResponse{Status: "ok", Loaded: s.loaded.Load(), Uptime: time.Since(s.started).Seconds()}
+
+	s.saveMu.Lock()
+	if !s.lastSave.IsZero() {
+		t := s.lastSave
+		resp.LastSave = &t
+	}
+	if s.saveErr != nil {
+		resp.SaveError = s.saveErr.Error()
+	}
+	s.saveMu.Unlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	resp.Memory.Used = ms.Sys - ms.HeapReleased
+	if limit := debug.SetMemoryLimit(-1); limit < math.MaxInt64 {
+		resp.Memory.Limit = uint64(limit)
+		resp.Memory.Pressure = float64(resp.Memory.Used) / float64(limit)
+	}
+
+	alive = !s.wedged()
+	if alive && resp.Loaded {
+		s.mu.RLock()
+		resp.Memory.Database = s.fb.MemoryUsage()
+		s.mu.RUnlock()
+	}
+
+	switch {
+	case !alive:
+		resp.Status = "wedged"
+	case !resp.Loaded:
+		resp.Status = "loading"
+	case resp.Memory.Pressure >= maxMemoryPressure:
+		resp.Status = "memory pressure"
+	}
+	return resp, alive, resp.Status == "ok"
+}
+
+// handleHealth answers liveness probes: 503 only when the server is wedged
+// and restarting it is the way out
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	resp, alive, _ := s.health()
+	status := http.StatusOK
+	if !alive {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+// handleReady answers readiness probes: 503 while the database loads, the
+// server is wedged or memory runs short, so no batches are sent its way
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	resp, _, ready := s.health()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Lease errors
+var (
+	errNoLeases   = errors.New("no subranges left to lease")
+	errNotHolder  = errors.New("lease is held by another worker or has expired")
+	errBadLeaseID = errors.New("no such lease")
+)
+
+// LeaseManager splits a search range into equal subranges and leases them
+// to workers for a limited time. Leases that are not renewed before they
+// expire go back to the pool for another worker.
+type LeaseManager struct {
+	mu        sync.Mutex
+	start     *big.Int
+	bits      int
+	splitBits int
+	ttl       time.Duration
+	leases    []leaseState
+	workers   map[string]*WorkerProgress
+}
+
+type leaseState struct {
+	Worker   string    `json:"worker,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Progress uint64    `json:"progress"`
+	Done     bool      `json:"done"`
+	Assigned int       `json:"assigned"`
+}
+
+// WorkerProgress summarizes what one worker has done
+type WorkerProgress struct {
+	LastSeen   time.Time `json:"last_seen"`
+	Active     int       `json:"active"`
+	Completed  int       `json:"completed"`
+	Progress   uint64    `json:"progress"`
+	Reassigned int       `json:"reassigned"`
+}
+
+// LeaseInfo describes a subrange and its lease
+type LeaseInfo struct {
+	ID       int       `json:"id"`
+	Start    string    `json:"start"`
+	End      string    `json:"end"`
+	Bits     int       `json:"bits"`
+	Worker   string    `json:"worker,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Progress uint64    `json:"progress"`
+	Done     bool      `json:"done"`
+	Assigned int       `json:"assigned"`
+}
+
+// NewLeaseManager splits [start, start + 2^bits) into 2^splitBits subranges
+// leased for ttl at a time
+func NewLeaseManager(start *big.Int, bits, splitBits int, ttl time.Duration) (*LeaseManager, error) {
+	if splitBits < 0 || splitBits >= bits || splitBits > 24 {
+		return nil, fmt.Errorf("split bits must be in 0...%d, got %d", min(bits-1, 24), splitBits)
+	}
+	return &LeaseManager{
+		start:     new(big.Int).Set(start),
+		bits:      bits,
+		splitBits: splitBits,
+		ttl:       ttl,
+		leases:    make([]leaseState, 1<<splitBits),
+		workers:   make(map[string]*WorkerProgress),
+	}, nil
+}
+
+// info describes lease id. The caller holds lm.mu.
+func (lm *LeaseManager) info(id int) LeaseInfo {
+	sub := lm.bits - lm.splitBits
+	start := new(big.Int).Lsh(big.NewInt(int64(id)), uint(sub))
+	start.Add(start, lm.start)
+	end := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(sub)))
+
+	l := lm.leases[id]
+	return LeaseInfo{
+		ID:       id,
+		Start:    start.Text(16),
+		End:      end.Text(16),
+		Bits:     sub,
+		Worker:   l.Worker,
+		Expires:  l.Expires,
+		Progress: l.Progress,
+		Done:     l.Done,
+		Assigned: l.Assigned,
+	}
+}
+
+// worker returns the progress record of a worker, creating it if needed.
+// The caller holds lm.mu.
+func (lm *LeaseManager) worker(name string, now time.Time) *WorkerProgress {
+	w, ok := lm.workers[name]
+	if !ok {
+		w = &WorkerProgress{}
+		lm.workers[name] = w
+	}
+	w.LastSeen = now
+	return w
+}
+
+// expire returns leases whose holders stopped renewing them to the pool.
+// The caller holds lm.mu.
+func (lm *LeaseManager) expire(now time.Time) {
+	for id := range lm.leases {
+		l := &lm.leases[id]
+		if l.Done || l.Worker == "" || now.Before(l.Expires) {
+			continue
+		}
+		if w, ok := lm.workers[l.Worker]; ok {
+			w.Active--
+			w.Reassigned++
+		}
+		l.Worker = ""
+		l.Progress = 0
+	}
+}
+
+// Acquire leases a subrange to a worker. A worker that already holds an
+// unexpired lease gets that lease back.
+func (lm *LeaseManager) Acquire(worker string) (LeaseInfo, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	lm.expire(now)
+
+	free := -1
+	for id, l := range lm.leases {
+		if l.Worker == worker && !l.Done {
+			return lm.info(id), nil
+		}
+		if free < 0 && l.Worker == "" && !l.Done {
+			free = id
+		}
+	}
+	if free < 0 {
+		return LeaseInfo{}, errNoLeases
+	}
+
+	l := &lm.leases[free]
+	l.Worker = worker
+	l.Expires = now.Add(lm.ttl)
+	l.Assigned++
+	lm.worker(worker, now).Active++
+	return lm.info(free), nil
+}
+
+// Renew extends a worker's lease and records its progress within it
+func (lm *LeaseManager) Renew(id int, worker string, progress uint64) (LeaseInfo, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	lm.expire(now)
+
+	l, err := lm.held(id, worker)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	w := lm.worker(worker, now)
+	if progress > l.Progress {
+		w.Progress += progress - l.Progress
+		l.Progress = progress
+	}
+	l.Expires = now.Add(lm.ttl)
+	return lm.info(id), nil
+}
+
+// Complete marks a worker's subrange as searched
+func (lm *LeaseManager) Complete(id int, worker string, progress uint64) (LeaseInfo, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	now := time.Now()
+	lm.expire(now)
+
+	l, err := lm.held(id, worker)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	w := lm.worker(worker, now)
+	if progress > l.Progress {
+		w.Progress += progress - l.Progress
+		l.Progress = progress
+	}
+	l.Done = true
+	w.Active--
+	w.Completed++
+	return lm.info(id), nil
+}
+
+// held returns lease id if worker holds it. The caller holds lm.mu.
+func (lm *LeaseManager) held(id int, worker string) (*leaseState, error) {
+	if id < 0 || id >= len(lm.leases) {
+		return nil, errBadLeaseID
+	}
+	l := &lm.leases[id]
+	if l.Done || l.Worker != worker {
+		return nil, errNotHolder
+	}
+	return l, nil
+}
+
+// LeaseStatus is a snapshot of every subrange and worker
+type LeaseStatus struct {
+	Total   int                        `json:"total"`
+	Done    int                        `json:"done"`
+	Active  int                        `json:"active"`
+	Leases  []LeaseInfo                `json:"leases"`
+	Workers map[string]*WorkerProgress `json:"workers"`
+}
+
+// Status returns the state of every lease and worker
+func (lm *LeaseManager) Status() LeaseStatus {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.expire(time.Now())
+
+	st := LeaseStatus{Total: len(lm.leases), Workers: make(map[string]*WorkerProgress)}
+	for id, l := range lm.leases {
+		switch {
+		case l.Done:
+			st.Done++
+		case l.Worker != "":
+			st.Active++
+		default:
+			continue
+		}
+		st.Leases = append(st.Leases, lm.info(id))
+	}
+	for name, w := range lm.workers {
+		copied := *w
+		st.Workers[name] = &copied
+	}
+	return st
+}
+
+// leaseFile is the on-disk form of a LeaseManager
+type leaseFile struct {
+	Start     string                     `json:"range_start"`
+	Bits      int                        `json:"range_bits"`
+	SplitBits int                        `json:"split_bits"`
+	Leases    []leaseState               `json:"leases"`
+	Workers   map[string]*WorkerProgress `json:"workers"`
+}
+
+// Save writes the lease state to a JSON file, replacing it atomically
+func (lm *LeaseManager) Save(path string) error {
+	lm.mu.Lock()
+	data, err := json.Marshal(leaseFile{
+		Start:     lm.start.Text(16),
+		Bits:      lm.bits,
+		SplitBits: lm.splitBits,
+		Leases:    lm.leases,
+		Workers:   lm.workers,
+	})
+	lm.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load restores lease state saved for the same range and split
+func (lm *LeaseManager) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f leaseFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("error reading lease file: %v", err)
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if f.Start != lm.start.Text(16) || f.Bits != lm.bits || f.SplitBits != lm.splitBits || len(f.Leases) != len(lm.leases) {
+		return fmt.Errorf("lease file is for range %s/%d split %d, not %s/%d split %d",
+			f.Start, f.Bits, f.SplitBits, lm.start.Text(16), lm.bits, lm.splitBits)
+	}
+	lm.leases = f.Leases
+	if f.Workers != nil {
+		lm.workers = f.Workers
+	}
+	return nil
+}
+
+// workerName identifies the worker behind a lease request: the worker form
+// value, or else the API key the request authenticated with
+func workerName(r *http.Request) string {
+	if worker := r.FormValue("worker"); worker != "" {
+		return worker
+	}
+	name, _ := keyName(r)
+	return name
+}
+
+func (s *Server) handleLeaseAcquire(w http.ResponseWriter, r *http.Request) {
+	worker := workerName(r)
+	if worker == "" {
+		writeError(w, http.StatusBadRequest, "worker is required")
+		return
+	}
+	lease, err := s.opts.Leases.Acquire(worker)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+// handleLeaseUpdate serves the renew and done endpoints through the
+// matching LeaseManager method
+func (s *Server) handleLeaseUpdate(update func(id int, worker string, progress uint64) (LeaseInfo, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid lease id")
+			return
+		}
+		worker := workerName(r)
+		if worker == "" {
+			writeError(w, http.StatusBadRequest, "worker is required")
+			return
+		}
+		var progress uint64
+		if p := r.FormValue("progress"); p != "" {
+			if progress, err = strconv.ParseUint(p, 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid progress")
+				return
+			}
+		}
+
+		lease, err := update(id, worker, progress)
+		switch err {
+		case nil:
+			writeJSON(w, http.StatusOK, lease)
+		case errBadLeaseID:
+			writeError(w, http.StatusNotFound, err.Error())
+		default:
+			writeError(w, http.StatusConflict, err.Error())
+		}
+	}
+}
+
+func (s *Server) handleLeaseStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.Leases.Status())
+}
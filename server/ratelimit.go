@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a per-key token bucket limiter
+type RateLimiter struct {
+	rate  float64 // Tokens added per second
+	burst float64 // Bucket capacity
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	lastGC  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows rate requests per second per key with bursts of up
+// to burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		lastGC:  time.Now(),
+	}
+}
+
+// Allow takes a token from the key's bucket, reporting false if it is empty
+func (rl *RateLimiter) Allow(key string) bool {
+	ok, _ := rl.AllowN(key, 1)
+	return ok
+}
+
+// AllowN takes n tokens from the key's bucket if it holds that many, else
+// reports false and how long until it will. n must not exceed the burst.
+func (rl *RateLimiter) AllowN(key string, n int) (bool, time.Duration) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.gc(now)
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < float64(n) {
+		return false, time.Duration((float64(n) - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return true, 0
+}
+
+// Burst returns the most tokens a bucket holds
+func (rl *RateLimiter) Burst() int {
+	return int(rl.burst)
+}
+
+// gc drops buckets that have been idle long enough to be full again
+func (rl *RateLimiter) gc(now time.Time) {
+	if now.Sub(rl.lastGC) < time.Minute {
+		return
+	}
+	rl.lastGC = now
+
+	idle := time.Minute
+	if rl.rate > 0 {
+		idle += time.Duration(rl.burst / rl.rate * float64(time.Second))
+	}
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > idle {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// Middleware rejects requests from clients that exceed their rate with
+// 429 Too Many Requests, keyed by client IP
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.Allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the connection a request came in on
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package server
+
+import "sync"
+
+// replayMemory is how many applied batches the server remembers the
+// answers to
+const replayMemory = 1 << 16
+
+// batchLog remembers the answers to the latest batches applied, by batch
+// ID, so a batch sent again because its answer was lost is answered the
+// same without being applied twice. Batches with ID 0, from clients that
+// predate batch IDs, are not remembered.
+type batchLog struct {
+	mu    sync.Mutex
+	resps map[uint64]submitResponse
+	ring  []uint64 // IDs in resps, the oldest at next once full
+	next  int
+}
+
+func newBatchLog(size int) *batchLog {
+	return &batchLog{resps: make(map[uint64]submitResponse, size), ring: make([]uint64, 0, size)}
+}
+
+// lookup returns the answer to the batch with id if it was applied
+func (l *batchLog) lookup(id uint64) (submitResponse, bool) {
+	if id == 0 {
+		return submitResponse{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resp, ok := l.resps[id]
+	return resp, ok
+}
+
+// remember records the answer to an applied batch, forgetting the oldest
+// once full
+func (l *batchLog) remember(id uint64, resp submitResponse) {
+	if id == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.resps[id]; ok {
+		return
+	}
+	if len(l.ring) < cap(l.ring) {
+		l.ring = append(l.ring, id)
+	} else {
+		delete(l.resps, l.ring[l.next])
+		l.ring[l.next] = id
+		l.next = (l.next + 1) % len(l.ring)
+	}
+	l.resps[id] = resp
+}
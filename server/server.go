@@ -0,0 +1,376 @@
+// Package server exposes a FastBase over HTTP
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/query"
+)
+
+// DefaultMaxBatchBytes bounds the size of a submitted batch unless
+// Options.MaxBatchBytes says otherwise
+const DefaultMaxBatchBytes = 64 << 20
+
+// Options configures a Server
+type Options struct {
+	ReadOnly      bool    // Expose only lookup and statistics endpoints
+	RateLimit     float64 // Requests per second per client IP; 0 disables limiting
+	RateBurst     int     // Burst size for the rate limiter
+	MaxBatchBytes int64   // Largest batch accepted by POST /dps
+
+	// Auth, if set, requires an API key for uploads, leases and collisions
+	Auth *Auth
+
+	// Leases, if set, hands out subranges of the search range to workers
+	Leases *LeaseManager
+
+	// OnCollision, if set, is called for every collision a submitted batch
+	// creates, after the batch has been applied
+	OnCollision func(c fastbase.Collision)
+
+	// UploadRate, if positive, limits the records each client may upload
+	// per second, with bursts of up to UploadBurst records; clients are
+	// told apart by API key when they have one, else by IP
+	UploadRate  float64
+	UploadBurst int
+
+	// DPBits, if positive, rejects batches holding records that are not
+	// distinguished at this many bits
+	DPBits int
+
+	// WedgeTimeout is how long /healthz waits for the database lock before
+	// reporting the server wedged; DefaultWedgeTimeout if 0
+	WedgeTimeout time.Duration
+
+	// BackgroundSave, if set, has Save write a frozen copy of the database
+	// while batches keep being applied, rather than holding the database
+	// lock for the whole save. The copy takes as much memory again as the
+	// database while it is written. Taking it still holds the lock, as
+	// Freeze copies every pool and list in one go: batches wait for the
+	// copy, about a second for every 200 MiB of database, though no longer
+	// for the write to disk.
+	BackgroundSave bool
+
+	// Mirror, if set, answers /stats, /find and /prefix from a database
+	// file on disk, reading each list asked for in place, so a read-only
+	// server needs no more memory than a list takes. The FastBase given
+	// to New is then left empty.
+	Mirror *fastbase.Mirror
+
+	// Logger, if set, receives structured logs: batches applied at Debug,
+	// collisions and syncs at Info, batches rejected at Warn and failed
+	// saves at Error. The database logs its own loads and saves through
+	// the logger given to its SetLogger.
+	Logger *slog.Logger
+}
+
+// Server serves queries against a FastBase and, unless read-only, accepts
+// batches of distinguished points from workers
+type Server struct {
+	opts Options
+
+	mu         sync.RWMutex // Guards the fields below
+	fb         *fastbase.FastBase
+	collisions []fastbase.Collision // Collisions created since startup
+	dirty      bool                 // Records were added since the last save
+
+	feed    *feed        // Live event subscribers
+	uploads *RateLimiter // Records uploaded per client, if limited
+	applied *batchLog    // Answers to the latest batches applied
+
+	started time.Time
+	loaded  atomic.Bool // The database is in place
+	probing atomic.Bool // A health probe waits for mu
+
+	saving   sync.Mutex // Held over each save, as background saves release mu
+	saveMu   sync.Mutex // Guards the fields below, apart from mu so probes see them while it is held
+	lastSave time.Time
+	saveErr  error
+}
+
+// New creates a server for fb. With a nil fb it answers only /healthz and
+// /readyz, and 503 elsewhere, until Ready hands it the database, so it can
+// listen while a large one loads.
+func New(fb *fastbase.FastBase, opts Options) *Server {
+	s := &Server{opts: opts, fb: fb, feed: newFeed(), applied: newBatchLog(replayMemory), started: time.Now()}
+	s.loaded.Store(fb != nil)
+	if opts.UploadRate > 0 {
+		s.uploads = NewRateLimiter(opts.UploadRate, opts.UploadBurst)
+	}
+	return s
+}
+
+// logger returns the configured logger, or one discarding everything
+func (s *Server) logger() *slog.Logger {
+	if s.opts.Logger == nil {
+		return discardLogger
+	}
+	return s.opts.Logger
+}
+
+// discardLogger drops every record, enabling no level so none is formatted
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
+// Handler returns the HTTP handler with all routes for the configured mode
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("GET /find", s.handleFind)
+	mux.HandleFunc("GET /prefix/{hex}", s.handlePrefix)
+	if !s.opts.ReadOnly {
+		mux.HandleFunc("GET /sync/summary", s.protect(s.handleSyncSummary))
+		mux.HandleFunc("GET /sync/records", s.protect(s.handleSyncRecords))
+		mux.HandleFunc("GET /sync/digest", s.protect(s.handleSyncDigest))
+		mux.HandleFunc("POST /dps", s.protect(s.handleSubmit))
+		mux.HandleFunc("POST /dps/stream", s.protect(s.handleStream))
+		mux.HandleFunc("GET /collisions", s.protect(s.handleCollisions))
+		mux.HandleFunc("GET /events", s.protect(s.handleEvents))
+	}
+	if !s.opts.ReadOnly && s.opts.Leases != nil {
+		mux.HandleFunc("POST /leases", s.protect(s.handleLeaseAcquire))
+		mux.HandleFunc("POST /leases/{id}/renew", s.protect(s.handleLeaseUpdate(s.opts.Leases.Renew)))
+		mux.HandleFunc("POST /leases/{id}/done", s.protect(s.handleLeaseUpdate(s.opts.Leases.Complete)))
+		mux.HandleFunc("GET /leases", s.protect(s.handleLeaseStatus))
+	}
+	if !s.opts.ReadOnly && s.opts.Auth != nil {
+		mux.HandleFunc("GET /keys", s.protect(s.handleKeys))
+		mux.HandleFunc("GET /workers", s.protect(s.handleWorkers))
+	}
+
+	var h http.Handler = s.whileLoading(mux)
+	if s.opts.RateLimit > 0 {
+		h = NewRateLimiter(s.opts.RateLimit, s.opts.RateBurst).Middleware(h)
+	}
+
+	// Probes skip the rate limit, which would make a busy server look dead
+	outer := http.NewServeMux()
+	outer.HandleFunc("GET /healthz", s.handleHealth)
+	outer.HandleFunc("GET /readyz", s.handleReady)
+	outer.Handle("/", h)
+	return outer
+}
+
+// protect requires an API key for h when authentication is configured
+func (s *Server) protect(h http.HandlerFunc) http.HandlerFunc {
+	if s.opts.Auth == nil {
+		return h
+	}
+	return s.opts.Auth.require(h)
+}
+
+type typeStatsResponse struct {
+	Type          string `json:"type"`
+	Count         int    `json:"count"`
+	MaxListSize   uint32 `json:"max_list_size"`
+	MaxListPrefix string `json:"max_list_prefix,omitempty"`
+}
+
+type topListResponse struct {
+	Prefix string `json:"prefix"`
+	Count  uint32 `json:"count"`
+	Tame   uint32 `json:"tame"`
+	Wild1  uint32 `json:"wild1"`
+	Wild2  uint32 `json:"wild2"`
+}
+
+type statsResponse struct {
+	NonEmptyLists int                 `json:"non_empty_lists"`
+	TotalRecords  int                 `json:"total_records"`
+	MaxListSize   uint32              `json:"max_list_size"`
+	MaxListPrefix string              `json:"max_list_prefix"`
+	Types         []typeStatsResponse `json:"types"`
+	TopLists      []topListResponse   `json:"top_lists,omitempty"`
+}
+
+type prefixResponse struct {
+	Prefix  string           `json:"prefix"`
+	Count   int              `json:"count"`
+	Records []recordResponse `json:"records"`
+}
+
+type recordResponse struct {
+	Prefix   string `json:"prefix"`
+	X        string `json:"x"`
+	Distance string `json:"distance"`
+	Type     string `json:"type"`
+}
+
+// MaxTopLists bounds the top query parameter of /stats, and is as many top
+// lists as a Mirror needs to keep
+const MaxTopLists = 1000
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	top := 0
+	if t := r.URL.Query().Get("top"); t != "" {
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 0 || n > MaxTopLists {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("top must be in 0...%d", MaxTopLists))
+			return
+		}
+		top = n
+	}
+	filters, err := s.whereFilters(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var report fastbase.StatsReport
+	var topLists []fastbase.ListStats
+	if m := s.opts.Mirror; m != nil {
+		// Filtered statistics would read the whole file for each request
+		if filters != nil {
+			writeError(w, http.StatusBadRequest, "where is not supported by /stats of a mirror served from disk")
+			return
+		}
+		report, topLists = m.Stats(), m.TopLists(top)
+	} else {
+		s.mu.RLock()
+		report = s.fb.Stats(filters...)
+		topLists = s.fb.TopLists(top, filters...)
+		s.mu.RUnlock()
+	}
+
+	resp := statsResponse{
+		NonEmptyLists: report.NonEmptyLists,
+		TotalRecords:  report.TotalRecords,
+		MaxListSize:   report.MaxListSize,
+		MaxListPrefix: hex.EncodeToString(report.MaxListPrefix[:]),
+	}
+	for t, ts := range report.Types {
+		tr := typeStatsResponse{Type: fastbase.KangarooType(t).String(), Count: ts.Count, MaxListSize: ts.MaxListSize}
+		if ts.Count > 0 {
+			tr.MaxListPrefix = hex.EncodeToString(ts.MaxListPrefix[:])
+		}
+		resp.Types = append(resp.Types, tr)
+	}
+	for _, l := range topLists {
+		resp.TopLists = append(resp.TopLists, topListResponse{
+			Prefix: hex.EncodeToString(l.Prefix[:]),
+			Count:  l.Count,
+			Tame:   l.TypeCounts[0],
+			Wild1:  l.TypeCounts[1],
+			Wild2:  l.TypeCounts[2],
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleFind(w http.ResponseWriter, r *http.Request) {
+	x, err := hex.DecodeString(strings.TrimPrefix(r.URL.Query().Get("x"), "0x"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "x must be hex")
+		return
+	}
+
+	var matches []fastbase.Match
+	schema := s.schema()
+	if m := s.opts.Mirror; m != nil {
+		matches, err = m.FindX(x)
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		matches, err = s.fb.FindX(x)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := make([]recordResponse, 0, len(matches))
+	for _, m := range matches {
+		resp = append(resp, recordJSON(schema, m.Prefix, m.Record))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePrefix(w http.ResponseWriter, r *http.Request) {
+	p, err := hex.DecodeString(r.PathValue("hex"))
+	if err != nil || len(p) != 3 {
+		writeError(w, http.StatusBadRequest, "prefix must be 3 hex bytes")
+		return
+	}
+	prefix := [3]byte{p[0], p[1], p[2]}
+	filters, err := s.whereFilters(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var records [][]byte
+	schema := s.schema()
+	if m := s.opts.Mirror; m != nil {
+		if records, err = m.ListRecords(prefix, filters...); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		records = s.fb.ListRecords(prefix, filters...)
+	}
+	resp := prefixResponse{
+		Prefix:  hex.EncodeToString(prefix[:]),
+		Count:   len(records),
+		Records: make([]recordResponse, 0, len(records)),
+	}
+	for _, rec := range records {
+		resp.Records = append(resp.Records, recordJSON(schema, prefix, rec))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// whereFilters compiles the optional where query parameter
+func (s *Server) whereFilters(r *http.Request) ([]fastbase.Filter, error) {
+	expr := r.URL.Query().Get("where")
+	if expr == "" {
+		return nil, nil
+	}
+	f, err := query.Compile(expr, s.schema())
+	if err != nil {
+		return nil, fmt.Errorf("invalid where expression: %v", err)
+	}
+	return []fastbase.Filter{f}, nil
+}
+
+// schema returns the record schema of the database served
+func (s *Server) schema() fastbase.Schema {
+	if s.opts.Mirror != nil {
+		return s.opts.Mirror.Schema()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fb.Schema()
+}
+
+func recordJSON(schema fastbase.Schema, prefix [3]byte, rec []byte) recordResponse {
+	return recordResponse{
+		Prefix:   hex.EncodeToString(prefix[:]),
+		X:        hex.EncodeToString(schema.X(rec)),
+		Distance: hex.EncodeToString(schema.Distance(rec)),
+		Type:     schema.Type(rec).String(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
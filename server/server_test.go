@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rckangaroo/fastbase"
+)
+
+// TestReadOnlyRoutes checks that a read-only server, which may run
+// without authentication, does not hand its records out in bulk
+func TestReadOnlyRoutes(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		readOnly bool
+		want     int
+	}{
+		{"/sync/records?range=000000-ffffff", true, http.StatusNotFound},
+		{"/sync/summary?range=000000-ffffff", true, http.StatusNotFound},
+		{"/sync/digest?path=00", true, http.StatusNotFound},
+		{"/sync/records?range=000000-ffffff", false, http.StatusOK},
+		{"/sync/digest?path=00", false, http.StatusOK},
+		{"/stats", true, http.StatusOK},
+	} {
+		h := New(fastbase.NewFastBase(), Options{ReadOnly: tc.readOnly}).Handler()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s with ReadOnly %v: status %d; want %d", tc.path, tc.readOnly, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamContentType is the request content type of POST /dps/stream
+const StreamContentType = "application/x-rckangaroo-stream"
+
+// handleStream accepts a long-lived upload of many batches over one request.
+// Each frame is a 4-byte big-endian length followed by a gzip-compressed
+// batch in the delta file format; every frame is answered with one line of
+// JSON carrying its sequence number, so clients can pipeline frames and
+// drop their copy of each batch once it is acknowledged.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	// Acks are written while the request body is still being read
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	limit := s.maxBatchBytes()
+	br := bufio.NewReader(r.Body)
+	enc := json.NewEncoder(w)
+	for seq := 1; ; seq++ {
+		body, err := readFrame(br, limit)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// The stream can't be resynchronized after a bad frame
+			s.rejected(r, err)
+			enc.Encode(submitResponse{Seq: seq, Error: err.Error()})
+			rc.Flush()
+			return
+		}
+
+		// Over the upload rate, acks are held back until it allows the
+		// batch, which slows a pipelining client down to it
+		resp, _ := s.applyBatch(r, body, true, true)
+		resp.Seq = seq
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// readFrame reads one length-prefixed frame, returning io.EOF only at a clean
+// frame boundary
+func readFrame(r io.Reader, limit int64) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated frame header")
+		}
+		return nil, err
+	}
+
+	n := int64(binary.BigEndian.Uint32(size[:]))
+	if n > limit {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the %d byte limit", n, limit)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("truncated frame: %v", err)
+	}
+	return body, nil
+}
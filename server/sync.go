@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"rckangaroo/fastbase"
+)
+
+// Sync tuning: how many buckets a summary splits a band of prefixes into,
+// how few records a differing bucket must hold on both sides to be
+// fetched rather than summarized again, and how many records are pushed
+// to a peer per batch
+const (
+	syncFanout      = 256
+	syncMaxFanout   = 4096
+	syncLeafRecords = 4096
+	syncPushRecords = 10000
+)
+
+// Peer is another pool server this one reconciles its database with
+type Peer struct {
+	URL    string       // Base URL, or host:port for plain HTTP
+	Token  string       // API key the peer knows this server by
+	Client *http.Client // Client for requests to the peer; http.DefaultClient if nil
+}
+
+// normalized returns the peer with a full base URL and a client
+func (p Peer) normalized() Peer {
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	p.URL = strings.TrimSuffix(p.URL, "/")
+	if !strings.Contains(p.URL, "://") {
+		p.URL = "http://" + p.URL
+	}
+	return p
+}
+
+// SyncStats reports one reconciliation with a peer
+type SyncStats struct {
+	Compared   int // Buckets whose summaries were compared
+	Differed   int // Buckets whose records were exchanged
+	Pulled     int // Records fetched from the peer
+	Added      int // Records fetched that were new here
+	Pushed     int // Records sent to the peer that it lacked
+	Collisions int // Collisions the records fetched created here
+}
+
+type bucketResponse struct {
+	Range  string `json:"range"`
+	Count  int    `json:"count"`
+	Digest string `json:"digest"`
+}
+
+type digestResponse struct {
+	Path     string            `json:"path"`
+	Hash     string            `json:"hash"`
+	Children map[string]string `json:"children,omitempty"` // By the hex of the next prefix byte
+}
+
+type summaryResponse struct {
+	Schema  string           `json:"schema"`
+	Buckets []bucketResponse `json:"buckets"`
+}
+
+// syncRange parses the range parameter of a sync request, every prefix if
+// it is absent
+func syncRange(r *http.Request) (fastbase.PrefixRange, error) {
+	s := r.URL.Query().Get("range")
+	if s == "" {
+		return fastbase.AllPrefixes, nil
+	}
+	return fastbase.ParsePrefixRange(s)
+}
+
+// handleSyncSummary answers GET /sync/summary?range=lo-hi&buckets=N with the
+// count and digest of the records in each of N bands of the range
+func (s *Server) handleSyncSummary(w http.ResponseWriter, r *http.Request) {
+	pr, err := syncRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	n := syncFanout
+	if v := r.URL.Query().Get("buckets"); v != "" {
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > syncMaxFanout {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("buckets must be between 1 and %d", syncMaxFanout))
+			return
+		}
+	}
+
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	buckets := s.fb.Summarize(pr, n)
+	s.mu.RUnlock()
+
+	resp := summaryResponse{Schema: schema.Name, Buckets: make([]bucketResponse, len(buckets))}
+	for i, b := range buckets {
+		resp.Buckets[i] = bucketResponse{Range: b.Range.String(), Count: b.Count, Digest: fmt.Sprintf("%016x", b.Digest)}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleSyncDigest answers GET /sync/digest?path=hex with the node of the
+// digest tree of the database for the prefixes starting with path
+func (s *Server) handleSyncDigest(w http.ResponseWriter, r *http.Request) {
+	path, err := hex.DecodeString(r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "path must be hex")
+		return
+	}
+
+	s.mu.RLock()
+	node, err := s.fb.DigestNode(path)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := digestResponse{Path: hex.EncodeToString(path), Hash: hex.EncodeToString(node.Hash[:])}
+	if node.Children != nil {
+		resp.Children = make(map[string]string, len(node.Children))
+		for b, h := range node.Children {
+			resp.Children[hex.EncodeToString([]byte{b})] = hex.EncodeToString(h[:])
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// PeerDigest fetches the node of a server's digest tree for path, so its
+// database can be compared with another with fastbase.DivergentPrefixes
+func PeerDigest(ctx context.Context, p Peer, path []byte) (fastbase.DigestNode, error) {
+	var node fastbase.DigestNode
+	p = p.normalized()
+	body, err := peerGet(ctx, p, "/sync/digest?path="+hex.EncodeToString(path))
+	if err != nil {
+		return node, err
+	}
+	var resp digestResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return node, fmt.Errorf("invalid digest from %s: %v", p.URL, err)
+	}
+	h, err := hex.DecodeString(resp.Hash)
+	if err != nil || len(h) != 32 {
+		return node, fmt.Errorf("invalid digest from %s", p.URL)
+	}
+	node.Hash = [32]byte(h)
+	if resp.Children != nil {
+		node.Children = make(map[byte][32]byte, len(resp.Children))
+		for k, v := range resp.Children {
+			b, err1 := hex.DecodeString(k)
+			h, err2 := hex.DecodeString(v)
+			if err1 != nil || err2 != nil || len(b) != 1 || len(h) != 32 {
+				return node, fmt.Errorf("invalid digest from %s", p.URL)
+			}
+			node.Children[b[0]] = [32]byte(h)
+		}
+	}
+	return node, nil
+}
+
+// handleSyncRecords answers GET /sync/records?range=lo-hi with the records
+// filed in the range, as a gzip-compressed batch in the delta file format
+// that POST /dps accepts
+func (s *Server) handleSyncRecords(w http.ResponseWriter, r *http.Request) {
+	pr, err := syncRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	batch := fastbase.NewBatch(schema)
+	tooLarge := false
+	s.fb.ForEachInRange(pr, func(prefix [3]byte, records [][]byte) bool {
+		for _, rec := range records {
+			batch.Add(prefix, s.fb.Narrow(rec))
+		}
+		tooLarge = int64(batch.Len())*int64(3+schema.RecordLength) > s.maxBatchBytes()
+		return !tooLarge
+	})
+	s.mu.RUnlock()
+
+	if tooLarge {
+		writeError(w, http.StatusRequestEntityTooLarge, "range holds too many records; summarize it in smaller bands")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Encoding", "gzip")
+	zw := gzip.NewWriter(w)
+	batch.WriteTo(zw)
+	zw.Close()
+}
+
+// SyncWith reconciles the database with a peer's, so both end up with the
+// records of either. Summaries of ever narrower bands of prefixes are
+// compared until the bands that differ are small, and only the records of
+// those are fetched; the records of such a band the peer lacks are sent to
+// it with POST /dps. Records fetched are stamped with the time they came
+// and, in databases with provenance, the peer's URL as the worker.
+func (s *Server) SyncWith(ctx context.Context, p Peer) (SyncStats, error) {
+	var st SyncStats
+	if !s.loaded.Load() {
+		return st, fmt.Errorf("the database is still loading")
+	}
+	p = p.normalized()
+
+	// Depth first, so only one path of bands waits at a time
+	bands := []fastbase.PrefixRange{fastbase.AllPrefixes}
+	for len(bands) > 0 {
+		band := bands[len(bands)-1]
+		bands = bands[:len(bands)-1]
+
+		theirs, err := s.peerSummary(ctx, p, band)
+		if err != nil {
+			return st, err
+		}
+		s.mu.RLock()
+		ours := s.fb.Summarize(band, syncFanout)
+		s.mu.RUnlock()
+		if len(theirs) != len(ours) {
+			return st, fmt.Errorf("peer split %s into %d buckets, not %d", band, len(theirs), len(ours))
+		}
+
+		for i := len(ours) - 1; i >= 0; i-- {
+			st.Compared++
+			b := ours[i]
+			if theirs[i].Range != b.Range.String() {
+				return st, fmt.Errorf("peer bucket %s does not match %s", theirs[i].Range, b.Range)
+			}
+			if theirs[i].Count == b.Count && theirs[i].Digest == fmt.Sprintf("%016x", b.Digest) {
+				continue
+			}
+			if max(theirs[i].Count, b.Count) > syncLeafRecords && !b.Range.Single() {
+				bands = append(bands, b.Range)
+				continue
+			}
+			st.Differed++
+			if err := s.exchange(ctx, p, b.Range, &st); err != nil {
+				return st, err
+			}
+		}
+	}
+	s.logger().Info("synced with peer", "peer", p.URL, "compared", st.Compared, "differed", st.Differed,
+		"pulled", st.Pulled, "added", st.Added, "pushed", st.Pushed, "collisions", st.Collisions)
+	return st, nil
+}
+
+// peerSummary fetches the buckets a peer splits a band into
+func (s *Server) peerSummary(ctx context.Context, p Peer, band fastbase.PrefixRange) ([]bucketResponse, error) {
+	q := url.Values{"range": {band.String()}, "buckets": {strconv.Itoa(syncFanout)}}
+	body, err := peerGet(ctx, p, "/sync/summary?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var resp summaryResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid summary from %s: %v", p.URL, err)
+	}
+
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	s.mu.RUnlock()
+	if resp.Schema != schema.Name {
+		return nil, fmt.Errorf("peer %s holds %s records, not %s", p.URL, resp.Schema, schema.Name)
+	}
+	return resp.Buckets, nil
+}
+
+// exchange fetches the records a peer holds in a band, adds them, and sends
+// the peer those of the band it lacks
+func (s *Server) exchange(ctx context.Context, p Peer, band fastbase.PrefixRange, st *SyncStats) error {
+	body, err := peerGet(ctx, p, "/sync/records?"+url.Values{"range": {band.String()}}.Encode())
+	if err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid records from %s: %v", p.URL, err)
+	}
+	limit := s.maxBatchBytes()
+	body, err = io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return fmt.Errorf("invalid records from %s: %v", p.URL, err)
+	}
+	if int64(len(body)) > limit {
+		return fmt.Errorf("records of %s from %s exceed the %d byte batch limit", band, p.URL, limit)
+	}
+
+	// The peer's records are checked like any worker's before they count
+	if _, err := s.checkBatch(body); err != nil {
+		return fmt.Errorf("records of %s from %s: %v", band, p.URL, err)
+	}
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	s.mu.RUnlock()
+	theirs := make(map[string]bool)
+	info, _ := fastbase.ScanDelta(bytes.NewReader(body), func(prefix [3]byte, rec []byte) error {
+		theirs[recordIdentity(schema, rec)] = true
+		return nil
+	})
+
+	if info.Records > 0 {
+		_, stats, err := s.addDelta(body, fastbase.Provenance{Worker: fastbase.WorkerID(p.URL), Time: time.Now()})
+		st.Pulled += stats.Records
+		st.Added += stats.Added
+		st.Collisions += stats.Collisions
+		if err != nil {
+			return err
+		}
+	}
+
+	// Send what the peer lacks, which may include what was added here since
+	// the summaries were compared
+	var batches []*fastbase.Batch
+	s.mu.RLock()
+	s.fb.ForEachInRange(band, func(prefix [3]byte, records [][]byte) bool {
+		for _, rec := range records {
+			if theirs[recordIdentity(schema, rec)] {
+				continue
+			}
+			if len(batches) == 0 || batches[len(batches)-1].Len() == syncPushRecords {
+				batches = append(batches, fastbase.NewBatch(schema))
+			}
+			batches[len(batches)-1].Add(prefix, s.fb.Narrow(rec))
+		}
+		return true
+	})
+	s.mu.RUnlock()
+
+	for _, batch := range batches {
+		if err := peerPost(ctx, p, batch); err != nil {
+			return err
+		}
+		st.Pushed += batch.Len()
+	}
+	return nil
+}
+
+// recordIdentity returns the part of a record that makes it a duplicate
+// of another
+func recordIdentity(schema fastbase.Schema, rec []byte) string {
+	return string(rec[:schema.XLength+schema.DistanceLength])
+}
+
+// peerGet fetches a path of a peer, failing unless it answers 200 OK
+func peerGet(ctx context.Context, p Peer, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Set explicitly, so the records come as sent and are unpacked here
+	req.Header.Set("Accept-Encoding", "gzip")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s: %s", p.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// peerPost uploads a batch to a peer, retrying while its upload rate is
+// exceeded
+func peerPost(ctx context.Context, p Peer, batch *fastbase.Batch) error {
+	var data bytes.Buffer
+	zw := gzip.NewWriter(&data)
+	batch.WriteTo(zw)
+	zw.Close()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL+"/dps", bytes.NewReader(data.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Encoding", "gzip")
+		if p.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+		resp, err := p.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		var answer submitResponse
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests && answer.RetryAfter > 0:
+			select {
+			case <-time.After(time.Duration(answer.RetryAfter) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return fmt.Errorf("%s refused %d records: %s %s", p.URL, batch.Len(), resp.Status, answer.Error)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"rckangaroo/fastbase"
+)
+
+// addSyncRecords adds records from..to-1 of a series to fb, all starting
+// with the x-coordinate byte lead, so many crowd into one band that sync
+// has to split further
+func addSyncRecords(t *testing.T, fb *fastbase.FastBase, lead byte, from, to int) {
+	t.Helper()
+	for n := from; n < to; n++ {
+		var x [32]byte
+		x[0] = lead
+		binary.BigEndian.PutUint32(x[1:], uint32(n)*2654435761)
+		rec, err := fastbase.NewRecord(x, big.NewInt(int64(n)+1), fastbase.KangarooType(n%3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fb.AddRecord(x[0], x[1], x[2], rec[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// syncTestDB returns a FastBase holding records from..to-1 of the series
+// under lead 0x5a, and any extra records under lead 0x11
+func syncTestDB(t *testing.T, from, to, extraFrom, extraTo int) *fastbase.FastBase {
+	t.Helper()
+	fb := fastbase.NewFastBase()
+	addSyncRecords(t, fb, 0x5a, from, to)
+	addSyncRecords(t, fb, 0x11, extraFrom, extraTo)
+	return fb
+}
+
+// syncTestServer serves fb over HTTP, returning the server and a peer
+// pointing at it
+func syncTestServer(t *testing.T, fb *fastbase.FastBase) (*Server, Peer) {
+	t.Helper()
+	s := New(fb, Options{})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, Peer{URL: ts.URL}
+}
+
+func TestSyncWith(t *testing.T) {
+	ctx := context.Background()
+	// Each holds 100 records the other lacks in a band of their own, and b
+	// one more among the 9000 both hold, which sync finds by splitting
+	// their band
+	a, peerA := syncTestServer(t, syncTestDB(t, 0, 9000, 0, 100))
+	b, peerB := syncTestServer(t, syncTestDB(t, 0, 9001, 100, 200))
+	if a.fb.Digest() == b.fb.Digest() {
+		t.Fatal("databases holding different records have the same digest")
+	}
+
+	st, err := a.SyncWith(ctx, peerB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Differed != 2 || st.Added != 101 || st.Pushed != 100 {
+		t.Errorf("first sync differed in %d buckets, added %d and pushed %d records; want 2, 101 and 100",
+			st.Differed, st.Added, st.Pushed)
+	}
+	if st.Pulled >= 1000 {
+		t.Errorf("first sync pulled %d records; want only those of the buckets that differed", st.Pulled)
+	}
+
+	want := syncTestDB(t, 0, 9001, 0, 200)
+	for name, s := range map[string]*Server{"a": a, "b": b} {
+		if !s.fb.Equal(want) {
+			t.Errorf("after the sync %s does not hold the records of both", name)
+		}
+	}
+	if a.fb.Digest() != b.fb.Digest() {
+		t.Error("digests differ after the sync")
+	}
+	for name, p := range map[string]Peer{"a": peerA, "b": peerB} {
+		node, err := PeerDigest(ctx, p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Hash != want.Digest() {
+			t.Errorf("%s serves digest %x; want %x", name, node.Hash, want.Digest())
+		}
+	}
+
+	// Once the digests match nothing more is sent either way
+	st, err = b.SyncWith(ctx, peerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Differed != 0 || st.Pulled != 0 || st.Pushed != 0 {
+		t.Errorf("second sync differed in %d buckets, pulled %d and pushed %d records; want none",
+			st.Differed, st.Pulled, st.Pushed)
+	}
+}
+
+func TestSyncWithOneRecordDiffering(t *testing.T) {
+	a, _ := syncTestServer(t, syncTestDB(t, 0, 5000, 0, 0))
+	b, peerB := syncTestServer(t, syncTestDB(t, 0, 5000, 0, 1))
+
+	st, err := a.SyncWith(context.Background(), peerB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Differed != 1 || st.Pulled != 1 || st.Added != 1 || st.Pushed != 0 {
+		t.Errorf("sync differed in %d buckets, pulled %d, added %d and pushed %d records; want 1, 1, 1 and 0",
+			st.Differed, st.Pulled, st.Added, st.Pushed)
+	}
+	if a.fb.Digest() != b.fb.Digest() {
+		t.Error("digests differ after the sync")
+	}
+}
+
+func TestPeerDigestDivergence(t *testing.T) {
+	ctx := context.Background()
+	a, peerA := syncTestServer(t, syncTestDB(t, 0, 5000, 0, 0))
+	b, peerB := syncTestServer(t, syncTestDB(t, 0, 5000, 7, 8))
+
+	remote := func(p Peer) func([]byte) (fastbase.DigestNode, error) {
+		return func(path []byte) (fastbase.DigestNode, error) { return PeerDigest(ctx, p, path) }
+	}
+	diverge, err := fastbase.DivergentPrefixes(a.fb.DigestNode, remote(peerB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	odd := syncTestDB(t, 0, 0, 7, 8)
+	var prefix [3]byte
+	odd.ForEach(func(p [3]byte, _ []byte) bool { prefix = p; return false })
+	if len(diverge) != 1 || !diverge[0].Contains(prefix) {
+		t.Fatalf("databases differing in a record under %x diverge in %v; want one band holding it", prefix, diverge)
+	}
+
+	// Exchanging the records of the band that diverges makes them equal
+	var st SyncStats
+	if err := a.exchange(ctx, peerB.normalized(), diverge[0], &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Added != 1 || st.Pushed != 0 {
+		t.Errorf("exchange added %d and pushed %d records; want 1 and 0", st.Added, st.Pushed)
+	}
+	diverge, err = fastbase.DivergentPrefixes(remote(peerA), remote(peerB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diverge) != 0 || a.fb.Digest() != b.fb.Digest() {
+		t.Errorf("after the exchange the databases still diverge in %v", diverge)
+	}
+}
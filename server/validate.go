@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"rckangaroo/fastbase"
+	"rckangaroo/kangaroo"
+)
+
+// checkBatch rejects a batch holding any record no honest worker sends:
+// one filed under a prefix other than the start of its x-coordinate, of
+// an unknown kangaroo type, or not distinguished at the pool's DP bits.
+// Checking the whole batch first keeps junk batches out entirely.
+func (s *Server) checkBatch(body []byte) (fastbase.DeltaInfo, error) {
+	s.mu.RLock()
+	schema := s.fb.Schema()
+	s.mu.RUnlock()
+
+	return fastbase.ScanDelta(bytes.NewReader(body), func(prefix [3]byte, rec []byte) error {
+		if !bytes.Equal(prefix[:], rec[:3]) {
+			return fmt.Errorf("filed under %x, but its x-coordinate starts with %x", prefix, rec[:3])
+		}
+		if t := schema.Type(rec); t > fastbase.TypeWild2 {
+			return fmt.Errorf("unknown kangaroo type %d", t)
+		}
+		if s.opts.DPBits > 0 && !kangaroo.IsDP(schema, rec, s.opts.DPBits) {
+			return fmt.Errorf("x-coordinate %x is not distinguished at %d bits", schema.X(rec), s.opts.DPBits)
+		}
+		return nil
+	})
+}
+
+// throttle charges n records to the upload rate of the client of r,
+// reporting false with the response to send if the batch may not be
+// applied. If wait is set it waits for the rate to allow the batch
+// instead of refusing it with 429.
+func (s *Server) throttle(r *http.Request, n int, wait bool) (submitResponse, int, bool) {
+	if s.uploads == nil {
+		return submitResponse{}, http.StatusOK, true
+	}
+	if burst := s.uploads.Burst(); n > burst {
+		err := fmt.Errorf("batch of %d records exceeds the upload burst of %d", n, burst)
+		s.rejected(r, err)
+		return submitResponse{Error: err.Error()}, http.StatusRequestEntityTooLarge, false
+	}
+
+	client := "ip " + clientIP(r)
+	if name, ok := keyName(r); ok {
+		client = "key " + name
+	}
+	for {
+		ok, after := s.uploads.AllowN(client, n)
+		if ok {
+			return submitResponse{}, http.StatusOK, true
+		}
+		if !wait {
+			return submitResponse{Error: "upload rate exceeded", RetryAfter: int(math.Ceil(after.Seconds()))},
+				http.StatusTooManyRequests, false
+		}
+		select {
+		case <-time.After(after):
+		case <-r.Context().Done():
+			return submitResponse{Error: "upload rate exceeded"}, http.StatusTooManyRequests, false
+		}
+	}
+}
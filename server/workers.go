@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// RateWindow is the span the rates of API keys are averaged over
+const RateWindow = 10 * time.Minute
+
+// rateWindow estimates a rate over a sliding window from the counts of the
+// current fixed window and the one before, weighting the latter by how
+// much of it the sliding window still covers
+type rateWindow struct {
+	start time.Time // Start of the current fixed window
+	cur   int
+	prev  int
+}
+
+// roll moves the fixed windows on to the one holding now
+func (w *rateWindow) roll(now time.Time) {
+	switch elapsed := now.Sub(w.start); {
+	case elapsed >= 2*RateWindow:
+		w.start, w.cur, w.prev = now, 0, 0
+	case elapsed >= RateWindow:
+		w.start, w.cur, w.prev = w.start.Add(RateWindow), 0, w.cur
+	}
+}
+
+// add counts n events at now
+func (w *rateWindow) add(now time.Time, n int) {
+	w.roll(now)
+	w.cur += n
+}
+
+// rate returns the events per second over the RateWindow before now
+func (w *rateWindow) rate(now time.Time) float64 {
+	w.roll(now)
+	covered := 1 - now.Sub(w.start).Seconds()/RateWindow.Seconds()
+	return (float64(w.prev)*covered + float64(w.cur)) / RateWindow.Seconds()
+}
+
+// WorkerStats is one place on the leaderboard of GET /workers
+type WorkerStats struct {
+	Rank  int     `json:"rank"`
+	Name  string  `json:"name"`
+	Share float64 `json:"share"` // Of the records all keys added
+	KeyStats
+}
+
+// Leaderboard ranks the API keys by the records they added, most first
+func (a *Auth) Leaderboard() []WorkerStats {
+	stats := a.Stats()
+	total := 0
+	board := make([]WorkerStats, 0, len(stats))
+	for name, st := range stats {
+		board = append(board, WorkerStats{Name: name, KeyStats: st})
+		total += st.Added
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Added != board[j].Added {
+			return board[i].Added > board[j].Added
+		}
+		return board[i].Name < board[j].Name
+	})
+	for i := range board {
+		board[i].Rank = i + 1
+		if total > 0 {
+			board[i].Share = float64(board[i].Added) / float64(total)
+		}
+	}
+	return board
+}
+
+func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.Auth.Leaderboard())
+}
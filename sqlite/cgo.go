@@ -0,0 +1,177 @@
+//go:build sqlite
+
+package sqlite
+
+/*
+#cgo LDFLAGS: -lsqlite3
+#include <stdlib.h>
+#include <sqlite3.h>
+
+// SQLITE_TRANSIENT is a cast macro cgo cannot express
+static int bind_blob(sqlite3_stmt *s, int i, const void *p, int n) {
+	return sqlite3_bind_blob(s, i, p, n, SQLITE_TRANSIENT);
+}
+static int bind_text(sqlite3_stmt *s, int i, const char *p, int n) {
+	return sqlite3_bind_text(s, i, p, n, SQLITE_TRANSIENT);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Conn is an open SQLite database
+type Conn struct {
+	db *C.sqlite3
+}
+
+// Stmt is a prepared statement of a Conn
+type Stmt struct {
+	c    *Conn
+	stmt *C.sqlite3_stmt
+}
+
+// Row is the current result row of a query
+type Row struct {
+	stmt *C.sqlite3_stmt
+}
+
+// Open opens the SQLite database file at path, creating it if missing
+func Open(path string) (*Conn, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	var db *C.sqlite3
+	rc := C.sqlite3_open_v2(cpath, &db, C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE, nil)
+	if rc != C.SQLITE_OK {
+		err := fmt.Errorf("opening %s: %s", path, C.GoString(C.sqlite3_errstr(rc)))
+		C.sqlite3_close(db)
+		return nil, err
+	}
+	return &Conn{db: db}, nil
+}
+
+// Close closes the database
+func (c *Conn) Close() error {
+	if rc := C.sqlite3_close(c.db); rc != C.SQLITE_OK {
+		return c.error(rc)
+	}
+	return nil
+}
+
+// error describes the latest failure of the database
+func (c *Conn) error(rc C.int) error {
+	if msg := C.sqlite3_errmsg(c.db); msg != nil {
+		return errors.New(C.GoString(msg))
+	}
+	return errors.New(C.GoString(C.sqlite3_errstr(rc)))
+}
+
+// Exec runs SQL statements that take no arguments
+func (c *Conn) Exec(sql string) error {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	if rc := C.sqlite3_exec(c.db, csql, nil, nil, nil); rc != C.SQLITE_OK {
+		return c.error(rc)
+	}
+	return nil
+}
+
+// Prepare compiles one SQL statement
+func (c *Conn) Prepare(sql string) (*Stmt, error) {
+	csql := C.CString(sql)
+	defer C.free(unsafe.Pointer(csql))
+	var stmt *C.sqlite3_stmt
+	if rc := C.sqlite3_prepare_v2(c.db, csql, -1, &stmt, nil); rc != C.SQLITE_OK {
+		return nil, c.error(rc)
+	}
+	return &Stmt{c: c, stmt: stmt}, nil
+}
+
+// Close frees the statement
+func (s *Stmt) Close() error {
+	C.sqlite3_finalize(s.stmt)
+	return nil
+}
+
+// bind binds args to the parameters of the statement in order. Arguments
+// may be nil, []byte, string, int, int64 or uint32.
+func (s *Stmt) bind(args []interface{}) error {
+	C.sqlite3_reset(s.stmt)
+	for i, arg := range args {
+		n := C.int(i + 1)
+		var rc C.int
+		switch v := arg.(type) {
+		case nil:
+			rc = C.sqlite3_bind_null(s.stmt, n)
+		case []byte:
+			var p unsafe.Pointer
+			if len(v) > 0 {
+				p = unsafe.Pointer(&v[0])
+			}
+			rc = C.bind_blob(s.stmt, n, p, C.int(len(v)))
+		case string:
+			cs := C.CString(v)
+			rc = C.bind_text(s.stmt, n, cs, C.int(len(v)))
+			C.free(unsafe.Pointer(cs))
+		case int:
+			rc = C.sqlite3_bind_int64(s.stmt, n, C.sqlite3_int64(v))
+		case int64:
+			rc = C.sqlite3_bind_int64(s.stmt, n, C.sqlite3_int64(v))
+		case uint32:
+			rc = C.sqlite3_bind_int64(s.stmt, n, C.sqlite3_int64(v))
+		default:
+			return fmt.Errorf("cannot bind a %T", arg)
+		}
+		if rc != C.SQLITE_OK {
+			return s.c.error(rc)
+		}
+	}
+	return nil
+}
+
+// Exec runs the statement with args, discarding any result rows
+func (s *Stmt) Exec(args ...interface{}) error {
+	return s.Query(nil, args...)
+}
+
+// Query runs the statement with args and calls fn, if not nil, for each
+// result row, stopping at the first error fn returns
+func (s *Stmt) Query(fn func(r Row) error, args ...interface{}) error {
+	if err := s.bind(args); err != nil {
+		return err
+	}
+	defer C.sqlite3_reset(s.stmt)
+	for {
+		switch rc := C.sqlite3_step(s.stmt); rc {
+		case C.SQLITE_DONE:
+			return nil
+		case C.SQLITE_ROW:
+			if fn == nil {
+				continue
+			}
+			if err := fn(Row{stmt: s.stmt}); err != nil {
+				return err
+			}
+		default:
+			return s.c.error(rc)
+		}
+	}
+}
+
+// Blob returns a copy of column i of the row as bytes
+func (r Row) Blob(i int) []byte {
+	p := C.sqlite3_column_blob(r.stmt, C.int(i))
+	n := C.sqlite3_column_bytes(r.stmt, C.int(i))
+	if p == nil || n == 0 {
+		return nil
+	}
+	return C.GoBytes(p, n)
+}
+
+// Int returns column i of the row as an integer
+func (r Row) Int(i int) int64 {
+	return int64(C.sqlite3_column_int64(r.stmt, C.int(i)))
+}
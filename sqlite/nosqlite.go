@@ -0,0 +1,51 @@
+//go:build !sqlite
+
+package sqlite
+
+// Conn is an open SQLite database
+type Conn struct{}
+
+// Stmt is a prepared statement of a Conn
+type Stmt struct{}
+
+// Row is the current result row of a query
+type Row struct{}
+
+// Open returns ErrNoSQLite: this build has no SQLite library
+func Open(path string) (*Conn, error) {
+	return nil, ErrNoSQLite
+}
+
+// The methods below are never reached, as no Conn exists without SQLite
+
+func (c *Conn) Close() error {
+	return ErrNoSQLite
+}
+
+func (c *Conn) Exec(sql string) error {
+	return ErrNoSQLite
+}
+
+func (c *Conn) Prepare(sql string) (*Stmt, error) {
+	return nil, ErrNoSQLite
+}
+
+func (s *Stmt) Close() error {
+	return ErrNoSQLite
+}
+
+func (s *Stmt) Exec(args ...interface{}) error {
+	return ErrNoSQLite
+}
+
+func (s *Stmt) Query(fn func(r Row) error, args ...interface{}) error {
+	return ErrNoSQLite
+}
+
+func (r Row) Blob(i int) []byte {
+	return nil
+}
+
+func (r Row) Int(i int) int64 {
+	return 0
+}
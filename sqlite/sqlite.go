@@ -0,0 +1,31 @@
+// Package sqlite is a minimal binding to the SQLite C library, enough to
+// keep a FastBase in an SQLite database file. It is linked in by building
+// with -tags sqlite where libsqlite3 and its headers are installed;
+// otherwise Open reports ErrNoSQLite.
+package sqlite
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNoSQLite is returned by Open in builds without SQLite
+var ErrNoSQLite = errors.New("built without SQLite support; install libsqlite3 and build with -tags sqlite")
+
+// URLPrefix starts the names of databases kept in SQLite, as in
+// sqlite:pool.sqlite or sqlite:///var/lib/pool.sqlite
+const URLPrefix = "sqlite:"
+
+// IsURL reports whether a database name refers to an SQLite database
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, URLPrefix)
+}
+
+// Path returns the file of the SQLite database a URL names
+func Path(url string) string {
+	path := strings.TrimPrefix(url, URLPrefix)
+	if rest, ok := strings.CutPrefix(path, "//"); ok {
+		return rest
+	}
+	return path
+}